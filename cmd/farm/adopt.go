@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/conflict"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adoptPackage  string
+	adoptExisting bool
+)
+
+var adoptCmd = &cobra.Command{
+	Use:     "adopt <path>...",
+	Aliases: []string{"add"},
+	Short:   "Move existing files into the package whose targets they're in, and link them in their place",
+	Long: `Move existing files into the package whose targets they're in, and link them in their place.
+
+Each file lands in the source of the package at the same place it has in the
+package's target, so ~/.config/git/config adopted into a package targeting ~
+becomes <source>/.config/git/config. Pick the package with --package when
+the targets of several hold the file. Sources that already exist are only
+replaced with --force, unless they're identical.
+
+The packages are linked once the files are moved, and the lockfile saved.
+To adopt every file in the way while linking instead, replacing the sources
+they conflict with, use 'farm link --adopt'.
+
+'farm add' is the same command, for growing a repo one file at a time.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		var adoptions []conflict.Conflict
+		var packages []*config.Package
+		for _, arg := range args {
+			path, err := config.ExpandPath(arg)
+			if err != nil {
+				return fmt.Errorf("invalid path: %w", err)
+			}
+
+			pkg, source, err := adoptSource(cfg, lock, path)
+			if err != nil {
+				return err
+			}
+			adoptions = append(adoptions, conflict.Conflict{Target: path, Source: source, Resolution: conflict.Adopt})
+			if !containsPackage(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+
+		if verbose || dryRun {
+			for _, c := range adoptions {
+				cmd.Printf("  %s -> %s\n", c.Target, c.Source)
+			}
+		}
+		if dryRun {
+			cmd.Printf("Will adopt %d files\n", len(adoptions))
+			return nil
+		}
+
+		for _, c := range adoptions {
+			if err := conflict.Apply(c); err != nil {
+				return fmt.Errorf("failed to adopt %s: %w", c.Target, err)
+			}
+		}
+
+		filteredConfig := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		result, err := linker.New(filteredConfig, lock, false).Link()
+		if err != nil {
+			return fmt.Errorf("failed to link: %w", err)
+		}
+
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+		if verbose {
+			printResult(cmd, result, false)
+		}
+		cmd.Printf("✓ Adopted %d files and linked them in their place\n", len(adoptions))
+
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+			return fmt.Errorf("linking completed with %d errors", len(result.Errors))
+		}
+		return nil
+	},
+}
+
+// adoptSource returns the package a file is adopted into and where its
+// source goes: the same place in the package as the file has in the target
+// of the package that holds it.
+func adoptSource(cfg *config.Config, lock *lockfile.LockFile, path string) (*config.Package, string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s doesn't exist", path)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, "", fmt.Errorf("%s is already a symlink", path)
+	}
+	if _, _, ok := lock.Which(path); ok {
+		return nil, "", fmt.Errorf("%s is already managed by farm", path)
+	}
+
+	type candidate struct {
+		pkg *config.Package
+		rel string
+	}
+	var candidates []candidate
+	for _, pkg := range cfg.Packages {
+		if adoptPackage != "" && pkg.Name != adoptPackage {
+			continue
+		}
+		for _, target := range pkg.Targets {
+			if rel, ok := cutPath(path, target); ok && rel != "" {
+				candidates = append(candidates, candidate{pkg, rel})
+				break
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if adoptPackage != "" {
+			return nil, "", fmt.Errorf("%s isn't inside the targets of package %s", path, adoptPackage)
+		}
+		return nil, "", fmt.Errorf("%s isn't inside the targets of any package", path)
+	case 1:
+	default:
+		names := make([]string, len(candidates))
+		for i, c := range candidates {
+			names[i] = c.pkg.Name
+		}
+		return nil, "", fmt.Errorf("%s is inside the targets of several packages (%s), pick one with --package", path, strings.Join(names, ", "))
+	}
+
+	pkg, rel := candidates[0].pkg, candidates[0].rel
+	if pattern, ok := linker.New(cfg, lock, true).Ignored(pkg, rel); ok {
+		return nil, "", fmt.Errorf("%s would be ignored in package %s by %s", path, pkg.Name, pattern)
+	}
+
+	source := filepath.Join(pkg.Source, rel)
+	if _, err := os.Lstat(source); err == nil && !force {
+		if c := conflict.New(path, source); !c.Identical {
+			return nil, "", fmt.Errorf("%s already exists, pass --force to replace it with %s", source, path)
+		}
+	}
+	return pkg, source, nil
+}
+
+// adoptConflicts moves the files in the way of links into their packages,
+// replacing the sources they conflict with, like stow --adopt. Directories
+// and the links of other dotfile managers are left for linking to report.
+func adoptConflicts(cmd *cobra.Command, cfg *config.Config, lock *lockfile.LockFile) error {
+	adopted := 0
+	for _, c := range linker.New(cfg, lock, true).Conflicts() {
+		if c.Manager != "" {
+			continue
+		}
+		info, err := os.Lstat(c.Target)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if source, err := os.Stat(c.Source); err != nil || source.IsDir() {
+			continue
+		}
+
+		if verbose || dryRun {
+			cmd.Printf("  %s -> %s\n", c.Target, c.Source)
+		}
+		if dryRun {
+			continue
+		}
+		if err := conflict.Apply(conflict.Conflict{Target: c.Target, Source: c.Source, Resolution: conflict.Adopt}); err != nil {
+			return fmt.Errorf("failed to adopt %s: %w", c.Target, err)
+		}
+		adopted++
+	}
+
+	if adopted > 0 {
+		cmd.Printf("Adopted %d files in the way into their packages\n", adopted)
+	}
+	return nil
+}
+
+func containsPackage(packages []*config.Package, pkg *config.Package) bool {
+	for _, p := range packages {
+		if p == pkg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/archive"
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportArchive  bool
+	signKey        string
+	allowedSigners string
+)
+
+var applyArchiveCmd = &cobra.Command{
+	Use:   "apply-archive <file>",
+	Short: "Place the files of an archive from 'farm lock export --archive', without the repo or a config",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		homeDir, err := home.Dir()
+		if err != nil {
+			return fmt.Errorf("failed to determine home directory: %w", err)
+		}
+
+		if err := verifyExport(cmd, args[0]); err != nil {
+			return err
+		}
+
+		applied, err := archive.Apply(args[0], homeDir, dryRun)
+		if err != nil {
+			return err
+		}
+
+		if dryRun || verbose {
+			for _, target := range applied {
+				cmd.Printf("  + %s\n", target)
+			}
+		}
+		if dryRun {
+			cmd.Printf("Will place %d files from %s\n", len(applied), args[0])
+			return nil
+		}
+
+		cmd.Printf("✓ Placed %d files from %s\n", len(applied), args[0])
+		return nil
+	},
+}
+
+// writeExportArchive writes the content of every linked file into an archive
+// at path, following links into the source and walking folded directories,
+// so it can be applied where the repo isn't available. Dead links are left
+// out.
+func writeExportArchive(cmd *cobra.Command, lock *lockfile.LockFile, path, homeDir string) error {
+	shorten := func(target string) string {
+		if rest, ok := strings.CutPrefix(target, homeDir+"/"); ok {
+			return "~/" + rest
+		}
+		return target
+	}
+
+	var files []archive.ExportedFile
+	for _, link := range lock.Symlinks.Sorted() {
+		if link.Kind == lockfile.KindDirectory {
+			continue
+		}
+
+		if !link.IsFolded {
+			if info, err := os.Stat(link.Target); err == nil && !info.IsDir() {
+				files = append(files, archive.ExportedFile{Path: link.Target, Target: shorten(link.Target)})
+			}
+			continue
+		}
+
+		err := filepath.WalkDir(link.Source, func(source string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(link.Source, source)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(link.Target, rel)
+			files = append(files, archive.ExportedFile{Path: source, Target: shorten(target)})
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", link.Source, err)
+		}
+	}
+
+	if dryRun {
+		cmd.Printf("Will export %d files to %s\n", len(files), path)
+		return nil
+	}
+
+	if err := archive.Export(path, files); err != nil {
+		return fmt.Errorf("failed to export archive: %w", err)
+	}
+	if err := signExport(cmd, path); err != nil {
+		return err
+	}
+	cmd.Printf("✓ Exported %d files to %s\n", len(files), path)
+	return nil
+}
+
+// verifyExport refuses exports not signed by anyone in --allowed-signers,
+// when it is set.
+func verifyExport(cmd *cobra.Command, path string) error {
+	if allowedSigners == "" {
+		return nil
+	}
+
+	signers, err := config.ExpandPath(allowedSigners)
+	if err != nil {
+		return fmt.Errorf("invalid allowed signers path: %w", err)
+	}
+	signer, err := archive.Verify(path, signers)
+	if err != nil {
+		return err
+	}
+	cmd.Printf("✓ Signed by %s\n", signer)
+	return nil
+}
+
+// signExport signs an exported archive or lockfile with --sign-key, when it
+// is set.
+func signExport(cmd *cobra.Command, path string) error {
+	if signKey == "" {
+		return nil
+	}
+
+	key, err := config.ExpandPath(signKey)
+	if err != nil {
+		return fmt.Errorf("invalid signing key path: %w", err)
+	}
+	if err := archive.Sign(path, key); err != nil {
+		return err
+	}
+	cmd.Printf("✓ Signed %s, signature in %s\n", path, archive.SignaturePath(path))
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+	"github.com/mskelton/farm/internal/plugin"
+	"github.com/spf13/cobra"
+)
+
+// sensitiveDirs hold credentials, so nothing placed in them should be
+// readable by everyone.
+var sensitiveDirs = []string{".ssh", ".gnupg", ".aws"}
+
+// secretPatterns match the names of files that usually hold secrets.
+var secretPatterns = []string{
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519", "*.pem", "*.key", "*.p12",
+	"*.pfx", "*.kdbx", ".env", ".env.*", ".netrc", ".pgpass", "credentials",
+	"*secret*", "*token*",
+}
+
+// auditFile is a file farm would place, along with the source it comes from.
+type auditFile struct {
+	source string
+	target string
+	mode   os.FileMode
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [environment]",
+	Short: "List the sensitive files farm would place and the commands it would run",
+	Long: `List the sensitive files farm would place and the commands it would run.
+
+The config is planned as if nothing had been linked yet, without changing
+anything or running any of it, and the report lists every file that would be
+readable by everyone inside ~/.ssh, ~/.gnupg, or ~/.aws, every file whose name
+looks like it holds a secret, and every hook and plugin that would run, so
+they can be reviewed before the first link.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args = environmentArgs(args)
+		if len(args) > 0 {
+			environment = args[0]
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := validateEnvironmentArg(args, cfg); err != nil {
+			return err
+		}
+
+		packages := cfg.GetPackagesForEnvironment(environment)
+		filteredConfig := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+
+		result, err := linker.New(filteredConfig, lockfile.New(), true).Link()
+		if err != nil {
+			return fmt.Errorf("failed to plan: %w", err)
+		}
+
+		var readable, secrets []auditFile
+		for _, file := range auditFiles(&result.Plan) {
+			if file.mode&0004 != 0 && inSensitiveDir(file.target) {
+				readable = append(readable, file)
+			}
+			if looksSecret(filepath.Base(file.target)) {
+				secrets = append(secrets, file)
+			}
+		}
+
+		cmd.Printf("Files readable by everyone in sensitive directories (%d):\n", len(readable))
+		for _, file := range readable {
+			cmd.Printf("  %s <- %s (%04o)\n", file.target, file.source, file.mode.Perm())
+		}
+
+		cmd.Printf("\nFiles that look like secrets (%d):\n", len(secrets))
+		for _, file := range secrets {
+			cmd.Printf("  %s <- %s\n", file.target, file.source)
+		}
+
+		var hookLines []string
+		for _, pkg := range packages {
+			for _, name := range pkg.HookNames() {
+				hookLines = append(hookLines, fmt.Sprintf("  %s %s: %s", pkg.Name, name, pkg.Hooks[name]))
+			}
+		}
+		cmd.Printf("\nHooks that would run (%d):\n", len(hookLines))
+		for _, line := range hookLines {
+			cmd.Println(line)
+		}
+
+		// Plugins aren't asked for their events, which would run them
+		plugins := plugin.Find(os.Getenv("PATH"))
+		cmd.Printf("\nPlugins that may run (%d):\n", len(plugins))
+		for _, p := range plugins {
+			cmd.Printf("  %s: %s\n", p.Name, p.Path)
+		}
+
+		if len(result.Errors) > 0 {
+			cmd.Println()
+			printErrors(cmd, result)
+		}
+
+		return nil
+	},
+}
+
+// auditFiles returns the files a plan places, walking folded directories
+// for the files they hold. Files whose source can't be read, such as
+// downloads, are left out.
+func auditFiles(p *plan.Plan) []auditFile {
+	var files []auditFile
+	for _, action := range p.Sorted().Actions {
+		if (action.Kind != plan.Create && action.Kind != plan.Replace) || action.Source == "" {
+			continue
+		}
+
+		info, err := os.Stat(action.Source)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, auditFile{source: action.Source, target: action.Target, mode: info.Mode().Perm()})
+			continue
+		}
+
+		_ = filepath.WalkDir(action.Source, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(action.Source, path)
+			files = append(files, auditFile{source: path, target: filepath.Join(action.Target, rel), mode: info.Mode().Perm()})
+			return nil
+		})
+	}
+	return files
+}
+
+func inSensitiveDir(path string) bool {
+	parts := strings.Split(filepath.Dir(path), string(filepath.Separator))
+	for _, dir := range sensitiveDirs {
+		if slices.Contains(parts, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func looksSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range secretPatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/i18n"
+	"github.com/mskelton/farm/internal/provision"
+	"github.com/spf13/cobra"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Install the items listed in the config's bundle section",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		return runBundle(cmd, cfg)
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [environment]",
+	Short: "Link dotfiles and install the config's bundle",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, args, err := startApply(cmd, args)
+		if err != nil {
+			return err
+		}
+		applying = state
+		defer func() { applying = nil }()
+
+		if state != nil && state.Linked {
+			cmd.Println("Links are already in place, continuing with the bundle")
+		} else {
+			if err := linkCmd.RunE(cmd, args); err != nil {
+				return err
+			}
+			if err := state.markLinked(); err != nil {
+				return err
+			}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := runBundle(cmd, cfg); err != nil {
+			return err
+		}
+		return state.complete()
+	},
+}
+
+// runBundle installs whatever each provisioner in the bundle is missing.
+func runBundle(cmd *cobra.Command, cfg *config.Config) error {
+	installed, errs := provisionItems(cmd, cfg.Bundle, false)
+	if !dryRun && len(cfg.Bundle) > 0 {
+		cmd.Printf("✓ Installed %d bundle items\n", installed)
+	}
+
+	return reportProvisionErrors(cmd, "bundle", errs)
+}
+
+// installExtensions installs the editor extensions the linked packages list.
+// Editors that aren't installed on this machine are skipped.
+func installExtensions(cmd *cobra.Command, packages []*config.Package) error {
+	extensions := config.Extensions(packages)
+	installed, errs := provisionItems(cmd, extensions, true)
+	if installed > 0 {
+		cmd.Printf("✓ Installed %d editor extensions\n", installed)
+	}
+
+	return reportProvisionErrors(cmd, "extension install", errs)
+}
+
+// provisionItems installs the missing items for each provisioner. Failures
+// are collected so one broken backend doesn't stop the others.
+func provisionItems(cmd *cobra.Command, items map[string][]string, skipMissingTools bool) (int, []error) {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	installed := 0
+
+	for _, name := range names {
+		p, _ := provision.Lookup(name)
+		missing, err := provision.Missing(p, items[name])
+		if skipMissingTools && errors.Is(err, exec.ErrNotFound) {
+			if verbose {
+				cmd.Printf("Skipping %s, it is not installed\n", name)
+			}
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		for _, item := range missing {
+			if applying.isDone(installStep(name, item)) {
+				continue
+			}
+			if dryRun {
+				cmd.Printf("Will install %s %s\n", name, item)
+				continue
+			}
+
+			if verbose {
+				cmd.Printf("Installing %s %s\n", name, item)
+			}
+			if err := p.Install(item); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			installed++
+			if err := applying.markDone(installStep(name, item)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return installed, errs
+}
+
+func reportProvisionErrors(cmd *cobra.Command, action string, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	cmd.Println("\n" + i18n.Sprintf("Errors:"))
+	for _, err := range errs {
+		cmd.Printf("  ✗ %v\n", err)
+	}
+	return fmt.Errorf("%s completed with %d errors", action, len(errs))
+}
+
+// printExtensionDrift reports extensions the packages list that are not
+// installed in their editor.
+func printExtensionDrift(cmd *cobra.Command, packages []*config.Package) {
+	extensions := config.Extensions(packages)
+	for _, editor := range provision.Editors {
+		if len(extensions[editor]) == 0 {
+			continue
+		}
+
+		p, _ := provision.Lookup(editor)
+		missing, err := provision.Missing(p, extensions[editor])
+		if errors.Is(err, exec.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			cmd.Printf("\n⚠ Failed to list %s extensions: %v\n", editor, err)
+			continue
+		}
+
+		if len(missing) > 0 {
+			cmd.Printf("\n⚠ Found %d missing %s extensions:\n", len(missing), editor)
+			for _, id := range missing {
+				cmd.Printf("  - %s\n", id)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/spf13/cobra"
+)
+
+var (
+	completionShell     string
+	completionSourceDir string
+)
+
+var completionInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Write the autocompletion script to your shell's completions directory",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shell := completionShell
+		if shell == "" {
+			shell = filepath.Base(os.Getenv("SHELL"))
+		}
+
+		target, err := completionPath(shell)
+		if err != nil {
+			return err
+		}
+		if targetBase != "" {
+			base, err := config.ExpandPath(targetBase)
+			if err != nil {
+				return fmt.Errorf("invalid target base: %w", err)
+			}
+			target = config.Rebase(base, target)
+		}
+
+		var script bytes.Buffer
+		switch shell {
+		case "bash":
+			err = rootCmd.GenBashCompletionV2(&script, true)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(&script)
+		case "fish":
+			err = rootCmd.GenFishCompletion(&script, true)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to generate %s completions: %w", shell, err)
+		}
+
+		// The script can live in the dotfiles repo and be linked into place
+		// so it is managed like any other farm file
+		dest := target
+		if completionSourceDir != "" {
+			if dest, err = completionSource(target); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			cmd.Printf("Will write %s completions to %s\n", shell, dest)
+			if dest != target {
+				cmd.Printf("Will link %s -> %s\n", target, dest)
+			}
+			return nil
+		}
+
+		if err := writeCompletionFile(dest, script.Bytes()); err != nil {
+			return err
+		}
+
+		if dest != target {
+			if err := linkCompletionFile(dest, target); err != nil {
+				return err
+			}
+		}
+
+		cmd.Printf("✓ Installed %s completions to %s\n", shell, target)
+		if shell == "zsh" {
+			cmd.Printf("Make sure %s is in your fpath before compinit runs\n", filepath.Dir(target))
+		}
+
+		return nil
+	},
+}
+
+func completionPath(shell string) (string, error) {
+	homeDir, err := home.Dir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(homeDir, ".config")
+	}
+
+	switch shell {
+	case "bash":
+		return filepath.Join(dataHome, "bash-completion", "completions", "farm"), nil
+	case "zsh":
+		return filepath.Join(homeDir, ".zsh", "completions", "_farm"), nil
+	case "fish":
+		return filepath.Join(configHome, "fish", "completions", "farm.fish"), nil
+	case "", ".":
+		return "", fmt.Errorf("could not detect your shell, use --shell to specify it")
+	default:
+		return "", fmt.Errorf("unsupported shell %s (supported: bash, zsh, fish)", shell)
+	}
+}
+
+// completionSource returns where the script goes in the package holding
+// --source-dir: the path inside the package that links to target, so linking
+// the package leaves the script's link as it is. The link is only kept by gc
+// when a package owns its source.
+func completionSource(target string) (string, error) {
+	sourceDir, err := filepath.Abs(completionSourceDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid source directory: %w", err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	pkg := cfg.FindPackage(sourceDir)
+	if pkg == nil {
+		return "", fmt.Errorf("source directory %s isn't inside the source of any package", sourceDir)
+	}
+
+	for _, pkgTarget := range pkg.Targets {
+		if rel, ok := cutPath(target, pkgTarget); ok && rel != "" {
+			return filepath.Join(pkg.Source, rel), nil
+		}
+	}
+	return "", fmt.Errorf("package %s doesn't link into %s", pkg.Name, filepath.Dir(target))
+}
+
+func writeCompletionFile(path string, script []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+
+	if err := os.WriteFile(path, script, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return home.Chown(path)
+}
+
+func linkCompletionFile(source, target string) error {
+	lock, err := loadLockfile()
+	if err != nil {
+		return fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+	}
+
+	if info, err := os.Lstat(target); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("target %s already exists and is not a symlink", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("failed to remove existing symlink %s: %w", target, err)
+		}
+	}
+
+	relSource, err := filepath.Rel(filepath.Dir(target), source)
+	if err != nil {
+		return fmt.Errorf("failed to calculate relative path: %w", err)
+	}
+
+	if err := os.Symlink(relSource, target); err != nil {
+		return fmt.Errorf("failed to create symlink %s -> %s: %w", target, source, err)
+	}
+	if err := home.Chown(target); err != nil {
+		return err
+	}
+
+	lock.AddSymlink(target, source, false)
+	if err := lock.Save(lockfilePath); err != nil {
+		return fmt.Errorf("failed to save lockfile: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mskelton/farm/internal/home"
+	"github.com/spf13/cobra"
+)
+
+var fullPaths bool
+
+// displayRoot is the base directory of the loaded config, which the output
+// abbreviates as ./ along with the home directory as ~.
+var displayRoot string
+
+// pathWriter abbreviates the paths in everything written through it, unless
+// --full-paths is set. Each write is expected to hold whole lines, which is
+// how cobra's Print functions write.
+type pathWriter struct {
+	out io.Writer
+}
+
+// displayPaths wraps out to abbreviate the paths written to it.
+func displayPaths(out io.Writer) io.Writer {
+	return &pathWriter{out: out}
+}
+
+func (w *pathWriter) Write(p []byte) (int, error) {
+	if fullPaths {
+		return w.out.Write(p)
+	}
+	if _, err := io.WriteString(w.out, shortenPaths(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rawOut returns where the command's output goes without abbreviating paths,
+// for output other programs read, such as JSON, or that comes from them,
+// such as hooks.
+func rawOut(cmd *cobra.Command) io.Writer {
+	return unwrapPaths(cmd.OutOrStdout())
+}
+
+// rawErr is rawOut for the command's error output.
+func rawErr(cmd *cobra.Command) io.Writer {
+	return unwrapPaths(cmd.ErrOrStderr())
+}
+
+func unwrapPaths(out io.Writer) io.Writer {
+	if w, ok := out.(*pathWriter); ok {
+		return w.out
+	}
+	return out
+}
+
+// shortenPaths abbreviates the repo root as . and the home directory as ~
+// wherever a path in s starts with them. Deeper directories are abbreviated
+// first, so a repo inside the home directory is written as ./ rather than
+// ~/dotfiles/.
+func shortenPaths(s string) string {
+	type root struct{ dir, short string }
+	var roots []root
+	if displayRoot != "" && displayRoot != "/" {
+		roots = append(roots, root{displayRoot, "."})
+	}
+	if homeDir, err := home.Dir(); err == nil && homeDir != "/" {
+		roots = append(roots, root{homeDir, "~"})
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return len(roots[i].dir) > len(roots[j].dir) })
+
+	for _, r := range roots {
+		s = abbreviate(s, r.dir, r.short)
+	}
+	return s
+}
+
+// abbreviate replaces dir with short where it's a whole path or the start of
+// one, leaving paths that merely share a prefix with it, like /home/me2 for
+// /home/me, alone.
+func abbreviate(s, dir, short string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, dir)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		end := i + len(dir)
+		if (i == 0 || isPathBoundary(s[i-1])) && (end == len(s) || s[end] == '/' || isPathBoundary(s[end])) {
+			b.WriteString(s[:i])
+			b.WriteString(short)
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+}
+
+// isPathBoundary reports whether c can't be part of a path, so a path may
+// start after it or end before it.
+func isPathBoundary(c byte) bool {
+	return strings.IndexByte(" \t\n\r'\"`()[]{}<>=:,;|", c) >= 0
+}
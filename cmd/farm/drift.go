@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/conflict"
+	"github.com/mskelton/farm/internal/i18n"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+// configChange is a package whose config differs from the one it was last
+// linked with, along with how.
+type configChange struct {
+	name   string
+	source string
+	kind   string
+}
+
+const (
+	configChanged = "changed"
+	configAdded   = "added"
+	configRemoved = "removed"
+)
+
+// configChanges compares the packages against the config hashes recorded by
+// the last link. Packages that are gone are looked up in the whole config, so
+// the packages of other environments don't count as removed. Nothing is
+// reported for lockfiles from before hashes were recorded.
+func configChanges(cfg *config.Config, packages []*config.Package, lock *lockfile.LockFile) []configChange {
+	if len(lock.ConfigHashes) == 0 {
+		return nil
+	}
+
+	var changes []configChange
+	for _, pkg := range packages {
+		hash, ok := lock.ConfigHashes[pkg.Source]
+		switch {
+		case !ok:
+			changes = append(changes, configChange{name: pkg.Name, source: pkg.Source, kind: configAdded})
+		case hash != cfg.PackageHash(pkg):
+			changes = append(changes, configChange{name: pkg.Name, source: pkg.Source, kind: configChanged})
+		}
+	}
+
+	var removed []configChange
+	for source := range lock.ConfigHashes {
+		if !slices.ContainsFunc(cfg.Packages, func(pkg *config.Package) bool { return pkg.Source == source }) {
+			removed = append(removed, configChange{name: filepath.Base(source), source: source, kind: configRemoved})
+		}
+	}
+	slices.SortFunc(removed, func(a, b configChange) int { return strings.Compare(a.source, b.source) })
+
+	return append(changes, removed...)
+}
+
+func printConfigChanges(cmd *cobra.Command, changes []configChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	cmd.Printf("\n⚠ %s\n", i18n.Sprintf("Config changed since linking (%d packages):", len(changes)))
+	for _, change := range changes {
+		cmd.Printf("  %s (%s)\n", change.name, i18n.Sprintf(change.kind))
+	}
+}
+
+// printChangeOrigins splits the packages whose links changed into the ones
+// whose config was edited and the ones that drifted on the filesystem, such
+// as files added to the source or links removed by hand.
+func printChangeOrigins(cmd *cobra.Command, packages []*config.Package, changes []configChange, result *linker.LinkResult) {
+	edited := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		edited[change.source] = true
+	}
+
+	var fromConfig, fromDrift []string
+	for _, pkg := range packages {
+		if !result.Changed[pkg.Source] {
+			continue
+		}
+		if edited[pkg.Source] {
+			fromConfig = append(fromConfig, pkg.Name)
+		} else {
+			fromDrift = append(fromDrift, pkg.Name)
+		}
+	}
+
+	if len(fromConfig) > 0 {
+		cmd.Printf("\n%s %s\n", i18n.Sprintf("Changes from config edits:"), strings.Join(fromConfig, ", "))
+	}
+	if len(fromDrift) > 0 {
+		cmd.Printf("%s %s\n", i18n.Sprintf("Changes from filesystem drift:"), strings.Join(fromDrift, ", "))
+	}
+}
+
+// printShadowedSymlinks lists the links that were replaced by a file, along
+// with how the file differs from the source when verbose, and how to adopt
+// the changes back or relink it.
+func printShadowedSymlinks(cmd *cobra.Command, shadowed []lockfile.Symlink) {
+	if len(shadowed) == 0 {
+		return
+	}
+
+	cmd.Printf("\n⚠ %s\n", i18n.Sprintf("Found %d links replaced by files:", len(shadowed)))
+	for _, link := range shadowed {
+		if info, err := os.Lstat(link.Target); err == nil && info.IsDir() {
+			cmd.Printf("  ! %s (%s)\n", link.Target, i18n.Sprintf("directory"))
+			continue
+		}
+
+		diff, err := conflict.Diff(link.Source, link.Target)
+		if err != nil {
+			cmd.Printf("  ! %s (%v)\n", link.Target, err)
+			continue
+		}
+		if diff == "" {
+			cmd.Printf("  ! %s (%s)\n", link.Target, i18n.Sprintf("same as source"))
+			continue
+		}
+
+		cmd.Printf("  ! %s (%s)\n", link.Target, i18n.Sprintf("modified"))
+		if verbose {
+			for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+				cmd.Printf("      %s\n", line)
+			}
+		}
+	}
+
+	cmd.Printf("\n%s\n", i18n.Sprintf("Run '%s' to adopt them back or relink them", "farm link --conflict-report <file>"))
+}
+
+// extensionPackages returns the packages link installs editor extensions
+// for: the ones whose links or config changed, so linking an unchanged repo
+// doesn't ask every editor for its extensions. Lockfiles without config
+// hashes can't tell, so every package is returned for them.
+func extensionPackages(packages []*config.Package, hashed bool, changes []configChange, result *linker.LinkResult) []*config.Package {
+	if !hashed {
+		return packages
+	}
+
+	var changed []*config.Package
+	for _, pkg := range packages {
+		edited := slices.ContainsFunc(changes, func(change configChange) bool { return change.source == pkg.Source })
+		if edited || result.Changed[pkg.Source] {
+			changed = append(changed, pkg)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mskelton/farm/fixture"
+	"github.com/spf13/cobra"
+)
+
+var fixturesCmd = &cobra.Command{
+	Use:    "fixtures <spec> [dir]",
+	Short:  "Build a tree of sources and targets from a fixture spec",
+	Hidden: true,
+	Long: `Build a tree of sources and targets from a fixture spec.
+
+The spec is a YAML file listing the config and the files, directories, and
+symlinks of the tree, built in dir or the current directory. It's how farm's
+own tests set up a repo and a home directory, and the smallest way to share a
+reproduction of a bug. See the fixture package for the format.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, err := fixture.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		dir := "."
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		if verbose || dryRun {
+			if spec.Config != "" {
+				cmd.Printf("  + %s\n", fixture.ConfigFile)
+			}
+			for _, file := range spec.Files {
+				cmd.Printf("  + %s\n", file.Path)
+			}
+		}
+		if dryRun {
+			cmd.Printf("Will build %d files in %s\n", len(spec.Files), dir)
+			return nil
+		}
+
+		if err := spec.Build(dir); err != nil {
+			return fmt.Errorf("failed to build fixture: %w", err)
+		}
+		cmd.Printf("✓ Built %d files in %s\n", len(spec.Files), dir)
+		return nil
+	},
+}
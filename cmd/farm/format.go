@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/mskelton/farm/internal/lockfile"
+)
+
+var statusFormat string
+
+// statusOutput is what `status --format` templates are executed against.
+type statusOutput struct {
+	Environment string
+	// EnvironmentSource is where the environment came from, argument or
+	// FARM_ENV, or empty without one
+	EnvironmentSource string
+	Symlinks          []lockfile.Symlink
+	Dead              []lockfile.DeadSymlink
+	Shadowed          []lockfile.Symlink
+}
+
+// parseTemplate parses a --format value written as a Go template, returning
+// nil for anything else. As with docker, \n and \t are unescaped so they can
+// be written without shell quoting tricks. The template is tried against the
+// zero value of what it will be executed against, so mistakes such as a
+// misspelled field are caught before anything changes.
+func parseTemplate(format string, data any) (*template.Template, error) {
+	if !strings.Contains(format, "{{") {
+		return nil, nil
+	}
+
+	format = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(format)
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, data); err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+
+	return tmpl, nil
+}
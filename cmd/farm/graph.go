@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [environment]",
+	Short: "Print a DOT or mermaid graph of packages, their targets, and conflicts",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args = environmentArgs(args)
+		if len(args) > 0 {
+			environment = args[0]
+		}
+
+		if graphFormat != "dot" && graphFormat != "mermaid" {
+			return fmt.Errorf("unknown format %q, expected dot or mermaid", graphFormat)
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := validateEnvironmentArg(args, cfg); err != nil {
+			return err
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		packages := cfg.GetPackagesForEnvironment(environment)
+		filtered := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		g := newPackageGraph(packages, linker.New(filtered, lock, true).Conflicts(), cfg)
+
+		if graphFormat == "mermaid" {
+			g.writeMermaid(cmd.OutOrStdout())
+		} else {
+			g.writeDot(cmd.OutOrStdout())
+		}
+		return nil
+	},
+}
+
+// packageGraph links packages to their targets, and to the files in the way
+// of their links.
+type packageGraph struct {
+	packages  []string
+	targets   []string
+	edges     [][2]string
+	conflicts [][2]string
+}
+
+func newPackageGraph(packages []*config.Package, conflicts []*linker.ConflictError, cfg *config.Config) *packageGraph {
+	g := &packageGraph{}
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		g.packages = append(g.packages, pkg.Name)
+		for _, target := range pkg.Targets {
+			if !seen[target] {
+				seen[target] = true
+				g.targets = append(g.targets, target)
+			}
+			g.edges = append(g.edges, [2]string{pkg.Name, target})
+		}
+	}
+
+	for _, c := range conflicts {
+		if pkg := cfg.FindPackage(c.Source); pkg != nil {
+			g.conflicts = append(g.conflicts, [2]string{pkg.Name, c.Target})
+		}
+	}
+
+	return g
+}
+
+func (g *packageGraph) writeDot(w io.Writer) {
+	fmt.Fprintln(w, "digraph farm {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, name := range g.packages {
+		fmt.Fprintf(w, "  %q [shape=box];\n", "package:"+name)
+	}
+	for _, target := range g.targets {
+		fmt.Fprintf(w, "  %q [shape=folder];\n", "target:"+target)
+	}
+	for _, edge := range g.edges {
+		fmt.Fprintf(w, "  %q -> %q;\n", "package:"+edge[0], "target:"+edge[1])
+	}
+	for _, c := range g.conflicts {
+		fmt.Fprintf(w, "  %q [shape=note, color=red];\n", "conflict:"+c[1])
+		fmt.Fprintf(w, "  %q -> %q [color=red, style=dashed, label=\"conflict\"];\n", "package:"+c[0], "conflict:"+c[1])
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func (g *packageGraph) writeMermaid(w io.Writer) {
+	ids := make(map[string]string)
+	id := func(kind, name string) string {
+		key := kind + ":" + name
+		if _, ok := ids[key]; !ok {
+			ids[key] = fmt.Sprintf("%s%d", kind[:1], len(ids))
+		}
+		return ids[key]
+	}
+	label := func(text string) string {
+		return strings.ReplaceAll(text, `"`, "#quot;")
+	}
+
+	fmt.Fprintln(w, "flowchart LR")
+	for _, name := range g.packages {
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", id("package", name), label(name))
+	}
+	for _, target := range g.targets {
+		fmt.Fprintf(w, "  %s[(\"%s\")]\n", id("target", target), label(target))
+	}
+	for _, edge := range g.edges {
+		fmt.Fprintf(w, "  %s --> %s\n", id("package", edge[0]), id("target", edge[1]))
+	}
+	for _, c := range g.conflicts {
+		fmt.Fprintf(w, "  %s{{\"%s\"}}\n", id("conflict", c[1]), label(c[1]))
+		fmt.Fprintf(w, "  %s -. conflict .-> %s\n", id("package", c[0]), id("conflict", c[1]))
+	}
+}
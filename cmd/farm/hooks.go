@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/hooks"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/spf13/cobra"
+)
+
+// runHooks runs the given hook for each package that defines it. When a
+// result is available it is written to a temporary JSON file whose path is
+// exported to the hook as FARM_RESULT.
+func runHooks(cmd *cobra.Command, packages []*config.Package, name string, result *linker.LinkResult) error {
+	resultPath := ""
+	for _, pkg := range packages {
+		command := pkg.Hooks[name]
+		if command == "" || applying.isDone(hookStep(pkg.Name, name)) {
+			continue
+		}
+
+		if result != nil && resultPath == "" {
+			path, err := writeHookResult(result)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(path)
+			resultPath = path
+		}
+
+		ctx := hooks.Context{
+			Package:    pkg.Name,
+			Source:     pkg.Source,
+			Targets:    pkg.Targets,
+			DryRun:     dryRun,
+			ResultPath: resultPath,
+			Options:    pkg.HookOptions,
+		}
+		if result != nil {
+			ctx.Changed = result.Changed[pkg.Source]
+		}
+
+		if verbose {
+			cmd.Printf("Running %s hook for %s\n", name, pkg.Name)
+		}
+
+		if err := hooks.Run(command, ctx, rawOut(cmd), rawErr(cmd)); err != nil {
+			return err
+		}
+		if err := applying.markDone(hookStep(pkg.Name, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeHookResult(result *linker.LinkResult) (string, error) {
+	data, err := json.Marshal(newPostApplyPayload(result))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode hook result: %w", err)
+	}
+
+	file, err := os.CreateTemp("", "farm-result-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create hook result file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write hook result file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run <package> <hook>",
+	Short: "Run one of a package's hooks without linking",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		name, hook := args[0], args[1]
+		pkg, err := lookupPackage(cfg, name)
+		if err != nil {
+			return err
+		}
+
+		command, ok := pkg.Hooks[hook]
+		if !ok {
+			if len(pkg.Hooks) == 0 {
+				return fmt.Errorf("package %s has no hooks", name)
+			}
+			return fmt.Errorf("package %s has no hook %s (available: %s)", name, hook, strings.Join(pkg.HookNames(), ", "))
+		}
+
+		ctx := hooks.Context{
+			Package: pkg.Name,
+			Source:  pkg.Source,
+			Targets: pkg.Targets,
+			DryRun:  dryRun,
+			Options: pkg.HookOptions,
+		}
+
+		return hooks.Run(command, ctx, rawOut(cmd), rawErr(cmd))
+	},
+}
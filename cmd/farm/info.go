@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info <package>",
+	Short: "Show the resolved config and links of one package",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		pkg, err := lookupPackage(cfg, args[0])
+		if err != nil {
+			return err
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		deadLinks, err := lock.FindDeadSymlinks()
+		if err != nil {
+			return fmt.Errorf("failed to check symlinks: %w", err)
+		}
+		dead := make(map[string]bool, len(deadLinks))
+		for _, link := range deadLinks {
+			dead[link.Target] = true
+		}
+
+		links, deadCount := 0, 0
+		var lastLinked time.Time
+		for _, link := range lock.Symlinks {
+			if cfg.FindPackage(link.Source) != pkg {
+				continue
+			}
+			links++
+			if dead[link.Target] {
+				deadCount++
+			}
+			if link.LastVerified().After(lastLinked) {
+				lastLinked = link.LastVerified()
+			}
+		}
+
+		cmd.Printf("%s: %s\n", pkg.Name, pkg.Source)
+		cmd.Printf("  targets: %s\n", strings.Join(pkg.Targets, ", "))
+		if len(pkg.Environments) > 0 {
+			cmd.Printf("  environments: %s\n", strings.Join(pkg.Environments, ", "))
+		}
+		cmd.Printf("  links: %s\n", healthCounts(links, deadCount))
+		if lastLinked.IsZero() {
+			cmd.Println("  last linked: never")
+		} else {
+			cmd.Printf("  last linked: %s\n", lastLinked.Local().Format(time.DateTime))
+		}
+
+		cmd.Println("\nFolding:")
+		switch {
+		case pkg.AutoFold:
+			cmd.Println("  automatic")
+		case pkg.DefaultFold:
+			cmd.Println("  directories are folded by default")
+		default:
+			cmd.Println("  directories are only folded when listed")
+		}
+		for _, pattern := range pkg.Fold {
+			cmd.Printf("  fold: %s\n", pattern)
+		}
+		for _, pattern := range pkg.NoFold {
+			cmd.Printf("  no_fold: %s\n", pattern)
+		}
+
+		cmd.Println("\nIgnore patterns:")
+		for _, pattern := range cfg.IgnoreGlobs {
+			if p := config.ParseIgnore(pattern); p.Package == "" || p.Package == pkg.Name {
+				cmd.Printf("  %s\n", p)
+			}
+		}
+
+		if len(pkg.Hooks) > 0 {
+			cmd.Println("\nHooks:")
+			for _, name := range pkg.HookNames() {
+				cmd.Printf("  %s: %s\n", name, pkg.Hooks[name])
+			}
+		}
+
+		var data strings.Builder
+		encoder := yaml.NewEncoder(&data)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(pkg); err != nil {
+			return fmt.Errorf("failed to encode package: %w", err)
+		}
+		cmd.Println("\nResolved config:")
+		for _, line := range strings.Split(strings.TrimRight(data.String(), "\n"), "\n") {
+			cmd.Printf("  %s\n", line)
+		}
+
+		return nil
+	},
+}
+
+// lookupPackage returns the package with the given name, failing when there
+// is none or the name is shared by several packages.
+func lookupPackage(cfg *config.Config, name string) (*config.Package, error) {
+	matches := cfg.FindPackagesByName(name)
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("package %s not found", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("package name %s is ambiguous, give the packages distinct names", name)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var initDetect bool
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a starter config and ignore file for a new dotfiles repo",
+	Long: `Create a starter config and ignore file for a new dotfiles repo.
+
+The config is written to the --config path, farm.yaml by default, and a
+` + config.IgnoreFile + ` for patterns that are never linked next to it. With
+--detect, each directory next to the config that isn't hidden becomes a
+package linked into the home directory. Existing files are left alone
+unless --force is given.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := filepath.Dir(configPath)
+		ignorePath := filepath.Join(dir, config.IgnoreFile)
+
+		if _, err := os.Stat(configPath); err == nil && !force {
+			return fmt.Errorf("%s already exists, pass --force to replace it", configPath)
+		}
+
+		var packages []string
+		if initDetect {
+			var err error
+			if packages, err = detectPackages(dir); err != nil {
+				return err
+			}
+		}
+
+		writeIgnore := true
+		if _, err := os.Stat(ignorePath); err == nil && !force {
+			writeIgnore = false
+		}
+
+		if dryRun {
+			cmd.Printf("Will create %s with %d packages\n", configPath, len(packages))
+			for _, name := range packages {
+				cmd.Printf("  + %s\n", name)
+			}
+			if writeIgnore {
+				cmd.Printf("Will create %s\n", ignorePath)
+			}
+			return nil
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := os.WriteFile(configPath, []byte(starterConfig(packages)), 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+		cmd.Printf("✓ Created %s with %d packages\n", configPath, len(packages))
+		if verbose {
+			for _, name := range packages {
+				cmd.Printf("  + %s\n", name)
+			}
+		}
+
+		if writeIgnore {
+			if err := os.WriteFile(ignorePath, []byte(starterIgnore), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", ignorePath, err)
+			}
+			cmd.Printf("✓ Created %s\n", ignorePath)
+		}
+
+		cmd.Println("Run 'farm link --dry-run' to see what would be linked")
+		return nil
+	},
+}
+
+// detectPackages returns the directories in dir that look like packages,
+// which is every one that isn't hidden, like .git.
+func detectPackages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var packages []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			packages = append(packages, entry.Name())
+		}
+	}
+	return packages, nil
+}
+
+// starterConfig writes a config linking each package into the home
+// directory, with a commented example when there are none.
+func starterConfig(packages []string) string {
+	var b strings.Builder
+	b.WriteString("# Each package is a directory whose contents are linked into its targets.\n")
+	b.WriteString("# See the README for folding, environments, hooks, and everything else.\n")
+
+	if len(packages) == 0 {
+		b.WriteString("packages: []\n")
+		b.WriteString("#  - source: ./zsh\n")
+		b.WriteString("#    targets: [\"~\"]\n")
+		return b.String()
+	}
+
+	b.WriteString("packages:\n")
+	for _, name := range packages {
+		fmt.Fprintf(&b, "  - source: ./%s\n", name)
+		b.WriteString("    targets: [\"~\"]\n")
+	}
+	return b.String()
+}
+
+const starterIgnore = `# Patterns of files farm never links, one per line, like the ignore list in
+# the config. .git*, README*, LICENSE*, and .DS_Store are always ignored.
+*.swp
+*~
+`
@@ -2,23 +2,84 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsck"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/git"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/hooks"
+	"github.com/mskelton/farm/internal/i18n"
 	"github.com/mskelton/farm/internal/linker"
 	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+	"github.com/mskelton/farm/internal/plugin"
+	"github.com/mskelton/farm/internal/prompt"
+	"github.com/mskelton/farm/internal/sources"
 	"github.com/spf13/cobra"
 )
 
 var (
-	configPath   string
-	lockfilePath string
-	dryRun       bool
-	verbose      bool
-	environment  string
+	configPath     string
+	lockfilePath   string
+	dryRun         bool
+	verbose        bool
+	environment    string
+	outputPath     string
+	maxChanges     int
+	force          bool
+	skipFailed     bool
+	noClean        bool
+	cleanAll       bool
+	targetBase     string
+	assumeYes      bool
+	nonInteractive bool
+	language       string
+	planFormat     string
+	explainPlan    bool
+	answersPath    string
+	userName       string
+	gitDir         string
+	workTree       string
+	minimalProfile string
+	linkPaths      []string
+	// verbosity counts the -v flags, verbose is set from the first
+	verbosity int
 )
 
+// verbosityValue is the value of -v, which can be repeated for more detail
+// like -vv. Counting starts over whenever verbose was cleared.
+type verbosityValue struct{}
+
+func (verbosityValue) String() string { return strconv.Itoa(verbosity) }
+func (verbosityValue) Type() string   { return "count" }
+
+func (verbosityValue) Set(value string) error {
+	if !verbose {
+		verbosity = 0
+	}
+	if value == "+1" {
+		verbosity++
+	} else {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		verbosity = n
+	}
+	verbose = verbosity > 0
+	return nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "farm",
 	Short: "A dotfile manager with advanced symlink management",
@@ -28,6 +89,20 @@ var rootCmd = &cobra.Command{
 - Granular folding/no-folding control
 - Automatic cleanup of dead symlinks`,
 	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Runs through sudo link for the user who ran sudo, not root
+		u, err := home.Detect(userName)
+		if err != nil {
+			return err
+		}
+		home.Set(u)
+
+		if err := setBareRepo(); err != nil {
+			return err
+		}
+
+		return i18n.SetLanguage(i18n.Detect(language))
+	},
 }
 
 var linkCmd = &cobra.Command{
@@ -36,11 +111,20 @@ var linkCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get environment from args if provided
+		args = environmentArgs(args)
 		if len(args) > 0 {
 			environment = args[0]
 		}
 
-		cfg, err := config.Load(configPath)
+		tmpl, err := parseTemplate(planFormat, &linker.LinkResult{})
+		if err != nil {
+			return err
+		}
+		if tmpl == nil && planFormat != "" && planFormat != "json" && planFormat != "yaml" {
+			return fmt.Errorf("unknown format %q, expected json, yaml, or a Go template", planFormat)
+		}
+
+		cfg, err := loadConfig()
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
 		}
@@ -51,15 +135,48 @@ var linkCmd = &cobra.Command{
 
 		// Filter packages for the specified environment
 		packages := cfg.GetPackagesForEnvironment(environment)
+		if minimalProfile != "" {
+			if environment != "" {
+				return fmt.Errorf("--minimal can't be combined with an environment")
+			}
+			if packages, err = cfg.GetMinimalPackages(minimalProfile); err != nil {
+				return err
+			}
+		}
 		if len(packages) == 0 {
 			if environment != "" {
-				cmd.Printf("No packages found for environment '%s'\n", environment)
+				cmd.Println(i18n.Sprintf("No packages found for environment '%s'", environment))
 				available := cfg.GetAvailableEnvironments()
 				if len(available) > 0 {
-					cmd.Printf("Available environments: %v\n", available)
+					cmd.Println(i18n.Sprintf("Available environments: %v", available))
 				}
 				return nil
 			}
+
+			// Without packages all that's left to do is removing dead links,
+			// which is too surprising to do unless asked for
+			cmd.Println(i18n.Sprintf("No packages configured in %s", configPath))
+			if !cleanAll {
+				cmd.Println(i18n.Sprintf("Run with --clean-all to clean up dead links"))
+				return nil
+			}
+		}
+
+		// Remote sources are fetched on first use, `farm update` refreshes them
+		if err := fetchRemoteSources(cmd, packages, false); err != nil {
+			return err
+		}
+
+		if err := extractArchives(cmd, packages); err != nil {
+			return err
+		}
+
+		if err := checkSubmodules(cmd, packages); err != nil {
+			return err
+		}
+
+		if err := checkRepos(cmd, cfg, packages); err != nil {
+			return err
 		}
 
 		// Create a temporary config with filtered packages
@@ -67,21 +184,94 @@ var linkCmd = &cobra.Command{
 			Packages:    packages,
 			Ignore:      cfg.Ignore,
 			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		if skipFailed {
+			filteredConfig.Settings.SkipFailedTargets = true
+		}
+		if noClean {
+			cleanOnLink := false
+			filteredConfig.Settings.CleanOnLink = &cleanOnLink
+		}
+		filteredConfig.Settings.CleanAll = cleanAll
+		if filteredConfig.Settings.Paths, err = sourcePaths(linkPaths); err != nil {
+			return err
 		}
 
-		lock, err := lockfile.Load(lockfilePath)
+		lock, err := loadLockfile()
 		if err != nil {
 			return fmt.Errorf("failed to load lockfile: %w", err)
 		}
 
+		if conflictReport != "" {
+			return writeConflictReport(cmd, filteredConfig, lock)
+		}
+
+		if err := confirmForeignLinks(cmd, filteredConfig, lock); err != nil {
+			return err
+		}
+
+		if adoptExisting {
+			if err := adoptConflicts(cmd, filteredConfig, lock); err != nil {
+				return err
+			}
+		}
+
+		if err := checkMaxChanges(cmd, cfg, filteredConfig, lock); err != nil {
+			return err
+		}
+
+		plugins, missing := plugin.Enabled(os.Getenv("PATH"), cfg.Settings.Plugins)
+		for _, name := range missing {
+			cmd.Printf("⚠ plugin %s is enabled but %s%s isn't on PATH\n", name, plugin.Prefix, name)
+		}
+		if err := dispatchPrePlan(cmd, plugins, packages, filteredConfig, lock); err != nil {
+			return err
+		}
+
+		if err := runHooks(cmd, packages, hooks.PreLink, nil); err != nil {
+			return err
+		}
+
+		// Compared before linking, which records the new hashes. Lockfiles
+		// without them can't tell config edits from drift.
+		hashed := len(lock.ConfigHashes) > 0
+		changes := configChanges(cfg, packages, lock)
+
 		l := linker.New(filteredConfig, lock, dryRun)
 		result, err := l.Link()
 		if err != nil {
 			return fmt.Errorf("failed to link: %w", err)
 		}
 
-		if verbose || dryRun {
-			printResult(cmd, result, dryRun)
+		if tmpl != nil {
+			if err := tmpl.Execute(rawOut(cmd), result); err != nil {
+				return fmt.Errorf("failed to format result: %w", err)
+			}
+		} else if planFormat != "" {
+			data, err := result.Plan.Marshal(planFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(rawOut(cmd), string(data))
+		} else if explainPlan {
+			fmt.Fprint(rawOut(cmd), result.Plan.Explain())
+		} else {
+			if verbose || dryRun {
+				printResult(cmd, result, dryRun)
+				if hashed {
+					printChangeOrigins(cmd, packages, changes, result)
+				}
+			}
+			if verbose {
+				printUnusedPatterns(cmd, result)
+			}
+			if verbosity > 1 {
+				printIgnored(cmd, result)
+			}
+			for _, warning := range result.Warnings {
+				cmd.Printf("⚠ %s\n", warning)
+			}
 		}
 
 		if !dryRun {
@@ -90,16 +280,34 @@ var linkCmd = &cobra.Command{
 			}
 			envMsg := ""
 			if environment != "" {
-				envMsg = fmt.Sprintf(" for environment '%s'", environment)
+				envMsg = i18n.Sprintf(" for environment '%s'", environment)
+			}
+			if planFormat == "" && !explainPlan {
+				cmd.Printf("✓ %s\n", i18n.Sprintf("Linked %d files, removed %d dead links%s", len(result.Created), len(result.Removed), envMsg))
+			}
+			refreshFontCache(cmd, result)
+
+			if err := plugin.Dispatch(plugins, plugin.EventPostApply, newPostApplyPayload(result), rawErr(cmd)); err != nil {
+				cmd.Printf("⚠ %v\n", err)
 			}
-			cmd.Printf("✓ Linked %d files, removed %d dead links%s\n", len(result.Created), len(result.Removed), envMsg)
 		}
 
-		if len(result.Errors) > 0 {
-			cmd.Println("\nErrors:")
-			for _, err := range result.Errors {
-				cmd.Printf("  ✗ %v\n", err)
+		if len(result.Errors) == 0 {
+			if err := installExtensions(cmd, extensionPackages(packages, hashed, changes, result)); err != nil {
+				return err
+			}
+
+			if err := runHooks(cmd, packages, hooks.PostLink, result); err != nil {
+				return err
 			}
+		}
+
+		if len(result.Errors) == 0 && !dryRun && planFormat == "" && !explainPlan {
+			printMessages(cmd, packages, result)
+		}
+
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
 			return fmt.Errorf("linking completed with %d errors", len(result.Errors))
 		}
 
@@ -113,11 +321,12 @@ var unlinkCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get environment from args if provided
+		args = environmentArgs(args)
 		if len(args) > 0 {
 			environment = args[0]
 		}
 
-		cfg, err := config.Load(configPath)
+		cfg, err := loadConfig()
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
 		}
@@ -130,10 +339,10 @@ var unlinkCmd = &cobra.Command{
 		packages := cfg.GetPackagesForEnvironment(environment)
 		if len(packages) == 0 {
 			if environment != "" {
-				cmd.Printf("No packages found for environment '%s'\n", environment)
+				cmd.Println(i18n.Sprintf("No packages found for environment '%s'", environment))
 				available := cfg.GetAvailableEnvironments()
 				if len(available) > 0 {
-					cmd.Printf("Available environments: %v\n", available)
+					cmd.Println(i18n.Sprintf("Available environments: %v", available))
 				}
 				return nil
 			}
@@ -144,9 +353,11 @@ var unlinkCmd = &cobra.Command{
 			Packages:    packages,
 			Ignore:      cfg.Ignore,
 			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
 		}
+		filteredConfig.Settings.RemoveEmptyDirs = removeEmptyDirs
 
-		lock, err := lockfile.Load(lockfilePath)
+		lock, err := loadLockfile()
 		if err != nil {
 			return fmt.Errorf("failed to load lockfile: %w", err)
 		}
@@ -159,9 +370,9 @@ var unlinkCmd = &cobra.Command{
 
 		if verbose || dryRun {
 			if dryRun {
-				cmd.Printf("Will remove symlinks:\n")
+				cmd.Println(i18n.Sprintf("Will remove symlinks:"))
 			} else {
-				cmd.Printf("Removed symlinks:\n")
+				cmd.Println(i18n.Sprintf("Removed symlinks:"))
 			}
 			for _, removed := range result.Removed {
 				cmd.Printf("  - %s\n", removed)
@@ -174,16 +385,20 @@ var unlinkCmd = &cobra.Command{
 			}
 			envMsg := ""
 			if environment != "" {
-				envMsg = fmt.Sprintf(" for environment '%s'", environment)
+				envMsg = i18n.Sprintf(" for environment '%s'", environment)
 			}
-			cmd.Printf("✓ Removed %d symlinks%s\n", len(result.Removed), envMsg)
+			cmd.Printf("✓ %s\n", i18n.Sprintf("Removed %d symlinks%s", len(result.Removed), envMsg))
+			refreshFontCache(cmd, result)
+		}
+
+		printEmptyDirs(cmd, result)
+
+		for _, warning := range result.Warnings {
+			cmd.Printf("⚠ %s\n", warning)
 		}
 
 		if len(result.Errors) > 0 {
-			cmd.Println("\nErrors:")
-			for _, err := range result.Errors {
-				cmd.Printf("  ✗ %v\n", err)
-			}
+			printErrors(cmd, result)
 			return fmt.Errorf("unlinking completed with %d errors", len(result.Errors))
 		}
 
@@ -191,39 +406,142 @@ var unlinkCmd = &cobra.Command{
 	},
 }
 
+var removeEmptyDirs bool
+
+// printEmptyDirs lists the directories unlink left empty, or removed along
+// with the links.
+func printEmptyDirs(cmd *cobra.Command, result *linker.LinkResult) {
+	if len(result.EmptyDirs) == 0 {
+		return
+	}
+
+	switch {
+	case removeEmptyDirs && dryRun:
+		cmd.Println("\n" + i18n.Sprintf("Will remove empty directories:"))
+	case removeEmptyDirs:
+		cmd.Println("\n" + i18n.Sprintf("Removed empty directories:"))
+	case dryRun:
+		cmd.Println("\n" + i18n.Sprintf("Will leave empty directories:"))
+	default:
+		cmd.Println("\n" + i18n.Sprintf("Left empty directories:"))
+	}
+	for _, dir := range result.EmptyDirs {
+		cmd.Printf("  - %s\n", dir)
+	}
+
+	if !removeEmptyDirs {
+		cmd.Println(i18n.Sprintf("Run with --remove-empty-dirs to remove them"))
+	}
+}
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean [environment]",
+	Short: "Remove dead symlinks without linking",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args = environmentArgs(args)
+		if len(args) > 0 {
+			environment = args[0]
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := validateEnvironmentArg(args, cfg); err != nil {
+			return err
+		}
+
+		filteredConfig := &config.Config{
+			Packages:    cfg.GetPackagesForEnvironment(environment),
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		filteredConfig.Settings.CleanAll = cleanAll
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		result, err := linker.New(filteredConfig, lock, dryRun).Clean()
+		if err != nil {
+			return fmt.Errorf("failed to clean: %w", err)
+		}
+
+		if verbose || dryRun {
+			printResult(cmd, result, dryRun)
+		}
+
+		if !dryRun {
+			if err := lock.Save(lockfilePath); err != nil {
+				return fmt.Errorf("failed to save lockfile: %w", err)
+			}
+			cmd.Printf("✓ %s\n", i18n.Sprintf("Removed %d dead links", len(result.Removed)))
+			refreshFontCache(cmd, result)
+		}
+
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+			return fmt.Errorf("cleaning completed with %d errors", len(result.Errors))
+		}
+
+		return nil
+	},
+}
+
 var statusCmd = &cobra.Command{
 	Use:   "status [environment]",
 	Short: "Show status of symlinks",
 	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get environment from args if provided
+		args = environmentArgs(args)
 		if len(args) > 0 {
 			environment = args[0]
 		}
 
-		lock, err := lockfile.Load(lockfilePath)
+		tmpl, err := parseTemplate(statusFormat, &statusOutput{})
+		if err != nil {
+			return err
+		}
+		if tmpl == nil && statusFormat != "" {
+			return fmt.Errorf("unknown format %q, expected a Go template", statusFormat)
+		}
+
+		if (verbose || statusTree) && tmpl == nil {
+			defer startPager(cmd)()
+		}
+
+		lock, claims, err := loadStatusLockfiles()
 		if err != nil {
 			return fmt.Errorf("failed to load lockfile: %w", err)
 		}
 
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if len(claims) > 0 && tmpl == nil {
+			defer printConflictingClaims(cmd, claims)
+		}
+
 		// If environment is specified, filter symlinks based on config
 		var relevantSymlinks []lockfile.Symlink
 		if environment != "" {
-			cfg, err := config.Load(configPath)
-			if err != nil {
-				return fmt.Errorf("failed to load config: %w", err)
-			}
-
 			if err := validateEnvironmentArg(args, cfg); err != nil {
 				return err
 			}
 
 			packages := cfg.GetPackagesForEnvironment(environment)
 			if len(packages) == 0 {
-				cmd.Printf("No packages found for environment '%s'\n", environment)
+				cmd.Println(i18n.Sprintf("No packages found for environment '%s'", environment))
 				available := cfg.GetAvailableEnvironments()
 				if len(available) > 0 {
-					cmd.Printf("Available environments: %v\n", available)
+					cmd.Println(i18n.Sprintf("Available environments: %v", available))
 				}
 				return nil
 			}
@@ -245,11 +563,6 @@ var statusCmd = &cobra.Command{
 			}
 		} else {
 			// Check if environment is required
-			cfg, err := config.Load(configPath)
-			if err != nil {
-				return fmt.Errorf("failed to load config: %w", err)
-			}
-
 			if err := validateEnvironmentArg(args, cfg); err != nil {
 				return err
 			}
@@ -257,113 +570,1493 @@ var statusCmd = &cobra.Command{
 			relevantSymlinks = lock.Symlinks.Sorted()
 		}
 
-		if len(relevantSymlinks) == 0 {
-			envMsg := ""
-			if environment != "" {
-				envMsg = fmt.Sprintf(" for environment '%s'", environment)
+		if tmpl != nil {
+			dead, err := lock.FindDeadSymlinks()
+			if err != nil {
+				return fmt.Errorf("failed to check for dead symlinks: %w", err)
+			}
+
+			output := &statusOutput{Environment: environment, EnvironmentSource: environmentSource, Symlinks: relevantSymlinks, Dead: dead, Shadowed: lock.FindShadowedSymlinks()}
+			if err := tmpl.Execute(rawOut(cmd), output); err != nil {
+				return fmt.Errorf("failed to format status: %w", err)
 			}
-			cmd.Printf("No symlinks tracked%s\n", envMsg)
 			return nil
 		}
 
-		if verbose {
-			envMsg := ""
-			if environment != "" {
-				envMsg = fmt.Sprintf(" for environment '%s'", environment)
+		if len(relevantSymlinks) == 0 {
+			envMsg := environmentMsg()
+			cmd.Println(i18n.Sprintf("No symlinks tracked%s", envMsg))
+			printExtensionDrift(cmd, cfg.GetPackagesForEnvironment(environment))
+			return nil
+		}
+
+		deadLinks, err := lock.FindDeadSymlinks()
+		if err != nil {
+			return fmt.Errorf("failed to check for dead symlinks: %w", err)
+		}
+
+		if statusTree {
+			envMsg := environmentMsg()
+			cmd.Printf("%s:\n", i18n.Sprintf("Tracking %d symlinks%s", len(relevantSymlinks), envMsg))
+
+			dead := make(map[string]bool, len(deadLinks))
+			for _, link := range deadLinks {
+				dead[link.Target] = true
 			}
-			cmd.Printf("Tracking %d symlinks%s:\n\n", len(relevantSymlinks), envMsg)
+			printStatusTree(cmd, cfg, relevantSymlinks, dead)
+		} else if verbose {
+			envMsg := environmentMsg()
+			cmd.Printf("%s:\n\n", i18n.Sprintf("Tracking %d symlinks%s", len(relevantSymlinks), envMsg))
 
 			for _, link := range relevantSymlinks {
 				cmd.Printf("  %s -> %s", link.Target, link.Source)
 				if link.IsFolded {
 					cmd.Print(" [folded]")
 				}
+				if link.Kind != "" {
+					cmd.Printf(" [%s]", link.Kind)
+				}
+				// Label links by repo when linking from several repositories
+				if len(cfg.Repos) > 0 {
+					if pkg := cfg.FindPackage(link.Source); pkg != nil && pkg.Repo != "" {
+						cmd.Printf(" (%s)", pkg.Repo)
+					}
+				}
 				cmd.Println()
+				if !link.Created.IsZero() {
+					cmd.Printf("      created %s, verified %s\n", link.Created.Local().Format(time.DateTime), link.LastVerified().Local().Format(time.DateTime))
+				}
 			}
+			printTargetStatus(cmd, cfg, relevantSymlinks)
 		} else {
-			envMsg := ""
-			if environment != "" {
-				envMsg = fmt.Sprintf(" for environment '%s'", environment)
-			}
-			cmd.Printf("Tracking %d symlinks%s\n", len(relevantSymlinks), envMsg)
-		}
-
-		deadLinks, err := lock.GetDeadSymlinks()
-		if err != nil {
-			return fmt.Errorf("failed to check for dead symlinks: %w", err)
+			envMsg := environmentMsg()
+			cmd.Println(i18n.Sprintf("Tracking %d symlinks%s", len(relevantSymlinks), envMsg))
 		}
 
 		if len(deadLinks) > 0 {
-			cmd.Printf("\n⚠ Found %d dead symlinks:\n", len(deadLinks))
+			cmd.Printf("\n⚠ %s\n", i18n.Sprintf("Found %d dead symlinks:", len(deadLinks)))
 			for _, dead := range deadLinks {
-				cmd.Printf("  ✗ %s\n", dead)
+				if !verbose {
+					cmd.Printf("  ✗ %s\n", dead.Target)
+					continue
+				}
+
+				cmd.Printf("  ✗ %s (%s)\n", dead.Target, dead.Reason)
+				if len(dead.Chain) > 1 {
+					cmd.Printf("    %s\n", strings.Join(dead.Chain, " -> "))
+				}
 			}
 			envMsg := ""
 			if environment != "" {
 				envMsg = fmt.Sprintf(" %s", environment)
 			}
-			cmd.Printf("\nRun 'farm link%s' to clean up dead symlinks\n", envMsg)
+			if cfg.Settings.CleansOnLink() {
+				cmd.Printf("\n%s\n", i18n.Sprintf("Run 'farm link%s' to clean up dead symlinks", envMsg))
+			} else {
+				cmd.Printf("\n%s\n", i18n.Sprintf("Run 'farm clean%s' to clean up dead symlinks", envMsg))
+			}
 		}
 
+		printShadowedSymlinks(cmd, lock.FindShadowedSymlinks())
+		printConfigChanges(cmd, configChanges(cfg, cfg.GetPackagesForEnvironment(environment), lock))
+		printStalePackages(cmd, findStalePackages(cfg, cfg.GetPackagesForEnvironment(environment), lock), environment)
+		printExtensionDrift(cmd, cfg.GetPackagesForEnvironment(environment))
+
 		return nil
 	},
 }
 
-func printResult(cmd *cobra.Command, result *linker.LinkResult, isDryRun bool) {
-	if len(result.Created) > 0 {
-		if isDryRun {
-			cmd.Println("Will create symlinks:")
-		} else {
-			cmd.Println("Created symlinks:")
-		}
-		for _, created := range result.Created {
-			cmd.Printf("  + %s\n", created)
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Verify the integrity of everything farm manages",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
 		}
-	}
 
-	if len(result.Removed) > 0 {
-		if isDryRun {
-			cmd.Println("\nWill remove dead symlinks:")
-		} else {
-			cmd.Println("\nRemoved dead symlinks:")
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
 		}
-		for _, removed := range result.Removed {
-			cmd.Printf("  - %s\n", removed)
+
+		// All packages are considered regardless of environment, like lock gc
+		problems := fsck.Check(cfg, lock)
+		cmd.Printf("Checked %d entries\n", len(lock.Symlinks))
+
+		if len(problems) == 0 {
+			cmd.Println("✓ No problems found")
+			return nil
 		}
-	}
-}
 
-func hasEnvironmentPackages(cfg *config.Config) bool {
-	for _, pkg := range cfg.Packages {
-		if len(pkg.Environments) > 0 {
-			return true
+		cmd.Printf("\n✗ Found %d problems:\n", len(problems))
+		for _, problem := range problems {
+			cmd.Printf("  %s: %s\n", problem.Target, problem.Issue)
+			if problem.Repair != "" {
+				cmd.Printf("    → %s\n", problem.Repair)
+			}
 		}
-	}
-	return false
+
+		return fmt.Errorf("fsck found %d problems", len(problems))
+	},
 }
 
-func validateEnvironmentArg(args []string, cfg *config.Config) error {
-	if hasEnvironmentPackages(cfg) && len(args) == 0 {
-		available := cfg.GetAvailableEnvironments()
+var unfoldCmd = &cobra.Command{
+	Use:   "unfold <target-path>",
+	Short: "Replace a folded directory symlink with per-file symlinks",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := config.ExpandPath(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid target path: %w", err)
+		}
 
-		return fmt.Errorf("environment not specified (available environments: %v)", available)
-	}
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
 
-	return nil
-}
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		l := linker.New(cfg, lock, dryRun)
+		result, err := l.Unfold(target)
+		if err != nil {
+			return fmt.Errorf("failed to unfold: %w", err)
+		}
+
+		if verbose || dryRun {
+			printResult(cmd, result, dryRun)
+		}
+
+		if !dryRun {
+			if err := lock.Save(lockfilePath); err != nil {
+				return fmt.Errorf("failed to save lockfile: %w", err)
+			}
+			cmd.Printf("✓ Unfolded %s into %d symlinks\n", target, len(result.Created))
+		}
+
+		if len(result.Errors) > 0 {
+			cmd.Println("\n" + i18n.Sprintf("Errors:"))
+			for _, err := range result.Errors {
+				cmd.Printf("  ✗ %v\n", err)
+			}
+			return fmt.Errorf("unfolding completed with %d errors", len(result.Errors))
+		}
+
+		return nil
+	},
+}
+
+var foldCmd = &cobra.Command{
+	Use:   "fold <target-path>",
+	Short: "Fold a directory unfolded with 'farm unfold' again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := config.ExpandPath(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid target path: %w", err)
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		l := linker.New(cfg, lock, dryRun)
+		result, err := l.Fold(target)
+		if err != nil {
+			return fmt.Errorf("failed to fold: %w", err)
+		}
+
+		if verbose || dryRun {
+			printResult(cmd, result, dryRun)
+		}
+
+		if !dryRun {
+			if err := lock.Save(lockfilePath); err != nil {
+				return fmt.Errorf("failed to save lockfile: %w", err)
+			}
+			if len(result.Created) > 0 {
+				cmd.Printf("✓ Folded %s\n", target)
+			} else {
+				cmd.Printf("✓ %s is no longer kept unfolded, but its package doesn't fold it\n", target)
+			}
+		}
+
+		if len(result.Errors) > 0 {
+			cmd.Println("\n" + i18n.Sprintf("Errors:"))
+			for _, err := range result.Errors {
+				cmd.Printf("  ✗ %v\n", err)
+			}
+			return fmt.Errorf("folding completed with %d errors", len(result.Errors))
+		}
+
+		return nil
+	},
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Fetch the latest version of remote package sources",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		return fetchRemoteSources(cmd, cfg.Packages, true)
+	},
+}
+
+var updateAssetsCmd = &cobra.Command{
+	Use:   "update-assets [environment]",
+	Short: "Download every package download again and replace changed files",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args = environmentArgs(args)
+		if len(args) > 0 {
+			environment = args[0]
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := validateEnvironmentArg(args, cfg); err != nil {
+			return err
+		}
+
+		filteredConfig := &config.Config{
+			Packages:    cfg.GetPackagesForEnvironment(environment),
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		result, err := linker.New(filteredConfig, lock, dryRun).UpdateDownloads()
+		if err != nil {
+			return fmt.Errorf("failed to update downloads: %w", err)
+		}
+
+		if verbose || dryRun {
+			printResult(cmd, result, dryRun)
+		}
+
+		if !dryRun {
+			if err := lock.Save(lockfilePath); err != nil {
+				return fmt.Errorf("failed to save lockfile: %w", err)
+			}
+			cmd.Printf("✓ Updated %d downloads\n", len(result.Created))
+		}
+
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+			return fmt.Errorf("update completed with %d errors", len(result.Errors))
+		}
+
+		return nil
+	},
+}
+
+func fetchRemoteSources(cmd *cobra.Command, packages []*config.Package, refresh bool) error {
+	fetched, err := sources.Fetch(packages, refresh, dryRun, func(source string) {
+		if dryRun {
+			cmd.Printf("Will fetch %s\n", source)
+		} else if verbose {
+			cmd.Printf("Fetching %s\n", source)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if refresh && !dryRun {
+		cmd.Printf("✓ Updated %d remote sources\n", fetched)
+	}
+
+	return nil
+}
+
+// extractArchives extracts the archive sources that weren't extracted yet or
+// changed since into the cache, so packages are linked from their current
+// contents.
+func extractArchives(cmd *cobra.Command, packages []*config.Package) error {
+	return sources.Extract(packages, func(archive string) {
+		if verbose {
+			cmd.Printf("Extracting %s\n", archive)
+		}
+	})
+}
+
+// checkSubmodules warns about packages whose source lives in a submodule
+// that hasn't been checked out, since linking them silently does nothing,
+// and offers to initialize it.
+func checkSubmodules(cmd *cobra.Command, packages []*config.Package) error {
+	checked := make(map[string]bool)
+
+	for _, pkg := range packages {
+		root, submodule, ok := git.UninitializedSubmodule(pkg.Source)
+		if !ok || checked[filepath.Join(root, submodule)] {
+			continue
+		}
+		checked[filepath.Join(root, submodule)] = true
+
+		cmd.Printf("⚠ Package source %s is inside the uninitialized submodule %s\n", pkg.Source, submodule)
+		if dryRun {
+			continue
+		}
+
+		ok, err := confirm(cmd, "Run 'git submodule update --init' for it?", false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := git.InitSubmodule(root, submodule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setBareRepo points farm's git checks at a bare dotfiles repo when
+// --git-dir is given, for dotfiles managed like the `config` alias does.
+func setBareRepo() error {
+	if gitDir == "" {
+		if workTree != "" {
+			return fmt.Errorf("--work-tree needs --git-dir")
+		}
+		return git.SetBareRepo("", "")
+	}
+
+	dir, err := config.ExpandPath(gitDir)
+	if err != nil {
+		return err
+	}
+
+	tree := workTree
+	if tree == "" {
+		tree, err = home.Dir()
+	} else {
+		tree, err = config.ExpandPath(tree)
+	}
+	if err != nil {
+		return err
+	}
+
+	return git.SetBareRepo(dir, tree)
+}
+
+// checkRepos enforces require_clean and require_up_to_date for the
+// repositories the packages are linked from, so a half-edited or outdated
+// checkout isn't deployed. The lockfile is expected to change with every
+// link and doesn't count as uncommitted. Dry runs only warn.
+func checkRepos(cmd *cobra.Command, cfg *config.Config, packages []*config.Package) error {
+	clean, upToDate := cfg.Settings.RequireClean, cfg.Settings.RequireUpToDate
+	if !clean.Enabled() && !upToDate.Enabled() {
+		return nil
+	}
+
+	lockPath, _ := filepath.Abs(lockfilePath)
+
+	var problems []string
+	refuse := false
+	checked := make(map[string]bool)
+	for _, pkg := range packages {
+		if pkg.Remote != "" {
+			continue
+		}
+
+		root, ok := git.Root(pkg.Source)
+		if !ok || checked[root] {
+			continue
+		}
+		checked[root] = true
+
+		if clean.Enabled() {
+			dirty, err := git.Dirty(root)
+			if err != nil {
+				return err
+			}
+			dirty = slices.DeleteFunc(dirty, func(path string) bool {
+				return fsutil.SamePath(path, lockPath)
+			})
+			if len(dirty) > 0 {
+				problems = append(problems, fmt.Sprintf("%s has %d uncommitted changes", root, len(dirty)))
+				refuse = refuse || clean == config.RequireOn
+			}
+		}
+
+		if upToDate.Enabled() {
+			behind, err := git.Behind(root)
+			if err != nil {
+				return err
+			}
+			if behind > 0 {
+				problems = append(problems, fmt.Sprintf("%s is %d commits behind its upstream", root, behind))
+				refuse = refuse || upToDate == config.RequireOn
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if !refuse || dryRun {
+		for _, problem := range problems {
+			cmd.Printf("⚠ %s\n", problem)
+		}
+		return nil
+	}
+	return fmt.Errorf("refusing to link: %s", strings.Join(problems, ", "))
+}
+
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins",
+	Short: "Manage farm-<name> plugins found on PATH",
+}
+
+var pluginsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List plugins and the events they handle",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		plugins := plugin.Find(os.Getenv("PATH"))
+		if len(plugins) == 0 {
+			cmd.Println("No plugins found")
+			return nil
+		}
+
+		// Listing works without a config, every plugin is disabled then.
+		// Only enabled plugins are run to ask for their events.
+		var names []string
+		if cfg, err := loadConfig(); err == nil {
+			names = cfg.Settings.Plugins
+		}
+		queried, _ := plugin.Enabled(os.Getenv("PATH"), names)
+		enabled := make(map[string]*plugin.Plugin, len(queried))
+		for _, p := range queried {
+			enabled[p.Name] = p
+		}
+
+		for _, p := range plugins {
+			info, ok := enabled[p.Name]
+			cmd.Printf("  %s", p.Name)
+			if ok && info.Description != "" {
+				cmd.Printf(" - %s", info.Description)
+			}
+			if ok {
+				cmd.Print(" (enabled)")
+			}
+			cmd.Println()
+
+			events := "not asked until enabled in settings.plugins"
+			if ok {
+				events = "none"
+				if len(info.Events) > 0 {
+					events = strings.Join(info.Events, ", ")
+				}
+			}
+			cmd.Printf("    path: %s\n    events: %s\n", p.Path, events)
+		}
+
+		return nil
+	},
+}
+
+type pluginPackage struct {
+	Source  string   `json:"source"`
+	Targets []string `json:"targets"`
+}
+
+type prePlanPayload struct {
+	Environment string          `json:"environment"`
+	DryRun      bool            `json:"dry_run"`
+	Packages    []pluginPackage `json:"packages"`
+	Plan        *plan.Plan      `json:"plan"`
+}
+
+type postApplyPayload struct {
+	Environment string   `json:"environment"`
+	Created     []string `json:"created"`
+	Removed     []string `json:"removed"`
+	Replaced    []string `json:"replaced"`
+	Errors      []string `json:"errors"`
+}
+
+// dispatchPrePlan plans the link and sends the plan to the plugins handling
+// pre-plan, any of which can abort the run. Nothing is planned when none do.
+func dispatchPrePlan(cmd *cobra.Command, plugins []*plugin.Plugin, packages []*config.Package, filteredConfig *config.Config, lock *lockfile.LockFile) error {
+	if !plugin.Handled(plugins, plugin.EventPrePlan) {
+		return nil
+	}
+
+	result, err := linker.New(filteredConfig, lock.Clone(), true).Link()
+	if err != nil {
+		return fmt.Errorf("failed to plan link: %w", err)
+	}
+	return plugin.Dispatch(plugins, plugin.EventPrePlan, newPrePlanPayload(packages, result.Plan.Sorted()), rawErr(cmd))
+}
+
+func newPrePlanPayload(packages []*config.Package, plan *plan.Plan) prePlanPayload {
+	payload := prePlanPayload{
+		Environment: environment,
+		DryRun:      dryRun,
+		Packages:    []pluginPackage{},
+		Plan:        plan,
+	}
+
+	for _, pkg := range packages {
+		payload.Packages = append(payload.Packages, pluginPackage{
+			Source:  pkg.Source,
+			Targets: pkg.Targets,
+		})
+	}
+
+	return payload
+}
+
+func newPostApplyPayload(result *linker.LinkResult) postApplyPayload {
+	payload := postApplyPayload{
+		Environment: environment,
+		Created:     result.Created,
+		Removed:     result.Removed,
+		Replaced:    result.Replaced,
+		Errors:      []string{},
+	}
+
+	for _, err := range result.Errors {
+		payload.Errors = append(payload.Errors, err.Error())
+	}
+
+	return payload
+}
+
+var reposCmd = &cobra.Command{
+	Use:   "repos",
+	Short: "List the source repositories in the config",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if len(cfg.Repos) == 0 {
+			cmd.Println("No repos configured")
+			return nil
+		}
+
+		for _, name := range cfg.RepoNames() {
+			repo := cfg.Repos[name]
+			cmd.Printf("  %s: %s", name, repo.Root)
+			if repo.Remote != "" {
+				cmd.Printf(" (%s)", repo.Remote)
+			}
+			if _, err := os.Stat(repo.Root); os.IsNotExist(err) {
+				cmd.Print(" [missing]")
+			}
+			cmd.Println()
+		}
+
+		return nil
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the packages in the config",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if len(cfg.Packages) == 0 {
+			cmd.Println("No packages configured")
+			return nil
+		}
+
+		for _, pkg := range cfg.Packages {
+			cmd.Printf("%s: %s\n", pkg.Name, pkg.Source)
+			cmd.Printf("  targets: %s\n", strings.Join(pkg.Targets, ", "))
+			if len(pkg.Environments) > 0 {
+				cmd.Printf("  environments: %s\n", strings.Join(pkg.Environments, ", "))
+			}
+			if len(pkg.Hooks) > 0 {
+				cmd.Printf("  hooks: %s\n", strings.Join(pkg.HookNames(), ", "))
+			}
+		}
+
+		return nil
+	},
+}
+
+var reposCloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Clone missing repositories from their remotes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		cloned := 0
+		for _, name := range cfg.RepoNames() {
+			repo := cfg.Repos[name]
+			if _, err := os.Stat(repo.Root); err == nil || repo.Remote == "" {
+				continue
+			}
+
+			if dryRun {
+				cmd.Printf("Will clone %s into %s\n", repo.Remote, repo.Root)
+				continue
+			}
+
+			git := exec.Command("git", "clone", repo.Remote, repo.Root)
+			git.Stdout = rawOut(cmd)
+			git.Stderr = rawErr(cmd)
+			if err := git.Run(); err != nil {
+				return fmt.Errorf("failed to clone repo %s: %w", name, err)
+			}
+			cloned++
+		}
+
+		if !dryRun {
+			cmd.Printf("✓ Cloned %d repos\n", cloned)
+		}
+
+		return nil
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect and manage the lockfile",
+}
+
+var lockExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the lockfile as human-readable YAML",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		baseDir, homeDir, err := lockfileDirs()
+		if err != nil {
+			return err
+		}
+
+		if exportArchive {
+			if outputPath == "" || outputPath == "-" {
+				return fmt.Errorf("--archive needs a file to write to, set with -o")
+			}
+			return writeExportArchive(cmd, lock, outputPath, homeDir)
+		}
+
+		data, err := lock.ExportYAML(baseDir, homeDir)
+		if err != nil {
+			return fmt.Errorf("failed to export lockfile: %w", err)
+		}
+
+		if outputPath == "" || outputPath == "-" {
+			if signKey != "" {
+				return fmt.Errorf("--sign-key needs a file to write to, set with -o")
+			}
+			fmt.Fprint(rawOut(cmd), string(data))
+			return nil
+		}
+
+		if err := os.WriteFile(outputPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", outputPath, err)
+		}
+		if err := signExport(cmd, outputPath); err != nil {
+			return err
+		}
+		cmd.Printf("✓ Exported %d symlinks to %s\n", len(lock.Symlinks), outputPath)
+
+		return nil
+	},
+}
+
+var lockImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Replace the lockfile with the contents of an exported YAML file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := verifyExport(cmd, args[0]); err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", args[0], err)
+		}
+
+		baseDir, homeDir, err := lockfileDirs()
+		if err != nil {
+			return err
+		}
+
+		imported, err := lockfile.ImportYAML(data, baseDir, homeDir)
+		if err != nil {
+			return fmt.Errorf("failed to import lockfile: %w", err)
+		}
+
+		// With --target-base only that namespace is replaced
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+		lock.Replace(imported)
+
+		if dryRun {
+			cmd.Printf("Will import %d symlinks into %s\n", len(lock.Symlinks), lockfilePath)
+			return nil
+		}
+
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+		cmd.Printf("✓ Imported %d symlinks into %s\n", len(lock.Symlinks), lockfilePath)
+
+		return nil
+	},
+}
+
+var portableOff bool
+
+var lockPortableCmd = &cobra.Command{
+	Use:   "portable",
+	Short: "Store lockfile paths relative to the repo and home directory",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Portability applies to the whole file, namespaces included
+		lock, err := lockfile.Load(lockfilePath)
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		if lock.Portable == !portableOff {
+			if portableOff {
+				cmd.Printf("%s already stores absolute paths\n", lockfilePath)
+			} else {
+				cmd.Printf("%s is already portable\n", lockfilePath)
+			}
+			return nil
+		}
+
+		if dryRun {
+			if portableOff {
+				cmd.Printf("Will store absolute paths in %s\n", lockfilePath)
+			} else {
+				cmd.Printf("Will make %s portable\n", lockfilePath)
+			}
+			return nil
+		}
+
+		lock.Portable = !portableOff
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+
+		if portableOff {
+			cmd.Printf("✓ Stored absolute paths in %s\n", lockfilePath)
+		} else {
+			cmd.Printf("✓ Made %s portable\n", lockfilePath)
+		}
+		return nil
+	},
+}
+
+var lockGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove symlinks whose source no longer belongs to any package",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		// All packages are considered regardless of environment, so links
+		// from other environments are never treated as orphans
+		l := linker.New(cfg, lock, dryRun)
+		orphans := l.Orphans()
+		if len(orphans) == 0 {
+			cmd.Println("No orphaned symlinks found")
+			return nil
+		}
+
+		cmd.Printf("Found %d orphaned symlinks:\n", len(orphans))
+		for _, link := range orphans {
+			cmd.Printf("  - %s -> %s\n", link.Target, link.Source)
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		ok, err := confirm(cmd, fmt.Sprintf("\nRemove %d orphaned symlinks?", len(orphans)), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			cmd.Println("Aborted")
+			return nil
+		}
+
+		result := l.RemoveOrphans(orphans)
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+		cmd.Printf("✓ Removed %d orphaned symlinks\n", len(result.Removed))
+
+		if len(result.Errors) > 0 {
+			cmd.Println("\n" + i18n.Sprintf("Errors:"))
+			for _, err := range result.Errors {
+				cmd.Printf("  ✗ %v\n", err)
+			}
+			return fmt.Errorf("garbage collection completed with %d errors", len(result.Errors))
+		}
+
+		return nil
+	},
+}
+
+func confirm(cmd *cobra.Command, question string, defaultAnswer bool) (bool, error) {
+	p, err := prompter(cmd)
+	if err != nil {
+		return false, err
+	}
+	return p.Confirm(question, defaultAnswer)
+}
+
+// prompter returns where answers to prompts come from: the answers file when
+// one is given, the terminal when it's interactive, and otherwise the
+// default answer.
+func prompter(cmd *cobra.Command) (prompt.Prompter, error) {
+	if answersPath != "" {
+		return prompt.Load(answersPath, cmd.OutOrStdout())
+	}
+
+	// Automation takes the answer without waiting for input
+	if assumeYes || !isInteractive(cmd) {
+		return &prompt.Auto{Out: cmd.OutOrStdout(), Yes: assumeYes}, nil
+	}
+
+	return prompt.NewTerminal(cmd.InOrStdin(), cmd.OutOrStdout()), nil
+}
+
+// isInteractive reports whether prompts can be answered, which is not the
+// case when running from scripts with stdin redirected from a non-terminal.
+func isInteractive(cmd *cobra.Command) bool {
+	if nonInteractive {
+		return false
+	}
+
+	file, ok := cmd.InOrStdin().(*os.File)
+	if !ok {
+		return true
+	}
+
+	return isTerminal(file)
+}
+
+// lockfileDirs returns the directories that exported lockfile paths are
+// made relative to.
+// loadConfig loads the config, moving every target under --target-base when
+// it is set.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	displayRoot = cfg.BaseDir
+
+	if err := applyTargetBase(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// applyTargetBase moves the targets of cfg under --target-base, if set.
+func applyTargetBase(cfg *config.Config) error {
+	if targetBase == "" {
+		return nil
+	}
+
+	base, err := config.ExpandPath(targetBase)
+	if err != nil {
+		return fmt.Errorf("invalid target base: %w", err)
+	}
+	cfg.RebaseTargets(base)
+	return nil
+}
+
+// loadLockfile loads the lockfile, or the namespace of it for --target-base
+// so experiments never touch the entries for the real targets.
+func loadLockfile() (*lockfile.LockFile, error) {
+	if targetBase == "" {
+		return lockfile.Load(lockfilePath)
+	}
+
+	base, err := config.ExpandPath(targetBase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target base: %w", err)
+	}
+	return lockfile.LoadNamespace(lockfilePath, base)
+}
+
+// statusLockfiles are the lockfiles given to status, which shadows the global
+// --lockfile flag so it can be repeated.
+var statusLockfiles []string
+
+// loadStatusLockfiles loads the lockfiles given to status, merged into a
+// single read-only view where earlier lockfiles win. Targets tracked by
+// several of them from different sources are returned along with the entry of
+// every lockfile that claims them.
+func loadStatusLockfiles() (*lockfile.LockFile, map[string][]claim, error) {
+	if len(statusLockfiles) == 0 {
+		lock, err := loadLockfile()
+		return lock, nil, err
+	}
+
+	var merged *lockfile.LockFile
+	locks := make([]*lockfile.LockFile, len(statusLockfiles))
+	claims := make(map[string][]claim)
+	for i, path := range statusLockfiles {
+		lockfilePath = path
+		lock, err := loadLockfile()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		locks[i] = lock
+
+		if merged == nil {
+			merged = lock.Clone()
+			continue
+		}
+		for _, target := range merged.Merge(lock) {
+			claims[target] = nil
+		}
+	}
+
+	for target := range claims {
+		for i, lock := range locks {
+			if link, ok := lock.Symlinks[target]; ok {
+				claims[target] = append(claims[target], claim{lockfile: statusLockfiles[i], link: link})
+			}
+		}
+	}
+
+	return merged, claims, nil
+}
+
+// claim is the entry one of several merged lockfiles has for a target.
+type claim struct {
+	lockfile string
+	link     lockfile.Symlink
+}
+
+func printConflictingClaims(cmd *cobra.Command, claims map[string][]claim) {
+	cmd.Printf("\n⚠ %s\n", i18n.Sprintf("Found %d conflicting claims:", len(claims)))
+	for _, target := range slices.Sorted(maps.Keys(claims)) {
+		cmd.Printf("  ✗ %s\n", target)
+		for _, c := range claims[target] {
+			cmd.Printf("    %s: %s", c.lockfile, c.link.Source)
+			if c.link.Kind != "" {
+				cmd.Printf(" [%s]", c.link.Kind)
+			}
+			cmd.Println()
+		}
+	}
+}
+
+func lockfileDirs() (string, string, error) {
+	lockPath, err := filepath.Abs(lockfilePath)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid lockfile path: %w", err)
+	}
+
+	homeDir, err := home.Dir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+
+	return filepath.Dir(lockPath), homeDir, nil
+}
+
+// checkMaxChanges plans the link against a copy of the lockfile and refuses
+// to continue when it would remove or replace more symlinks than allowed.
+func checkMaxChanges(cmd *cobra.Command, cfg, filteredConfig *config.Config, lock *lockfile.LockFile) error {
+	limit := cfg.Settings.MaxChanges
+	if cmd.Flags().Changed("max-changes") {
+		limit = maxChanges
+	}
+
+	if limit <= 0 || dryRun || force || assumeYes {
+		return nil
+	}
+
+	plan, err := linker.New(filteredConfig, lock.Clone(), true).Link()
+	if err != nil {
+		return fmt.Errorf("failed to plan link: %w", err)
+	}
+
+	changes := len(plan.Removed) + len(plan.Replaced)
+	if changes <= limit {
+		return nil
+	}
+
+	if verbose {
+		for _, removed := range plan.Removed {
+			cmd.Printf("  - %s\n", removed)
+		}
+		for _, replaced := range plan.Replaced {
+			cmd.Printf("  ~ %s\n", replaced)
+		}
+	}
+
+	return fmt.Errorf("link would remove or replace %d symlinks, more than the limit of %d (use --force or --yes to proceed)", changes, limit)
+}
+
+func printResult(cmd *cobra.Command, result *linker.LinkResult, isDryRun bool) {
+	if len(result.Created) > 0 {
+		if isDryRun {
+			cmd.Println(i18n.Sprintf("Will create symlinks:"))
+		} else {
+			cmd.Println(i18n.Sprintf("Created symlinks:"))
+		}
+		for _, created := range result.Created {
+			cmd.Printf("  + %s%s\n", created, planReason(result, plan.Create, created))
+		}
+	}
+
+	if len(result.CreatedDirs) > 0 {
+		if isDryRun {
+			cmd.Println("\n" + i18n.Sprintf("Will create directories:"))
+		} else {
+			cmd.Println("\n" + i18n.Sprintf("Created directories:"))
+		}
+		for _, dir := range result.CreatedDirs {
+			if dir.Mode != 0 {
+				cmd.Printf("  + %s (%#o)\n", dir.Path, dir.Mode)
+			} else {
+				cmd.Printf("  + %s\n", dir.Path)
+			}
+		}
+	}
+
+	if len(result.SkippedTargets) > 0 {
+		cmd.Println("\n" + i18n.Sprintf("Skipped optional targets:"))
+		for _, target := range result.SkippedTargets {
+			cmd.Printf("  - %s\n", target)
+		}
+	}
+
+	for _, base := range result.MissingBases {
+		cmd.Printf("\n⚠ %s doesn't exist, mark the target optional to skip it when missing\n", base)
+	}
+
+	if len(result.Replaced) > 0 {
+		if isDryRun {
+			cmd.Println("\n" + i18n.Sprintf("Will replace symlinks:"))
+		} else {
+			cmd.Println("\n" + i18n.Sprintf("Replaced symlinks:"))
+		}
+		for _, replaced := range result.Replaced {
+			cmd.Printf("  ~ %s%s\n", replaced, planReason(result, plan.Replace, replaced))
+		}
+	}
+
+	if len(result.Removed) > 0 {
+		if isDryRun {
+			cmd.Println("\n" + i18n.Sprintf("Will remove dead symlinks:"))
+		} else {
+			cmd.Println("\n" + i18n.Sprintf("Removed dead symlinks:"))
+		}
+		for _, removed := range result.Removed {
+			cmd.Printf("  - %s%s\n", removed, planReason(result, plan.Remove, removed))
+		}
+	}
+
+	if isDryRun && len(result.Diffs) > 0 {
+		cmd.Println("\n" + i18n.Sprintf("Will change file content:"))
+		for _, target := range slices.Sorted(maps.Keys(result.Diffs)) {
+			cmd.Print(result.Diffs[target])
+		}
+	}
+}
+
+// planReason returns the reason behind a change to target, formatted to
+// follow it, when running verbosely.
+func planReason(result *linker.LinkResult, kind plan.Kind, target string) string {
+	if !verbose {
+		return ""
+	}
+	if reason := result.Plan.Reason(kind, target); reason != "" {
+		return " (" + reason + ")"
+	}
+	return ""
+}
+
+// printUnusedPatterns lists the ignore, fold, and no_fold patterns that
+// matched nothing, which usually points at a typo or a stale rule.
+func printUnusedPatterns(cmd *cobra.Command, result *linker.LinkResult) {
+	if len(result.UnusedPatterns) == 0 {
+		return
+	}
+
+	cmd.Println("\n⚠ " + i18n.Sprintf("Unused patterns:"))
+	for _, p := range result.UnusedPatterns {
+		ignore := config.ParseIgnore(p.Pattern)
+		if p.Kind == linker.PatternIgnore && (ignore.Package != "" || ignore.Anchored) {
+			// Spell out where scoped patterns apply, a common reason they match nothing
+			cmd.Printf("  - %s %s (%s)\n", p.Kind, p.Pattern, ignore)
+		} else if p.Package == "" {
+			cmd.Printf("  - %s %s\n", p.Kind, p.Pattern)
+		} else {
+			cmd.Printf("  - %s %s (%s)\n", p.Kind, p.Pattern, p.Package)
+		}
+	}
+}
+
+// printIgnored lists what the ignore patterns left out of the link, with
+// the pattern that excluded each path.
+func printIgnored(cmd *cobra.Command, result *linker.LinkResult) {
+	if len(result.Ignored) == 0 {
+		return
+	}
+
+	cmd.Println("\n" + i18n.Sprintf("Ignored paths:"))
+	for _, file := range result.Ignored {
+		cmd.Printf("  - %s (%s)\n", file.Source, file.Pattern)
+	}
+}
+
+// printMessages prints each package's follow-up reminders once, skipping
+// messages for unchanged packages that only apply when something changed.
+func printMessages(cmd *cobra.Command, packages []*config.Package, result *linker.LinkResult) {
+	var messages []string
+	seen := make(map[string]bool)
+
+	for _, pkg := range packages {
+		for _, msg := range pkg.Messages {
+			if msg.When == config.MessageChanged && !result.Changed[pkg.Source] {
+				continue
+			}
+			if seen[msg.Text] {
+				continue
+			}
+			seen[msg.Text] = true
+			messages = append(messages, msg.Text)
+		}
+	}
+
+	if len(messages) == 0 {
+		return
+	}
+
+	cmd.Println("\n" + i18n.Sprintf("Next steps:"))
+	for _, msg := range messages {
+		cmd.Printf("  • %s\n", msg)
+	}
+}
+
+// printTargetStatus breaks the tracked links down by target for packages
+// linked into more than one place, so a target that failed to link stands out.
+func printTargetStatus(cmd *cobra.Command, cfg *config.Config, links []lockfile.Symlink) {
+	printed := false
+	for _, pkg := range cfg.GetPackagesForEnvironment(environment) {
+		if len(pkg.Targets) < 2 {
+			continue
+		}
+
+		if !printed {
+			cmd.Println("\nTargets:")
+			printed = true
+		}
+
+		for _, target := range pkg.Targets {
+			count := 0
+			for _, link := range links {
+				if cfg.FindPackage(link.Source) == pkg && (link.Target == target || strings.HasPrefix(link.Target, target+"/")) {
+					count++
+				}
+			}
+
+			if count == 0 {
+				cmd.Printf("  ✗ %s -> %s: not linked\n", pkg.Name, target)
+			} else {
+				cmd.Printf("  ✓ %s -> %s: %d symlinks\n", pkg.Name, target, count)
+			}
+		}
+	}
+}
+
+// refreshFontCache rebuilds the fontconfig cache on Linux after fonts were
+// installed or removed. macOS picks up changes to ~/Library/Fonts by itself.
+func refreshFontCache(cmd *cobra.Command, result *linker.LinkResult) {
+	if !result.FontsChanged || runtime.GOOS != "linux" {
+		return
+	}
+
+	if _, err := exec.LookPath("fc-cache"); err != nil {
+		return
+	}
+
+	if out, err := exec.Command("fc-cache", "-f").CombinedOutput(); err != nil {
+		cmd.Printf("⚠ Failed to refresh the font cache: %v: %s\n", err, strings.TrimSpace(string(out)))
+	} else if verbose {
+		cmd.Println("Refreshed the font cache")
+	}
+}
+
+// printErrors lists errors, keeping filesystem operations that kept failing
+// after retries apart since they usually point at an unreachable home
+// directory rather than a config problem.
+func printErrors(cmd *cobra.Command, result *linker.LinkResult) {
+	persistent := result.Persistent()
+	isPersistent := make(map[error]bool)
+	for _, err := range persistent {
+		isPersistent[err] = true
+	}
+
+	if len(persistent) < len(result.Errors) {
+		cmd.Println("\n" + i18n.Sprintf("Errors:"))
+		for _, err := range result.Errors {
+			if !isPersistent[err] {
+				cmd.Printf("  ✗ %v\n", err)
+			}
+		}
+	}
+
+	if len(persistent) > 0 {
+		cmd.Println("\n" + i18n.Sprintf("Persistent filesystem errors:"))
+		for _, err := range persistent {
+			cmd.Printf("  ✗ %v\n", err)
+		}
+	}
+}
+
+func hasEnvironmentPackages(cfg *config.Config) bool {
+	for _, pkg := range cfg.Packages {
+		if len(pkg.Environments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Where the environment in use came from, shown by status.
+const (
+	envFromArgument = "argument"
+	envFromVariable = "FARM_ENV"
+)
+
+var environmentSource string
+
+// environmentArgs returns the arguments of a command taking an environment,
+// falling back to the one set in FARM_ENV when none is given, and records
+// where the environment came from.
+func environmentArgs(args []string) []string {
+	environmentSource = ""
+	if len(args) > 0 {
+		environmentSource = envFromArgument
+	} else if env := os.Getenv("FARM_ENV"); env != "" {
+		args = []string{env}
+		environmentSource = envFromVariable
+	}
+	return args
+}
+
+// environmentMsg describes the environment status shows and where it came
+// from, or nothing without one.
+func environmentMsg() string {
+	if environment == "" {
+		return ""
+	}
+
+	msg := i18n.Sprintf(" for environment '%s'", environment)
+	if environmentSource == envFromVariable {
+		return msg + i18n.Sprintf(" (from FARM_ENV)")
+	}
+	return msg + i18n.Sprintf(" (from the command line)")
+}
+
+// sourcePaths cleans the paths given with --path, which are relative to the
+// package sources.
+func sourcePaths(paths []string) ([]string, error) {
+	var cleaned []string
+	for _, path := range paths {
+		path = filepath.ToSlash(filepath.Clean(path))
+		if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, "../") {
+			return nil, fmt.Errorf("--path %s must be a path inside the package sources, such as .config/nvim", path)
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return nil, fmt.Errorf("--path %s: %w", path, err)
+		}
+		cleaned = append(cleaned, path)
+	}
+	return cleaned, nil
+}
+
+func validateEnvironmentArg(args []string, cfg *config.Config) error {
+	if hasEnvironmentPackages(cfg) && len(args) == 0 {
+		available := cfg.GetAvailableEnvironments()
+
+		return fmt.Errorf("environment not specified (available environments: %v)", available)
+	}
+
+	return nil
+}
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "farm.yaml", "config file path")
 	rootCmd.PersistentFlags().StringVarP(&lockfilePath, "lockfile", "l", "farm.lock", "lockfile path")
 	rootCmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "n", false, "perform a dry run")
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().VarPF(verbosityValue{}, "verbose", "v", "verbose output, repeat for more detail").NoOptDefVal = "+1"
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "answer yes to all prompts")
+	rootCmd.PersistentFlags().BoolVar(&noPager, "no-pager", false, "don't pipe long output into $PAGER")
+	rootCmd.PersistentFlags().BoolVar(&fullPaths, "full-paths", false, "print paths in full rather than starting with ~ and ./")
+	rootCmd.PersistentFlags().StringVar(&answersPath, "answers", "", "answer prompts from this YAML file of prerecorded answers")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "never prompt, use the default answer instead")
+	rootCmd.PersistentFlags().StringVar(&language, "lang", "", "language of the output of link, unlink, clean, and status, defaults to the LANG environment variable")
+	rootCmd.PersistentFlags().StringVar(&userName, "user", "", "link for this user, expanding ~ to their home and handing them what farm creates")
+	rootCmd.PersistentFlags().StringVar(&gitDir, "git-dir", "", "git directory of a bare dotfiles repo, used for the git checks of sources in its work tree")
+	rootCmd.PersistentFlags().StringVar(&workTree, "work-tree", "", "work tree of the bare repo given with --git-dir, defaults to the home directory")
+	rootCmd.PersistentFlags().StringVar(&targetBase, "target-base", "", "link every target under this directory instead, tracked separately in the lockfile")
+
+	for _, c := range []*cobra.Command{linkCmd, applyCmd} {
+		c.Flags().IntVar(&maxChanges, "max-changes", 0, "abort if more than this many symlinks would be removed or replaced")
+		c.Flags().BoolVarP(&force, "force", "f", false, "proceed even if the change limit is exceeded")
+		c.Flags().BoolVar(&skipFailed, "skip-failed-targets", false, "link a package's other targets when one of them fails")
+		c.Flags().BoolVar(&noClean, "no-clean", false, "leave dead links in place, use 'farm clean' to remove them")
+		c.Flags().BoolVar(&cleanAll, "clean-all", false, "remove dead links of every package, not only the ones being linked")
+	}
 
+	linkCmd.Flags().BoolVar(&adoptExisting, "adopt", false, "move files in the way of links into their packages, replacing the sources")
+	linkCmd.Flags().StringVar(&conflictReport, "conflict-report", "", "write every conflict to this file for 'farm resolve' instead of linking")
 	rootCmd.AddCommand(linkCmd)
+	unlinkCmd.Flags().BoolVar(&removeEmptyDirs, "remove-empty-dirs", false, "remove the directories unlinking leaves empty")
 	rootCmd.AddCommand(unlinkCmd)
+	linkCmd.Flags().StringVar(&planFormat, "format", "", "print the plan as json or yaml, or the result formatted with a Go template, instead of text")
+	linkCmd.Flags().BoolVar(&explainPlan, "explain-plan", false, "print every planned action with the reason for it, one per line separated by tabs")
+	linkCmd.Flags().StringArrayVar(&linkPaths, "path", nil, "link only this path inside the package sources, whose parts may be globs, repeat for several")
+	linkCmd.Flags().StringVar(&minimalProfile, "minimal", "", "link only the packages of this minimal profile, copying their files by default")
+	statusCmd.Flags().StringVar(&statusFormat, "format", "", "print the status formatted with a Go template instead of text")
+	graphCmd.Flags().StringVar(&graphFormat, "format", "dot", "graph format, dot or mermaid")
+	statusCmd.Flags().StringArrayVarP(&statusLockfiles, "lockfile", "l", nil, "lockfile path, repeat to show several merged read-only")
+	statusCmd.Flags().BoolVar(&statusTree, "tree", false, "group links by package and target")
+	cleanCmd.Flags().BoolVar(&cleanAll, "clean-all", false, "remove dead links of every package, not only the ones being cleaned")
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(resolveCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(mvCmd)
+	adoptCmd.Flags().StringVarP(&adoptPackage, "package", "p", "", "package to adopt the files into")
+	adoptCmd.Flags().BoolVarP(&force, "force", "f", false, "replace sources that already exist")
+	rootCmd.AddCommand(adoptCmd)
+	initCmd.Flags().BoolVar(&initDetect, "detect", false, "add a package for each directory next to the config")
+	initCmd.Flags().BoolVarP(&force, "force", "f", false, "replace the config and ignore file when they exist")
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(fixturesCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(whichCmd)
+	rootCmd.AddCommand(whyIgnoredCmd)
+	rootCmd.AddCommand(editCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(fsckCmd)
+
+	testCmd.Flags().BoolVar(&keepSandbox, "keep", false, "keep the sandbox directory for inspection")
+	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(unfoldCmd)
+	rootCmd.AddCommand(foldCmd)
+	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(updateAssetsCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(bundleCmd)
+	applyCmd.Flags().BoolVar(&resumeApply, "resume", false, "continue an apply that stopped halfway, skipping the steps it completed")
+	rootCmd.AddCommand(applyCmd)
+
+	for _, c := range []*cobra.Command{stowCmd, stowDeleteCmd, stowRestowCmd} {
+		c.Flags().StringVarP(&stowDir, "dir", "d", "", "stow directory containing the packages (default: current directory)")
+		c.Flags().StringVarP(&stowTarget, "target", "t", "", "target directory (default: parent of the stow directory)")
+		c.Flags().BoolVar(&stowNoFolding, "no-folding", false, "link files individually instead of folding directories")
+		rootCmd.AddCommand(c)
+	}
+	stowCmd.Flags().BoolVarP(&stowLink, "stow", "S", false, "link the packages (default)")
+	stowCmd.Flags().BoolVarP(&stowDelete, "delete", "D", false, "unlink the packages")
+	stowCmd.Flags().BoolVarP(&stowRestow, "restow", "R", false, "unlink and relink the packages")
+
+	pluginsCmd.AddCommand(pluginsListCmd)
+	rootCmd.AddCommand(pluginsCmd)
+
+	reposCmd.AddCommand(reposCloneCmd)
+	rootCmd.AddCommand(reposCmd)
+
+	lockExportCmd.Flags().StringVarP(&outputPath, "output", "o", "", "write to a file instead of stdout")
+	lockExportCmd.Flags().BoolVar(&exportArchive, "archive", false, "write the linked files themselves into a .tar.gz for 'farm apply-archive'")
+	lockExportCmd.Flags().StringVar(&signKey, "sign-key", "", "sign the export with this SSH key, writing the signature next to it")
+	lockCmd.AddCommand(lockExportCmd)
+	lockImportCmd.Flags().StringVar(&allowedSigners, "allowed-signers", "", "refuse exports not signed by a key in this allowed signers file")
+	lockCmd.AddCommand(lockImportCmd)
+	lockCmd.AddCommand(lockGCCmd)
+	lockPortableCmd.Flags().BoolVar(&portableOff, "off", false, "store absolute paths again")
+	lockCmd.AddCommand(lockPortableCmd)
+	rootCmd.AddCommand(lockCmd)
+	applyArchiveCmd.Flags().StringVar(&allowedSigners, "allowed-signers", "", "refuse archives not signed by a key in this allowed signers file")
+	rootCmd.AddCommand(applyArchiveCmd)
+	packageCmd.Flags().StringVar(&packageEnv, "env", "", "environment whose packages are packaged")
+	packageCmd.Flags().StringVarP(&outputPath, "output", "o", "", "archive to write")
+	packageCmd.Flags().StringVar(&signKey, "sign-key", "", "sign the archive with this SSH key, writing the signature next to it")
+	rootCmd.AddCommand(packageCmd)
+
+	// Extend cobra's default completion command with an install subcommand
+	rootCmd.InitDefaultCompletionCmd()
+	completionCmd, _, _ := rootCmd.Find([]string{"completion"})
+	completionInstallCmd.Flags().StringVar(&completionShell, "shell", "", "shell to install completions for (default: $SHELL)")
+	completionInstallCmd.Flags().StringVar(&completionSourceDir, "source-dir", "", "write the script into this directory and symlink it into place")
+	completionCmd.AddCommand(completionInstallCmd)
 }
 
 func main() {
+	rootCmd.SetOut(displayPaths(os.Stdout))
+	rootCmd.SetErr(displayPaths(os.Stderr))
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
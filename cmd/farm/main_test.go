@@ -1,11 +1,25 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mskelton/farm/fixture"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+	"github.com/mskelton/farm/internal/provision"
+	"github.com/mskelton/farm/transform"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -187,3 +201,3280 @@ func TestCLIDeadLinkCleanup(t *testing.T) {
 	_, err = os.Lstat("./target/dead.txt")
 	assert.True(t, os.IsNotExist(err))
 }
+
+func TestCLINoPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	cleanAll = false
+	defer func() { cleanAll = false }()
+
+	require.NoError(t, os.MkdirAll("source", 0755))
+	require.NoError(t, os.WriteFile("source/dead.txt", []byte("will be deleted"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	require.NoError(t, os.Remove("source/dead.txt"))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte("packages: []\n"), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	// Dead links are only cleaned up without packages when asked to
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "No packages configured in farm.yaml\nRun with --clean-all to clean up dead links\n", buf.String())
+	_, err := os.Lstat("./target/dead.txt")
+	assert.NoError(t, err)
+
+	rootCmd.SetArgs([]string{"link", "--clean-all"})
+	require.NoError(t, rootCmd.Execute())
+	_, err = os.Lstat("./target/dead.txt")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCLINoClean(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	noClean = false
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("b"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`settings:
+  clean_on_link: false
+packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "a.txt")))
+
+	// The setting keeps link purely additive
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	_, err := os.Lstat("./target/a.txt")
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Run 'farm clean' to clean up dead symlinks")
+
+	rootCmd.SetArgs([]string{"clean"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Removed 1 dead links")
+	_, err = os.Lstat("./target/a.txt")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Lstat("./target/b.txt")
+	assert.NoError(t, err)
+
+	// The flag does the same for a config that cleans on link
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "b.txt")))
+
+	rootCmd.SetArgs([]string{"link", "--no-clean"})
+	require.NoError(t, rootCmd.Execute())
+	_, err = os.Lstat("./target/b.txt")
+	assert.NoError(t, err)
+
+	noClean = false
+}
+
+func TestCLIStatusTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	statusTree = false
+
+	for _, file := range []string{"vim/.vimrc", "vim/.gvimrc", "zsh/.zshrc"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+      - ./backup
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	require.NoError(t, os.Remove("vim/.gvimrc"))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"status", "--tree"})
+	require.NoError(t, rootCmd.Execute())
+
+	home := filepath.Join(tmpDir, "home")
+	backup := filepath.Join(tmpDir, "backup")
+	out := buf.String()
+	assert.Contains(t, out, "vim (2 ok, 2 dead)\n")
+	assert.Contains(t, out, "  "+home+" (1 ok, 1 dead)\n    ✗ .gvimrc -> .gvimrc\n    ✓ .vimrc -> .vimrc\n")
+	assert.Contains(t, out, "  "+backup+" (1 ok, 1 dead)\n")
+	assert.Contains(t, out, "zsh (1 ok)\n  "+home+" (1 ok)\n    ✓ .zshrc -> .zshrc\n")
+
+	statusTree = false
+}
+
+func TestCLIStatusStale(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	for _, file := range []string{"vim/.vimrc", "zsh/.zshrc", "zsh/README.md"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	lock, err := lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	_, ok := lock.LastApplied(filepath.Join(tmpDir, "vim"))
+	assert.True(t, ok)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "changed since")
+
+	// Edits to ignored files don't need a link
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes("vim/.vimrc", later, later))
+	require.NoError(t, os.Chtimes("zsh/README.md", later, later))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "⚠ 1 packages changed since they were linked:\n  vim (changed ")
+	assert.NotContains(t, buf.String(), "  zsh (changed ")
+	assert.Contains(t, buf.String(), "Run 'farm link' to apply the changes")
+}
+
+func TestCLIConfigChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	for _, file := range []string{"vim/.vimrc", "zsh/.zshrc", "tmux/.tmux.conf"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	lock, err := lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	assert.Len(t, lock.ConfigHashes, 2)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "Config changed")
+
+	// vim's config is edited and tmux is new, while zsh only drifts
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+      - ./backup
+  - source: ./zsh
+    targets:
+      - ./home
+  - source: ./tmux
+    targets:
+      - ./home
+`), 0644))
+	require.NoError(t, os.WriteFile("zsh/.zprofile", []byte("content"), 0644))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "⚠ Config changed since linking (2 packages):\n  vim (changed)\n  tmux (added)\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "Changes from config edits: vim, tmux\n")
+	assert.Contains(t, buf.String(), "Changes from filesystem drift: zsh\n")
+
+	// Packages dropped from the config count too
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "⚠ Config changed since linking (1 packages):\n  zsh (removed)\n")
+}
+
+func TestCLIBareRepo(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() { gitDir, workTree = "", "" }()
+
+	require.NoError(t, exec.Command("git", "init", "-q", "--bare", ".cfg").Run())
+	for _, file := range []string{"nvim/init.lua", "nvim/scratch.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "--git-dir", ".cfg", "--work-tree", ".", "add", "nvim/init.lua").Run())
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./nvim
+    targets:
+      - ./home
+    only_tracked: true
+`), 0644))
+
+	// Without the bare repo the source isn't known to git
+	rootCmd.SetArgs([]string{"link"})
+	assert.ErrorContains(t, rootCmd.Execute(), "linking completed with 1 errors")
+
+	rootCmd.SetArgs([]string{"link", "--git-dir", ".cfg", "--work-tree", tmpDir})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "home/init.lua")
+	assert.NoFileExists(t, "home/scratch.lua")
+
+	gitDir, workTree = "", ""
+	rootCmd.SetArgs([]string{"status", "--work-tree", tmpDir})
+	assert.ErrorContains(t, rootCmd.Execute(), "--work-tree needs --git-dir")
+}
+
+func TestCLIStatusLockfiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	statusLockfiles = nil
+
+	for _, file := range []string{"system/.zshrc", "system/.profile", "user/.zshrc", "user/.vimrc"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	home := filepath.Join(tmpDir, "home")
+	system := lockfile.New()
+	system.AddSymlink(filepath.Join(home, ".zshrc"), filepath.Join(tmpDir, "system/.zshrc"), false)
+	system.AddSymlink(filepath.Join(home, ".profile"), filepath.Join(tmpDir, "system/.profile"), false)
+	require.NoError(t, system.Save("system.lock"))
+
+	user := lockfile.New()
+	user.AddSymlink(filepath.Join(home, ".zshrc"), filepath.Join(tmpDir, "user/.zshrc"), false)
+	user.AddSymlink(filepath.Join(home, ".vimrc"), filepath.Join(tmpDir, "user/.vimrc"), false)
+	require.NoError(t, user.Save("user.lock"))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./user
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"status", "--lockfile", "system.lock", "-l", "user.lock"})
+	require.NoError(t, rootCmd.Execute())
+
+	out := buf.String()
+	assert.Contains(t, out, "Tracking 3 symlinks")
+	assert.Contains(t, out, "Found 1 conflicting claims:\n  ✗ "+filepath.Join(home, ".zshrc")+"\n")
+	assert.Contains(t, out, "    system.lock: "+filepath.Join(tmpDir, "system/.zshrc")+"\n")
+	assert.Contains(t, out, "    user.lock: "+filepath.Join(tmpDir, "user/.zshrc")+"\n")
+
+	// Merging is read-only
+	lock, err := lockfile.Load("system.lock")
+	require.NoError(t, err)
+	assert.Len(t, lock.Symlinks, 2)
+
+	statusLockfiles = nil
+	lockfilePath = "farm.lock"
+}
+
+func TestCLIUnlinkEmptyDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	removeEmptyDirs = false
+	defer func() { removeEmptyDirs = false }()
+
+	require.NoError(t, os.MkdirAll("nvim/.config/nvim", 0755))
+	require.NoError(t, os.WriteFile("nvim/.config/nvim/init.lua", []byte("vim.o.number = true"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./nvim
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	nvim := filepath.Join(tmpDir, "home/.config/nvim")
+	config := filepath.Join(tmpDir, "home/.config")
+
+	rootCmd.SetArgs([]string{"unlink", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "Will leave empty directories:\n  - "+nvim+"\n  - "+config+"\n")
+	assert.Contains(t, buf.String(), "Run with --remove-empty-dirs to remove them")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"unlink", "--remove-empty-dirs"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Removed empty directories:\n  - "+nvim+"\n  - "+config+"\n")
+	assert.NoDirExists(t, config)
+	assert.DirExists(t, "home")
+}
+
+func TestCLIGraph(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	graphFormat = "dot"
+
+	for _, file := range []string{"vim/.vimrc", "zsh/.zshrc", "home/.zshrc"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+      - ./backup
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"graph"})
+	require.NoError(t, rootCmd.Execute())
+
+	home := filepath.Join(tmpDir, "home")
+	backup := filepath.Join(tmpDir, "backup")
+	zshrc := filepath.Join(home, ".zshrc")
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "digraph farm {\n"))
+	assert.Contains(t, out, `"package:vim" -> "target:`+home+`";`)
+	assert.Contains(t, out, `"package:vim" -> "target:`+backup+`";`)
+	assert.Contains(t, out, `"package:zsh" -> "target:`+home+`";`)
+	assert.Equal(t, 1, strings.Count(out, `"target:`+home+`" [shape=folder];`))
+	assert.Contains(t, out, `"package:zsh" -> "conflict:`+zshrc+`" [color=red`)
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"graph", "--format", "mermaid"})
+	require.NoError(t, rootCmd.Execute())
+
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "flowchart LR\n"))
+	assert.Contains(t, out, `  p0["vim"]`)
+	assert.Contains(t, out, `  t2[("`+home+`")]`)
+	assert.Contains(t, out, "  p0 --> t2\n")
+	assert.Contains(t, out, `  c4{{"`+zshrc+`"}}`)
+	assert.Contains(t, out, "  p1 -. conflict .-> c4\n")
+
+	rootCmd.SetArgs([]string{"graph", "--format", "svg"})
+	assert.Error(t, rootCmd.Execute())
+
+	graphFormat = "dot"
+	_, err := os.Stat("farm.lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCLIWhich(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("nvim/.config/nvim/lua", 0755))
+	require.NoError(t, os.WriteFile("nvim/.config/nvim/lua/init.lua", []byte("-- init"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./nvim
+    targets:
+      - ./home
+    fold:
+      - .config/nvim
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"which", "home/.config/nvim/lua/init.lua"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "package: nvim\n")
+	assert.Contains(t, buf.String(), "source:  "+filepath.Join(tmpDir, "nvim/.config/nvim/lua/init.lua")+"\n")
+	assert.Contains(t, buf.String(), "via:     "+filepath.Join(tmpDir, "home/.config/nvim")+" [folded]\n")
+
+	require.NoError(t, os.WriteFile("home/.bashrc", []byte(""), 0644))
+	rootCmd.SetArgs([]string{"which", "home/.bashrc"})
+	assert.ErrorContains(t, rootCmd.Execute(), "is not managed by farm")
+}
+
+func TestCLIWhyIgnored(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() { verbose = false }()
+
+	for _, file := range []string{"nvim/.config/nvim/init.lua", "nvim/.config/nvim/lazy-lock.json", "nvim/.config/nvim/spell/en.add", "nvim/.netrwhist"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(file), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`ignore:
+  - lazy-lock.json
+  - nvim:/.netrwhist
+  - spell
+packages:
+  - source: ./nvim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"why-ignored", "nvim/.config/nvim/lazy-lock.json"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "package: nvim\n")
+	assert.Contains(t, buf.String(), "pattern: lazy-lock.json (lazy-lock.json anywhere in every package)\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"why-ignored", "nvim/.netrwhist"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "pattern: nvim:/.netrwhist (.netrwhist from the root of package nvim)\n")
+
+	// Files in an ignored directory point at the directory
+	buf.Reset()
+	rootCmd.SetArgs([]string{"why-ignored", "nvim/.config/nvim/spell/en.add"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "pattern: spell (spell anywhere in every package)\n")
+	assert.Contains(t, buf.String(), "ignores: "+filepath.Join(tmpDir, "nvim/.config/nvim/spell")+", and everything in it\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"why-ignored", "nvim/.config/nvim/init.lua"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "not ignored\n")
+
+	rootCmd.SetArgs([]string{"why-ignored", "farm.yaml"})
+	assert.ErrorContains(t, rootCmd.Execute(), "isn't part of any package")
+
+	// Linking with -vv traces what each pattern left out
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "Ignored paths:")
+
+	buf.Reset()
+	verbose = false
+	rootCmd.SetArgs([]string{"link", "-vv"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Ignored paths:\n")
+	assert.Contains(t, buf.String(), "  - "+filepath.Join(tmpDir, "nvim/.config/nvim/lazy-lock.json")+" (lazy-lock.json)\n")
+	assert.Contains(t, buf.String(), "  - "+filepath.Join(tmpDir, "nvim/.config/nvim/spell")+" (spell)\n")
+	assert.Contains(t, buf.String(), "  - "+filepath.Join(tmpDir, "nvim/.netrwhist")+" (nvim:/.netrwhist)\n")
+}
+
+func TestCLIEdit(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("tmux/.config/tmux", 0755))
+	require.NoError(t, os.WriteFile("tmux/.config/tmux/tmux.conf", []byte("set -g mouse on"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./tmux
+    targets:
+      - ./home
+    fold:
+      - .config/tmux
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// The editor records the file it was asked to open
+	editor := filepath.Join(tmpDir, "editor.sh")
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\necho \"$1\" > "+filepath.Join(tmpDir, "edited")+"\n"), 0755))
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	// The file is opened in the source, not through the folded directory
+	rootCmd.SetArgs([]string{"edit", "home/.config/tmux/tmux.conf"})
+	require.NoError(t, rootCmd.Execute())
+
+	edited, err := os.ReadFile("edited")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "tmux/.config/tmux/tmux.conf")+"\n", string(edited))
+}
+
+func TestCLIRequireClean(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	// The targets live outside the repo, the config and lockfile inside it
+	dotfiles := filepath.Join(tmpDir, "dotfiles")
+	require.NoError(t, os.MkdirAll(dotfiles, 0755))
+	require.NoError(t, os.Chdir(dotfiles))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile("zsh/.zshrc", []byte("zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`settings:
+  require_clean: true
+packages:
+  - source: ./zsh
+    targets:
+      - ../home
+`), 0644))
+
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=farm", "-c", "user.email=farm@example.com"}, args...)
+		output, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	// The lockfile written by the link doesn't make the repo dirty
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	require.NoError(t, os.WriteFile("zsh/.zshrc", []byte("half-edited"), 0644))
+	rootCmd.SetArgs([]string{"link"})
+	assert.ErrorContains(t, rootCmd.Execute(), "has 1 uncommitted changes")
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`settings:
+  require_clean: warn
+packages:
+  - source: ./zsh
+    targets:
+      - ../home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "uncommitted changes")
+}
+
+func TestCLIPlanFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	planFormat = ""
+	defer func() { planFormat = "" }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("vim/.vimrc", []byte("set number"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"link", "--dry-run", "--format", "json"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+
+	var p plan.Plan
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &p), buf.String())
+	assert.Equal(t, []plan.Action{
+		{Kind: plan.Mkdir, Package: "vim", Source: filepath.Join(tmpDir, "vim/.vimrc"), Target: filepath.Join(tmpDir, "home"), Reason: "parent directory"},
+		{Kind: plan.Create, Package: "vim", Source: filepath.Join(tmpDir, "vim/.vimrc"), Target: filepath.Join(tmpDir, "home/.vimrc"), Reason: "new link"},
+	}, p.Actions)
+	assert.NoDirExists(t, "home")
+
+	rootCmd.SetArgs([]string{"link", "--format", "toml"})
+	assert.ErrorContains(t, rootCmd.Execute(), "unknown format")
+}
+
+func TestCLIExplainPlan(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() { explainPlan, verbose = false, false }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("vim/.vimrc", []byte("set number"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// The repo moves, leaving the link pointing at the old source
+	require.NoError(t, os.MkdirAll("dotfiles", 0755))
+	require.NoError(t, os.Rename("vim", "dotfiles/vim"))
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("vim/.vimrc", []byte("set number"), 0644))
+	require.NoError(t, os.WriteFile("dotfiles/vim/.gvimrc", []byte("set guifont"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./dotfiles/vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"link", "--dry-run", "--explain-plan"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+
+	home := filepath.Join(tmpDir, "home")
+	assert.Equal(t, "create\tvim\t"+filepath.Join(home, ".gvimrc")+"\tnew link\n"+
+		"replace\tvim\t"+filepath.Join(home, ".vimrc")+"\tsource moved from "+filepath.Join(tmpDir, "vim/.vimrc")+"\n", buf.String())
+
+	// Verbose output carries the reasons too
+	explainPlan = false
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--dry-run", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "  + "+filepath.Join(home, ".gvimrc")+" (new link)\n")
+}
+
+func TestCLIFormatTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	planFormat = ""
+	statusFormat = ""
+	defer func() { planFormat, statusFormat = "", "" }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("vim/.vimrc", []byte("set number"), 0644))
+	require.NoError(t, os.WriteFile("vim/.gvimrc", []byte("set guifont"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	// Mistakes in the template are caught before linking
+	rootCmd.SetArgs([]string{"link", "--format", "{{.Nope}}"})
+	assert.ErrorContains(t, rootCmd.Execute(), "invalid format template")
+	assert.NoDirExists(t, "home")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--format", `{{range .Created}}{{.}}\n{{end}}`})
+	require.NoError(t, rootCmd.Execute())
+
+	home := filepath.Join(tmpDir, "home")
+	assert.Equal(t, filepath.Join(home, ".gvimrc")+"\n"+filepath.Join(home, ".vimrc")+"\n", buf.String())
+
+	require.NoError(t, os.Remove("vim/.gvimrc"))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "--format", `{{len .Symlinks}}{{range .Dead}}\t{{.Target}}: {{.Reason}}{{end}}`})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "2\t"+filepath.Join(home, ".gvimrc")+": source is missing", buf.String())
+
+	rootCmd.SetArgs([]string{"status", "--format", "json"})
+	assert.ErrorContains(t, rootCmd.Execute(), "expected a Go template")
+}
+
+func TestCLIUser(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	planFormat = ""
+	defer func() { planFormat = "" }()
+	defer func() { userName = "" }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("vim/.vimrc", []byte("set number"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ~/
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetErr(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"link", "--user", "farm-no-such-user"})
+	assert.ErrorContains(t, rootCmd.Execute(), "failed to look up user farm-no-such-user")
+
+	nobody, err := home.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user to link for")
+	}
+
+	// ~ expands to the home of the user linked for
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--dry-run", "--format", "json", "--user", "nobody"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	home.Set(nil)
+
+	var p plan.Plan
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &p), buf.String())
+	require.NotEmpty(t, p.Actions)
+	assert.Equal(t, filepath.Join(nobody.Dir, ".vimrc"), p.Actions[len(p.Actions)-1].Target)
+}
+
+func TestCLIConflictReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	for _, name := range []string{".zshrc", ".vimrc", ".tmux.conf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644))
+	}
+
+	// Existing files from before the machine was managed by farm
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, ".zshrc"), []byte(".zshrc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, ".vimrc"), []byte("local"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link", "--conflict-report", "conflicts.yaml"})
+	require.NoError(t, rootCmd.Execute())
+	conflictReport = ""
+
+	// Nothing is linked until the conflicts are resolved
+	_, err := os.Lstat(filepath.Join(targetDir, ".tmux.conf"))
+	assert.True(t, os.IsNotExist(err))
+
+	report, err := os.ReadFile("conflicts.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(report), "resolution: overwrite")
+	assert.Contains(t, string(report), "resolution: backup")
+
+	rootCmd.SetArgs([]string{"resolve", "conflicts.yaml"})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	for _, name := range []string{".zshrc", ".vimrc", ".tmux.conf"} {
+		info, err := os.Lstat(filepath.Join(targetDir, name))
+		require.NoError(t, err)
+		assert.True(t, info.Mode()&os.ModeSymlink != 0, name)
+	}
+	content, err := os.ReadFile(filepath.Join(targetDir, ".vimrc.farm-backup"))
+	require.NoError(t, err)
+	assert.Equal(t, "local", string(content))
+}
+
+func TestCLIShadowedSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "settings.json"), []byte("{\n  \"theme\": \"dark\"\n}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".npmrc"), []byte("save-exact=true\n"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// An app saves its settings over the link, another writes back the same
+	settings := filepath.Join(targetDir, "settings.json")
+	require.NoError(t, os.Remove(settings))
+	require.NoError(t, os.WriteFile(settings, []byte("{\n  \"theme\": \"light\"\n}\n"), 0644))
+	npmrc := filepath.Join(targetDir, ".npmrc")
+	require.NoError(t, os.Remove(npmrc))
+	require.NoError(t, os.WriteFile(npmrc, []byte("save-exact=true\n"), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"status", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	verbose = false
+
+	output := buf.String()
+	assert.Contains(t, output, "Found 2 links replaced by files:")
+	assert.Contains(t, output, "  ! "+npmrc+" (same as source)")
+	assert.Contains(t, output, "  ! "+settings+" (modified)")
+	assert.Contains(t, output, "      -   \"theme\": \"dark\"\n      +   \"theme\": \"light\"\n")
+	assert.Contains(t, output, "Run 'farm link --conflict-report <file>' to adopt them back or relink them")
+
+	rootCmd.SetArgs([]string{"link", "--conflict-report", "conflicts.yaml"})
+	require.NoError(t, rootCmd.Execute())
+	conflictReport = ""
+
+	report, err := os.ReadFile("conflicts.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(report), "resolution: adopt")
+	assert.Contains(t, string(report), "resolution: overwrite")
+
+	rootCmd.SetArgs([]string{"resolve", "conflicts.yaml"})
+	require.NoError(t, rootCmd.Execute())
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// The saved settings were adopted back into the package
+	content, err := os.ReadFile(filepath.Join(sourceDir, "settings.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "light")
+	for _, target := range []string{settings, npmrc} {
+		info, err := os.Lstat(target)
+		require.NoError(t, err)
+		assert.True(t, info.Mode()&os.ModeSymlink != 0, target)
+	}
+}
+
+func TestCLIMerge(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	require.NoError(t, os.MkdirAll("npm", 0755))
+	require.NoError(t, os.MkdirAll("env", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("npm", ".npmrc"), []byte("save-exact=true\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("env", ".envrc"), []byte("export editor=vi\n"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./npm
+    targets:
+      - ./home
+    copy: true
+  - source: ./env
+    targets:
+      - ./home
+    transform: [test-render]
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	npmrc := filepath.Join(tmpDir, "home", ".npmrc")
+	envrc := filepath.Join(tmpDir, "home", ".envrc")
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	defer rootCmd.SetIn(nil)
+
+	rootCmd.SetArgs([]string{"merge", npmrc})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "already matches its source")
+
+	// The tool changes nothing and the edits are adopted into the source
+	t.Setenv("FARM_DIFFTOOL", "true")
+	require.NoError(t, os.WriteFile(npmrc, []byte("save-exact=false\n"), 0644))
+	buf.Reset()
+	rootCmd.SetIn(bytes.NewBufferString("y\n"))
+	rootCmd.SetArgs([]string{"merge", npmrc})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Adopted the changes to "+npmrc)
+	content, err := os.ReadFile(filepath.Join("npm", ".npmrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "save-exact=false\n", string(content))
+
+	// Rendered files can't be adopted, only reapplied or left alone
+	require.NoError(t, os.WriteFile(envrc, []byte("EXPORT EDITOR=NANO\n"), 0644))
+	buf.Reset()
+	rootCmd.SetIn(bytes.NewBufferString("n\n"))
+	rootCmd.SetArgs([]string{"merge", envrc})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "Adopt")
+	assert.Contains(t, buf.String(), "Left "+envrc+" as it is")
+
+	buf.Reset()
+	rootCmd.SetIn(bytes.NewBufferString("y\n"))
+	rootCmd.SetArgs([]string{"merge", envrc})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Reapplied "+envrc)
+	content, err = os.ReadFile(envrc)
+	require.NoError(t, err)
+	assert.Equal(t, "EXPORT EDITOR=VI\n", string(content))
+
+	// A tool that brings the target back in line needs no questions
+	t.Setenv("FARM_DIFFTOOL", "cp")
+	require.NoError(t, os.WriteFile(npmrc, []byte("registry=local\n"), 0644))
+	buf.Reset()
+	rootCmd.SetArgs([]string{"merge", npmrc})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ "+npmrc+" matches its source")
+
+	// What was merged is tracked as in sync
+	buf.Reset()
+	rootCmd.SetArgs([]string{"fsck"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "No problems found")
+
+	rootCmd.SetArgs([]string{"merge", filepath.Join("npm", ".npmrc")})
+	assert.ErrorContains(t, rootCmd.Execute(), "isn't a copied or rendered file")
+}
+
+func TestCLIDryRunDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { dryRun = false }()
+
+	require.NoError(t, os.MkdirAll("env", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("env", ".envrc"), []byte("export editor=vi\nexport pager=less\n"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./env
+    targets:
+      - ./home
+    transform: [test-render]
+`), 0644))
+
+	envrc := filepath.Join(tmpDir, "home", ".envrc")
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	// Files rendered for the first time are shown in full
+	rootCmd.SetArgs([]string{"link", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Will change file content:")
+	assert.Contains(t, buf.String(), "--- /dev/null\n+++ "+envrc+" (render)\n")
+	assert.Contains(t, buf.String(), "+EXPORT EDITOR=VI\n+EXPORT PAGER=LESS\n")
+
+	dryRun = false
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// Changes to the source show how the rendered file would change
+	require.NoError(t, os.WriteFile(filepath.Join("env", ".envrc"), []byte("export editor=nvim\nexport pager=less\n"), 0644))
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "--- "+envrc+"\n+++ "+envrc+" (render)\n")
+	assert.Contains(t, buf.String(), "-EXPORT EDITOR=VI\n+EXPORT EDITOR=NVIM\n EXPORT PAGER=LESS\n")
+
+	content, err := os.ReadFile(envrc)
+	require.NoError(t, err)
+	assert.Equal(t, "EXPORT EDITOR=VI\nEXPORT PAGER=LESS\n", string(content))
+}
+
+func TestCLIMv(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join("nvim", ".config", "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("nvim", ".config", "nvim", "init.lua"), []byte("-- init"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  # Shell
+  - source: ./zsh
+    targets:
+      - ./home
+  - source: ./nvim
+    targets:
+      - ./home
+    no_fold:
+      - .config/nvim
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd.SetArgs([]string{"mv", "missing", "elsewhere"})
+	assert.ErrorContains(t, rootCmd.Execute(), "doesn't exist")
+	rootCmd.SetArgs([]string{"mv", "farm.yaml", "config.yaml"})
+	assert.ErrorContains(t, rootCmd.Execute(), "isn't part of any package")
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"mv", "zsh", "shell/zsh", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "package zsh: source ./zsh → ./shell/zsh")
+	assert.Contains(t, buf.String(), "updating 1 config references and 1 links")
+	assert.DirExists(t, "zsh")
+
+	// Moving a package relinks its files at the same targets
+	rootCmd.SetArgs([]string{"mv", "zsh", "shell/zsh"})
+	require.NoError(t, rootCmd.Execute())
+	dest, err := filepath.EvalSymlinks(filepath.Join("home", ".zshrc"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("shell", "zsh", ".zshrc"), dest)
+
+	config, err := os.ReadFile("farm.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(config), "  # Shell\n  - source: ./shell/zsh\n")
+
+	// Moving within a package moves its links, and the entries naming it
+	rootCmd.SetArgs([]string{"mv", "nvim/.config/nvim", "nvim/.config/neovim"})
+	require.NoError(t, rootCmd.Execute())
+	_, err = os.Lstat(filepath.Join("home", ".config", "nvim", "init.lua"))
+	assert.True(t, os.IsNotExist(err))
+	dest, err = filepath.EvalSymlinks(filepath.Join("home", ".config", "neovim", "init.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("nvim", ".config", "neovim", "init.lua"), dest)
+
+	config, err = os.ReadFile("farm.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(config), "      - .config/neovim\n")
+
+	lock, err := lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	for _, link := range lock.Symlinks {
+		assert.NotContains(t, link.Source, filepath.Join(tmpDir, "zsh"))
+		assert.NotContains(t, link.Source, filepath.Join(".config", "nvim", "init.lua"))
+	}
+
+	// Nothing moves when the rewritten config would be invalid
+	rootCmd.SetArgs([]string{"mv", "nvim", "shell/zsh/nvim"})
+	assert.ErrorContains(t, rootCmd.Execute(), "would break the config")
+	assert.DirExists(t, "nvim")
+	assert.NoDirExists(t, filepath.Join("shell", "zsh", "nvim"))
+
+	// A failed relink moves the files and the config back
+	require.NoError(t, os.MkdirAll(filepath.Join("home", ".config", "vim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("home", ".config", "vim", "init.lua"), []byte("mine"), 0644))
+	before, err := os.ReadFile("farm.yaml")
+	require.NoError(t, err)
+	rootCmd.SetArgs([]string{"mv", "nvim/.config/neovim", "nvim/.config/vim"})
+	assert.ErrorContains(t, rootCmd.Execute(), "moved "+filepath.Join(tmpDir, "nvim/.config/neovim")+" back")
+	assert.FileExists(t, filepath.Join("nvim", ".config", "neovim", "init.lua"))
+	assert.NoDirExists(t, filepath.Join("nvim", ".config", "vim"))
+	after, err := os.ReadFile("farm.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"fsck"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "No problems found")
+}
+
+func TestCLIForeignLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("STOW_DIR", filepath.Join(tmpDir, "stow"))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	require.NoError(t, os.MkdirAll(filepath.Join("stow", "zsh"), 0755))
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.MkdirAll("home", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("stow", "zsh", ".zshrc"), []byte("stow"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("farm"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(tmpDir, "stow", "zsh", ".zshrc"), filepath.Join("home", ".zshrc")))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetIn(nil)
+
+	// Declining keeps stow's link in place
+	rootCmd.SetIn(bytes.NewBufferString("n\n"))
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Found 1 links of other dotfile managers in the way:\n  "+filepath.Join(tmpDir, "home", ".zshrc")+" (stow)")
+	assert.Contains(t, buf.String(), "kept "+filepath.Join(tmpDir, "home", ".zshrc"))
+	content, err := os.ReadFile(filepath.Join("home", ".zshrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "stow", string(content))
+
+	rootCmd.SetIn(bytes.NewBufferString("y\n"))
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	content, err = os.ReadFile(filepath.Join("home", ".zshrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "farm", string(content))
+}
+
+func TestCLILockExportImport(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	outputPath = ""
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644))
+
+	configContent := `packages:
+  - source: ./source
+    targets:
+      - ./target
+`
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(configContent), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd.SetArgs([]string{"lock", "export", "-o", "farm.lock.yaml"})
+	require.NoError(t, rootCmd.Execute())
+
+	exported, err := os.ReadFile("farm.lock.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), "target: ./target/file.txt")
+	assert.Contains(t, string(exported), "source: ./source/file.txt")
+
+	require.NoError(t, os.Remove("farm.lock"))
+
+	rootCmd.SetArgs([]string{"lock", "import", "farm.lock.yaml"})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd.SetArgs([]string{"status"})
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks")
+
+	// Links of --target-base runs survive the round trip
+	defer func() { targetBase = "" }()
+	rootCmd.SetArgs([]string{"link", "--target-base", "alt"})
+	require.NoError(t, rootCmd.Execute())
+	targetBase = ""
+
+	rootCmd.SetArgs([]string{"lock", "export", "-o", "farm.lock.yaml"})
+	require.NoError(t, rootCmd.Execute())
+	rootCmd.SetArgs([]string{"lock", "import", "farm.lock.yaml"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "--target-base", "alt"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks")
+}
+
+func TestCLILockGC(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	for _, pkg := range []string{"vim", "zsh"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, pkg), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, pkg, pkg+"rc"), []byte(pkg), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// Remove the zsh package from the config
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetIn(bytes.NewBufferString("n\n"))
+	rootCmd.SetArgs([]string{"lock", "gc"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Found 1 orphaned symlinks")
+	assert.Contains(t, buf.String(), "Aborted")
+	assert.FileExists(t, "./home/zshrc")
+
+	// Non-interactive runs take the default answer without reading input
+	rootCmd.SetIn(bytes.NewBufferString("y\n"))
+	rootCmd.SetArgs([]string{"lock", "gc", "--non-interactive"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "./home/zshrc")
+	nonInteractive = false
+
+	// Questions the answers file doesn't cover fail instead of guessing
+	require.NoError(t, os.WriteFile("answers.yaml", []byte("- question: submodule\n  answer: yes\n"), 0644))
+	rootCmd.SetArgs([]string{"lock", "gc", "--answers", "answers.yaml"})
+	assert.ErrorContains(t, rootCmd.Execute(), "no answer to")
+	assert.FileExists(t, "./home/zshrc")
+
+	require.NoError(t, os.WriteFile("answers.yaml", []byte("- question: orphaned symlinks\n  answer: no\n"), 0644))
+	rootCmd.SetIn(bytes.NewBufferString("y\n"))
+	rootCmd.SetArgs([]string{"lock", "gc", "--answers", "answers.yaml"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "./home/zshrc")
+	answersPath = ""
+
+	rootCmd.SetIn(bytes.NewBufferString(""))
+	rootCmd.SetArgs([]string{"lock", "gc", "--yes"})
+	require.NoError(t, rootCmd.Execute())
+	assumeYes = false
+
+	_, err := os.Lstat("./home/zshrc")
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, "./home/vimrc")
+}
+
+func TestCLIMultipleRepos(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("personal/vim", 0755))
+	require.NoError(t, os.WriteFile("personal/vim/vimrc", []byte("vim"), 0644))
+	require.NoError(t, os.MkdirAll("company/git", 0755))
+	require.NoError(t, os.WriteFile("company/git/gitconfig", []byte("git"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`repos:
+  personal:
+    root: ./personal
+  work:
+    root: ./company
+packages:
+  - repo: personal
+    source: ./vim
+    targets:
+      - ./home
+  - repo: work
+    source: ./git
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "./home/vimrc")
+	assert.FileExists(t, "./home/gitconfig")
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"status", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "gitconfig (work)")
+	assert.Contains(t, buf.String(), "vimrc (personal)")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"repos"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "personal: "+filepath.Join(tmpDir, "personal"))
+}
+
+func TestCLIDisplayPaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("HOME", filepath.Join(tmpDir, "home"))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { dryRun, verbose, fullPaths, planFormat = false, false, false, "" }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("vim", ".vimrc"), []byte("set nu"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets: ["~"]
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(displayPaths(buf))
+	defer rootCmd.SetOut(nil)
+
+	// Output meant for other programs keeps the paths in full
+	rootCmd.SetArgs([]string{"link", "--dry-run", "--format", "json"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), filepath.Join(tmpDir, "home", ".vimrc"))
+
+	dryRun = false
+	planFormat = ""
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// The home directory is shown as ~ and the repo as .
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "  ~/.vimrc -> ./vim/.vimrc\n")
+	assert.NotContains(t, buf.String(), tmpDir)
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "-v", "--full-paths"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "  "+filepath.Join(tmpDir, "home", ".vimrc")+" -> "+filepath.Join(tmpDir, "vim", ".vimrc")+"\n")
+
+	// Only whole paths are shortened
+	fullPaths = false
+	home := filepath.Join(tmpDir, "home")
+	assert.Equal(t, "~ ~/.zshrc ./home2/.zshrc /x"+home+" '~'\n", shortenPaths(home+" "+home+"/.zshrc "+home+"2/.zshrc /x"+home+" '"+home+"'\n"))
+}
+
+func TestCLILinkKeepsCreated(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { verbose = false }()
+
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("vim", ".vimrc"), []byte("set nu"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	vimrc := filepath.Join(tmpDir, "home", ".vimrc")
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	lock, err := lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	link := lock.Symlinks[vimrc]
+	link.Created, link.Verified = created, created
+	lock.Symlinks[vimrc] = link
+	require.NoError(t, lock.Save("farm.lock"))
+
+	// Linking again verifies the link without changing when it was created
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	lock, err = lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	assert.True(t, lock.Symlinks[vimrc].Created.Equal(created))
+	assert.True(t, lock.Symlinks[vimrc].Verified.After(created))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"status", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "      created 2024-01-02 03:04:05, verified ")
+}
+
+func TestCLIMaxChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	maxChanges = 0
+	force = false
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`settings:
+  max_changes: 2
+packages:
+  - source: ./source
+    targets:
+      - ./target
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	// Simulate a bad edit that removes every source file
+	require.NoError(t, os.RemoveAll(sourceDir))
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+
+	rootCmd.SetArgs([]string{"link"})
+	err := rootCmd.Execute()
+	assert.ErrorContains(t, err, "would remove or replace 3 symlinks, more than the limit of 2")
+	_, err = os.Lstat("./target/a")
+	assert.NoError(t, err, "nothing should be removed when the limit is exceeded")
+
+	rootCmd.SetArgs([]string{"link", "--max-changes", "3"})
+	require.NoError(t, rootCmd.Execute())
+
+	_, err = os.Lstat("./target/a")
+	assert.True(t, os.IsNotExist(err))
+
+	maxChanges = 0
+}
+
+func TestCLICompletionInstall(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("HOME", tmpDir)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	rootCmd.SetArgs([]string{"completion", "install", "--shell", "fish"})
+	require.NoError(t, rootCmd.Execute())
+
+	script, err := os.ReadFile(filepath.Join(tmpDir, ".config", "fish", "completions", "farm.fish"))
+	require.NoError(t, err)
+	assert.Contains(t, string(script), "complete -c farm")
+
+	// The script has to belong to a package, or gc would remove its link
+	require.NoError(t, os.WriteFile("farm.yaml", []byte("packages: []\n"), 0644))
+	rootCmd.SetArgs([]string{"completion", "install", "--shell", "zsh", "--source-dir", "dotfiles/zsh"})
+	assert.ErrorContains(t, rootCmd.Execute(), "isn't inside the source of any package")
+
+	// And the package has to link into the completions directory
+	require.NoError(t, os.MkdirAll("dotfiles/zsh", 0755))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./dotfiles/zsh
+    targets: [./home]
+`), 0644))
+	rootCmd.SetArgs([]string{"completion", "install", "--shell", "zsh", "--source-dir", "dotfiles/zsh"})
+	assert.ErrorContains(t, rootCmd.Execute(), "doesn't link into "+filepath.Join(tmpDir, ".zsh", "completions"))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./dotfiles/zsh
+    targets: ["~"]
+`), 0644))
+	rootCmd.SetArgs([]string{"completion", "install", "--shell", "zsh", "--source-dir", "dotfiles/zsh"})
+	require.NoError(t, rootCmd.Execute())
+
+	// The script goes where the package maps to the completions directory
+	target := filepath.Join(tmpDir, ".zsh", "completions", "_farm")
+	info, err := os.Lstat(target)
+	require.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	assert.FileExists(t, filepath.Join(tmpDir, "dotfiles", "zsh", ".zsh", "completions", "_farm"))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NoFileExists(t, filepath.Join(tmpDir, "_farm"))
+
+	lock, err := os.ReadFile("farm.lock")
+	require.NoError(t, err)
+	assert.Contains(t, string(lock), target)
+
+	rootCmd.SetArgs([]string{"lock", "gc"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, target)
+
+	rootCmd.SetArgs([]string{"completion", "install", "--shell", "tcsh", "--source-dir", ""})
+	assert.ErrorContains(t, rootCmd.Execute(), "unsupported shell tcsh")
+
+	completionShell = ""
+}
+
+func TestCLIMessages(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	for _, pkg := range []string{"tmux", "fish"} {
+		require.NoError(t, os.MkdirAll(pkg, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(pkg, pkg+".conf"), []byte(pkg), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./tmux
+    targets:
+      - ./home
+    message:
+      text: Reload tmux
+      when: changed
+  - source: ./fish
+    targets:
+      - ./home
+    message:
+      - Restart your shell
+      - Restart your shell
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Next steps:\n  • Reload tmux\n  • Restart your shell\n")
+
+	// Nothing changed, so only unconditional messages are printed
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "Reload tmux")
+	assert.Contains(t, buf.String(), "Restart your shell")
+}
+
+func TestCLIHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("tmux", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("tmux", "tmux.conf"), []byte("tmux"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./tmux
+    targets:
+      - ./home
+    hooks:
+      pre_link: echo "pre $FARM_PACKAGE dry=$FARM_DRY_RUN"
+      post_link: echo "post changed=$FARM_CHANGED targets=$FARM_TARGETS" && grep -q created "$FARM_RESULT"
+`), 0644))
+
+	home := filepath.Join(tmpDir, "home")
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "pre tmux dry=false\n")
+	assert.Contains(t, buf.String(), "post changed=true targets="+home+"\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "pre tmux dry=true\n")
+	assert.Contains(t, buf.String(), "post changed=false")
+
+	// A failing pre_link hook aborts before anything is linked
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./tmux
+    targets:
+      - ./other
+    hooks:
+      pre_link: exit 1
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	err := rootCmd.Execute()
+	assert.ErrorContains(t, err, "hook \"exit 1\" for package tmux failed")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "other", "tmux.conf"))
+}
+
+func TestCLIRunHook(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("tmux", 0755))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./tmux
+    targets:
+      - ./home
+    hooks:
+      post_link: echo "linked"
+      reload: echo "reload $FARM_PACKAGE"
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"list"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "tmux: "+filepath.Join(tmpDir, "tmux")+"\n")
+	assert.Contains(t, buf.String(), "  hooks: post_link, reload\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"run", "tmux", "reload"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "reload tmux\n", buf.String())
+	assert.NoFileExists(t, "farm.lock")
+
+	rootCmd.SetArgs([]string{"run", "tmux", "missing"})
+	assert.ErrorContains(t, rootCmd.Execute(), "package tmux has no hook missing (available: post_link, reload)")
+
+	rootCmd.SetArgs([]string{"run", "nvim", "reload"})
+	assert.ErrorContains(t, rootCmd.Execute(), "package nvim not found")
+}
+
+func TestCLIInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("tmux", 0755))
+	require.NoError(t, os.MkdirAll("vim", 0755))
+	require.NoError(t, os.WriteFile("tmux/tmux.conf", []byte("set -g mouse on"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`ignore:
+  - tmux:plugins
+  - vim:*.swp
+packages:
+  - source: ./tmux
+    targets:
+      - ./home
+    default_fold: true
+    no_fold:
+      - plugins
+    hooks:
+      reload: tmux source ~/.tmux.conf
+  - source: ./vim
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"info", "tmux"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "tmux: "+filepath.Join(tmpDir, "tmux")+"\n")
+	assert.Contains(t, buf.String(), "  last linked: never\n")
+	assert.Contains(t, buf.String(), "  directories are folded by default\n  no_fold: plugins\n")
+	assert.Contains(t, buf.String(), "  plugins anywhere in package tmux\n")
+	assert.NotContains(t, buf.String(), "*.swp")
+	assert.Contains(t, buf.String(), "  reload: tmux source ~/.tmux.conf\n")
+	assert.Contains(t, buf.String(), "Resolved config:\n  name: tmux\n")
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"info", "tmux"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "  links: (1 ok)\n")
+	assert.NotContains(t, buf.String(), "last linked: never")
+
+	rootCmd.SetArgs([]string{"info", "nvim"})
+	assert.ErrorContains(t, rootCmd.Execute(), "package nvim not found")
+}
+
+func TestCLIArchiveSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	writeArchive := func(content string) {
+		file, err := os.Create("theme.tar.gz")
+		require.NoError(t, err)
+		defer file.Close()
+
+		gz := gzip.NewWriter(file)
+		tw := tar.NewWriter(gz)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "colors.conf", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+	}
+	writeArchive("dark")
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./theme.tar.gz
+    targets:
+      - ./home
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	dryRun = true
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "colors.conf")
+	assert.NoFileExists(t, "home/colors.conf")
+	dryRun = false
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	content, err := os.ReadFile("home/colors.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", string(content))
+
+	// A changed archive is extracted again, and the links follow
+	writeArchive("light")
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	content, err = os.ReadFile("home/colors.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "light", string(content))
+}
+
+func TestCLISkipFailedTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	skipFailed = false
+	defer func() { skipFailed = false }()
+
+	require.NoError(t, os.MkdirAll("vscode", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("vscode", "settings.json"), []byte("{}"), 0644))
+	require.NoError(t, os.MkdirAll("cursor", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("cursor", "settings.json"), []byte("local"), 0644))
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vscode
+    targets:
+      - ./code
+      - ./cursor
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"link"})
+	assert.Error(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "skipped because another target of the package failed")
+	assert.NoFileExists(t, filepath.Join("code", "settings.json"))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link", "--skip-failed-targets"})
+	assert.Error(t, rootCmd.Execute())
+	assert.FileExists(t, filepath.Join("code", "settings.json"))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "-v"})
+	require.NoError(t, rootCmd.Execute())
+	verbose = false
+	assert.Contains(t, buf.String(), "✓ vscode -> "+filepath.Join(tmpDir, "code")+": 1 symlinks\n")
+	assert.Contains(t, buf.String(), "✗ vscode -> "+filepath.Join(tmpDir, "cursor")+": not linked\n")
+}
+
+func TestCLIStow(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	dotfiles := filepath.Join(tmpDir, "dotfiles")
+	require.NoError(t, os.MkdirAll(filepath.Join(dotfiles, "nvim", ".config", "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dotfiles, "nvim", ".config", "nvim", "init.lua"), []byte("-- nvim"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dotfiles, "zsh"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dotfiles, "zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.Chdir(dotfiles))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() {
+		stowDir, stowTarget = "", ""
+		stowLink, stowDelete, stowRestow, stowNoFolding = false, false, false, false
+	}()
+
+	rootCmd.SetArgs([]string{"stow", "nvim", "zsh"})
+	require.NoError(t, rootCmd.Execute())
+
+	// Stow folds directories by default and targets the parent directory
+	info, err := os.Lstat(filepath.Join(tmpDir, ".config"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	assert.FileExists(t, filepath.Join(tmpDir, ".zshrc"))
+
+	rootCmd.SetArgs([]string{"stow", "-D", "nvim"})
+	require.NoError(t, rootCmd.Execute())
+	stowDelete = false
+	assert.NoFileExists(t, filepath.Join(tmpDir, ".config", "nvim", "init.lua"))
+	assert.FileExists(t, filepath.Join(tmpDir, ".zshrc"))
+
+	home := filepath.Join(tmpDir, "home")
+	rootCmd.SetArgs([]string{"restow", "-d", dotfiles, "-t", home, "--no-folding", "nvim"})
+	require.NoError(t, rootCmd.Execute())
+	info, err = os.Lstat(filepath.Join(home, ".config", "nvim"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.FileExists(t, filepath.Join(home, ".config", "nvim", "init.lua"))
+
+	rootCmd.SetArgs([]string{"delete", "-t", home, "nvim"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NoFileExists(t, filepath.Join(home, ".config", "nvim", "init.lua"))
+
+	rootCmd.SetArgs([]string{"stow", "-S", "-D", "nvim"})
+	assert.ErrorContains(t, rootCmd.Execute(), "only one of -S, -D, and -R")
+
+	stowLink, stowDelete = false, false
+	rootCmd.SetArgs([]string{"stow", "missing"})
+	assert.ErrorContains(t, rootCmd.Execute(), "package missing not found")
+
+	// --target-base moves the targets and tracks them apart
+	t.Setenv("HOME", tmpDir)
+	stowDir, stowTarget = "", ""
+	defer func() { targetBase = "" }()
+	sim := filepath.Join(tmpDir, "sim")
+	rootCmd.SetArgs([]string{"stow", "-D", "--target-base", sim, "zsh"})
+	require.NoError(t, rootCmd.Execute())
+	stowDelete = false
+	assert.FileExists(t, filepath.Join(tmpDir, ".zshrc"))
+
+	rootCmd.SetArgs([]string{"stow", "--target-base", sim, "zsh"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, filepath.Join(sim, ".zshrc"))
+
+	rootCmd.SetArgs([]string{"test", "--target-base", sim})
+	assert.ErrorContains(t, rootCmd.Execute(), "test can't be combined with --target-base")
+}
+
+type fakeProvisioner struct {
+	installed []string
+	// failing items fail to install
+	failing []string
+}
+
+func (p *fakeProvisioner) Installed() ([]string, error) {
+	return p.installed, nil
+}
+
+func (p *fakeProvisioner) Install(item string) error {
+	if slices.Contains(p.failing, item) {
+		return fmt.Errorf("failed to install %s", item)
+	}
+	p.installed = append(p.installed, item)
+	return nil
+}
+
+var testProvisioner = &fakeProvisioner{}
+
+func init() {
+	provision.Register("test", testProvisioner)
+}
+
+func TestCLIApply(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	testProvisioner.installed = []string{"fzf"}
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ./home
+bundle:
+  test:
+    - fzf
+    - starship
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"apply", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "Will install test starship\n")
+	assert.NotContains(t, buf.String(), "test fzf")
+	assert.Equal(t, []string{"fzf"}, testProvisioner.installed)
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"apply"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, filepath.Join("home", ".zshrc"))
+	assert.Contains(t, buf.String(), "✓ Installed 1 bundle items\n")
+	assert.Equal(t, []string{"fzf", "starship"}, testProvisioner.installed)
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"bundle"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Installed 0 bundle items\n")
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages: []
+bundle:
+  apt:
+    - git
+`), 0644))
+	rootCmd.SetArgs([]string{"bundle"})
+	assert.ErrorContains(t, rootCmd.Execute(), "bundle: unknown provisioner apt")
+}
+
+func TestCLIEditorExtensions(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	// A fake code binary that records installed extensions, cursor is not
+	// installed at all
+	binDir := filepath.Join(tmpDir, "bin")
+	state := filepath.Join(tmpDir, "extensions")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, os.WriteFile(state, []byte("Golang.Go\n"), 0644))
+	listed := filepath.Join(tmpDir, "listed")
+	script := "#!/bin/sh\nif [ \"$1\" = --list-extensions ]; then touch " + listed + "; cat " + state + "; else echo \"$2\" >> " + state + "; fi\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "code"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.NoError(t, os.MkdirAll("vscode", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("vscode", "settings.json"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./vscode
+    targets:
+      - ./code
+    extensions:
+      code:
+        - golang.go
+        - esbenp.prettier-vscode
+      cursor:
+        - golang.go
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"link", "--dry-run"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Will install code esbenp.prettier-vscode\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	dryRun = false
+	assert.Contains(t, buf.String(), "Found 1 missing code extensions:\n  - esbenp.prettier-vscode\n")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Installed 1 editor extensions\n")
+	content, err := os.ReadFile(state)
+	require.NoError(t, err)
+	assert.Equal(t, "Golang.Go\nesbenp.prettier-vscode\n", string(content))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NotContains(t, buf.String(), "missing")
+
+	// Linking again with nothing changed doesn't ask the editors
+	require.NoError(t, os.Remove(listed))
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.NoFileExists(t, listed)
+}
+
+func TestCLIFsck(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ./home
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"fsck"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "Checked 1 entries\n✓ No problems found\n", buf.String())
+
+	require.NoError(t, os.Remove(filepath.Join("home", ".zshrc")))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"fsck"})
+	assert.ErrorContains(t, rootCmd.Execute(), "fsck found 1 problems")
+	assert.Contains(t, buf.String(), filepath.Join(tmpDir, "home", ".zshrc")+": symlink is missing\n    → run 'farm link' to recreate it\n")
+}
+
+func TestCLITargetBase(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() { targetBase = "" }()
+
+	home := filepath.Join(tmpDir, "home")
+	t.Setenv("HOME", home)
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ~/
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, filepath.Join(home, ".zshrc"))
+
+	sim := filepath.Join(tmpDir, "homesim")
+	rootCmd.SetArgs([]string{"link", "--target-base", sim})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, filepath.Join(sim, ".zshrc"))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"status", "-v", "--target-base", sim})
+	require.NoError(t, rootCmd.Execute())
+	verbose = false
+	assert.Contains(t, buf.String(), filepath.Join(sim, ".zshrc"))
+	assert.NotContains(t, buf.String(), filepath.Join(home, ".zshrc"))
+
+	// Unlinking the experiment leaves the real home alone
+	rootCmd.SetArgs([]string{"unlink", "--target-base", sim})
+	require.NoError(t, rootCmd.Execute())
+	assert.NoFileExists(t, filepath.Join(sim, ".zshrc"))
+	assert.FileExists(t, filepath.Join(home, ".zshrc"))
+
+	targetBase = ""
+	lock, err := lockfile.Load("farm.lock")
+	require.NoError(t, err)
+	assert.Contains(t, lock.Symlinks, filepath.Join(home, ".zshrc"))
+	assert.Empty(t, lock.Namespace(sim).Symlinks)
+}
+
+func TestCLITest(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	home := filepath.Join(tmpDir, "home")
+	t.Setenv("HOME", home)
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ~/
+    hooks:
+      verify: test -L "$HOME/.zshrc" && test "$FARM_SANDBOX" = "$HOME"
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"test"})
+	require.NoError(t, rootCmd.Execute())
+
+	output := buf.String()
+	assert.Contains(t, output, "Produced 1 entries")
+	assert.Contains(t, output, "~/.zshrc")
+	assert.Contains(t, output, "✓ zsh verified")
+
+	// Nothing is linked into the real home or tracked in the lockfile
+	assert.NoFileExists(t, filepath.Join(home, ".zshrc"))
+	assert.NoFileExists(t, "farm.lock")
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ~/
+    hooks:
+      verify: test -e "$HOME/.bashrc"
+`), 0644))
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"test"})
+	err := rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 failed verify hooks")
+	assert.Contains(t, buf.String(), "✗ zsh")
+}
+
+func TestCLILanguage(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { language = "" }()
+
+	t.Setenv("HOME", filepath.Join(tmpDir, "home"))
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "")
+	t.Setenv("LANG", "de_DE.UTF-8")
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ~/
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ 1 Dateien verlinkt, 0 tote Links entfernt")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "--lang", "en"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks")
+
+	rootCmd.SetArgs([]string{"status", "--lang", "xx"})
+	err := rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported language xx")
+
+	language = ""
+	t.Setenv("LANG", "")
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks")
+}
+
+func TestCLIMinimal(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { minimalProfile = "" }()
+
+	for _, file := range []string{"zsh/.zshrc", "nvim/lua/init.lua", "git/.gitconfig"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`minimal:
+  devcontainer:
+    packages: [zsh, nvim]
+packages:
+  - name: zsh
+    source: ./zsh
+    targets: [./home]
+  - name: nvim
+    source: ./nvim
+    targets: [./home/.config/nvim]
+    default_fold: true
+  - name: git
+    source: ./git
+    targets: [./home]
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link", "--minimal", "devcontainer"})
+	require.NoError(t, rootCmd.Execute())
+
+	// Only the profile's packages are linked, as copies
+	assert.FileExists(t, "home/.zshrc")
+	info, err := os.Lstat("home/.zshrc")
+	require.NoError(t, err)
+	assert.False(t, info.Mode()&os.ModeSymlink != 0)
+	assert.FileExists(t, "home/.config/nvim/lua/init.lua")
+	info, err = os.Lstat("home/.config/nvim/lua")
+	require.NoError(t, err)
+	assert.False(t, info.Mode()&os.ModeSymlink != 0)
+	assert.NoFileExists(t, "home/.gitconfig")
+
+	rootCmd.SetArgs([]string{"link", "work", "--minimal", "devcontainer"})
+	assert.ErrorContains(t, rootCmd.Execute(), "--minimal can't be combined with an environment")
+
+	environment = ""
+	rootCmd.SetArgs([]string{"link", "--minimal", "ci"})
+	assert.ErrorContains(t, rootCmd.Execute(), "unknown minimal profile ci")
+}
+
+func TestCLIApplyArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("HOME", filepath.Join(tmpDir, "home"))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	outputPath = ""
+	defer func() { outputPath, exportArchive = "", false }()
+
+	for _, file := range []string{"dotfiles/zsh/.zshrc", "dotfiles/nvim/lua/init.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(file), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./dotfiles/zsh
+    targets: ["~"]
+  - source: ./dotfiles/nvim
+    targets: [~/.config/nvim]
+    default_fold: true
+`), 0644))
+
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	info, err := os.Lstat("home/.config/nvim/lua")
+	require.NoError(t, err)
+	require.True(t, info.Mode()&os.ModeSymlink != 0)
+
+	rootCmd.SetArgs([]string{"lock", "export", "--archive"})
+	assert.ErrorContains(t, rootCmd.Execute(), "--archive needs a file to write to")
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"lock", "export", "--archive", "-o", "dotfiles.tar.gz"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Exported 2 files to dotfiles.tar.gz")
+
+	// A fresh container has neither the repo nor a config
+	require.NoError(t, os.RemoveAll("dotfiles"))
+	require.NoError(t, os.Remove("farm.yaml"))
+	t.Setenv("HOME", filepath.Join(tmpDir, "container"))
+	outputPath, exportArchive = "", false
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"apply-archive", "dotfiles.tar.gz"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Placed 2 files from dotfiles.tar.gz")
+
+	content, err := os.ReadFile("container/.config/nvim/lua/init.lua")
+	require.NoError(t, err)
+	assert.Equal(t, "dotfiles/nvim/lua/init.lua", string(content))
+	assert.FileExists(t, "container/.zshrc")
+	info, err = os.Lstat("container/.zshrc")
+	require.NoError(t, err)
+	assert.False(t, info.Mode()&os.ModeSymlink != 0)
+}
+
+func init() {
+	transform.Register("test-render", transform.Func(func(in transform.Input) (*transform.Output, error) {
+		content, err := os.ReadFile(in.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &transform.Output{Action: transform.ActionRender, Content: bytes.ToUpper(content)}, nil
+	}))
+}
+
+func TestCLIPackage(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("HOME", filepath.Join(tmpDir, "home"))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	outputPath = ""
+	defer func() { outputPath, packageEnv, signKey, allowedSigners = "", "", "", "" }()
+
+	for _, file := range []string{"dotfiles/zsh/.zshrc", "dotfiles/zsh/.zshrc@no-such-host", "dotfiles/npm/.npmrc", "dotfiles/nvim/lua/init.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(file), 0644))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./dotfiles/zsh
+    targets: ["~"]
+    variants: true
+  - source: ./dotfiles/npm
+    targets: ["~"]
+    transform: [test-render]
+    environments: [work]
+  - source: ./dotfiles/nvim
+    targets:
+      - path: ~/.config/nvim
+        optional: true
+    default_fold: true
+`), 0644))
+
+	rootCmd.SetArgs([]string{"package", "--env", "work"})
+	assert.ErrorContains(t, rootCmd.Execute(), "package needs a file to write to")
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"package", "--env", "work", "-o", "dotfiles.tar.gz"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Packaged 3 files into dotfiles.tar.gz")
+
+	// The archive is signed with an SSH key and only applied when the
+	// signature checks out
+	require.NoError(t, exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", "id_ed25519").Run())
+	public, err := os.ReadFile("id_ed25519.pub")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile("allowed_signers", append([]byte("team@example.com "), public...), 0644))
+
+	rootCmd.SetArgs([]string{"apply-archive", "dotfiles.tar.gz", "--allowed-signers", "allowed_signers"})
+	assert.ErrorContains(t, rootCmd.Execute(), "dotfiles.tar.gz isn't signed")
+
+	rootCmd.SetArgs([]string{"package", "--env", "work", "-o", "dotfiles.tar.gz", "--sign-key", "id_ed25519"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "dotfiles.tar.gz.sig")
+
+	// Nothing is linked while packaging
+	assert.NoDirExists(t, "home")
+	assert.NoFileExists(t, "farm.lock")
+
+	// The archive is applied without the repo
+	require.NoError(t, os.RemoveAll("dotfiles"))
+	t.Setenv("HOME", filepath.Join(tmpDir, "offline"))
+	outputPath = ""
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"apply-archive", "dotfiles.tar.gz", "--allowed-signers", "allowed_signers"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Signed by team@example.com")
+
+	for file, content := range map[string]string{
+		"offline/.zshrc":                    "dotfiles/zsh/.zshrc",
+		"offline/.npmrc":                    "DOTFILES/NPM/.NPMRC",
+		"offline/.config/nvim/lua/init.lua": "dotfiles/nvim/lua/init.lua",
+	} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err, file)
+		assert.Equal(t, content, string(data), file)
+	}
+}
+
+func TestCLIAudit(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+	t.Setenv("PATH", tmpDir)
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	for file, mode := range map[string]os.FileMode{
+		"ssh/config":           0644,
+		"ssh/id_ed25519":       0600,
+		"aws/credentials":      0644,
+		"zsh/.zshrc":           0644,
+		"farm-notify":          0755,
+		"gnupg/gpg-agent.conf": 0600,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), mode))
+	}
+
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - name: ssh
+    source: ./ssh
+    targets: [./home/.ssh]
+  - name: aws
+    source: ./aws
+    targets: [./home/.aws]
+    default_fold: true
+  - name: gnupg
+    source: ./gnupg
+    targets: [./home/.gnupg]
+  - name: zsh
+    source: ./zsh
+    targets: [./home]
+    hooks:
+      post_link: exec zsh
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"audit"})
+	require.NoError(t, rootCmd.Execute())
+
+	output := buf.String()
+	assert.Contains(t, output, "Files readable by everyone in sensitive directories (2):")
+	assert.Contains(t, output, filepath.Join(tmpDir, "home/.ssh/config")+" <- "+filepath.Join(tmpDir, "ssh/config")+" (0644)")
+	assert.Contains(t, output, filepath.Join(tmpDir, "home/.aws/credentials")+" <- ")
+	assert.NotContains(t, output, "gpg-agent.conf")
+
+	assert.Contains(t, output, "Files that look like secrets (2):")
+	assert.Contains(t, output, filepath.Join(tmpDir, "home/.ssh/id_ed25519")+" <- ")
+
+	assert.Contains(t, output, "Hooks that would run (1):\n  zsh post_link: exec zsh")
+	assert.Contains(t, output, "Plugins that may run (1):\n  notify: "+filepath.Join(tmpDir, "farm-notify"))
+
+	// Nothing is linked
+	assert.NoDirExists(t, "home")
+	assert.NoFileExists(t, "farm.lock")
+}
+
+func TestCLIApplyResume(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	testProvisioner.installed = nil
+	testProvisioner.failing = []string{"starship"}
+	defer func() { testProvisioner.failing, resumeApply = nil, false }()
+
+	require.NoError(t, os.MkdirAll("zsh", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", ".zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile("farm.yaml", []byte(`packages:
+  - source: ./zsh
+    targets:
+      - ./home
+    hooks:
+      pre_link: echo run >> pre.log
+      post_link: test -f ready
+bundle:
+  test:
+    - fzf
+    - starship
+`), 0644))
+
+	rootCmd.SetArgs([]string{"apply", "--resume"})
+	assert.ErrorContains(t, rootCmd.Execute(), "there is no interrupted apply to resume")
+	resumeApply = false
+
+	// The post_link hook fails halfway through
+	rootCmd.SetArgs([]string{"apply"})
+	require.Error(t, rootCmd.Execute())
+	assert.FileExists(t, "farm.lock.apply")
+
+	require.NoError(t, os.WriteFile(filepath.Join("zsh", "ready"), nil, 0644))
+	rootCmd.SetArgs([]string{"apply", "--resume"})
+	assert.ErrorContains(t, rootCmd.Execute(), "bundle completed with 1 errors")
+	resumeApply = false
+	assert.Equal(t, []string{"fzf"}, testProvisioner.installed)
+
+	// The pre_link hook that completed isn't run again, hooks run in the
+	// package directory
+	log, err := os.ReadFile(filepath.Join("zsh", "pre.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(log))
+
+	// Once linked, resuming goes straight to what's left of the bundle
+	testProvisioner.failing = nil
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"apply", "--resume"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Links are already in place, continuing with the bundle")
+	assert.Equal(t, []string{"fzf", "starship"}, testProvisioner.installed)
+	assert.NoFileExists(t, "farm.lock.apply")
+}
+
+func TestCLIAdopt(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	defer func() { adoptPackage, force, adoptExisting, dryRun = "", false, false, false }()
+
+	buildFixture(t, `config: |
+  packages:
+    - source: ./git
+      targets:
+        - ./home
+    - source: ./zsh
+      targets:
+        - ./home
+files:
+  - path: git
+    dir: true
+  - path: zsh
+    dir: true
+  - path: home/.config/git/config
+    content: "[user]\n"
+  - path: home/.zshrc
+    content: "export EDITOR=nvim\n"
+`)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"adopt", "home/.config/git/config"})
+	assert.ErrorContains(t, rootCmd.Execute(), "inside the targets of several packages (git, zsh), pick one with --package")
+
+	// A dry run moves nothing
+	dryRun = true
+	rootCmd.SetArgs([]string{"adopt", "-p", "git", "home/.config/git/config"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Will adopt 1 files\n")
+	assert.NoFileExists(t, "git/.config/git/config")
+	dryRun = false
+
+	rootCmd.SetArgs([]string{"adopt", "-p", "git", "home/.config/git/config"})
+	require.NoError(t, rootCmd.Execute())
+	content, err := os.ReadFile("git/.config/git/config")
+	require.NoError(t, err)
+	assert.Equal(t, "[user]\n", string(content))
+	resolved, err := filepath.EvalSymlinks(filepath.Join(tmpDir, "home/.config/git/config"))
+	require.NoError(t, err)
+	expected, _ := filepath.EvalSymlinks(filepath.Join(tmpDir, "git/.config/git/config"))
+	assert.Equal(t, expected, resolved)
+
+	rootCmd.SetArgs([]string{"adopt", "-p", "git", "home/.config/git/config"})
+	assert.ErrorContains(t, rootCmd.Execute(), "is already a symlink")
+
+	// Sources that differ are only replaced with --force
+	require.NoError(t, os.WriteFile("zsh/.zshrc", []byte("export EDITOR=vim\n"), 0644))
+	rootCmd.SetArgs([]string{"adopt", "-p", "zsh", "home/.zshrc"})
+	assert.ErrorContains(t, rootCmd.Execute(), "already exists, pass --force")
+
+	// link --adopt moves the files in the way into their packages instead
+	rootCmd.SetArgs([]string{"link", "--adopt"})
+	require.NoError(t, rootCmd.Execute())
+	content, err = os.ReadFile("zsh/.zshrc")
+	require.NoError(t, err)
+	assert.Equal(t, "export EDITOR=nvim\n", string(content))
+	info, err := os.Lstat("home/.zshrc")
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink)
+
+	// farm add is the same command
+	require.NoError(t, os.WriteFile("home/.tigrc", []byte("*.swp\n"), 0644))
+	rootCmd.SetArgs([]string{"add", "home/.tigrc", "--package", "git"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "git/.tigrc")
+	lock, err := loadLockfile()
+	require.NoError(t, err)
+	assert.Contains(t, lock.Symlinks, filepath.Join(tmpDir, "home/.tigrc"))
+}
+
+func TestCLIFarmEnv(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { environment = "" }()
+
+	buildFixture(t, `config: |
+  packages:
+    - source: ./work
+      targets: [./home]
+      environments: [work]
+    - source: ./personal
+      targets: [./home]
+      environments: [personal]
+files:
+  - path: work/.work
+  - path: personal/.personal
+`)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	// Without FARM_ENV the environment is required
+	t.Setenv("FARM_ENV", "")
+	rootCmd.SetArgs([]string{"link"})
+	assert.ErrorContains(t, rootCmd.Execute(), "environment not specified")
+
+	t.Setenv("FARM_ENV", "work")
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "home/.work")
+	assert.NoFileExists(t, "home/.personal")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks for environment 'work' (from FARM_ENV)\n")
+
+	// An argument takes precedence over FARM_ENV
+	rootCmd.SetArgs([]string{"link", "personal"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "home/.personal")
+
+	buf.Reset()
+	rootCmd.SetArgs([]string{"status", "personal"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "Tracking 1 symlinks for environment 'personal' (from the command line)\n")
+}
+
+func TestCLILinkPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { linkPaths = nil }()
+
+	buildFixture(t, `config: |
+  packages:
+    - source: ./nvim
+      targets: [./home]
+files:
+  - path: nvim/.config/nvim/init.lua
+  - path: nvim/.config/nvim/lua/plugins/lsp.lua
+`)
+
+	rootCmd.SetArgs([]string{"link", "--path", "../nvim"})
+	assert.ErrorContains(t, rootCmd.Execute(), "must be a path inside the package sources")
+	linkPaths = nil
+
+	rootCmd.SetArgs([]string{"link", "--path", "./.config/nvim/lua/"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "home/.config/nvim/lua/plugins/lsp.lua")
+	assert.NoFileExists(t, "home/.config/nvim/init.lua")
+}
+
+func TestCLIInit(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { initDetect, force = false, false }()
+
+	buildFixture(t, `files:
+  - path: .git
+    dir: true
+  - path: nvim
+    dir: true
+  - path: zsh/.zshrc
+  - path: zsh/.zshrc.swp
+`)
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"init", "--detect"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Created farm.yaml with 2 packages\n")
+	assert.FileExists(t, ".farmignore")
+
+	cfg, err := loadConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Packages, 2)
+	assert.Equal(t, "nvim", cfg.Packages[0].Name)
+	assert.Equal(t, "zsh", cfg.Packages[1].Name)
+	assert.Contains(t, cfg.Ignore, "*.swp")
+
+	rootCmd.SetArgs([]string{"init"})
+	assert.ErrorContains(t, rootCmd.Execute(), "farm.yaml already exists, pass --force to replace it")
+
+	// Without --detect the config starts out empty, and loads
+	initDetect = false
+	rootCmd.SetArgs([]string{"init", "--force"})
+	require.NoError(t, rootCmd.Execute())
+	cfg, err = loadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Packages)
+}
+
+func TestCLIFixtures(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+
+	require.NoError(t, os.WriteFile("spec.yaml", []byte(`config: |
+  packages:
+    - source: ./zsh
+      targets: [./home]
+files:
+  - path: zsh/.zshrc
+    content: export EDITOR=nvim
+  - path: home/.zshrc
+    content: export EDITOR=vim
+`), 0644))
+
+	buf := new(bytes.Buffer)
+	rootCmd.SetOut(buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"fixtures", "spec.yaml", "repro"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "✓ Built 2 files in repro\n")
+
+	// The reproduction fails the way the spec describes
+	configPath = "repro/farm.yaml"
+	lockfilePath = "repro/farm.lock"
+	defer func() { configPath, lockfilePath = "farm.yaml", "farm.lock" }()
+	rootCmd.SetArgs([]string{"link"})
+	assert.Error(t, rootCmd.Execute())
+	assert.Contains(t, buf.String(), "repro/home/.zshrc already exists")
+}
+
+// buildFixture builds the tree of a fixture spec in the working directory.
+func buildFixture(t *testing.T, data string) {
+	t.Helper()
+	spec, err := fixture.Parse([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, spec.Build("."))
+}
+
+func TestCLIPlugins(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+
+	require.NoError(t, os.Chdir(tmpDir))
+
+	// Reset flags to defaults
+	configPath = "farm.yaml"
+	lockfilePath = "farm.lock"
+	dryRun = false
+	verbose = false
+	environment = ""
+	defer func() { dryRun = false }()
+
+	binDir := filepath.Join(tmpDir, "bin")
+	buildFixture(t, `config: |
+  settings:
+    plugins: [record]
+  packages:
+    - source: ./zsh
+      targets: [./home]
+files:
+  - path: zsh/.zshrc
+  - path: home
+    dir: true
+  - path: bin/farm-record
+    mode: "0755"
+    content: |
+      #!/bin/sh
+      if [ "$1" = "farm-plugin-info" ]; then
+        echo '{"events": ["pre-plan", "post-apply"]}'
+        exit 0
+      fi
+      cat > {root}/$1.json
+      echo "recorded $1"
+  - path: bin/farm-other
+    mode: "0755"
+    content: |
+      #!/bin/sh
+      touch {root}/other-ran
+`)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Dry runs get the plan, but nothing was applied
+	dryRun = true
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	data, err := os.ReadFile("pre-plan.json")
+	require.NoError(t, err)
+	var payload struct {
+		DryRun bool      `json:"dry_run"`
+		Plan   plan.Plan `json:"plan"`
+	}
+	require.NoError(t, json.Unmarshal(data, &payload))
+	assert.True(t, payload.DryRun)
+	require.Len(t, payload.Plan.Actions, 1)
+	assert.Equal(t, plan.Create, payload.Plan.Actions[0].Kind)
+	assert.Equal(t, filepath.Join(tmpDir, "home/.zshrc"), payload.Plan.Actions[0].Target)
+	assert.NoFileExists(t, "post-apply.json")
+	dryRun = false
+
+	// What plugins print stays out of the command's output
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	rootCmd.SetErr(errOut)
+	defer rootCmd.SetErr(nil)
+	rootCmd.SetArgs([]string{"link"})
+	require.NoError(t, rootCmd.Execute())
+	assert.FileExists(t, "post-apply.json")
+	assert.NotContains(t, out.String(), "recorded")
+	assert.Contains(t, errOut.String(), "recorded post-apply")
+
+	// Plugins that weren't enabled never run, not even to be listed
+	out.Reset()
+	rootCmd.SetArgs([]string{"plugins", "list"})
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, out.String(), "  record (enabled)\n")
+	assert.Contains(t, out.String(), "events: pre-plan, post-apply")
+	assert.Contains(t, out.String(), "  other\n")
+	assert.NoFileExists(t, "other-ran")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <target>",
+	Short: "Merge local edits of a copied or rendered file with its source in a diff tool",
+	Long: `Merge local edits of a copied or rendered file with its source in a diff tool.
+
+The tool is $FARM_DIFFTOOL, run with what farm would write and the target as
+its two arguments, or git difftool when it isn't set. Plain copies are opened
+against the source itself, so changes made to it land in the repo, while
+rendered files are opened against a temporary render.
+
+Once the tool exits, a target that matches its source again is tracked as in
+sync. Otherwise the remaining edits of a copy can be adopted into the source,
+or the target overwritten with what farm would write.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, cfg, lock, err := loadTarget(args[0])
+		if err != nil {
+			return err
+		}
+
+		l := linker.New(cfg, lock, false)
+		expected, err := l.Expected(target)
+		if err != nil {
+			return err
+		}
+		link := lock.Symlinks[target]
+
+		current, err := os.ReadFile(target)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", target, err)
+		}
+		if bytes.Equal(current, expected) {
+			cmd.Printf("✓ %s already matches its source\n", target)
+			return nil
+		}
+
+		// Copies written as is are merged with the source itself, anything
+		// else with a render of it
+		left := link.Source
+		source, err := os.ReadFile(link.Source)
+		adoptable := err == nil && bytes.Equal(source, expected)
+		if !adoptable {
+			dir, err := os.MkdirTemp("", "farm-merge-*")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(dir)
+
+			left = filepath.Join(dir, filepath.Base(target))
+			if err := os.WriteFile(left, expected, 0600); err != nil {
+				return fmt.Errorf("failed to write %s: %w", left, err)
+			}
+		}
+
+		if dryRun {
+			cmd.Printf("Will merge %s with %s\n", target, left)
+			return nil
+		}
+
+		if err := runDiffTool(left, target); err != nil {
+			return err
+		}
+
+		// The source may have been edited in the tool
+		if expected, err = l.Expected(target); err != nil {
+			return err
+		}
+		if current, err = os.ReadFile(target); err != nil {
+			return fmt.Errorf("failed to read %s: %w", target, err)
+		}
+
+		if bytes.Equal(current, expected) {
+			cmd.Printf("✓ %s matches its source\n", target)
+		} else {
+			current, err = reconcileMerge(cmd, target, link, current, expected, adoptable)
+			if err != nil || current == nil {
+				return err
+			}
+		}
+
+		lock.AddFile(target, link.Source, link.Kind, fsutil.Checksum(current))
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+		return nil
+	},
+}
+
+// runDiffTool opens $FARM_DIFFTOOL, or git difftool when it isn't set, on two
+// files and waits for it to exit.
+func runDiffTool(left, right string) error {
+	tool := strings.Fields(os.Getenv("FARM_DIFFTOOL"))
+	if len(tool) == 0 {
+		tool = []string{"git", "difftool", "--no-index", "--no-prompt"}
+	}
+
+	c := exec.Command(tool[0], append(tool[1:], left, right)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("failed to run %s: %w", tool[0], err)
+	}
+	return nil
+}
+
+// reconcileMerge settles a target that still differs from its source after
+// merging, adopting its edits into the source when it's a plain copy or
+// overwriting it with what farm would write, and returns the content the
+// target is left with. Nothing is returned when the user declines both.
+func reconcileMerge(cmd *cobra.Command, target string, link lockfile.Symlink, current, expected []byte, adoptable bool) ([]byte, error) {
+	if adoptable {
+		ok, err := confirm(cmd, fmt.Sprintf("Adopt the changes to %s into %s?", target, link.Source), false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err := os.WriteFile(link.Source, current, 0644); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", link.Source, err)
+			}
+			cmd.Printf("✓ Adopted the changes to %s into %s\n", target, link.Source)
+			return current, nil
+		}
+	}
+
+	ok, err := confirm(cmd, fmt.Sprintf("Overwrite %s with what farm would write?", target), false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cmd.Printf("Left %s as it is, 'farm fsck' will report it as modified\n", target)
+		return nil, nil
+	}
+
+	if err := os.WriteFile(target, expected, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", target, err)
+	}
+	cmd.Printf("✓ Reapplied %s\n", target)
+	return expected, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <old-source-path> <new-source-path>",
+	Short: "Move a file or directory within the repo, updating the config, links, and lockfile",
+	Long: `Move a file or directory within the repo, updating the config, links, and lockfile.
+
+The sources of the packages inside it and the fold, no_fold, and anchored
+ignore entries naming it are rewritten in the config, keeping comments.
+Everything linked from it is then relinked from its new place, at the same
+targets when only the package moved, and the lockfile is saved once all of
+it is done. Nothing moves when the rewritten config would be invalid, and the
+files and config are moved back when relinking fails.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := config.ExpandPath(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+		to, err := config.ExpandPath(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+
+		if _, err := os.Lstat(from); err != nil {
+			return fmt.Errorf("%s doesn't exist", from)
+		}
+		if _, err := os.Lstat(to); err == nil {
+			return fmt.Errorf("%s already exists", to)
+		}
+		if strings.HasPrefix(to, from+"/") {
+			return fmt.Errorf("can't move %s into itself", from)
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if !movesPackages(cfg, from) {
+			return fmt.Errorf("%s isn't part of any package", from)
+		}
+
+		lock, err := loadLockfile()
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		original, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		data, changes, err := config.Move(configPath, cfg, from, to)
+		if err != nil {
+			return err
+		}
+		// Nothing moves unless the rewritten config is valid
+		if _, err := config.Parse(data, configPath); err != nil {
+			return fmt.Errorf("moving %s to %s would break the config: %w", from, to, err)
+		}
+
+		// Links keep their target when the package they belong to moves as
+		// a whole, anything else lands somewhere else in its package
+		var moved []lockfile.Symlink
+		keepsTarget := make(map[string]bool)
+		for _, link := range lock.Symlinks.Sorted() {
+			if _, ok := cutPath(link.Source, from); !ok {
+				continue
+			}
+			moved = append(moved, link)
+			if pkg := cfg.FindPackage(link.Source); pkg != nil {
+				_, keepsTarget[link.Target] = cutPath(pkg.Source, from)
+			}
+		}
+
+		if verbose || dryRun {
+			for _, change := range changes {
+				cmd.Printf("  %s\n", change)
+			}
+			for _, link := range moved {
+				cmd.Printf("  ~ %s\n", link.Target)
+			}
+		}
+		if dryRun {
+			cmd.Printf("Will move %s to %s, updating %d config references and %d links\n", from, to, len(changes), len(moved))
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(to), err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to move %s: %w", from, err)
+		}
+		// Until the lockfile is saved, a failure puts the files and the
+		// config back, leaving the lockfile as it was
+		rollback := func(cause error) error {
+			if err := os.Rename(to, from); err != nil {
+				return fmt.Errorf("%w, and failed to move %s back: %v", cause, to, err)
+			}
+			if err := os.WriteFile(configPath, original, 0644); err != nil {
+				return fmt.Errorf("%w, and failed to restore the config file: %v", cause, err)
+			}
+			return fmt.Errorf("%w, moved %s back, run 'farm link' to restore its links", cause, from)
+		}
+
+		if len(changes) > 0 {
+			if err := os.WriteFile(configPath, data, 0644); err != nil {
+				return rollback(fmt.Errorf("failed to write config file: %w", err))
+			}
+		}
+
+		cfg, err = loadConfig()
+		if err != nil {
+			return rollback(fmt.Errorf("failed to load config: %w", err))
+		}
+
+		sources := make(map[string]bool)
+		for _, link := range moved {
+			source := movedPath(link.Source, from, to)
+			if pkg := cfg.FindPackage(source); pkg != nil {
+				sources[pkg.Source] = true
+			}
+			if err := unlinkMoved(cmd, lock, link, source, keepsTarget[link.Target]); err != nil {
+				return rollback(err)
+			}
+		}
+		rekeyMoved(lock, from, to)
+
+		var packages []*config.Package
+		for _, pkg := range cfg.Packages {
+			if sources[pkg.Source] {
+				packages = append(packages, pkg)
+			}
+		}
+
+		filteredConfig := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		result, err := linker.New(filteredConfig, lock, false).Link()
+		if err != nil {
+			return rollback(fmt.Errorf("failed to link: %w", err))
+		}
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+			return rollback(fmt.Errorf("relinking failed with %d errors", len(result.Errors)))
+		}
+
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+		if verbose {
+			printResult(cmd, result, false)
+		}
+		cmd.Printf("✓ Moved %s to %s, updated %d config references and %d links\n", from, to, len(changes), len(moved))
+		return nil
+	},
+}
+
+// movesPackages reports whether path lies in a package or holds one.
+func movesPackages(cfg *config.Config, path string) bool {
+	if cfg.FindPackage(path) != nil {
+		return true
+	}
+	for _, pkg := range cfg.Packages {
+		if _, ok := cutPath(pkg.Source, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unlinkMoved removes the link to a source that moved, for the linker to
+// create again from where it is now. Copied and rendered files that keep
+// their target stay in place, tracked with the new source. The ones whose
+// target changes are removed, unless they were edited since farm wrote them.
+func unlinkMoved(cmd *cobra.Command, lock *lockfile.LockFile, link lockfile.Symlink, source string, keepsTarget bool) error {
+	if link.Kind == lockfile.KindDirectory || (link.IsFile() && keepsTarget) {
+		link.Source = source
+		lock.Symlinks[link.Target] = link
+		return nil
+	}
+
+	lock.RemoveSymlink(link.Target)
+	info, err := os.Lstat(link.Target)
+	if err != nil {
+		return nil
+	}
+
+	if link.IsFile() {
+		content, err := os.ReadFile(link.Target)
+		if err != nil || link.Checksum == "" || fsutil.Checksum(content) != link.Checksum {
+			cmd.Printf("  ⚠ left %s in place, it was edited since farm wrote it\n", link.Target)
+			return nil
+		}
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	if err := os.Remove(link.Target); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", link.Target, err)
+	}
+	if verbose {
+		cmd.Printf("  - %s\n", link.Target)
+	}
+	return nil
+}
+
+// rekeyMoved moves what the lockfile records per package source or folded
+// directory to the new path.
+func rekeyMoved(lock *lockfile.LockFile, from, to string) {
+	for source, decision := range maps.Clone(lock.Folds) {
+		if _, ok := cutPath(source, from); ok {
+			delete(lock.Folds, source)
+			lock.Folds[movedPath(source, from, to)] = decision
+		}
+	}
+	for source, applied := range maps.Clone(lock.Applied) {
+		if _, ok := cutPath(source, from); ok {
+			delete(lock.Applied, source)
+			lock.Applied[movedPath(source, from, to)] = applied
+		}
+	}
+	for source, hash := range maps.Clone(lock.ConfigHashes) {
+		if _, ok := cutPath(source, from); ok {
+			delete(lock.ConfigHashes, source)
+			lock.ConfigHashes[movedPath(source, from, to)] = hash
+		}
+	}
+}
+
+// cutPath returns path relative to dir when it is dir or lies inside it.
+func cutPath(path, dir string) (string, bool) {
+	if path == dir {
+		return "", true
+	}
+	return strings.CutPrefix(path, dir+"/")
+}
+
+func movedPath(path, from, to string) string {
+	rest, _ := cutPath(path, from)
+	return filepath.Join(to, rest)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/archive"
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var packageEnv string
+
+var packageCmd = &cobra.Command{
+	Use:   "package",
+	Short: "Render the config into a self-contained archive for 'farm apply-archive'",
+	Long: `Render the config into a self-contained archive for 'farm apply-archive'.
+
+Every package of the environment is copied into a temporary directory with a
+fresh lockfile, like 'farm test', so templates are rendered, variants are
+resolved, and nothing outside of it is touched. The files produced are then
+written into a .tar.gz along with a manifest of where each goes, which
+'farm apply-archive' places on a machine without git or the repo.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if outputPath == "" || outputPath == "-" {
+			return fmt.Errorf("package needs a file to write to, set with -o")
+		}
+
+		// Targets already go under a temporary directory of their own
+		if targetBase != "" {
+			return fmt.Errorf("package can't be combined with --target-base")
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		var envArgs []string
+		if packageEnv != "" {
+			envArgs = []string{packageEnv}
+		}
+		if err := validateEnvironmentArg(envArgs, cfg); err != nil {
+			return err
+		}
+
+		packages := cfg.GetPackagesForEnvironment(packageEnv)
+		if err := fetchRemoteSources(cmd, packages, false); err != nil {
+			return err
+		}
+		if err := extractArchives(cmd, packages); err != nil {
+			return err
+		}
+
+		staging, err := os.MkdirTemp("", "farm-package-*")
+		if err != nil {
+			return fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		defer os.RemoveAll(staging)
+
+		for _, pkg := range packages {
+			pkg.Copy = true
+		}
+
+		stagingConfig := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+
+		// Remember where the targets really are before they're moved into
+		// the staging directory
+		originals := packageTargets(packages)
+		stagingConfig.RebaseTargets(staging)
+		staged := packageTargets(packages)
+
+		// Optional targets are skipped when the directory they live in
+		// doesn't exist, which in the staging directory none does
+		for _, target := range staged {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create staging directory: %w", err)
+			}
+		}
+
+		lock := lockfile.New()
+		result, err := linker.New(stagingConfig, lock, false).Link()
+		if err != nil {
+			return fmt.Errorf("failed to link: %w", err)
+		}
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+			return fmt.Errorf("packaging failed with %d errors", len(result.Errors))
+		}
+
+		homeDir, err := home.Dir()
+		if err != nil {
+			return fmt.Errorf("failed to determine home directory: %w", err)
+		}
+
+		var files []archive.ExportedFile
+		for _, link := range lock.Symlinks.Sorted() {
+			if !link.IsFile() {
+				continue
+			}
+
+			target := originalTarget(link.Target, originals, staged)
+			if rest, ok := strings.CutPrefix(target, homeDir+"/"); ok {
+				target = "~/" + rest
+			}
+			files = append(files, archive.ExportedFile{Path: link.Target, Target: target})
+			if verbose || dryRun {
+				cmd.Printf("  + %s\n", target)
+			}
+		}
+
+		if dryRun {
+			cmd.Printf("Will package %d files into %s\n", len(files), outputPath)
+			return nil
+		}
+
+		if err := archive.Export(outputPath, files); err != nil {
+			return fmt.Errorf("failed to write %s: %w", outputPath, err)
+		}
+		if err := signExport(cmd, outputPath); err != nil {
+			return err
+		}
+		cmd.Printf("✓ Packaged %d files into %s\n", len(files), outputPath)
+
+		return nil
+	},
+}
+
+// packageTargets lists every directory the packages write into, in the same
+// order however they're rebased.
+func packageTargets(packages []*config.Package) []string {
+	var targets []string
+	for _, pkg := range packages {
+		targets = append(targets, pkg.Targets...)
+		for _, download := range pkg.Downloads {
+			targets = append(targets, download.Dest)
+		}
+	}
+	return targets
+}
+
+// originalTarget maps a path in the staging directory back to where it
+// belongs, through the most specific staged target it lies in.
+func originalTarget(path string, originals, staged []string) string {
+	original, base := path, ""
+	for i, dir := range staged {
+		if (path == dir || strings.HasPrefix(path, dir+"/")) && len(dir) > len(base) {
+			rel, _ := filepath.Rel(dir, path)
+			original, base = filepath.Join(originals[i], rel), dir
+		}
+	}
+	return original
+}
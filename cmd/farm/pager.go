@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var noPager bool
+
+// startPager buffers the command's output when it goes to a terminal and
+// returns a function that shows it, through $PAGER when it doesn't fit on
+// the screen, like git does.
+func startPager(cmd *cobra.Command) func() {
+	original := cmd.OutOrStdout()
+	out, ok := unwrapPaths(original).(*os.File)
+	if noPager || !ok || !isTerminal(out) {
+		return func() {}
+	}
+
+	buf := new(bytes.Buffer)
+	cmd.SetOut(displayPaths(buf))
+
+	return func() {
+		cmd.SetOut(original)
+
+		pager := strings.Fields(os.Getenv("PAGER"))
+		if len(pager) == 0 {
+			pager = []string{"less"}
+		}
+		if pager[0] == "cat" || bytes.Count(buf.Bytes(), []byte("\n")) < terminalHeight(out) {
+			out.Write(buf.Bytes())
+			return
+		}
+
+		c := exec.Command(pager[0], pager[1:]...)
+		c.Stdin = bytes.NewReader(buf.Bytes())
+		c.Stdout = out
+		c.Stderr = os.Stderr
+		// Let less exit on its own for short output and keep colors
+		if _, ok := os.LookupEnv("LESS"); !ok {
+			c.Env = append(os.Environ(), "LESS=FRX")
+		}
+
+		// Fall back to printing directly when the pager can't be started
+		var exitErr *exec.ExitError
+		if err := c.Run(); err != nil && !errors.As(err, &exitErr) {
+			out.Write(buf.Bytes())
+		}
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// linesFromEnv returns $LINES, or the classic 24 rows when it isn't set.
+func linesFromEnv() int {
+	if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil && lines > 0 {
+		return lines
+	}
+	return 24
+}
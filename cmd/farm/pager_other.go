@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// terminalHeight returns $LINES, since the terminal isn't queried on this
+// platform.
+func terminalHeight(file *os.File) int {
+	return linesFromEnv()
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalHeight returns the number of rows of the terminal, falling back
+// to $LINES.
+func terminalHeight(file *os.File) int {
+	var size struct {
+		rows, cols, x, y uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	if errno == 0 && size.rows > 0 {
+		return int(size.rows)
+	}
+	return linesFromEnv()
+}
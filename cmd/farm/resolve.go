@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/conflict"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+// conflictReport is the file link writes its conflicts to instead of linking
+var conflictReport string
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve <report>",
+	Short: "Apply the resolutions in a conflict report written by link --conflict-report",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report, err := conflict.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		resolved := 0
+		for _, c := range report.Conflicts {
+			if c.Resolution == conflict.Skip {
+				continue
+			}
+
+			if dryRun {
+				cmd.Printf("Will %s %s\n", c.Resolution, c.Target)
+				continue
+			}
+
+			if verbose {
+				cmd.Printf("%s %s\n", c.Resolution, c.Target)
+			}
+			if err := conflict.Apply(c); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.Target, err))
+				continue
+			}
+			resolved++
+		}
+
+		if !dryRun {
+			cmd.Printf("✓ Resolved %d conflicts, run 'farm link' to link them\n", resolved)
+		}
+
+		return reportProvisionErrors(cmd, "resolve", errs)
+	},
+}
+
+// writeConflictReport plans the link and writes every conflict to the report
+// file with a proposed resolution, without changing anything. Links that were
+// replaced by a modified file are proposed to be adopted back.
+func writeConflictReport(cmd *cobra.Command, cfg *config.Config, lock *lockfile.LockFile) error {
+	conflicts := linker.New(cfg, lock, true).Conflicts()
+	if len(conflicts) == 0 {
+		cmd.Println("No conflicts found")
+		return nil
+	}
+
+	report := &conflict.Report{}
+	for _, c := range conflicts {
+		// Targets farm linked were replaced since, likely by the program
+		// whose settings they hold
+		if link, ok := lock.Symlinks[c.Target]; ok && !link.IsFile() && link.Kind != lockfile.KindDirectory {
+			report.Conflicts = append(report.Conflicts, conflict.NewShadowed(c.Target, c.Source))
+			continue
+		}
+		report.Conflicts = append(report.Conflicts, conflict.New(c.Target, c.Source))
+	}
+
+	if err := report.Save(conflictReport); err != nil {
+		return err
+	}
+
+	cmd.Printf("Wrote %d conflicts to %s, review them and run 'farm resolve %s'\n", len(conflicts), conflictReport, conflictReport)
+	return nil
+}
+
+// confirmForeignLinks asks before replacing the links in the way that point
+// into another dotfile manager's directory, with foreign_links set to ask.
+// They're replaced once confirmed and kept otherwise.
+func confirmForeignLinks(cmd *cobra.Command, cfg *config.Config, lock *lockfile.LockFile) error {
+	if dryRun || (cfg.Settings.ForeignLinks != "" && cfg.Settings.ForeignLinks != config.ForeignAsk) {
+		return nil
+	}
+
+	var foreign []*linker.ConflictError
+	for _, c := range linker.New(cfg, lock, true).Conflicts() {
+		if c.Manager != "" {
+			foreign = append(foreign, c)
+		}
+	}
+	if len(foreign) == 0 {
+		return nil
+	}
+
+	cmd.Printf("Found %d links of other dotfile managers in the way:\n", len(foreign))
+	for _, c := range foreign {
+		cmd.Printf("  %s (%s)\n", c.Target, c.Manager)
+	}
+
+	ok, err := confirm(cmd, "Replace them with farm's links?", false)
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Settings.ForeignLinks = config.ForeignReplace
+	} else {
+		cfg.Settings.ForeignLinks = config.ForeignKeep
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var resumeApply bool
+
+// applyState is the progress of a `farm apply`, saved after every step so
+// an apply that stops halfway, through a power loss or a failing hook or
+// install, can be resumed with --resume rather than redone from the start.
+// It's removed once an apply succeeds.
+type applyState struct {
+	Environment string    `json:"environment,omitempty"`
+	Started     time.Time `json:"started"`
+	// Linked is set once the links are in place and the link hooks ran
+	Linked bool `json:"linked,omitempty"`
+	// Done lists the hooks and installs that completed, such as
+	// "hook zsh pre_link" or "install brew fzf"
+	Done []string `json:"done,omitempty"`
+
+	path string
+}
+
+// applying is the state of the apply in progress, nil outside of one, in
+// which case nothing is recorded.
+var applying *applyState
+
+// applyStatePath is where the progress of an apply is saved, next to the
+// lockfile.
+func applyStatePath() string {
+	return lockfilePath + ".apply"
+}
+
+// loadApplyState returns the state of an interrupted apply, or nil when
+// there is none.
+func loadApplyState() (*applyState, error) {
+	path := applyStatePath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var state applyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	state.path = path
+	return &state, nil
+}
+
+func (s *applyState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode apply progress: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save apply progress: %w", err)
+	}
+	return nil
+}
+
+// isDone reports whether a step completed in an earlier run of the apply.
+func (s *applyState) isDone(step string) bool {
+	return s != nil && slices.Contains(s.Done, step)
+}
+
+// markDone records that a step completed. Dry runs complete nothing.
+func (s *applyState) markDone(step string) error {
+	if s == nil || dryRun {
+		return nil
+	}
+	s.Done = append(s.Done, step)
+	return s.save()
+}
+
+// startApply returns the state the apply records its progress in along with
+// its arguments, picking up where an interrupted apply stopped with --resume.
+// Dry runs without --resume record nothing.
+func startApply(cmd *cobra.Command, args []string) (*applyState, []string, error) {
+	args = environmentArgs(args)
+	environment := ""
+	if len(args) > 0 {
+		environment = args[0]
+	}
+
+	previous, err := loadApplyState()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !resumeApply {
+		if dryRun {
+			return nil, args, nil
+		}
+		if previous != nil {
+			cmd.Println("An earlier apply was interrupted, starting over. Use --resume to continue it instead.")
+		}
+		state := &applyState{Environment: environment, Started: time.Now(), path: applyStatePath()}
+		return state, args, state.save()
+	}
+
+	if previous == nil {
+		return nil, nil, fmt.Errorf("there is no interrupted apply to resume")
+	}
+	if environment != "" && environment != previous.Environment {
+		return nil, nil, fmt.Errorf("the interrupted apply was for environment '%s', not '%s'", previous.Environment, environment)
+	}
+	if previous.Environment != "" {
+		args = []string{previous.Environment}
+	}
+
+	cmd.Printf("Resuming the apply started %s, %d steps already done\n", previous.Started.Format(time.DateTime), len(previous.Done))
+	return previous, args, nil
+}
+
+// markLinked records that the links are in place and the link hooks ran.
+func (s *applyState) markLinked() error {
+	if s == nil || dryRun {
+		return nil
+	}
+	s.Linked = true
+	return s.save()
+}
+
+// complete removes the state once the whole apply succeeded.
+func (s *applyState) complete() error {
+	if s == nil || dryRun {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func hookStep(pkg, hook string) string {
+	return "hook " + pkg + " " + hook
+}
+
+func installStep(provisioner, item string) string {
+	return "install " + provisioner + " " + item
+}
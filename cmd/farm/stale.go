@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/i18n"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+// stalePackage is a package whose source changed after it was last linked.
+type stalePackage struct {
+	pkg     *config.Package
+	applied time.Time
+	changed time.Time
+}
+
+// findStalePackages returns the packages whose source has a file or
+// directory modified after the package was last linked. Packages that were
+// never linked aren't stale, just not linked.
+func findStalePackages(cfg *config.Config, packages []*config.Package, lock *lockfile.LockFile) []stalePackage {
+	var stale []stalePackage
+	for _, pkg := range packages {
+		applied, ok := lock.LastApplied(pkg.Source)
+		if !ok {
+			continue
+		}
+
+		if changed := lastChanged(cfg, pkg); changed.After(applied) {
+			stale = append(stale, stalePackage{pkg: pkg, applied: applied, changed: changed})
+		}
+	}
+	return stale
+}
+
+// lastChanged returns the newest modification time in the package's source,
+// skipping ignored paths since changing them changes nothing that is linked.
+func lastChanged(cfg *config.Config, pkg *config.Package) time.Time {
+	var newest time.Time
+	_ = filepath.WalkDir(pkg.Source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if rel, err := filepath.Rel(pkg.Source, path); err == nil && rel != "." {
+			if _, ignored := cfg.IgnoredIn(pkg, rel); ignored {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
+
+func printStalePackages(cmd *cobra.Command, stale []stalePackage, environment string) {
+	if len(stale) == 0 {
+		return
+	}
+
+	cmd.Printf("\n⚠ %s\n", i18n.Sprintf("%d packages changed since they were linked:", len(stale)))
+	for _, s := range stale {
+		cmd.Printf("  %s (%s)\n", s.pkg.Name, i18n.Sprintf("changed %s, linked %s", s.changed.Local().Format(time.DateTime), s.applied.Local().Format(time.DateTime)))
+	}
+
+	envMsg := ""
+	if environment != "" {
+		envMsg = " " + environment
+	}
+	cmd.Printf("\n%s\n", i18n.Sprintf("Run 'farm link%s' to apply the changes", envMsg))
+}
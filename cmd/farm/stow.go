@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/spf13/cobra"
+)
+
+// Flags mirroring GNU Stow for the stow compatibility commands
+var (
+	stowDir       string
+	stowTarget    string
+	stowLink      bool
+	stowDelete    bool
+	stowRestow    bool
+	stowNoFolding bool
+)
+
+var stowCmd = &cobra.Command{
+	Use:   "stow [-d dir] [-t target] [-S|-D|-R] <package>...",
+	Short: "Link packages using GNU Stow compatible arguments",
+	Long: `Link packages using GNU Stow compatible arguments.
+
+Each package is a directory inside the stow directory (default: the current
+directory) and is linked into the target directory (default: the parent of the
+stow directory). Links are tracked in the farm lockfile like any other link.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		action, err := stowAction()
+		if err != nil {
+			return err
+		}
+		return runStow(cmd, action, args)
+	},
+}
+
+var stowDeleteCmd = &cobra.Command{
+	Use:   "delete [-d dir] [-t target] <package>...",
+	Short: "Unlink packages, like stow -D",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStow(cmd, "delete", args)
+	},
+}
+
+var stowRestowCmd = &cobra.Command{
+	Use:   "restow [-d dir] [-t target] <package>...",
+	Short: "Unlink and relink packages, like stow -R",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStow(cmd, "restow", args)
+	},
+}
+
+func stowAction() (string, error) {
+	count := 0
+	action := "stow"
+	for _, flag := range []struct {
+		set    bool
+		action string
+	}{{stowLink, "stow"}, {stowDelete, "delete"}, {stowRestow, "restow"}} {
+		if flag.set {
+			count++
+			action = flag.action
+		}
+	}
+
+	if count > 1 {
+		return "", fmt.Errorf("only one of -S, -D, and -R may be given")
+	}
+	return action, nil
+}
+
+// stowConfig builds a config with one package per stow package, folding
+// directories by default like stow does.
+func stowConfig(packages []string) (*config.Config, error) {
+	dir := stowDir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve stow directory: %w", err)
+	}
+
+	target := stowTarget
+	if target == "" {
+		target = filepath.Dir(dir)
+	}
+
+	cfg := &config.Config{BaseDir: dir}
+	for _, name := range packages {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("package %s not found in %s", name, dir)
+		}
+
+		cfg.Packages = append(cfg.Packages, &config.Package{
+			Name:        name,
+			Source:      name,
+			Targets:     []string{target},
+			DefaultFold: !stowNoFolding,
+		})
+	}
+
+	return cfg.Resolve()
+}
+
+func runStow(cmd *cobra.Command, action string, packages []string) error {
+	cfg, err := stowConfig(packages)
+	if err != nil {
+		return err
+	}
+	if err := applyTargetBase(cfg); err != nil {
+		return err
+	}
+
+	lock, err := loadLockfile()
+	if err != nil {
+		return fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	l := linker.New(cfg, lock, dryRun)
+	result := &linker.LinkResult{}
+
+	if action == "delete" || action == "restow" {
+		unlinked, err := l.UnlinkPackages()
+		if err != nil {
+			return fmt.Errorf("failed to unlink: %w", err)
+		}
+		result.Removed = unlinked.Removed
+		result.Errors = unlinked.Errors
+		result.Warnings = unlinked.Warnings
+	}
+
+	if action == "stow" || action == "restow" {
+		linked, err := l.Link()
+		if err != nil {
+			return fmt.Errorf("failed to link: %w", err)
+		}
+		result.Created = linked.Created
+		result.Removed = append(result.Removed, linked.Removed...)
+		result.Replaced = linked.Replaced
+		result.Errors = append(result.Errors, linked.Errors...)
+		result.Warnings = append(result.Warnings, linked.Warnings...)
+	}
+
+	if verbose || dryRun {
+		printResult(cmd, result, dryRun)
+	}
+
+	for _, warning := range result.Warnings {
+		cmd.Printf("⚠ %s\n", warning)
+	}
+
+	if !dryRun {
+		if err := lock.Save(lockfilePath); err != nil {
+			return fmt.Errorf("failed to save lockfile: %w", err)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		printErrors(cmd, result)
+		return fmt.Errorf("%s completed with %d errors", action, len(result.Errors))
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/hooks"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var keepSandbox bool
+
+var testCmd = &cobra.Command{
+	Use:   "test [environment]",
+	Short: "Link the config into a throwaway directory and run its verify hooks",
+	Long: `Link the config into a throwaway directory and run its verify hooks.
+
+Every target is moved under a temporary directory, like --target-base, and
+linked with a fresh lockfile so nothing outside of it is touched. Each
+package's verify hook then runs with HOME and FARM_SANDBOX set to that
+directory.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args = environmentArgs(args)
+		if len(args) > 0 {
+			environment = args[0]
+		}
+
+		// Targets already go under a temporary directory of their own
+		if targetBase != "" {
+			return fmt.Errorf("test can't be combined with --target-base")
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if err := validateEnvironmentArg(args, cfg); err != nil {
+			return err
+		}
+
+		packages := cfg.GetPackagesForEnvironment(environment)
+		if err := fetchRemoteSources(cmd, packages, false); err != nil {
+			return err
+		}
+		if err := extractArchives(cmd, packages); err != nil {
+			return err
+		}
+
+		sandbox, err := os.MkdirTemp("", "farm-test-*")
+		if err != nil {
+			return fmt.Errorf("failed to create sandbox: %w", err)
+		}
+		if keepSandbox {
+			cmd.Printf("Sandbox: %s\n", sandbox)
+		} else {
+			defer os.RemoveAll(sandbox)
+		}
+
+		sandboxConfig := &config.Config{
+			Packages:    packages,
+			Ignore:      cfg.Ignore,
+			IgnoreGlobs: cfg.IgnoreGlobs,
+			Settings:    cfg.Settings,
+		}
+		sandboxConfig.RebaseTargets(sandbox)
+
+		lock := lockfile.New()
+		result, err := linker.New(sandboxConfig, lock, false).Link()
+		if err != nil {
+			return fmt.Errorf("failed to link: %w", err)
+		}
+
+		cmd.Printf("Produced %d entries:\n", len(lock.Symlinks))
+		for _, link := range lock.Symlinks.Sorted() {
+			rel, _ := filepath.Rel(sandbox, link.Target)
+			cmd.Printf("  ~/%s", rel)
+			switch {
+			case link.Kind != "":
+				cmd.Printf(" [%s]", link.Kind)
+			case link.IsFolded:
+				cmd.Printf(" -> %s [folded]", link.Source)
+			default:
+				cmd.Printf(" -> %s", link.Source)
+			}
+			cmd.Println()
+		}
+
+		failed := 0
+		for _, pkg := range packages {
+			command, ok := pkg.Hooks[hooks.Verify]
+			if !ok {
+				continue
+			}
+
+			ctx := hooks.Context{
+				Package: pkg.Name,
+				Source:  pkg.Source,
+				Targets: pkg.Targets,
+				Changed: result.Changed[pkg.Source],
+				Sandbox: sandbox,
+				Options: pkg.HookOptions,
+			}
+			if err := hooks.Run(command, ctx, rawOut(cmd), rawErr(cmd)); err != nil {
+				cmd.Printf("✗ %s: %v\n", pkg.Name, err)
+				failed++
+				continue
+			}
+			cmd.Printf("✓ %s verified\n", pkg.Name)
+		}
+
+		if len(result.Errors) > 0 {
+			printErrors(cmd, result)
+		}
+
+		if len(result.Errors) > 0 || failed > 0 {
+			return fmt.Errorf("test failed with %d link errors and %d failed verify hooks", len(result.Errors), failed)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/i18n"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var statusTree bool
+
+// statusGroup is the links of one package under one of its targets.
+type statusGroup struct {
+	target string
+	links  []lockfile.Symlink
+	dead   int
+}
+
+// printStatusTree prints the links grouped by package and then by target,
+// with the number of healthy and dead links of every group. Links whose
+// package is no longer configured are grouped on their own.
+func printStatusTree(cmd *cobra.Command, cfg *config.Config, links []lockfile.Symlink, dead map[string]bool) {
+	var order []string
+	packages := make(map[string]*config.Package)
+	groups := make(map[string][]*statusGroup)
+
+	for _, link := range links {
+		name := i18n.Sprintf("(unconfigured)")
+		target := filepath.Dir(link.Target)
+		if pkg := cfg.FindPackage(link.Source); pkg != nil {
+			name = pkg.Name
+			packages[name] = pkg
+			for _, t := range pkg.Targets {
+				if link.Target == t || strings.HasPrefix(link.Target, t+"/") {
+					target = t
+					break
+				}
+			}
+		}
+
+		if _, ok := groups[name]; !ok {
+			order = append(order, name)
+		}
+
+		var group *statusGroup
+		for _, g := range groups[name] {
+			if g.target == target {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &statusGroup{target: target}
+			groups[name] = append(groups[name], group)
+		}
+
+		group.links = append(group.links, link)
+		if dead[link.Target] {
+			group.dead++
+		}
+	}
+
+	for _, name := range order {
+		total, deadCount := 0, 0
+		for _, group := range groups[name] {
+			total += len(group.links)
+			deadCount += group.dead
+		}
+
+		cmd.Printf("\n%s %s\n", name, healthCounts(total, deadCount))
+		for _, group := range groups[name] {
+			cmd.Printf("  %s %s\n", group.target, healthCounts(len(group.links), group.dead))
+
+			for _, link := range group.links {
+				mark := "✓"
+				if dead[link.Target] {
+					mark = "✗"
+				}
+
+				source := link.Source
+				if pkg := packages[name]; pkg != nil {
+					if rel, err := filepath.Rel(pkg.Source, link.Source); err == nil {
+						source = rel
+					}
+				}
+				target, err := filepath.Rel(group.target, link.Target)
+				if err != nil {
+					target = link.Target
+				}
+
+				cmd.Printf("    %s %s -> %s", mark, target, source)
+				if link.IsFolded {
+					cmd.Print(" [folded]")
+				}
+				if link.Kind != "" {
+					cmd.Printf(" [%s]", link.Kind)
+				}
+				cmd.Println()
+			}
+		}
+	}
+}
+
+func healthCounts(total, dead int) string {
+	if dead == 0 {
+		return i18n.Sprintf("(%d ok)", total)
+	}
+	return i18n.Sprintf("(%d ok, %d dead)", total-dead, dead)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/spf13/cobra"
+)
+
+var whichCmd = &cobra.Command{
+	Use:   "which <target>",
+	Short: "Show which package and source file manage a target path",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, lock, err := loadTarget(args[0])
+		if err != nil {
+			return err
+		}
+
+		link, source, tracked, err := findSource(cfg, lock, path)
+		if err != nil {
+			return err
+		}
+
+		cmd.Println(path)
+		if pkg := cfg.FindPackage(source); pkg != nil {
+			cmd.Printf("  package: %s\n", pkg.Name)
+		} else {
+			cmd.Println("  package: (unconfigured)")
+		}
+		cmd.Printf("  source:  %s\n", source)
+		if !tracked {
+			cmd.Println("  ⚠ not tracked in the lockfile, run 'farm link' to track it")
+			return nil
+		}
+		if link.Target != path {
+			cmd.Printf("  via:     %s [folded]\n", link.Target)
+		}
+		if link.Kind != "" {
+			cmd.Printf("  kind:    %s\n", link.Kind)
+		}
+
+		return nil
+	},
+}
+
+var whyIgnoredCmd = &cobra.Command{
+	Use:   "why-ignored <source>",
+	Short: "Show which ignore pattern keeps a file of a package from being linked",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := config.ExpandPath(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		pkg := cfg.FindPackage(path)
+		if pkg == nil {
+			return fmt.Errorf("%s isn't part of any package", path)
+		}
+		rel, err := filepath.Rel(pkg.Source, path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+
+		cmd.Println(path)
+		cmd.Printf("  package: %s\n", pkg.Name)
+
+		// Linking stops at the first ignored directory, so that's the path
+		// the pattern excluded
+		l := linker.New(cfg, lockfile.New(), true)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if rel == "." {
+			parts = nil
+		}
+		for i := range parts {
+			excluded := strings.Join(parts[:i+1], "/")
+			pattern, ok := l.Ignored(pkg, excluded)
+			if !ok {
+				continue
+			}
+
+			cmd.Printf("  pattern: %s\n", describeIgnore(pattern))
+			if i < len(parts)-1 {
+				cmd.Printf("  ignores: %s, and everything in it\n", filepath.Join(pkg.Source, excluded))
+			}
+			return nil
+		}
+
+		cmd.Println("  not ignored")
+		return nil
+	},
+}
+
+// describeIgnore explains where an ignore pattern comes from and what it
+// matches.
+func describeIgnore(pattern string) string {
+	switch pattern {
+	case config.GitignorePattern:
+		return pattern + " (respect_gitignore is on)"
+	case linker.OnlyTrackedPattern:
+		return pattern + " (git doesn't track it)"
+	}
+	return fmt.Sprintf("%s (%s)", pattern, config.ParseIgnore(pattern))
+}
+
+var editCmd = &cobra.Command{
+	Use:   "edit <target>",
+	Short: "Open the source file behind a target path in $EDITOR",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, lock, err := loadTarget(args[0])
+		if err != nil {
+			return err
+		}
+
+		_, source, _, err := findSource(cfg, lock, path)
+		if err != nil {
+			return err
+		}
+
+		editor := strings.Fields(os.Getenv("VISUAL"))
+		if len(editor) == 0 {
+			editor = strings.Fields(os.Getenv("EDITOR"))
+		}
+		if len(editor) == 0 {
+			editor = []string{"vi"}
+		}
+
+		if verbose {
+			cmd.Printf("Editing %s\n", source)
+		}
+
+		c := exec.Command(editor[0], append(editor[1:], source)...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("failed to run %s: %w", editor[0], err)
+		}
+
+		return nil
+	},
+}
+
+// loadTarget expands a target path given on the command line and loads the
+// config and lockfile to look it up in.
+func loadTarget(arg string) (string, *config.Config, *lockfile.LockFile, error) {
+	path, err := config.ExpandPath(arg)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	lock, err := loadLockfile()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	return path, cfg, lock, nil
+}
+
+// findSource returns the source file behind a target path and the lockfile
+// entry that manages it. Links farm lost track of are traced through the
+// filesystem to a configured package instead, and reported as untracked.
+func findSource(cfg *config.Config, lock *lockfile.LockFile, path string) (lockfile.Symlink, string, bool, error) {
+	if link, source, ok := lock.Which(path); ok {
+		return link, source, true, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || resolved == path || cfg.FindPackage(resolved) == nil {
+		return lockfile.Symlink{}, "", false, fmt.Errorf("%s is not managed by farm", path)
+	}
+	return lockfile.Symlink{}, resolved, false, nil
+}
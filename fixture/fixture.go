@@ -0,0 +1,205 @@
+// Package fixture builds trees of sources and targets from a small YAML spec,
+// for tests of farm and of programs embedding it, and for reproducing a bug
+// without sharing a whole dotfiles repo:
+//
+//	config: |
+//	  packages:
+//	    - source: ./zsh
+//	      targets: [./home]
+//	files:
+//	  - path: zsh/.zshrc
+//	    content: export EDITOR=nvim
+//	  - path: home/.zshrc
+//	    content: export EDITOR=vim
+//	  - path: home/.config
+//	    dir: true
+//	  - path: home/.vimrc
+//	    link: ../vim/.vimrc
+//
+// {root} in the config, the content of files, and the destination of links is
+// replaced with the absolute path of the directory the tree is built in.
+package fixture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the name the config of a spec is written to at the root of
+// the tree.
+const ConfigFile = "farm.yaml"
+
+type Spec struct {
+	// Config is written to ConfigFile, when set.
+	Config string `yaml:"config,omitempty"`
+	Files  []File `yaml:"files,omitempty"`
+}
+
+// File is a regular file, a directory when Dir is set, or a symlink to Link.
+type File struct {
+	// Path is relative to the root of the tree.
+	Path    string `yaml:"path"`
+	Content string `yaml:"content,omitempty"`
+	// Mode is the octal permissions of the file or directory, 0644 and 0755
+	// when unset.
+	Mode string `yaml:"mode,omitempty"`
+	Dir  bool   `yaml:"dir,omitempty"`
+	Link string `yaml:"link,omitempty"`
+}
+
+// Load reads a spec from a YAML file.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse reads a spec from YAML, checking that every file stays inside the
+// tree and is only one kind of file.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture: %w", err)
+	}
+
+	for i, file := range spec.Files {
+		if !inside(file.Path) {
+			return nil, fmt.Errorf("file %d: path must be relative to the root of the tree", i)
+		}
+		if file.Dir && (file.Link != "" || file.Content != "") {
+			return nil, fmt.Errorf("file %s: a directory can't have content or a link", file.Path)
+		}
+		if file.Link != "" && (file.Content != "" || file.Mode != "") {
+			return nil, fmt.Errorf("file %s: a link can't have content or a mode", file.Path)
+		}
+		if _, err := file.mode(); err != nil {
+			return nil, fmt.Errorf("file %s: %w", file.Path, err)
+		}
+	}
+	return &spec, nil
+}
+
+// Build creates the tree in root, which is created when it doesn't exist.
+// Directories above each file are created as needed, and files that already
+// exist are replaced. Files are never created through a symlink, so a spec
+// can't write outside root with a link followed by a file inside it.
+func (s *Spec) Build(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", root, err)
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return err
+	}
+	expand := strings.NewReplacer("{root}", root).Replace
+
+	if s.Config != "" {
+		path := filepath.Join(root, ConfigFile)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %s: %w", ConfigFile, err)
+		}
+		if err := os.WriteFile(path, []byte(expand(s.Config)), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", ConfigFile, err)
+		}
+	}
+
+	for _, file := range s.Files {
+		// Specs that weren't parsed are checked too, the root is never replaced
+		if !inside(file.Path) {
+			return fmt.Errorf("file %s: path must be relative to the root of the tree", file.Path)
+		}
+		clean := filepath.Clean(file.Path)
+
+		dir, err := mkdirInside(root, filepath.Dir(clean))
+		if err != nil {
+			return fmt.Errorf("file %s: %w", file.Path, err)
+		}
+
+		// Replacing what's there removes a link rather than what it points at
+		path := filepath.Join(dir, filepath.Base(clean))
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", path, err)
+		}
+
+		mode, _ := file.mode()
+		switch {
+		case file.Dir:
+			err = os.Mkdir(path, mode)
+		case file.Link != "":
+			err = os.Symlink(expand(file.Link), path)
+		default:
+			err = os.WriteFile(path, []byte(expand(file.Content)), mode)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", file.Path, err)
+		}
+
+		// The umask may have taken permissions away
+		if file.Mode != "" {
+			if err := os.Chmod(path, mode); err != nil {
+				return fmt.Errorf("failed to set the mode of %s: %w", file.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// inside reports whether path names a file below the root of the tree,
+// rather than the root itself or somewhere outside it.
+func inside(path string) bool {
+	clean := filepath.Clean(path)
+	return path != "" && !filepath.IsAbs(clean) && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// mkdirInside creates the directories of rel below root one at a time,
+// refusing to go through a symlink, which an earlier file of the spec may have
+// pointed outside the tree.
+func mkdirInside(root, rel string) (string, error) {
+	dir := root
+	if rel == "." {
+		return dir, nil
+	}
+
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		dir = filepath.Join(dir, name)
+		info, err := os.Lstat(dir)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return "", fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+		case err != nil:
+			return "", err
+		case info.Mode()&os.ModeSymlink != 0:
+			return "", fmt.Errorf("%s is a symlink, files can't be created through it", dir)
+		case !info.IsDir():
+			return "", fmt.Errorf("%s isn't a directory", dir)
+		}
+	}
+	return dir, nil
+}
+
+func (f File) mode() (os.FileMode, error) {
+	if f.Mode == "" {
+		if f.Dir {
+			return 0755, nil
+		}
+		return 0644, nil
+	}
+
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %s, expected octal permissions such as 0755", f.Mode)
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,100 @@
+package fixture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	root := t.TempDir()
+
+	spec, err := Parse([]byte(`config: |
+  packages:
+    - source: ./zsh
+      targets: [{root}/home]
+files:
+  - path: zsh/.zshrc
+    content: export EDITOR=nvim
+  - path: zsh/bin/run
+    content: "#!/bin/sh"
+    mode: 0700
+  - path: home/.config
+    dir: true
+  - path: home/.vimrc
+    link: "{root}/vim/.vimrc"
+`))
+	require.NoError(t, err)
+	require.NoError(t, spec.Build(root))
+
+	config, err := os.ReadFile(filepath.Join(root, ConfigFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(config), "targets: ["+root+"/home]")
+
+	content, err := os.ReadFile(filepath.Join(root, "zsh/.zshrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "export EDITOR=nvim", string(content))
+
+	info, err := os.Stat(filepath.Join(root, "zsh/bin/run"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	assert.DirExists(t, filepath.Join(root, "home/.config"))
+
+	dest, err := os.Readlink(filepath.Join(root, "home/.vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "vim/.vimrc"), dest)
+
+	// Building again replaces what's there
+	require.NoError(t, spec.Build(root))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse([]byte("files:\n  - path: ../outside\n"))
+	assert.ErrorContains(t, err, "file 0: path must be relative to the root of the tree")
+
+	for _, path := range []string{".", "a/.."} {
+		_, err = Parse([]byte("files:\n  - path: " + path + "\n"))
+		assert.ErrorContains(t, err, "file 0: path must be relative to the root of the tree")
+	}
+
+	_, err = Parse([]byte("files:\n  - path: home\n    dir: true\n    content: x\n"))
+	assert.ErrorContains(t, err, "file home: a directory can't have content or a link")
+
+	_, err = Parse([]byte("files:\n  - path: run\n    mode: 999\n"))
+	assert.ErrorContains(t, err, "file run: invalid mode 999")
+}
+
+func TestBuildKeepsRoot(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "precious.txt"), []byte("keep"), 0644))
+
+	spec := &Spec{Files: []File{{Path: "a/..", Content: "x"}}}
+	assert.ErrorContains(t, spec.Build(root), "file a/..: path must be relative to the root of the tree")
+	assert.FileExists(t, filepath.Join(root, "precious.txt"))
+}
+
+func TestBuildThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	spec, err := Parse([]byte(`files:
+  - path: esc
+    link: ` + outside + `
+  - path: esc/victim
+    content: x
+`))
+	require.NoError(t, err)
+	assert.ErrorContains(t, spec.Build(root), "file esc/victim: "+filepath.Join(root, "esc")+" is a symlink")
+	assert.NoFileExists(t, filepath.Join(outside, "victim"))
+
+	// Replacing a link removes the link, not what it points at
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "keep"), []byte("x"), 0644))
+	spec, err = Parse([]byte("files:\n  - path: esc\n    dir: true\n"))
+	require.NoError(t, err)
+	require.NoError(t, spec.Build(root))
+	assert.FileExists(t, filepath.Join(outside, "keep"))
+}
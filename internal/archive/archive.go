@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/fsutil"
+)
+
+var extensions = []string{".tar.gz", ".tgz", ".zip"}
+
+// IsArchive reports whether path names an archive farm can extract, judging
+// by its extension.
+func IsArchive(path string) bool {
+	for _, ext := range extensions {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the archive's file name without its extension.
+func Name(path string) string {
+	name := filepath.Base(path)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+func DefaultCacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "farm", "archives")
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache", "farm", "archives")
+}
+
+// entryDir is the directory in the cache holding everything about the
+// archive at path, keyed by the path so the extracted tree doesn't move when
+// the archive changes.
+func entryDir(cacheDir, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:8]))
+}
+
+// Dir returns the directory the archive at path is extracted to.
+func Dir(cacheDir, path string) string {
+	return filepath.Join(entryDir(cacheDir, path), Name(path))
+}
+
+// IsCurrent reports whether the archive has been extracted and hasn't changed
+// since.
+func IsCurrent(cacheDir, path string) (bool, error) {
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return false, err
+	}
+
+	extracted, err := os.ReadFile(filepath.Join(entryDir(cacheDir, path), "checksum"))
+	if err != nil {
+		return false, nil
+	}
+	return strings.TrimSpace(string(extracted)) == checksum, nil
+}
+
+// Extract extracts the archive at path into the cache, replacing the tree
+// extracted from an earlier version of it. The tree is only swapped out once
+// the archive was extracted in full.
+func Extract(cacheDir, path string) error {
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+
+	entry := entryDir(cacheDir, path)
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp(entry, ".extract-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	if strings.HasSuffix(path, ".zip") {
+		err = extractZip(path, tmp)
+	} else {
+		err = extractTarGz(path, tmp)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+
+	dir := Dir(cacheDir, path)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", dir, err)
+	}
+
+	return os.WriteFile(filepath.Join(entry, "checksum"), []byte(checksum+"\n"), 0644)
+}
+
+func fileChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	return fsutil.Checksum(data), nil
+}
+
+// destination returns where an entry of the archive is extracted to, refusing
+// names that would escape dest.
+func destination(dest, name string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %s is outside the archive", name)
+	}
+	return target, nil
+}
+
+func extractTarGz(path, dest string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := destination(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr, header.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			// Links may only point inside the archive, or files written
+			// through them could land anywhere
+			if filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("entry %s links outside the archive", header.Name)
+			}
+			if _, err := destination(dest, filepath.Join(filepath.Dir(filepath.FromSlash(header.Name)), header.Linkname)); err != nil {
+				return fmt.Errorf("entry %s links outside the archive", header.Name)
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(path, dest string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		target, err := destination(dest, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
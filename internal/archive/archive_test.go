@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "theme", Name("/vendor/theme.tar.gz"))
+	assert.Equal(t, "theme", Name("/vendor/theme.tgz"))
+	assert.Equal(t, "theme", Name("/vendor/theme.zip"))
+	assert.True(t, IsArchive("theme.tgz"))
+	assert.False(t, IsArchive("theme.tar"))
+}
+
+func TestExtract(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	path := filepath.Join(tmpDir, "theme.tar.gz")
+	writeTarGz(t, path, map[string]string{"colors.conf": "dark", "fonts/font.conf": "mono"})
+
+	current, err := IsCurrent(cacheDir, path)
+	require.NoError(t, err)
+	assert.False(t, current)
+
+	require.NoError(t, Extract(cacheDir, path))
+	dir := Dir(cacheDir, path)
+	assert.Equal(t, "theme", filepath.Base(dir))
+
+	content, err := os.ReadFile(filepath.Join(dir, "fonts/font.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "mono", string(content))
+
+	current, err = IsCurrent(cacheDir, path)
+	require.NoError(t, err)
+	assert.True(t, current)
+
+	// A new version replaces the extracted tree in the same place
+	writeTarGz(t, path, map[string]string{"colors.conf": "light"})
+	current, err = IsCurrent(cacheDir, path)
+	require.NoError(t, err)
+	assert.False(t, current)
+
+	require.NoError(t, Extract(cacheDir, path))
+	assert.Equal(t, dir, Dir(cacheDir, path))
+	content, err = os.ReadFile(filepath.Join(dir, "colors.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "light", string(content))
+	assert.NoFileExists(t, filepath.Join(dir, "fonts/font.conf"))
+}
+
+func TestExtractZip(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	path := filepath.Join(tmpDir, "theme.zip")
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	zw := zip.NewWriter(file)
+	w, err := zw.Create("theme/colors.conf")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("dark"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, file.Close())
+
+	require.NoError(t, Extract(cacheDir, path))
+	content, err := os.ReadFile(filepath.Join(Dir(cacheDir, path), "theme/colors.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "dark", string(content))
+}
+
+func TestExtractOutsideArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	path := filepath.Join(tmpDir, "evil.tar.gz")
+	writeTarGz(t, path, map[string]string{"../../escaped": "oops"})
+
+	assert.ErrorContains(t, Extract(cacheDir, path), "entry ../../escaped is outside the archive")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "escaped"))
+
+	current, err := IsCurrent(cacheDir, path)
+	require.NoError(t, err)
+	assert.False(t, current)
+}
+
+func TestExportApply(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, file := range []string{"zshrc", "nvim/init.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, "src", file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "src", file), []byte(file), 0600))
+	}
+
+	path := filepath.Join(tmpDir, "dotfiles.tar.gz")
+	etc := filepath.Join(tmpDir, "etc", "motd")
+	require.NoError(t, Export(path, []ExportedFile{
+		{Path: filepath.Join(tmpDir, "src", "zshrc"), Target: "~/.zshrc"},
+		{Path: filepath.Join(tmpDir, "src", "nvim/init.lua"), Target: "~/.config/nvim/init.lua"},
+		{Path: filepath.Join(tmpDir, "src", "zshrc"), Target: etc},
+	}))
+
+	homeDir := filepath.Join(tmpDir, "home")
+	applied, err := Apply(path, homeDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(homeDir, ".zshrc"), filepath.Join(homeDir, ".config", "nvim", "init.lua"), etc}, applied)
+	assert.NoDirExists(t, homeDir)
+
+	// Existing files are overwritten, symlinks replaced rather than written
+	// through
+	require.NoError(t, os.MkdirAll(homeDir, 0755))
+	require.NoError(t, os.Symlink(filepath.Join(tmpDir, "src", "zshrc"), filepath.Join(homeDir, ".zshrc")))
+
+	_, err = Apply(path, homeDir, false)
+	require.NoError(t, err)
+
+	info, err := os.Lstat(filepath.Join(homeDir, ".zshrc"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	content, err := os.ReadFile(filepath.Join(homeDir, ".config", "nvim", "init.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "nvim/init.lua", string(content))
+	assert.FileExists(t, etc)
+
+	// Archives from elsewhere have no manifest
+	other := filepath.Join(tmpDir, "theme.tar.gz")
+	writeTarGz(t, other, map[string]string{"colors.conf": "dark"})
+	_, err = Apply(other, homeDir, false)
+	assert.ErrorContains(t, err, "has no farm-manifest.yaml")
+}
+
+func TestSignVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	key := filepath.Join(tmpDir, "id_ed25519")
+	other := filepath.Join(tmpDir, "other")
+	for _, path := range []string{key, other} {
+		require.NoError(t, exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", path).Run())
+	}
+
+	public, err := os.ReadFile(key + ".pub")
+	require.NoError(t, err)
+	signers := filepath.Join(tmpDir, "allowed_signers")
+	require.NoError(t, os.WriteFile(signers, append([]byte("team@example.com "), public...), 0644))
+
+	path := filepath.Join(tmpDir, "dotfiles.tar.gz")
+	require.NoError(t, os.WriteFile(path, []byte("archive"), 0644))
+
+	_, err = Verify(path, signers)
+	assert.ErrorContains(t, err, "isn't signed")
+
+	require.NoError(t, Sign(path, key))
+	signer, err := Verify(path, signers)
+	require.NoError(t, err)
+	assert.Equal(t, "team@example.com", signer)
+
+	// Signing again replaces the signature
+	require.NoError(t, Sign(path, key))
+
+	// A changed archive no longer matches its signature
+	require.NoError(t, os.WriteFile(path, []byte("tampered"), 0644))
+	_, err = Verify(path, signers)
+	assert.ErrorContains(t, err, "doesn't match")
+
+	// Keys that aren't allowed are refused
+	require.NoError(t, Sign(path, other))
+	_, err = Verify(path, signers)
+	assert.ErrorContains(t, err, "isn't signed by anyone in")
+}
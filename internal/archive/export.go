@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the entry of an exported archive listing where each of
+// its files is placed. It comes first, so the archive can be applied while
+// it's read.
+const ManifestName = "farm-manifest.yaml"
+
+// ExportedFile is a file put into an exported archive, read from Path and
+// placed at Target, which starts with ~/ when it lives in the home
+// directory.
+type ExportedFile struct {
+	Path   string
+	Target string
+}
+
+type manifest struct {
+	Files []manifestEntry `yaml:"files"`
+}
+
+type manifestEntry struct {
+	Target string `yaml:"target"`
+	Path   string `yaml:"path"`
+}
+
+// entryName is where a file placed at target is stored in the archive,
+// keeping files in the home directory apart from the rest.
+func entryName(target string) string {
+	if rest, ok := strings.CutPrefix(target, "~/"); ok {
+		return path.Join("home", filepath.ToSlash(rest))
+	}
+	return path.Join("root", filepath.ToSlash(target))
+}
+
+// Export writes files into a .tar.gz archive at dest along with a manifest
+// of where they go, so Apply can place them on a machine without the repo
+// they came from.
+func Export(dest string, files []ExportedFile) error {
+	var m manifest
+	for _, file := range files {
+		m.Files = append(m.Files, manifestEntry{Target: file.Target, Path: entryName(file.Target)})
+	}
+
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		if err := addFile(tw, file.Path, m.Files[i].Path); err != nil {
+			return fmt.Errorf("failed to add %s: %w", file.Path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addFile(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// Apply places the files of an archive written by Export, expanding ~/ to
+// homeDir, and returns where they went. Existing files are overwritten. A
+// dry run only returns where they would go.
+func Apply(src, homeDir string, dryRun bool) ([]string, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+
+	var targets map[string]string
+	var applied []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return applied, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if header.Name == ManifestName {
+			if targets, err = readManifest(tr, homeDir); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if targets == nil {
+			return nil, fmt.Errorf("%s has no %s, it wasn't exported by farm", src, ManifestName)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target, ok := targets[header.Name]
+		if !ok {
+			return applied, fmt.Errorf("entry %s isn't listed in the manifest", header.Name)
+		}
+
+		if !dryRun {
+			// Remove first so a symlink at the target isn't written through
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return applied, fmt.Errorf("failed to replace %s: %w", target, err)
+			}
+			if err := writeFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return applied, fmt.Errorf("failed to write %s: %w", target, err)
+			}
+		}
+		applied = append(applied, target)
+	}
+
+	if targets == nil {
+		return nil, fmt.Errorf("%s has no %s, it wasn't exported by farm", src, ManifestName)
+	}
+	return applied, nil
+}
+
+// readManifest returns where each entry of the archive is placed.
+func readManifest(r io.Reader, homeDir string) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	targets := make(map[string]string, len(m.Files))
+	for _, entry := range m.Files {
+		target := entry.Target
+		if rest, ok := strings.CutPrefix(target, "~/"); ok {
+			target = filepath.Join(homeDir, rest)
+		}
+		if !filepath.IsAbs(target) {
+			return nil, fmt.Errorf("manifest target %s must be absolute or start with ~/", entry.Target)
+		}
+		targets[entry.Path] = filepath.Clean(target)
+	}
+	return targets, nil
+}
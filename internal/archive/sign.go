@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// signatureNamespace keeps signatures made for farm exports from being
+// valid for anything else signed with the same key, such as git commits.
+const signatureNamespace = "farm"
+
+// SignaturePath is where the signature of the export at path is kept.
+func SignaturePath(path string) string {
+	return path + ".sig"
+}
+
+// Sign signs an archive or exported lockfile at path with an SSH key using
+// ssh-keygen, writing the signature next to it. The key may be a public key
+// whose private half is loaded in ssh-agent.
+func Sign(path, key string) error {
+	if err := os.Remove(SignaturePath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", SignaturePath(path), err)
+	}
+
+	out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", key, "-n", signatureNamespace, path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Verify checks the signature of the export at path against an allowed
+// signers file, the format git uses for SSH signatures, and returns who
+// signed it.
+func Verify(path, allowedSigners string) (string, error) {
+	sig := SignaturePath(path)
+	if _, err := os.Stat(sig); err != nil {
+		return "", fmt.Errorf("%s isn't signed, %s doesn't exist", path, sig)
+	}
+
+	out, err := exec.Command("ssh-keygen", "-Y", "find-principals", "-s", sig, "-f", allowedSigners).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s isn't signed by anyone in %s", path, allowedSigners)
+	}
+	principal, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedSigners, "-I", principal, "-n", signatureNamespace, "-s", sig)
+	cmd.Stdin = file
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("signature of %s doesn't match: %s", path, strings.TrimSpace(string(out)))
+	}
+	return principal, nil
+}
@@ -1,27 +1,529 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mskelton/farm/internal/archive"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/git"
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/hooks"
+	"github.com/mskelton/farm/internal/provision"
+	"github.com/mskelton/farm/internal/remote"
+	"github.com/mskelton/farm/transform"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Packages    []*Package `yaml:"packages"`
-	Ignore      []string   `yaml:"ignore,omitempty"`
+	Packages    []*Package          `yaml:"packages"`
+	Ignore      []string            `yaml:"ignore,omitempty"`
+	BaseDir     string              `yaml:"base_dir,omitempty"`
+	Repos       map[string]*Repo    `yaml:"repos,omitempty"`
+	Settings    Settings            `yaml:"settings,omitempty"`
+	Bundle      map[string][]string `yaml:"bundle,omitempty"`
+	Minimal     map[string]*Minimal `yaml:"minimal,omitempty"`
 	IgnoreGlobs []string
+
+	// gitignore caches the .gitignore files read for RespectGitignore
+	gitignore *git.Ignore
+}
+
+type Settings struct {
+	MaxChanges int                `yaml:"max_changes,omitempty"`
+	Retry      fsutil.RetryPolicy `yaml:"retry,omitempty"`
+	// SkipFailedTargets links a package's healthy targets even when another
+	// of its targets fails
+	SkipFailedTargets bool `yaml:"skip_failed_targets,omitempty"`
+	// CleanOnLink removes dead links at the start of every link. Unset
+	// means true.
+	CleanOnLink *bool `yaml:"clean_on_link,omitempty"`
+	// CleanAll removes every dead link in the lockfile rather than only the
+	// ones of the packages being linked, set by --clean-all
+	CleanAll bool `yaml:"-"`
+	// RemoveEmptyDirs removes the directories unlink leaves empty, set by
+	// --remove-empty-dirs
+	RemoveEmptyDirs bool `yaml:"-"`
+	// Paths limits linking to these paths relative to the package sources,
+	// whose components may be globs, set by --path
+	Paths []string `yaml:"-"`
+	// NormalizeUnicode composes file names to NFC before matching patterns
+	// and tracking targets. Unset means true on macOS, which may return
+	// names decomposed.
+	NormalizeUnicode *bool `yaml:"normalize_unicode,omitempty"`
+	// RequireClean checks that the repositories of the linked packages have
+	// no uncommitted changes before linking
+	RequireClean Requirement `yaml:"require_clean,omitempty"`
+	// RequireUpToDate checks that the repositories of the linked packages
+	// aren't behind their upstream before linking
+	RequireUpToDate Requirement `yaml:"require_up_to_date,omitempty"`
+	// RespectGitignore ignores the files the .gitignore files of a
+	// package's repository exclude
+	RespectGitignore bool `yaml:"respect_gitignore,omitempty"`
+	// WindowsUser is whose profile win: targets resolve to under WSL.
+	// Unset means the Windows user WSL runs for.
+	WindowsUser string `yaml:"windows_user,omitempty"`
+	// ForeignLinks is what happens to links in the way that point into
+	// another dotfile manager's directory. Unset means ask.
+	ForeignLinks ForeignLinks `yaml:"foreign_links,omitempty"`
+	// Plugins are the names of the farm-<name> plugins link sends events
+	// to. Other plugins on PATH are never run.
+	Plugins []string `yaml:"plugins,omitempty"`
+}
+
+// ForeignLinks is what link does with an existing link into the directory
+// of another dotfile manager, such as a stow directory or chezmoi's source,
+// where any other link pointing elsewhere is replaced without asking.
+type ForeignLinks string
+
+const (
+	// ForeignAsk asks before replacing them, and fails when nobody confirmed
+	ForeignAsk ForeignLinks = "ask"
+	// ForeignReplace replaces them like any other link
+	ForeignReplace ForeignLinks = "replace"
+	// ForeignKeep leaves them in place and skips the file
+	ForeignKeep ForeignLinks = "keep"
+)
+
+// Requirement is a check that refuses to link when set to true, or only
+// warns when set to warn.
+type Requirement string
+
+const (
+	RequireOff  Requirement = "false"
+	RequireOn   Requirement = "true"
+	RequireWarn Requirement = "warn"
+)
+
+// Enabled reports whether the check runs at all.
+func (r Requirement) Enabled() bool {
+	return r == RequireOn || r == RequireWarn
+}
+
+// CleansOnLink reports whether link removes dead links before linking.
+func (s Settings) CleansOnLink() bool {
+	return s.CleanOnLink == nil || *s.CleanOnLink
+}
+
+// NormalizesUnicode reports whether file names are composed to NFC.
+func (s Settings) NormalizesUnicode() bool {
+	if s.NormalizeUnicode == nil {
+		return runtime.GOOS == "darwin"
+	}
+	return *s.NormalizeUnicode
+}
+
+// Minimal is a profile of `farm link --minimal`, the subset of packages
+// linked on their own in devcontainers and Codespaces. Symlinks into a
+// mounted repo may not survive there, so files are copied unless copy is set
+// to false.
+type Minimal struct {
+	Packages []string `yaml:"packages"`
+	Copy     *bool    `yaml:"copy,omitempty"`
+}
+
+// Copies reports whether the profile's files are copied rather than linked.
+func (m *Minimal) Copies() bool {
+	return m.Copy == nil || *m.Copy
+}
+
+type Repo struct {
+	Root   string `yaml:"root"`
+	Remote string `yaml:"remote,omitempty"`
 }
 
 type Package struct {
-	Source       string   `yaml:"source"`
-	Targets      []string `yaml:"targets"`
-	NoFold       []string `yaml:"no_fold,omitempty"`
-	Fold         []string `yaml:"fold,omitempty"`
-	DefaultFold  bool     `yaml:"default_fold"`
-	Environments []string `yaml:"environments,omitempty"`
+	Name         string              `yaml:"name,omitempty"`
+	Source       string              `yaml:"source"`
+	Targets      []string            `yaml:"targets"`
+	NoFold       []string            `yaml:"no_fold,omitempty"`
+	Fold         []string            `yaml:"fold,omitempty"`
+	DefaultFold  bool                `yaml:"default_fold"`
+	AutoFold     bool                `yaml:"-"`
+	Environments []string            `yaml:"environments,omitempty"`
+	Repo         string              `yaml:"repo,omitempty"`
+	Transform    []string            `yaml:"transform,omitempty"`
+	Directories  []Directory         `yaml:"directories,omitempty"`
+	Messages     Messages            `yaml:"message,omitempty"`
+	Hooks        map[string]string   `yaml:"hooks,omitempty"`
+	Extensions   map[string][]string `yaml:"extensions,omitempty"`
+	Fonts        bool                `yaml:"fonts,omitempty"`
+	Downloads    []Download          `yaml:"downloads,omitempty"`
+	// ParentDirMode is the mode of the directories created above the
+	// package's links. Without it they're created as 0755 less the umask.
+	ParentDirMode FileMode `yaml:"parent_dir_mode,omitempty"`
+	// Dereference links to the file a symlink in the source resolves to,
+	// rather than to the symlink itself.
+	Dereference bool `yaml:"dereference,omitempty"`
+	// CopyPlaceholders copies the file a git symlink placeholder points at
+	// instead of linking to it, for filesystems without symlinks.
+	CopyPlaceholders bool `yaml:"copy_placeholders,omitempty"`
+	// Header is written as a comment at the top of the files the package
+	// copies or renders, with {source} replaced by the source path
+	Header string `yaml:"header,omitempty"`
+	// Owner and Group are who the files and directories the package creates
+	// belong to, by name or numeric ID, when farm is allowed to change it
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	// Variants links files like gitconfig.darwin or gitconfig@workmac as
+	// gitconfig on the machines they match, and skips them elsewhere
+	Variants bool `yaml:"variants,omitempty"`
+	// RequiresPaths must exist before the package is linked, such as a
+	// mounted volume its source or targets live on
+	RequiresPaths []RequiredPath `yaml:"requires_paths,omitempty"`
+	// HookOptions limits how long the package's hooks may run, where, and
+	// with which environment
+	HookOptions hooks.Options `yaml:"hook_options,omitempty"`
+	// OnlyTracked links only the files git tracks in the package's source,
+	// so what's linked matches what's in version control
+	OnlyTracked bool `yaml:"only_tracked,omitempty"`
+	// DependsOn names the packages linked before this one, along with
+	// their hooks, such as one whose hook installs a tool this package's
+	// hooks need
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// AllowOverlap lets the package share its source with another package,
+	// or have it lie inside another package's source
+	AllowOverlap bool `yaml:"allow_overlap,omitempty"`
+	// Copy copies the package's files into its targets instead of linking
+	// them, so they stay when the source goes away
+	Copy   bool   `yaml:"copy,omitempty"`
+	Remote string `yaml:"-"`
+	// Archive is the .tar.gz or .zip file the package's source was given
+	// as, whose extracted tree in the cache is linked from
+	Archive string `yaml:"-"`
+	// OptionalTargets are the targets marked `optional: true`, which are
+	// skipped when the directory they live in doesn't exist
+	OptionalTargets []string `yaml:"-"`
+	// ExistingTargets are the targets marked `only_if_target_exists: true`,
+	// which are skipped unless the target directory itself exists
+	ExistingTargets []string `yaml:"-"`
+	// WindowsTargets are the targets written as win: paths under WSL, which
+	// files are copied into since Windows programs can't follow the
+	// symlinks WSL creates
+	WindowsTargets []string `yaml:"-"`
+}
+
+// Directory is a directory farm ensures exists, even when empty. Relative
+// paths are created under each of the package's targets.
+type Directory struct {
+	Path string   `yaml:"path"`
+	Mode FileMode `yaml:"mode,omitempty"`
+}
+
+func (d *Directory) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		d.Path = value.Value
+		return nil
+	}
+
+	type rawDirectory Directory
+	return value.Decode((*rawDirectory)(d))
+}
+
+// RequiredPath is a path a package depends on. With wait, farm keeps
+// checking for it that long before giving up on the package.
+type RequiredPath struct {
+	Path string        `yaml:"path"`
+	Wait time.Duration `yaml:"wait,omitempty"`
+}
+
+func (r *RequiredPath) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Path = value.Value
+		return nil
+	}
+
+	type rawRequiredPath RequiredPath
+	return value.Decode((*rawRequiredPath)(r))
+}
+
+const (
+	MessageAlways  = "always"
+	MessageChanged = "changed"
+)
+
+// Message is a reminder printed after a successful run, such as a command
+// to reload a program. With `when: changed` it is only printed when the
+// package's links changed.
+type Message struct {
+	Text string `yaml:"text"`
+	When string `yaml:"when,omitempty"`
+}
+
+// Messages accepts a single message or a list, where each message is either
+// a plain string or a mapping with text and when.
+type Messages []Message
+
+func (m *Messages) UnmarshalYAML(value *yaml.Node) error {
+	nodes := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		nodes = value.Content
+	}
+
+	for _, node := range nodes {
+		var msg Message
+		if node.Kind == yaml.ScalarNode {
+			msg.Text = node.Value
+		} else {
+			type rawMessage Message
+			if err := node.Decode((*rawMessage)(&msg)); err != nil {
+				return err
+			}
+		}
+
+		if msg.When == "" {
+			msg.When = MessageAlways
+		}
+		if msg.When != MessageAlways && msg.When != MessageChanged {
+			return fmt.Errorf("line %d: message when must be %q or %q", node.Line, MessageAlways, MessageChanged)
+		}
+
+		*m = append(*m, msg)
+	}
+
+	return nil
+}
+
+// FileMode is a permission mode written in octal, such as 0700.
+type FileMode os.FileMode
+
+func (m *FileMode) UnmarshalYAML(value *yaml.Node) error {
+	mode, err := strconv.ParseUint(value.Value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("line %d: invalid mode %s, expected octal permissions such as 0755", value.Line, value.Value)
+	}
+
+	*m = FileMode(mode)
+	return nil
+}
+
+// Paths returns the absolute paths of the directory, one per target when the
+// path is relative.
+func (d Directory) Paths(targets []string) []string {
+	if filepath.IsAbs(d.Path) {
+		return []string{d.Path}
+	}
+
+	paths := make([]string, 0, len(targets))
+	for _, target := range targets {
+		paths = append(paths, filepath.Join(target, d.Path))
+	}
+	return paths
+}
+
+// Download is a file, typically a binary, that farm fetches and verifies
+// against its checksum. Variants keyed by "os/arch" or "os" override the URL
+// for specific platforms.
+type Download struct {
+	Name     string             `yaml:"name"`
+	Dest     string             `yaml:"dest,omitempty"`
+	Variants map[string]Variant `yaml:"variants,omitempty"`
+	Variant  `yaml:",inline"`
+}
+
+type Variant struct {
+	URL     string `yaml:"url,omitempty"`
+	SHA256  string `yaml:"sha256,omitempty"`
+	Extract string `yaml:"extract,omitempty"`
+}
+
+// Resolve returns the variant to download on the given platform, preferring
+// an exact os/arch match over an os match over the default URL.
+func (d Download) Resolve(goos, goarch string) (Variant, bool) {
+	if v, ok := d.Variants[goos+"/"+goarch]; ok {
+		return v, true
+	}
+	if v, ok := d.Variants[goos]; ok {
+		return v, true
+	}
+	return d.Variant, d.URL != ""
+}
+
+func (d Download) Path() string {
+	return filepath.Join(d.Dest, d.Name)
+}
+
+func (v Variant) validate() error {
+	if v.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if sum, err := hex.DecodeString(v.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("sha256 must be a hex encoded sha256 checksum")
+	}
+	return nil
+}
+
+func (p *Package) UnmarshalYAML(value *yaml.Node) error {
+	type rawPackage Package
+
+	// Keys merged in from a shared block get the same treatment as keys
+	// written out
+	value = flattenMapping(value)
+
+	// `fold: auto` replaces the list of fold patterns with automatic folding
+	node := *value
+	node.Content = nil
+	autoFold := false
+	var options []targetOptions
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i], resolveAlias(value.Content[i+1])
+		if key.Value == "fold" && val.Kind == yaml.ScalarNode {
+			if val.Value != "auto" {
+				return fmt.Errorf("line %d: fold must be a list of paths or \"auto\"", val.Line)
+			}
+			autoFold = true
+			continue
+		}
+		if key.Value == "targets" && val.Kind == yaml.SequenceNode {
+			targets, opts, err := decodeTargets(val)
+			if err != nil {
+				return err
+			}
+			val = targets
+			options = opts
+		}
+		node.Content = append(node.Content, key, val)
+	}
+
+	var raw rawPackage
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	*p = Package(raw)
+	p.AutoFold = autoFold
+	for _, opts := range options {
+		if opts.Optional {
+			p.OptionalTargets = append(p.OptionalTargets, opts.Path)
+		}
+		if opts.OnlyIfExists {
+			p.ExistingTargets = append(p.ExistingTargets, opts.Path)
+		}
+	}
+
+	return nil
+}
+
+// resolveAlias returns the node an alias refers to.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// flattenMapping resolves the `<<` merge keys of a mapping into a new
+// mapping holding the merged keys directly, leaving the shared nodes alone.
+// Keys written out win over merged ones, and earlier merged mappings win
+// over later ones, as in YAML's merge key spec.
+func flattenMapping(value *yaml.Node) *yaml.Node {
+	value = resolveAlias(value)
+	if value.Kind != yaml.MappingNode {
+		return value
+	}
+
+	flat := *value
+	flat.Content = nil
+	seen := make(map[string]bool)
+	add := func(key, val *yaml.Node) {
+		if !seen[key.Value] {
+			seen[key.Value] = true
+			flat.Content = append(flat.Content, key, val)
+		}
+	}
+
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i], resolveAlias(value.Content[i+1])
+		if key.ShortTag() != "!!merge" {
+			add(key, val)
+			continue
+		}
+
+		if val.Kind == yaml.SequenceNode {
+			merged = append(merged, val.Content...)
+		} else {
+			merged = append(merged, val)
+		}
+	}
+
+	for _, m := range merged {
+		m = flattenMapping(m)
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			add(m.Content[i], m.Content[i+1])
+		}
+	}
+
+	return &flat
+}
+
+// targetOptions are the settings of a target written as a mapping.
+type targetOptions struct {
+	Path         string `yaml:"path"`
+	Optional     bool   `yaml:"optional"`
+	OnlyIfExists bool   `yaml:"only_if_target_exists"`
+}
+
+// decodeTargets accepts targets written as plain paths or as mappings with a
+// path and options, returning a list of plain paths along with the options of
+// the targets written as mappings.
+func decodeTargets(value *yaml.Node) (*yaml.Node, []targetOptions, error) {
+	node := *value
+	node.Content = nil
+
+	var options []targetOptions
+	for _, item := range value.Content {
+		if item.Kind != yaml.MappingNode {
+			node.Content = append(node.Content, item)
+			continue
+		}
+
+		var target targetOptions
+		if err := item.Decode(&target); err != nil {
+			return nil, nil, err
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: target.Path, Line: item.Line})
+		options = append(options, target)
+	}
+
+	return &node, options, nil
+}
+
+// IsOptionalTarget reports whether target is marked optional.
+func (p *Package) IsOptionalTarget(target string) bool {
+	return contains(p.OptionalTargets, target)
+}
+
+// OnlyIfTargetExists reports whether target is only linked when it already
+// exists.
+func (p *Package) OnlyIfTargetExists(target string) bool {
+	return contains(p.ExistingTargets, target)
+}
+
+// CopiesInto reports whether files are copied rather than linked to path,
+// for packages in copy mode and paths in one of their Windows targets.
+func (p *Package) CopiesInto(path string) bool {
+	if p.Copy {
+		return true
+	}
+	for _, target := range p.WindowsTargets {
+		if path == target || strings.HasPrefix(path, target+"/") {
+			return true
+		}
+	}
+	return false
 }
 
 var defaultIgnorePatterns = []string{
@@ -30,6 +532,7 @@ var defaultIgnorePatterns = []string{
 	"README*",
 	"LICENSE*",
 	"COPYING",
+	IgnoreFile,
 }
 
 func Load(configPath string) (*Config, error) {
@@ -42,24 +545,187 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	return Parse(data, configPath)
+}
+
+// Parse loads a config from data as if it were read from the file at
+// configPath, which relative paths are resolved against, without reading that
+// file. It's used to validate a config before writing it.
+func Parse(data []byte, configPath string) (*Config, error) {
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	if err := config.Validate(); err != nil {
+	// Relative paths are resolved against the config file's directory, or
+	// against base_dir when set (which is itself relative to the config file)
+	configDir, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config directory: %w", err)
+	}
+	if config.BaseDir == "" {
+		config.BaseDir = configDir
+	} else {
+		config.BaseDir = resolvePath(configDir, config.BaseDir)
+	}
+
+	patterns, err := readIgnoreFile(filepath.Join(configDir, IgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	config.Ignore = append(config.Ignore, patterns...)
+
+	resolved, err := config.Resolve()
+	if err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	return &config, nil
+	return resolved, nil
+}
+
+// Resolve returns a copy of the config with its paths made absolute and its
+// defaults filled in, failing when the config is invalid. The config itself
+// is left as it was parsed, so resolving it again gives the same result.
+func (c *Config) Resolve() (*Config, error) {
+	resolved := c.Clone()
+	if err := resolved.resolve(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
 }
 
+// Validate reports whether the config is valid without changing it.
 func (c *Config) Validate() error {
+	_, err := c.Resolve()
+	return err
+}
+
+// Clone returns a deep copy of the config, sharing nothing that resolving
+// or rebasing it changes.
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Ignore = slices.Clone(c.Ignore)
+	clone.IgnoreGlobs = slices.Clone(c.IgnoreGlobs)
+	clone.Bundle = maps.Clone(c.Bundle)
+
+	if c.Minimal != nil {
+		clone.Minimal = make(map[string]*Minimal, len(c.Minimal))
+		for name, minimal := range c.Minimal {
+			if minimal != nil {
+				minimalCopy := *minimal
+				minimalCopy.Packages = slices.Clone(minimal.Packages)
+				minimal = &minimalCopy
+			}
+			clone.Minimal[name] = minimal
+		}
+	}
+
+	if c.Repos != nil {
+		clone.Repos = make(map[string]*Repo, len(c.Repos))
+		for name, repo := range c.Repos {
+			if repo != nil {
+				repoCopy := *repo
+				repo = &repoCopy
+			}
+			clone.Repos[name] = repo
+		}
+	}
+
+	clone.Packages = make([]*Package, len(c.Packages))
+	for i, pkg := range c.Packages {
+		clone.Packages[i] = pkg.Clone()
+	}
+
+	return &clone
+}
+
+// Clone returns a deep copy of the package.
+func (p *Package) Clone() *Package {
+	clone := *p
+	clone.Targets = slices.Clone(p.Targets)
+	clone.NoFold = slices.Clone(p.NoFold)
+	clone.Fold = slices.Clone(p.Fold)
+	clone.Environments = slices.Clone(p.Environments)
+	clone.Transform = slices.Clone(p.Transform)
+	clone.Directories = slices.Clone(p.Directories)
+	clone.RequiresPaths = slices.Clone(p.RequiresPaths)
+	clone.Messages = slices.Clone(p.Messages)
+	clone.Hooks = maps.Clone(p.Hooks)
+	clone.HookOptions.PassEnv = slices.Clone(p.HookOptions.PassEnv)
+	clone.Extensions = maps.Clone(p.Extensions)
+	clone.OptionalTargets = slices.Clone(p.OptionalTargets)
+	clone.ExistingTargets = slices.Clone(p.ExistingTargets)
+	clone.WindowsTargets = slices.Clone(p.WindowsTargets)
+	clone.DependsOn = slices.Clone(p.DependsOn)
+
+	clone.Downloads = slices.Clone(p.Downloads)
+	for i, download := range clone.Downloads {
+		clone.Downloads[i].Variants = maps.Clone(download.Variants)
+	}
+
+	return &clone
+}
+
+// resolve makes the config's paths absolute and fills in defaults in place,
+// validating it along the way.
+func (c *Config) resolve() error {
+	if c.Settings.MaxChanges < 0 {
+		return fmt.Errorf("settings: max_changes must not be negative")
+	}
+
+	if c.Settings.Retry.Attempts < 0 || c.Settings.Retry.Backoff < 0 || c.Settings.Retry.Rate < 0 {
+		return fmt.Errorf("settings: retry attempts, backoff, and rate must not be negative")
+	}
+
+	// Settings, maps, and hooks are checked in sorted order, so the same
+	// config always fails with the same error
+	requirements := map[string]Requirement{
+		"require_clean":      c.Settings.RequireClean,
+		"require_up_to_date": c.Settings.RequireUpToDate,
+	}
+	for _, key := range slices.Sorted(maps.Keys(requirements)) {
+		switch requirements[key] {
+		case "", RequireOff, RequireOn, RequireWarn:
+		default:
+			return fmt.Errorf("settings: %s must be true, false, or warn", key)
+		}
+	}
+
+	switch c.Settings.ForeignLinks {
+	case "", ForeignAsk, ForeignReplace, ForeignKeep:
+	default:
+		return fmt.Errorf("settings: foreign_links must be ask, replace, or keep")
+	}
+
+	for _, name := range c.BundleNames() {
+		if _, ok := provision.Lookup(name); !ok {
+			return fmt.Errorf("bundle: unknown provisioner %s", name)
+		}
+	}
+
+	for _, name := range c.RepoNames() {
+		repo := c.Repos[name]
+		if repo == nil || repo.Root == "" {
+			return fmt.Errorf("repo %s: root is required", name)
+		}
+
+		rootAbs, err := filepath.Abs(resolvePath(c.BaseDir, repo.Root))
+		if err != nil {
+			return fmt.Errorf("repo %s: invalid root path: %w", name, err)
+		}
+		repo.Root = rootAbs
+	}
+
 	for i, pkg := range c.Packages {
 		if pkg.Source == "" {
 			return fmt.Errorf("package %d: source is required", i)
 		}
 
+		// Font packages install into the user's font directory by default
+		if len(pkg.Targets) == 0 && pkg.Fonts {
+			pkg.Targets = []string{DefaultFontDir()}
+		}
+
 		if len(pkg.Targets) == 0 {
 			return fmt.Errorf("package %d: at least one target is required", i)
 		}
@@ -70,39 +736,212 @@ func (c *Config) Validate() error {
 			}
 		}
 
-		sourceAbs, err := filepath.Abs(pkg.Source)
+		for j, dir := range pkg.Directories {
+			if dir.Path == "" {
+				return fmt.Errorf("package %d: empty directory path", i)
+			}
+
+			// Home-relative directories are absolute, others live under targets
+			if strings.HasPrefix(dir.Path, "~") || filepath.IsAbs(dir.Path) {
+				pkg.Directories[j].Path = filepath.Clean(expandHome(dir.Path))
+			}
+		}
+
+		for j, required := range pkg.RequiresPaths {
+			if required.Path == "" {
+				return fmt.Errorf("package %d: empty required path", i)
+			}
+			if required.Wait < 0 {
+				return fmt.Errorf("package %d: required path %s: wait must not be negative", i, required.Path)
+			}
+			pkg.RequiresPaths[j].Path = filepath.Clean(resolvePath(c.BaseDir, required.Path))
+		}
+
+		for j, download := range pkg.Downloads {
+			if download.Name == "" || strings.ContainsRune(download.Name, filepath.Separator) {
+				return fmt.Errorf("package %d: download name must be a file name", i)
+			}
+
+			if download.URL != "" || download.SHA256 != "" {
+				if err := download.Variant.validate(); err != nil {
+					return fmt.Errorf("package %d: download %s: %w", i, download.Name, err)
+				}
+			}
+			for _, platform := range slices.Sorted(maps.Keys(download.Variants)) {
+				variant := download.Variants[platform]
+				if err := variant.validate(); err != nil {
+					return fmt.Errorf("package %d: download %s (%s): %w", i, download.Name, platform, err)
+				}
+			}
+
+			if download.Dest == "" {
+				download.Dest = "~/.local/bin"
+			}
+			pkg.Downloads[j].Dest = filepath.Clean(resolvePath(c.BaseDir, download.Dest))
+		}
+
+		for _, name := range pkg.Transform {
+			if _, ok := transform.Lookup(name); !ok {
+				return fmt.Errorf("package %d: unknown transformer %s", i, name)
+			}
+		}
+
+		// Package sources are relative to their repo's root when set
+		sourceBase := c.BaseDir
+		if pkg.Repo != "" {
+			repo, ok := c.Repos[pkg.Repo]
+			if !ok {
+				return fmt.Errorf("package %d: unknown repo %s", i, pkg.Repo)
+			}
+			sourceBase = repo.Root
+		}
+
+		// Remote sources are linked from their checkout in the cache
+		if src, ok := remote.Parse(pkg.Source); ok {
+			if pkg.Repo != "" {
+				return fmt.Errorf("package %d: remote sources can't be combined with repo", i)
+			}
+			if err := src.Validate(); err != nil {
+				return fmt.Errorf("package %d: invalid remote source: %w", i, err)
+			}
+			pkg.Remote = pkg.Source
+			pkg.Source = src.Dir(remote.DefaultCacheDir())
+		}
+
+		sourceAbs, err := filepath.Abs(resolvePath(sourceBase, pkg.Source))
 		if err != nil {
 			return fmt.Errorf("package %d: invalid source path: %w", i, err)
 		}
 		pkg.Source = sourceAbs
 
-		for j, target := range pkg.Targets {
-			targetAbs, err := filepath.Abs(expandHome(target))
-			if err != nil {
-				return fmt.Errorf("package %d: invalid target path %s: %w", i, target, err)
+		// Archives are linked from their extracted tree in the cache
+		if archive.IsArchive(pkg.Source) && pkg.Remote == "" {
+			pkg.Archive = pkg.Source
+			pkg.Source = archive.Dir(archive.DefaultCacheDir(), pkg.Archive)
+		}
+
+		if pkg.Name == "" {
+			pkg.Name = filepath.Base(pkg.Source)
+		}
+
+		for _, editor := range slices.Sorted(maps.Keys(pkg.Extensions)) {
+			if !provision.IsEditor(editor) {
+				return fmt.Errorf("package %d: unknown editor %s for extensions, expected one of %s", i, editor, strings.Join(provision.Editors, ", "))
+			}
+		}
+
+		for _, name := range pkg.HookNames() {
+			if strings.TrimSpace(pkg.Hooks[name]) == "" {
+				return fmt.Errorf("package %d: hook %s has no command", i, name)
+			}
+		}
+
+		if pkg.HookOptions.Timeout < 0 {
+			return fmt.Errorf("package %d: hook timeout must not be negative", i)
+		}
+		// Hooks run in the source, so their directory is relative to it
+		if pkg.HookOptions.Dir != "" {
+			pkg.HookOptions.Dir = filepath.Clean(resolvePath(pkg.Source, pkg.HookOptions.Dir))
+		}
+
+		for _, targets := range []*[]string{&pkg.Targets, &pkg.OptionalTargets, &pkg.ExistingTargets} {
+			resolved := (*targets)[:0]
+			for _, target := range *targets {
+				windows := strings.HasPrefix(target, windowsPrefix)
+				expanded := target
+				if windows {
+					var ok bool
+					if expanded, ok = c.expandWindows(target); !ok {
+						continue
+					}
+				}
+
+				expanded, err := expandLocation(expanded)
+				if err != nil {
+					return fmt.Errorf("package %d: target %s: %w", i, target, err)
+				}
+				targetAbs, err := filepath.Abs(resolvePath(c.BaseDir, expanded))
+				if err != nil {
+					return fmt.Errorf("package %d: invalid target path %s: %w", i, target, err)
+				}
+
+				if windows && locationOS != "windows" && !contains(pkg.WindowsTargets, targetAbs) {
+					pkg.WindowsTargets = append(pkg.WindowsTargets, targetAbs)
+				}
+				resolved = append(resolved, targetAbs)
 			}
-			pkg.Targets[j] = targetAbs
+			*targets = resolved
 		}
 	}
 
+	if err := c.validateOverlap(); err != nil {
+		return err
+	}
+
+	// Packages are referred to by name, which is only known once resolved
+	for _, name := range slices.Sorted(maps.Keys(c.Minimal)) {
+		minimal := c.Minimal[name]
+		if minimal == nil || len(minimal.Packages) == 0 {
+			return fmt.Errorf("minimal %s: packages are required", name)
+		}
+		for _, pkg := range minimal.Packages {
+			if len(c.FindPackagesByName(pkg)) == 0 {
+				return fmt.Errorf("minimal %s: unknown package %s", name, pkg)
+			}
+		}
+	}
+
+	if err := c.orderPackages(); err != nil {
+		return err
+	}
+
+	if err := c.validateIgnore(); err != nil {
+		return err
+	}
+
 	// Compile ignore patterns at config level
-	allPatterns := defaultIgnorePatterns
-	allPatterns = append(allPatterns, c.Ignore...)
-	c.IgnoreGlobs = allPatterns
+	c.IgnoreGlobs = slices.Concat(defaultIgnorePatterns, c.Ignore)
 
 	return nil
 }
 
-func (c *Config) ShouldIgnore(path string) bool {
+// PackageHash returns a digest of everything in the resolved config that
+// affects how the package is linked, so a lockfile can tell which packages
+// changed since they were last linked. Ignore patterns scoped to other
+// packages are left out.
+func (c *Config) PackageHash(pkg *Package) string {
+	var ignore []string
 	for _, pattern := range c.IgnoreGlobs {
-		if c.matchesPath(pattern, path) {
-			return true
+		if p := ParseIgnore(pattern); p.Package == "" || p.Package == pkg.Name {
+			ignore = append(ignore, pattern)
 		}
 	}
-	return false
+
+	data, _ := json.Marshal(struct {
+		Package *Package
+		Ignore  []string
+	}{pkg, ignore})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Config) ShouldIgnore(path string) bool {
+	_, ok := c.IgnoredBy(path)
+	return ok
+}
+
+// IgnoredBy returns the first ignore pattern that matches path, ignoring
+// patterns scoped to a package.
+func (c *Config) IgnoredBy(path string) (string, bool) {
+	return c.IgnoredIn(nil, path)
 }
 
 func (c *Config) matchesPath(pattern, path string) bool {
+	if c.Settings.NormalizesUnicode() {
+		pattern, path = fsutil.NFC(pattern), fsutil.NFC(path)
+	}
+
 	// Direct match
 	if pattern == path {
 		return true
@@ -189,14 +1028,140 @@ func (c *Config) matchesPath(pattern, path string) bool {
 	return false
 }
 
+// RebaseTargets moves every target under base so a config can be tried out
+// without touching the real home directory. Paths inside the home directory
+// keep their location relative to it, other absolute paths are nested under
+// base as a whole.
+func (c *Config) RebaseTargets(base string) {
+	for _, pkg := range c.Packages {
+		for _, targets := range [][]string{pkg.Targets, pkg.OptionalTargets, pkg.ExistingTargets, pkg.WindowsTargets} {
+			for i, target := range targets {
+				targets[i] = Rebase(base, target)
+			}
+		}
+		for i, dir := range pkg.Directories {
+			if filepath.IsAbs(dir.Path) {
+				pkg.Directories[i].Path = Rebase(base, dir.Path)
+			}
+		}
+		for i, download := range pkg.Downloads {
+			pkg.Downloads[i].Dest = Rebase(base, download.Dest)
+		}
+	}
+}
+
+// Rebase moves a single path under base the way RebaseTargets does.
+func Rebase(base, path string) string {
+	homeDir, err := home.Dir()
+	if err == nil {
+		if rel, err := filepath.Rel(homeDir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.Join(base, rel)
+		}
+	}
+	return filepath.Join(base, path)
+}
+
+// DefaultFontDir returns the per-user font directory for the current OS.
+func DefaultFontDir() string {
+	if runtime.GOOS == "darwin" {
+		return expandHome("~/Library/Fonts")
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "fonts")
+	}
+	return expandHome("~/.local/share/fonts")
+}
+
 func expandHome(path string) string {
 	if len(path) > 0 && path[0] == '~' {
-		home, _ := os.UserHomeDir()
-		return filepath.Join(home, path[1:])
+		homeDir, _ := home.Dir()
+		return filepath.Join(homeDir, path[1:])
 	}
 	return path
 }
 
+// resolvePath expands a leading ~ and joins relative paths onto base. An
+// empty base leaves relative paths to be resolved against the working
+// directory.
+func resolvePath(base, path string) string {
+	path = expandHome(path)
+	if base == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+func ExpandPath(path string) (string, error) {
+	return filepath.Abs(expandHome(path))
+}
+
+func (c *Config) FindPackage(source string) *Package {
+	var match *Package
+	for _, pkg := range c.Packages {
+		if source != pkg.Source && !strings.HasPrefix(source, pkg.Source+"/") {
+			continue
+		}
+
+		// Prefer the most specific package when sources are nested
+		if match == nil || len(pkg.Source) > len(match.Source) {
+			match = pkg
+		}
+	}
+	return match
+}
+
+func (c *Config) FindPackagesByName(name string) []*Package {
+	var matches []*Package
+	for _, pkg := range c.Packages {
+		if pkg.Name == name {
+			matches = append(matches, pkg)
+		}
+	}
+	return matches
+}
+
+func (p *Package) HookNames() []string {
+	names := make([]string, 0, len(p.Hooks))
+	for name := range p.Hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extensions merges the editor extensions listed by the given packages.
+func Extensions(packages []*Package) map[string][]string {
+	extensions := make(map[string][]string)
+	for _, pkg := range packages {
+		for editor, ids := range pkg.Extensions {
+			for _, id := range ids {
+				if !contains(extensions[editor], id) {
+					extensions[editor] = append(extensions[editor], id)
+				}
+			}
+		}
+	}
+	return extensions
+}
+
+func (c *Config) BundleNames() []string {
+	names := make([]string, 0, len(c.Bundle))
+	for name := range c.Bundle {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) RepoNames() []string {
+	names := make([]string, 0, len(c.Repos))
+	for name := range c.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (c *Config) GetPackagesForEnvironment(env string) []*Package {
 	if env == "" {
 		// If no environment specified, return all packages that don't have environment restrictions
@@ -221,6 +1186,28 @@ func (c *Config) GetPackagesForEnvironment(env string) []*Package {
 	return packages
 }
 
+// GetMinimalPackages returns the packages of a minimal profile, switched to
+// copy mode unless the profile turns it off.
+func (c *Config) GetMinimalPackages(profile string) ([]*Package, error) {
+	minimal, ok := c.Minimal[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimal profile %s", profile)
+	}
+
+	var packages []*Package
+	for _, pkg := range c.Packages {
+		if contains(minimal.Packages, pkg.Name) {
+			if minimal.Copies() {
+				pkg.Copy = true
+			}
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// GetAvailableEnvironments returns the environments packages are limited
+// to, sorted.
 func (c *Config) GetAvailableEnvironments() []string {
 	envMap := make(map[string]bool)
 	for _, pkg := range c.Packages {
@@ -229,11 +1216,7 @@ func (c *Config) GetAvailableEnvironments() []string {
 		}
 	}
 
-	var environments []string
-	for env := range envMap {
-		environments = append(environments, env)
-	}
-	return environments
+	return slices.Sorted(maps.Keys(envMap))
 }
 
 func contains(slice []string, item string) bool {
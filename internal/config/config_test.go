@@ -4,11 +4,13 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"sort"
 	"testing"
+	"time"
 
+	"github.com/mskelton/farm/internal/hooks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -71,6 +73,393 @@ packages:
 				assert.Contains(t, pkg.NoFold, "sensitive")
 			},
 		},
+		{
+			name: "config with automatic folding",
+			configYAML: `
+packages:
+  - source: ./config
+    targets:
+      - ~/.config
+    fold: auto
+    no_fold:
+      - sensitive
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				pkg := c.Packages[0]
+				assert.True(t, pkg.AutoFold)
+				assert.Empty(t, pkg.Fold)
+				assert.Contains(t, pkg.NoFold, "sensitive")
+			},
+		},
+		{
+			name: "config with optional targets",
+			configYAML: `
+packages:
+  - source: ./vscode
+    targets:
+      - /home/user/.config/Code/User
+      - path: /home/user/.config/Cursor/User
+        optional: true
+      - path: /home/user/.config/Zed
+        only_if_target_exists: true
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				pkg := c.Packages[0]
+				assert.Equal(t, []string{"/home/user/.config/Code/User", "/home/user/.config/Cursor/User", "/home/user/.config/Zed"}, pkg.Targets)
+				assert.False(t, pkg.IsOptionalTarget("/home/user/.config/Code/User"))
+				assert.True(t, pkg.IsOptionalTarget("/home/user/.config/Cursor/User"))
+				assert.False(t, pkg.OnlyIfTargetExists("/home/user/.config/Cursor/User"))
+				assert.True(t, pkg.OnlyIfTargetExists("/home/user/.config/Zed"))
+			},
+		},
+		{
+			name: "config with retry settings",
+			configYAML: `
+settings:
+  retry:
+    attempts: 5
+    backoff: 250ms
+packages:
+  - source: ./config
+    targets:
+      - ~/.config
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, 5, c.Settings.Retry.Attempts)
+				assert.Equal(t, 250*time.Millisecond, c.Settings.Retry.Backoff)
+			},
+		},
+		{
+			name: "config with directories",
+			configYAML: `
+packages:
+  - source: ./config
+    targets:
+      - /home/user
+    directories:
+      - .local/bin
+      - path: /var/cache/foo
+        mode: 0700
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				dirs := c.Packages[0].Directories
+				require.Len(t, dirs, 2)
+				assert.Equal(t, []string{"/home/user/.local/bin"}, dirs[0].Paths(c.Packages[0].Targets))
+				assert.Equal(t, "/var/cache/foo", dirs[1].Path)
+				assert.Equal(t, FileMode(0700), dirs[1].Mode)
+			},
+		},
+		{
+			name: "invalid directory mode",
+			configYAML: `
+packages:
+  - source: ./config
+    targets:
+      - ~/.config
+    directories:
+      - path: foo
+        mode: rwx
+`,
+			expectError: true,
+			errorMsg:    "invalid mode rwx",
+		},
+		{
+			name: "config with required paths",
+			configYAML: `
+packages:
+  - source: ./music
+    targets:
+      - /home/user/Music
+    requires_paths:
+      - /mnt/nas
+      - path: /media/drive/
+        wait: 30s
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, []RequiredPath{
+					{Path: "/mnt/nas"},
+					{Path: "/media/drive", Wait: 30 * time.Second},
+				}, c.Packages[0].RequiresPaths)
+			},
+		},
+		{
+			name: "empty required path",
+			configYAML: `
+packages:
+  - source: ./music
+    targets:
+      - /home/user/Music
+    requires_paths:
+      - path: ""
+`,
+			expectError: true,
+			errorMsg:    "empty required path",
+		},
+		{
+			name: "config with hook options",
+			configYAML: `
+packages:
+  - source: ./nvim
+    targets:
+      - ~/.config/nvim
+    hooks:
+      post_link: nvim --headless +Lazy! sync +qa
+    hook_options:
+      timeout: 2m
+      dir: lua
+      clean_env: true
+      pass_env: [PATH, HOME]
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, hooks.Options{
+					Timeout:  2 * time.Minute,
+					Dir:      filepath.Join(c.Packages[0].Source, "lua"),
+					CleanEnv: true,
+					PassEnv:  []string{"PATH", "HOME"},
+				}, c.Packages[0].HookOptions)
+			},
+		},
+		{
+			name: "negative hook timeout",
+			configYAML: `
+packages:
+  - source: ./nvim
+    targets:
+      - ~/.config/nvim
+    hook_options:
+      timeout: -1s
+`,
+			expectError: true,
+			errorMsg:    "hook timeout must not be negative",
+		},
+		{
+			name: "config with messages",
+			configYAML: `
+packages:
+  - source: ./tmux
+    targets:
+      - ~/.config/tmux
+    message: Run tmux source ~/.tmux.conf
+  - source: ./fish
+    targets:
+      - ~/.config/fish
+    message:
+      - Restart your shell
+      - text: Run fish_update_completions
+        when: changed
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, Messages{{Text: "Run tmux source ~/.tmux.conf", When: MessageAlways}}, c.Packages[0].Messages)
+				assert.Equal(t, Messages{
+					{Text: "Restart your shell", When: MessageAlways},
+					{Text: "Run fish_update_completions", When: MessageChanged},
+				}, c.Packages[1].Messages)
+			},
+		},
+		{
+			name: "invalid message condition",
+			configYAML: `
+packages:
+  - source: ./tmux
+    targets:
+      - ~/.config/tmux
+    message:
+      text: Reload tmux
+      when: sometimes
+`,
+			expectError: true,
+			errorMsg:    "message when must be",
+		},
+		{
+			name: "package hooks",
+			configYAML: `
+packages:
+  - source: ./tmux
+    targets:
+      - ~/
+    hooks:
+      post_link: tmux source-file ~/.tmux.conf
+      reload: tmux source-file ~/.tmux.conf
+  - name: editor
+    source: ./nvim
+    targets:
+      - ~/.config/nvim
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, "tmux", c.Packages[0].Name)
+				assert.Equal(t, "tmux source-file ~/.tmux.conf", c.Packages[0].Hooks["post_link"])
+				assert.Equal(t, []string{"post_link", "reload"}, c.Packages[0].HookNames())
+				assert.Equal(t, "editor", c.Packages[1].Name)
+				assert.Len(t, c.FindPackagesByName("tmux"), 1)
+			},
+		},
+		{
+			name: "empty hook command",
+			configYAML: `
+packages:
+  - source: ./tmux
+    targets:
+      - ~/
+    hooks:
+      watch: ""
+      reload: ""
+`,
+			expectError: true,
+			errorMsg:    "hook reload has no command",
+		},
+		{
+			name: "config with bundle",
+			configYAML: `
+packages: []
+bundle:
+  brew:
+    - fzf
+  code:
+    - golang.go
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, []string{"brew", "code"}, c.BundleNames())
+				assert.Equal(t, []string{"fzf"}, c.Bundle["brew"])
+			},
+		},
+		{
+			name: "unknown provisioner",
+			configYAML: `
+packages: []
+bundle:
+  zypper:
+    - git
+  apt:
+    - git
+`,
+			expectError: true,
+			errorMsg:    "unknown provisioner apt",
+		},
+		{
+			name: "package extensions",
+			configYAML: `
+packages:
+  - source: ./vscode
+    targets:
+      - ~/.config/Code/User
+    extensions:
+      code:
+        - golang.go
+      cursor:
+        - golang.go
+  - source: ./go
+    targets:
+      - ~/go
+    extensions:
+      code:
+        - golang.go
+        - ms-vscode.makefile-tools
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, map[string][]string{
+					"code":   {"golang.go", "ms-vscode.makefile-tools"},
+					"cursor": {"golang.go"},
+				}, Extensions(c.Packages))
+			},
+		},
+		{
+			name: "unknown editor",
+			configYAML: `
+packages:
+  - source: ./vscode
+    targets:
+      - ~/.config/Code/User
+    extensions:
+      vim:
+        - fzf
+`,
+			expectError: true,
+			errorMsg:    "unknown editor vim",
+		},
+		{
+			name: "font package",
+			configYAML: `
+packages:
+  - source: ./fonts
+    fonts: true
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.True(t, c.Packages[0].Fonts)
+				assert.Equal(t, []string{DefaultFontDir()}, c.Packages[0].Targets)
+			},
+		},
+		{
+			name: "package downloads",
+			configYAML: `
+packages:
+  - source: ./starship
+    targets:
+      - ~/.config
+    downloads:
+      - name: starship
+        url: https://example.com/starship.tar.gz
+        sha256: e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+        extract: starship
+        variants:
+          darwin/arm64:
+            url: https://example.com/starship-arm64.tar.gz
+            sha256: e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+`,
+			expectError: false,
+			validate: func(t *testing.T, c *Config) {
+				download := c.Packages[0].Downloads[0]
+				home, _ := os.UserHomeDir()
+				assert.Equal(t, filepath.Join(home, ".local", "bin", "starship"), download.Path())
+
+				variant, ok := download.Resolve("darwin", "arm64")
+				assert.True(t, ok)
+				assert.Equal(t, "https://example.com/starship-arm64.tar.gz", variant.URL)
+
+				variant, ok = download.Resolve("linux", "amd64")
+				assert.True(t, ok)
+				assert.Equal(t, "https://example.com/starship.tar.gz", variant.URL)
+				assert.Equal(t, "starship", variant.Extract)
+			},
+		},
+		{
+			name: "download without checksum",
+			configYAML: `
+packages:
+  - source: ./fzf
+    targets:
+      - ~/.config
+    downloads:
+      - name: fzf
+        variants:
+          linux:
+            url: https://example.com/fzf
+`,
+			expectError: true,
+			errorMsg:    "download fzf (linux): sha256 must be a hex encoded sha256 checksum",
+		},
+		{
+			name: "invalid fold mode",
+			configYAML: `
+packages:
+  - source: ./config
+    targets:
+      - ~/.config
+    fold: always
+`,
+			expectError: true,
+			errorMsg:    "fold must be a list of paths",
+		},
 		{
 			name: "missing source",
 			configYAML: `
@@ -190,6 +579,274 @@ packages:
 	assert.False(t, config.ShouldIgnore("myfile"))
 }
 
+func TestRelativePathResolution(t *testing.T) {
+	tmpDir := t.TempDir()
+	dotfilesDir := filepath.Join(tmpDir, "dotfiles")
+	require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		configYAML     string
+		expectedSource string
+		expectedTarget string
+	}{
+		{
+			name: "relative to config file",
+			configYAML: `
+packages:
+  - source: ./vim
+    targets:
+      - ./home
+`,
+			expectedSource: filepath.Join(dotfilesDir, "vim"),
+			expectedTarget: filepath.Join(dotfilesDir, "home"),
+		},
+		{
+			name: "relative base_dir",
+			configYAML: `
+base_dir: ../shared
+packages:
+  - source: ./vim
+    targets:
+      - ~/.vim
+`,
+			expectedSource: filepath.Join(tmpDir, "shared", "vim"),
+			expectedTarget: filepath.Join(home, ".vim"),
+		},
+		{
+			name: "absolute base_dir",
+			configYAML: `
+base_dir: /srv/dotfiles
+packages:
+  - source: vim
+    targets:
+      - /etc/vim
+`,
+			expectedSource: "/srv/dotfiles/vim",
+			expectedTarget: "/etc/vim",
+		},
+	}
+
+	// Resolution must not depend on the working directory
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+	require.NoError(t, os.Chdir(tmpDir))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(dotfilesDir, "farm.yaml")
+			require.NoError(t, os.WriteFile(configPath, []byte(tt.configYAML), 0644))
+
+			config, err := Load(configPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedSource, config.Packages[0].Source)
+			assert.Equal(t, tt.expectedTarget, config.Packages[0].Targets[0])
+		})
+	}
+}
+
+func TestAnchorsAndMergeKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+# Unknown top-level keys are ignored, which makes them a place for anchors
+defaults: &defaults
+  targets: &targets
+    - path: ~/.config
+      optional: true
+    - ~/.local/share
+  fold: auto
+  environments: [work]
+
+packages:
+  - <<: *defaults
+    source: ./nvim
+  - <<: *defaults
+    source: ./zsh
+    environments: [home]
+  - source: ./kitty
+    targets: *targets
+`), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	require.Len(t, cfg.Packages, 3)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	targets := []string{filepath.Join(home, ".config"), filepath.Join(home, ".local/share")}
+
+	// Merged keys get the same treatment as keys written out
+	nvim := cfg.Packages[0]
+	assert.Equal(t, filepath.Join(tmpDir, "nvim"), nvim.Source)
+	assert.Equal(t, targets, nvim.Targets)
+	assert.Equal(t, targets[:1], nvim.OptionalTargets)
+	assert.True(t, nvim.AutoFold)
+	assert.Equal(t, []string{"work"}, nvim.Environments)
+
+	// Keys written out win over merged ones
+	zsh := cfg.Packages[1]
+	assert.Equal(t, []string{"home"}, zsh.Environments)
+	assert.True(t, zsh.AutoFold)
+
+	kitty := cfg.Packages[2]
+	assert.Equal(t, targets, kitty.Targets)
+	assert.Equal(t, targets[:1], kitty.OptionalTargets)
+	assert.False(t, kitty.AutoFold)
+
+	// Packages built from the same block don't share state
+	nvim.Targets[0] = "/elsewhere"
+	nvim.Environments[0] = "elsewhere"
+	assert.Equal(t, targets, zsh.Targets)
+	assert.Equal(t, targets, kitty.Targets)
+}
+
+func TestResolveLeavesConfigUntouched(t *testing.T) {
+	var raw Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+base_dir: /dotfiles
+repos:
+  work:
+    root: work
+packages:
+  - source: ./vim
+    targets:
+      - path: ./home
+        optional: true
+    downloads:
+      - name: tool
+        url: https://example.com/tool
+        sha256: 0000000000000000000000000000000000000000000000000000000000000000
+  - source: ./nvim
+    repo: work
+    targets:
+      - ./home
+`), &raw))
+
+	resolved, err := raw.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "/dotfiles/vim", resolved.Packages[0].Source)
+	assert.Equal(t, []string{"/dotfiles/home"}, resolved.Packages[0].OptionalTargets)
+	assert.Equal(t, "/dotfiles/work/nvim", resolved.Packages[1].Source)
+	assert.Equal(t, "/dotfiles/work", resolved.Repos["work"].Root)
+
+	// The parsed config is left as written
+	assert.Equal(t, "./vim", raw.Packages[0].Source)
+	assert.Equal(t, []string{"./home"}, raw.Packages[0].Targets)
+	assert.Equal(t, []string{"./home"}, raw.Packages[0].OptionalTargets)
+	assert.Empty(t, raw.Packages[0].Name)
+	assert.Empty(t, raw.Packages[0].Downloads[0].Dest)
+	assert.Equal(t, "work", raw.Repos["work"].Root)
+	assert.Empty(t, raw.IgnoreGlobs)
+
+	// Resolving again, or resolving the result, gives the same config
+	again, err := raw.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, resolved, again)
+	twice, err := resolved.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, resolved, twice)
+}
+
+func TestRepos(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+repos:
+  personal:
+    root: ./personal
+  work:
+    root: /srv/work-dotfiles
+    remote: git@example.com:company/dotfiles.git
+packages:
+  - source: ./vim
+    targets:
+      - ./home
+  - repo: work
+    source: ./git
+    targets:
+      - ./home
+`), 0644))
+
+	config, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"personal", "work"}, config.RepoNames())
+	assert.Equal(t, filepath.Join(tmpDir, "personal"), config.Repos["personal"].Root)
+	assert.Equal(t, "git@example.com:company/dotfiles.git", config.Repos["work"].Remote)
+	assert.Equal(t, filepath.Join(tmpDir, "vim"), config.Packages[0].Source)
+	assert.Equal(t, "/srv/work-dotfiles/git", config.Packages[1].Source)
+	assert.Equal(t, config.Packages[1], config.FindPackage("/srv/work-dotfiles/git/config"))
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+packages:
+  - repo: missing
+    source: ./git
+    targets:
+      - ./home
+`), 0644))
+
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "unknown repo missing")
+}
+
+func TestRemoteSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+packages:
+  - source: github.com/user/dotfiles//nvim@main
+    targets:
+      - ./home/.config/nvim
+`), 0644))
+
+	config, err := Load(configPath)
+	require.NoError(t, err)
+
+	pkg := config.Packages[0]
+	assert.Equal(t, "github.com/user/dotfiles//nvim@main", pkg.Remote)
+	assert.Equal(t, filepath.Join(tmpDir, "cache", "farm", "sources", "github.com", "user", "dotfiles@main", "nvim"), pkg.Source)
+
+	// Paths that only look like a host are local, whether or not they exist
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "config.d", "nvim", "lua"), 0755))
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+packages:
+  - source: config.d/nvim/lua
+    targets:
+      - ./home/.config/nvim/lua
+  - source: github.com/user/dotfiles
+    targets:
+      - ./home
+  - source: https://github.com/user/dotfiles
+    targets:
+      - ./home
+`), 0644))
+
+	config, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Empty(t, config.Packages[0].Remote)
+	assert.Equal(t, filepath.Join(tmpDir, "config.d", "nvim", "lua"), config.Packages[0].Source)
+	assert.Empty(t, config.Packages[1].Remote)
+	assert.Equal(t, filepath.Join(tmpDir, "github.com", "user", "dotfiles"), config.Packages[1].Source)
+	assert.Equal(t, "https://github.com/user/dotfiles", config.Packages[2].Remote)
+
+	// Checkouts stay inside the cache
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+packages:
+  - source: github.com/../../.ssh//keys@main
+    targets:
+      - ./home
+`), 0644))
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "package 0: invalid remote source")
+}
+
 func TestExpandHome(t *testing.T) {
 	home, err := os.UserHomeDir()
 	require.NoError(t, err)
@@ -546,11 +1203,7 @@ func TestGetAvailableEnvironments(t *testing.T) {
 	}
 
 	environments := config.GetAvailableEnvironments()
-	expected := []string{"work", "home"}
-
-	// Sort both slices for comparison
-	sort.Strings(environments)
-	sort.Strings(expected)
+	expected := []string{"home", "work"}
 
 	if !reflect.DeepEqual(environments, expected) {
 		t.Errorf("expected environments %v, got %v", expected, environments)
@@ -579,3 +1232,449 @@ func TestContains(t *testing.T) {
 		})
 	}
 }
+
+func TestRebaseTargets(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	cfg := &Config{
+		Packages: []*Package{
+			{
+				Source:      "/dotfiles/vim",
+				Targets:     []string{filepath.Join(home, ".config", "nvim"), "/etc/vim"},
+				Directories: []Directory{{Path: filepath.Join(home, ".cache", "vim")}, {Path: "undo"}},
+				Downloads:   []Download{{Name: "fzf", Dest: filepath.Join(home, ".local", "bin")}},
+			},
+		},
+	}
+
+	cfg.RebaseTargets("/tmp/homesim")
+
+	pkg := cfg.Packages[0]
+	assert.Equal(t, []string{"/tmp/homesim/.config/nvim", "/tmp/homesim/etc/vim"}, pkg.Targets)
+	assert.Equal(t, "/tmp/homesim/.cache/vim", pkg.Directories[0].Path)
+	assert.Equal(t, "undo", pkg.Directories[1].Path)
+	assert.Equal(t, "/tmp/homesim/.local/bin/fzf", pkg.Downloads[0].Path())
+}
+
+func TestScopedIgnorePatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`ignore:
+  - vim:plugin/cache
+  - /zsh/local
+  - zsh:/cache
+packages:
+  - source: ./vim
+    targets:
+      - ~/
+  - source: ./zsh
+    targets:
+      - ~/
+`), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	vim, zsh := cfg.Packages[0], cfg.Packages[1]
+
+	// Scoped patterns only apply to their package
+	pattern, ok := cfg.IgnoredIn(vim, ".vim/plugin/cache")
+	assert.True(t, ok)
+	assert.Equal(t, "vim:plugin/cache", pattern)
+	_, ok = cfg.IgnoredIn(zsh, ".vim/plugin/cache")
+	assert.False(t, ok)
+
+	// Anchored patterns match from the root of the repo
+	_, ok = cfg.IgnoredIn(zsh, "local/aliases.zsh")
+	assert.True(t, ok)
+	_, ok = cfg.IgnoredIn(zsh, ".config/local")
+	assert.False(t, ok)
+	_, ok = cfg.IgnoredIn(vim, "local")
+	assert.False(t, ok)
+
+	// or from the root of the package they're scoped to
+	_, ok = cfg.IgnoredIn(zsh, "cache/history")
+	assert.True(t, ok)
+	_, ok = cfg.IgnoredIn(zsh, ".zsh/cache")
+	assert.False(t, ok)
+
+	// Without a package only unscoped patterns apply
+	assert.False(t, cfg.ShouldIgnore("plugin/cache"))
+	assert.True(t, cfg.ShouldIgnore("README.md"))
+
+	assert.Equal(t, "plugin/cache anywhere in package vim", ParseIgnore("vim:plugin/cache").String())
+	assert.Equal(t, "zsh/local from the root of the repo", ParseIgnore("/zsh/local").String())
+	assert.Equal(t, "cache from the root of package zsh", ParseIgnore("zsh:/cache").String())
+	assert.Equal(t, "*.bak anywhere in every package", ParseIgnore("*.bak").String())
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`ignore:
+  - emacs:cache
+packages:
+  - source: ./vim
+    targets:
+      - ~/
+`), 0644))
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "ignore pattern emacs:cache: unknown package emacs")
+}
+
+func TestPackageHash(t *testing.T) {
+	vim := &Package{Name: "vim", Source: "/repo/vim", Targets: []string{"/home"}}
+	zsh := &Package{Name: "zsh", Source: "/repo/zsh", Targets: []string{"/home"}}
+	cfg := &Config{Packages: []*Package{vim, zsh}, IgnoreGlobs: []string{"*.bak"}}
+
+	hash := cfg.PackageHash(vim)
+	assert.Equal(t, hash, cfg.PackageHash(vim))
+	assert.NotEqual(t, hash, cfg.PackageHash(zsh))
+
+	// Patterns scoped to other packages don't affect the hash
+	cfg.IgnoreGlobs = append(cfg.IgnoreGlobs, "zsh:cache")
+	assert.Equal(t, hash, cfg.PackageHash(vim))
+
+	cfg.IgnoreGlobs = append(cfg.IgnoreGlobs, "vim:cache")
+	assert.NotEqual(t, hash, cfg.PackageHash(vim))
+
+	cfg.IgnoreGlobs = []string{"*.bak"}
+	vim.Targets = append(vim.Targets, "/backup")
+	assert.NotEqual(t, hash, cfg.PackageHash(vim))
+}
+
+func TestLocations(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	defer func(goos string) { locationOS = goos }(locationOS)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`packages:
+  - source: ./powershell
+    targets:
+      - "{powershell}"
+      - "{windows_powershell}/Modules"
+`), 0644))
+
+	locationOS = "windows"
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(homeDir, "Documents", "PowerShell"),
+		filepath.Join(homeDir, "Documents", "WindowsPowerShell", "Modules"),
+	}, cfg.Packages[0].Targets)
+
+	// PowerShell follows the XDG layout on other platforms
+	locationOS = "linux"
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(homeDir, ".config", "powershell"), cfg.Packages[0].Targets[0])
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`packages:
+  - source: ./powershell
+    targets:
+      - "{pwsh}"
+`), 0644))
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "target {pwsh}: unknown location {pwsh}")
+}
+
+func TestWindowsTargets(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+
+	defer func(goos string, wsl func() bool, user func() string, drive string) {
+		locationOS, inWSL, windowsUser, wslDrive = goos, wsl, user, drive
+	}(locationOS, inWSL, windowsUser, wslDrive)
+
+	drive := t.TempDir()
+	locationOS, wslDrive = "linux", drive
+	windowsUser = func() string { return "Mark" }
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`packages:
+  - source: ./vscode
+    targets:
+      - ~/.config/Code/User
+      - path: win:~/AppData/Roaming/Code/User
+        optional: true
+`), 0644))
+
+	// Outside WSL there is no Windows side, so its targets are left out
+	inWSL = func() bool { return false }
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(homeDir, ".config", "Code", "User")}, cfg.Packages[0].Targets)
+	assert.Empty(t, cfg.Packages[0].OptionalTargets)
+	assert.Empty(t, cfg.Packages[0].WindowsTargets)
+
+	inWSL = func() bool { return true }
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	windowsTarget := filepath.Join(drive, "Users", "Mark", "AppData", "Roaming", "Code", "User")
+	assert.Equal(t, []string{filepath.Join(homeDir, ".config", "Code", "User"), windowsTarget}, cfg.Packages[0].Targets)
+	assert.Equal(t, []string{windowsTarget}, cfg.Packages[0].OptionalTargets)
+	assert.Equal(t, []string{windowsTarget}, cfg.Packages[0].WindowsTargets)
+	assert.True(t, cfg.Packages[0].CopiesInto(filepath.Join(windowsTarget, "settings.json")))
+	assert.False(t, cfg.Packages[0].CopiesInto(filepath.Join(homeDir, ".config", "Code", "User", "settings.json")))
+
+	// The setting picks whose profile is used
+	require.NoError(t, os.WriteFile(configPath, []byte(`settings:
+  windows_user: mskelton
+packages:
+  - source: ./vscode
+    targets:
+      - win:~/AppData/Roaming/Code/User
+`), 0644))
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(drive, "Users", "mskelton", "AppData", "Roaming", "Code", "User")}, cfg.Packages[0].Targets)
+
+	// On Windows itself the profile is the home directory, linked as usual
+	locationOS = "windows"
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(homeDir, "AppData", "Roaming", "Code", "User")}, cfg.Packages[0].Targets)
+	assert.Empty(t, cfg.Packages[0].WindowsTargets)
+}
+
+func TestMinimal(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`minimal:
+  devcontainer:
+    packages: [zsh, git]
+  codespaces:
+    packages: [zsh]
+    copy: false
+packages:
+  - name: zsh
+    source: ./zsh
+    targets: ["~"]
+  - name: git
+    source: ./git
+    targets: ["~"]
+  - name: nvim
+    source: ./nvim
+    targets: [~/.config/nvim]
+`), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+
+	packages, err := cfg.GetMinimalPackages("devcontainer")
+	require.NoError(t, err)
+	require.Len(t, packages, 2)
+	assert.Equal(t, "zsh", packages[0].Name)
+	assert.Equal(t, "git", packages[1].Name)
+	assert.True(t, packages[0].Copy)
+	assert.True(t, packages[0].CopiesInto(filepath.Join(tmpDir, ".zshrc")))
+
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	packages, err = cfg.GetMinimalPackages("codespaces")
+	require.NoError(t, err)
+	require.Len(t, packages, 1)
+	assert.False(t, packages[0].Copy)
+
+	_, err = cfg.GetMinimalPackages("ci")
+	assert.ErrorContains(t, err, "unknown minimal profile ci")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`minimal:
+  devcontainer:
+    packages: [tmux]
+packages:
+  - name: zsh
+    source: ./zsh
+    targets: ["~"]
+`), 0644))
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "minimal devcontainer: unknown package tmux")
+}
+
+func TestDependsOn(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	load := func(content string) (*Config, error) {
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+		return Load(configPath)
+	}
+	names := func(cfg *Config) []string {
+		var names []string
+		for _, pkg := range cfg.Packages {
+			names = append(names, pkg.Name)
+		}
+		return names
+	}
+
+	// Dependencies move ahead, the rest keep their order
+	cfg, err := load(`packages:
+  - source: ./nvim
+    targets: [./home]
+    depends_on: [mise]
+  - source: ./zsh
+    targets: [./home]
+  - source: ./mise
+    targets: [./home]
+    depends_on: [zsh]
+  - source: ./git
+    targets: [./home]
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zsh", "mise", "nvim", "git"}, names(cfg))
+
+	_, err = load(`packages:
+  - source: ./nvim
+    targets: [./home]
+    depends_on: [mise]
+`)
+	assert.ErrorContains(t, err, "package nvim depends on unknown package mise")
+
+	_, err = load(`packages:
+  - source: ./git
+    targets: [./home]
+  - source: ./nvim
+    targets: [./home]
+    depends_on: [mise]
+  - source: ./mise
+    targets: [./home]
+    depends_on: [nvim]
+`)
+	assert.ErrorContains(t, err, "packages nvim, mise depend on each other")
+}
+
+func TestIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`ignore: ["*.bak"]
+packages:
+  - source: ./zsh
+    targets: [./home]
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, IgnoreFile), []byte("# Editor files\n*.swp\n\n  zsh:/local  \n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.bak", "*.swp", "zsh:/local"}, cfg.Ignore)
+	assert.True(t, cfg.ShouldIgnore(IgnoreFile))
+}
+
+func TestOverlappingSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	load := func(content string) (*Config, error) {
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+		return Load(configPath)
+	}
+
+	_, err := load(`packages:
+  - source: ./zsh
+    targets: [./home]
+  - name: zsh-copy
+    source: ./zsh
+    targets: [./other]
+`)
+	assert.ErrorContains(t, err, "packages zsh and zsh-copy have the same source "+filepath.Join(tmpDir, "zsh"))
+
+	_, err = load(`packages:
+  - source: ./config/nvim
+    targets: [./home/.config/nvim]
+  - source: ./config
+    targets: [./home/.config]
+`)
+	assert.ErrorContains(t, err, "the source of package nvim lies inside the source of package config")
+
+	// Siblings sharing a prefix don't overlap
+	_, err = load(`packages:
+  - source: ./zsh
+    targets: [./home]
+  - source: ./zsh-work
+    targets: [./home]
+`)
+	assert.NoError(t, err)
+
+	// Neither do packages of different environments
+	_, err = load(`packages:
+  - source: ./zsh
+    targets: [./home]
+    environments: [work]
+  - source: ./zsh
+    targets: [./other]
+    environments: [home]
+`)
+	assert.NoError(t, err)
+
+	_, err = load(`packages:
+  - source: ./zsh
+    targets: [./home]
+  - source: ./zsh
+    targets: [./other]
+    allow_overlap: true
+`)
+	assert.NoError(t, err)
+}
+
+func TestMove(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`# My dotfiles
+packages:
+  - source: ./zsh
+    targets: [./home]
+  - source: ./nvim
+    targets: [./home]
+    no_fold:
+      - .config/nvim/lua
+    fold: [.config/other]
+ignore:
+  - /zsh/plugins
+  - nvim:/.config/nvim/lua/scratch.lua
+  - "*.swp"
+`), 0644))
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+
+	// Moving a package renames its source and keeps its name
+	data, changes, err := Move(configPath, cfg, filepath.Join(tmpDir, "zsh"), filepath.Join(tmpDir, "shell", "z"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"package zsh: source ./zsh → ./shell/z",
+		"package zsh: kept its name",
+		"ignore /zsh/plugins → /shell/z/plugins",
+	}, changes)
+	assert.Equal(t, `# My dotfiles
+packages:
+  - name: zsh
+    source: ./shell/z
+    targets: [./home]
+  - source: ./nvim
+    targets: [./home]
+    no_fold:
+      - .config/nvim/lua
+    fold: [.config/other]
+ignore:
+  - /shell/z/plugins
+  - nvim:/.config/nvim/lua/scratch.lua
+  - "*.swp"
+`, string(data))
+
+	// Moving within a package rewrites the entries that name it
+	_, changes, err = Move(configPath, cfg, filepath.Join(tmpDir, "nvim", ".config", "nvim"), filepath.Join(tmpDir, "nvim", ".config", "neovim"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"package nvim: no_fold .config/nvim/lua → .config/neovim/lua",
+		"ignore nvim:/.config/nvim/lua/scratch.lua → nvim:/.config/neovim/lua/scratch.lua",
+	}, changes)
+
+	// Nothing refers to other paths
+	data, changes, err = Move(configPath, cfg, filepath.Join(tmpDir, "nvim", "README.md"), filepath.Join(tmpDir, "nvim", "docs.md"))
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	original, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(original), string(data))
+}
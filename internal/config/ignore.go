@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/git"
+)
+
+// GitignorePattern is reported as the pattern that ignored a path excluded by
+// a .gitignore file, with the respect_gitignore setting on.
+const GitignorePattern = ".gitignore"
+
+// IgnoreFile holds ignore patterns next to the config, one per line, which are
+// added to the ones in the config.
+const IgnoreFile = ".farmignore"
+
+// readIgnoreFile returns the patterns of an ignore file, skipping blank lines
+// and comments. A missing file has none.
+func readIgnoreFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", IgnoreFile, err)
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, nil
+}
+
+// IgnorePattern is an ignore pattern along with its scope. Patterns written
+// as `name:pattern` only apply to the package with that name, and patterns
+// starting with a slash are anchored: they match from the root of the repo,
+// or from the root of the package when scoped to one, rather than anywhere
+// in the path.
+type IgnorePattern struct {
+	Package  string
+	Anchored bool
+	Pattern  string
+}
+
+func ParseIgnore(pattern string) IgnorePattern {
+	p := IgnorePattern{Pattern: pattern}
+	if name, rest, ok := strings.Cut(pattern, ":"); ok && name != "" && !strings.Contains(name, "/") {
+		p.Package, p.Pattern = name, rest
+	}
+	if strings.HasPrefix(p.Pattern, "/") {
+		p.Anchored = true
+		p.Pattern = strings.TrimLeft(p.Pattern, "/")
+	}
+	return p
+}
+
+// String describes where the pattern applies.
+func (p IgnorePattern) String() string {
+	scope := "every package"
+	if p.Package != "" {
+		scope = "package " + p.Package
+	}
+
+	switch {
+	case p.Anchored && p.Package != "":
+		return fmt.Sprintf("%s from the root of %s", p.Pattern, scope)
+	case p.Anchored:
+		return fmt.Sprintf("%s from the root of the repo", p.Pattern)
+	default:
+		return fmt.Sprintf("%s anywhere in %s", p.Pattern, scope)
+	}
+}
+
+// IgnoredIn returns the first ignore pattern that matches path, relative to
+// the source of pkg. Scoped and anchored patterns never match without a
+// package, and neither do .gitignore files.
+func (c *Config) IgnoredIn(pkg *Package, path string) (string, bool) {
+	for _, pattern := range c.IgnoreGlobs {
+		p := ParseIgnore(pattern)
+		if p.Package == "" && !p.Anchored {
+			if c.matchesPath(p.Pattern, path) {
+				return pattern, true
+			}
+			continue
+		}
+
+		if pkg == nil || (p.Package != "" && p.Package != pkg.Name) {
+			continue
+		}
+
+		if !p.Anchored {
+			if c.matchesPath(p.Pattern, path) {
+				return pattern, true
+			}
+			continue
+		}
+
+		rel := path
+		if p.Package == "" {
+			var err error
+			if rel, err = filepath.Rel(c.repoRoot(pkg), filepath.Join(pkg.Source, path)); err != nil {
+				continue
+			}
+		}
+		if c.matchesAnchored(p.Pattern, filepath.ToSlash(rel)) {
+			return pattern, true
+		}
+	}
+
+	if pkg != nil && c.Settings.RespectGitignore {
+		if c.gitignore == nil {
+			c.gitignore = git.NewIgnore()
+		}
+		if c.gitignore.Ignored(filepath.Join(pkg.Source, path)) {
+			return GitignorePattern, true
+		}
+	}
+
+	return "", false
+}
+
+// repoRoot returns the root of the repo pkg lives in, which is where anchored
+// ignore patterns start from.
+func (c *Config) repoRoot(pkg *Package) string {
+	if repo, ok := c.Repos[pkg.Repo]; ok && pkg.Repo != "" {
+		return repo.Root
+	}
+	return c.BaseDir
+}
+
+// matchesAnchored matches pattern against the start of path, one component
+// at a time, so it matches the path itself and everything under it.
+func (c *Config) matchesAnchored(pattern, path string) bool {
+	if c.Settings.NormalizesUnicode() {
+		pattern, path = fsutil.NFC(pattern), fsutil.NFC(path)
+	}
+
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(path, "/")
+	if len(pathParts) < len(patternParts) {
+		return false
+	}
+
+	for i, part := range patternParts {
+		if matched, _ := filepath.Match(part, pathParts[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// validateIgnore checks that the packages ignore patterns are scoped to
+// exist.
+func (c *Config) validateIgnore() error {
+	for _, pattern := range c.Ignore {
+		p := ParseIgnore(pattern)
+		if p.Package != "" && len(c.FindPackagesByName(p.Package)) == 0 {
+			return fmt.Errorf("ignore pattern %s: unknown package %s", pattern, p.Package)
+		}
+		if p.Pattern == "" {
+			return fmt.Errorf("ignore pattern %s: pattern is empty", pattern)
+		}
+	}
+	return nil
+}
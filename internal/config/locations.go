@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// locationOS is the platform well-known locations are resolved for, swapped
+// out by tests.
+var locationOS = runtime.GOOS
+
+// locations are the well-known directories targets can start with, written
+// in braces, for places that differ between platforms.
+var locations = map[string]func() string{
+	// PowerShell 7 reads its profile from Documents on Windows and follows
+	// the XDG layout elsewhere
+	"powershell": func() string {
+		if locationOS == "windows" {
+			return filepath.Join(documentsDir(), "PowerShell")
+		}
+		return filepath.Join(configDir(), "powershell")
+	},
+	// Windows PowerShell 5.1 only exists on Windows
+	"windows_powershell": func() string {
+		return filepath.Join(documentsDir(), "WindowsPowerShell")
+	},
+	"documents": documentsDir,
+}
+
+// expandLocation replaces a well-known location such as {powershell} at the
+// start of path.
+func expandLocation(path string) (string, error) {
+	if !strings.HasPrefix(path, "{") {
+		return path, nil
+	}
+
+	name, rest, ok := strings.Cut(path[1:], "}")
+	if !ok {
+		return path, nil
+	}
+
+	location, ok := locations[name]
+	if !ok {
+		return "", fmt.Errorf("unknown location {%s}", name)
+	}
+	return location() + rest, nil
+}
+
+func documentsDir() string {
+	return expandHome("~/Documents")
+}
+
+// configDir is where programs following the XDG layout read their config.
+// Windows has no such convention, so AppData is used there.
+func configDir() string {
+	if locationOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return dir
+		}
+		return expandHome("~/AppData/Roaming")
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	return expandHome("~/.config")
+}
+
+// inWSL reports whether farm runs under the Windows Subsystem for Linux,
+// swapped out by tests.
+var inWSL = func() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	_, err := os.Stat("/proc/sys/fs/binfmt_misc/WSLInterop")
+	return err == nil
+}
+
+// wslDrive is where WSL mounts the Windows system drive.
+var wslDrive = "/mnt/c"
+
+// windowsUser looks up the name of the Windows user WSL runs for, which
+// may differ from the Linux user, swapped out by tests.
+var windowsUser = sync.OnceValue(func() string {
+	out, err := exec.Command("cmd.exe", "/c", "echo %USERNAME%").Output()
+	if name := strings.TrimSpace(string(out)); err == nil && name != "" && name != "%USERNAME%" {
+		return name
+	}
+	return os.Getenv("USER")
+})
+
+// windowsPrefix marks targets in the Windows user profile, such as
+// win:~/AppData/Roaming/Code/User.
+const windowsPrefix = "win:"
+
+// expandWindows resolves a win: target to the Windows user profile. Under
+// WSL that's the profile mounted at /mnt/c/Users/<user>, where user is the
+// windows_user setting or the Windows user WSL runs for, and on Windows
+// itself the home directory. Elsewhere there is no Windows side, so ok is
+// false and the target is left out.
+func (c *Config) expandWindows(path string) (expanded string, ok bool) {
+	rest := strings.TrimPrefix(path, windowsPrefix)
+	if locationOS == "windows" {
+		return rest, true
+	}
+	if !inWSL() {
+		return "", false
+	}
+
+	user := c.Settings.WindowsUser
+	if user == "" {
+		user = windowsUser()
+	}
+
+	profile := filepath.Join(wslDrive, "Users", user)
+	if rest == "~" || strings.HasPrefix(rest, "~/") {
+		return filepath.Join(profile, rest[1:]), true
+	}
+	if filepath.IsAbs(rest) {
+		return rest, true
+	}
+	return filepath.Join(profile, rest), true
+}
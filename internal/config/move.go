@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/home"
+	"gopkg.in/yaml.v3"
+)
+
+// Move rewrites the config file at path for a file or directory of the repo
+// that moves from one path to another: the sources of the packages inside it, and the
+// fold, no_fold, and anchored ignore entries that name it. Packages whose
+// name came from their source keep it, so nothing referring to them breaks.
+// Comments are kept. The rewritten file is returned along with a description
+// of each change, without writing anything.
+func Move(path string, cfg *Config, from, to string) ([]byte, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return data, nil, nil
+	}
+	root := doc.Content[0]
+
+	var changes []string
+	sources := make(map[string]string)
+	if packages := mappingValue(root, "packages"); packages != nil && packages.Kind == yaml.SequenceNode {
+		for _, node := range packages.Content {
+			if node.Kind != yaml.MappingNode {
+				continue
+			}
+			changes = append(changes, movePackage(node, cfg, from, to, sources)...)
+		}
+	}
+
+	if ignore := mappingValue(root, "ignore"); ignore != nil && ignore.Kind == yaml.SequenceNode {
+		for _, node := range ignore.Content {
+			if pattern, ok := moveIgnore(node.Value, cfg, sources, from, to); ok {
+				changes = append(changes, fmt.Sprintf("ignore %s → %s", node.Value, pattern))
+				node.Value = pattern
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		return data, nil, nil
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to write config file: %w", err)
+	}
+	return out.Bytes(), changes, nil
+}
+
+// movePackage rewrites a package for the move, recording the source each
+// package name had before it so scoped ignore patterns can be found.
+func movePackage(node *yaml.Node, cfg *Config, from, to string, sources map[string]string) []string {
+	source := mappingValue(node, "source")
+	if source == nil || source.Value == "" {
+		return nil
+	}
+
+	base := cfg.BaseDir
+	if repo := mappingValue(node, "repo"); repo != nil {
+		if r, ok := cfg.Repos[repo.Value]; ok {
+			base = r.Root
+		}
+	}
+	abs, err := filepath.Abs(resolvePath(base, source.Value))
+	if err != nil {
+		return nil
+	}
+
+	name := filepath.Base(abs)
+	nameNode := mappingValue(node, "name")
+	if nameNode != nil {
+		name = nameNode.Value
+	}
+	sources[name] = abs
+
+	// The package itself moves
+	if rest, ok := within(abs, from); ok {
+		moved := filepath.Join(to, rest)
+		value := relocate(source.Value, base, moved)
+		changes := []string{fmt.Sprintf("package %s: source %s → %s", name, source.Value, value)}
+		source.Value = value
+
+		if nameNode == nil && filepath.Base(moved) != name {
+			node.Content = append([]*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "name"},
+				{Kind: yaml.ScalarNode, Value: name},
+			}, node.Content...)
+			changes = append(changes, fmt.Sprintf("package %s: kept its name", name))
+		}
+		return changes
+	}
+
+	// Something inside the package moves, within it
+	oldRel, inOld := within(from, abs)
+	newRel, inNew := within(to, abs)
+	if !inOld || !inNew || oldRel == "" {
+		return nil
+	}
+
+	var changes []string
+	for _, key := range []string{"fold", "no_fold"} {
+		list := mappingValue(node, key)
+		if list == nil || list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, entry := range list.Content {
+			if rest, ok := within(entry.Value, oldRel); ok {
+				moved := filepath.Join(newRel, rest)
+				changes = append(changes, fmt.Sprintf("package %s: %s %s → %s", name, key, entry.Value, moved))
+				entry.Value = moved
+			}
+		}
+	}
+	return changes
+}
+
+// moveIgnore rewrites an anchored ignore pattern that names the moved path,
+// from the root of the repo or of the package it's scoped to.
+func moveIgnore(pattern string, cfg *Config, sources map[string]string, from, to string) (string, bool) {
+	p := ParseIgnore(pattern)
+	if !p.Anchored {
+		return "", false
+	}
+
+	base := cfg.BaseDir
+	if p.Package != "" {
+		source, ok := sources[p.Package]
+		if !ok {
+			return "", false
+		}
+		// Packages that move take their patterns along
+		if rest, ok := within(source, from); ok {
+			source = filepath.Join(to, rest)
+		}
+		base = source
+	}
+
+	rest, ok := within(filepath.Join(base, p.Pattern), from)
+	if !ok {
+		return "", false
+	}
+	rel, ok := within(filepath.Join(to, rest), base)
+	if !ok || rel == "" {
+		return "", false
+	}
+
+	moved := "/" + filepath.ToSlash(rel)
+	if p.Package != "" {
+		moved = p.Package + ":" + moved
+	}
+	return moved, true
+}
+
+// within returns path relative to dir when it is dir or lies inside it.
+func within(path, dir string) (string, bool) {
+	if path == dir {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(path, dir+"/")
+	return rest, ok
+}
+
+// relocate writes the new path of a moved source in the style of the old
+// one: relative to the same base, to the home directory, or absolute.
+func relocate(value, base, moved string) string {
+	if filepath.IsAbs(value) {
+		return moved
+	}
+	if strings.HasPrefix(value, "~") {
+		if homeDir, err := home.Dir(); err == nil {
+			if rest, ok := within(moved, homeDir); ok {
+				return "~/" + rest
+			}
+		}
+		return moved
+	}
+
+	rel, err := filepath.Rel(base, moved)
+	if err != nil {
+		return moved
+	}
+	if strings.HasPrefix(value, "./") && !strings.HasPrefix(rel, "..") {
+		return "./" + rel
+	}
+	return rel
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
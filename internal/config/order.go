@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// orderPackages moves every package after the packages it depends on, so
+// they're linked and their hooks run first. Packages keep the order they're
+// written in otherwise.
+func (c *Config) orderPackages() error {
+	for _, pkg := range c.Packages {
+		for _, dep := range pkg.DependsOn {
+			if len(c.FindPackagesByName(dep)) == 0 {
+				return fmt.Errorf("package %s depends on unknown package %s", pkg.Name, dep)
+			}
+		}
+	}
+
+	ordered := make([]*Package, 0, len(c.Packages))
+	placed := make(map[*Package]bool, len(c.Packages))
+	ready := func(pkg *Package) bool {
+		for _, dep := range pkg.DependsOn {
+			for _, other := range c.FindPackagesByName(dep) {
+				if !placed[other] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	// Place the first package whose dependencies are placed, until none
+	// is left or the rest depend on each other
+	for len(ordered) < len(c.Packages) {
+		i := slices.IndexFunc(c.Packages, func(pkg *Package) bool {
+			return !placed[pkg] && ready(pkg)
+		})
+		if i < 0 {
+			var names []string
+			for _, pkg := range c.Packages {
+				if !placed[pkg] {
+					names = append(names, pkg.Name)
+				}
+			}
+			return fmt.Errorf("packages %s depend on each other", strings.Join(names, ", "))
+		}
+
+		placed[c.Packages[i]] = true
+		ordered = append(ordered, c.Packages[i])
+	}
+
+	c.Packages = ordered
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// validateOverlap reports packages that link the same files, because their
+// sources are the same or one lies inside the other, usually a package that
+// was copied and not changed. Packages that are never linked together, as
+// their environments differ, don't overlap. Either package can allow it with
+// allow_overlap.
+func (c *Config) validateOverlap() error {
+	for i, pkg := range c.Packages {
+		for _, other := range c.Packages[i+1:] {
+			if pkg.AllowOverlap || other.AllowOverlap || !sharesEnvironment(pkg, other) {
+				continue
+			}
+
+			switch {
+			case pkg.Source == other.Source:
+				return fmt.Errorf("packages %s and %s have the same source %s, set allow_overlap: true on one of them if that's intended", pkg.Name, other.Name, pkg.Source)
+			case strings.HasPrefix(other.Source, pkg.Source+"/"):
+				return fmt.Errorf("the source of package %s lies inside the source of package %s, set allow_overlap: true on one of them if that's intended", other.Name, pkg.Name)
+			case strings.HasPrefix(pkg.Source, other.Source+"/"):
+				return fmt.Errorf("the source of package %s lies inside the source of package %s, set allow_overlap: true on one of them if that's intended", pkg.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sharesEnvironment reports whether two packages can be linked together,
+// which packages without environments are with any other.
+func sharesEnvironment(a, b *Package) bool {
+	if len(a.Environments) == 0 || len(b.Environments) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(a.Environments, func(env string) bool {
+		return slices.Contains(b.Environments, env)
+	})
+}
@@ -0,0 +1,149 @@
+// Package conflict writes the targets that are in the way of links to a
+// report the user can review, and applies the resolutions chosen in it.
+package conflict
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Backup renames the existing file so the link can take its place
+	Backup = "backup"
+	// Overwrite removes the existing file
+	Overwrite = "overwrite"
+	// Adopt moves the existing file into the package, replacing the source
+	Adopt = "adopt"
+	// Skip leaves the existing file alone
+	Skip = "skip"
+)
+
+// BackupSuffix is appended to the name of files moved aside by Backup.
+const BackupSuffix = ".farm-backup"
+
+type Conflict struct {
+	Target string `yaml:"target"`
+	Source string `yaml:"source"`
+	// Existing is what is in the way, a file or a directory
+	Existing string `yaml:"existing"`
+	// Identical is set when the existing file has the same content as the
+	// source
+	Identical  bool   `yaml:"identical,omitempty"`
+	Resolution string `yaml:"resolution"`
+}
+
+type Report struct {
+	Conflicts []Conflict `yaml:"conflicts"`
+}
+
+const header = `# Conflicts found by farm. Review the resolution of each target and run
+# 'farm resolve <file>' to apply them:
+#
+#   backup     rename the existing file to <target>` + BackupSuffix + `
+#   overwrite  remove the existing file
+#   adopt      move the existing file into the package, replacing the source
+#   skip       leave the existing file alone
+#
+`
+
+// New describes the conflict at target and proposes a resolution. Files that
+// are identical to their source are overwritten, anything else is backed up.
+func New(target, source string) Conflict {
+	c := Conflict{Target: target, Source: source, Existing: "file", Resolution: Backup}
+
+	info, err := os.Lstat(target)
+	if err == nil && info.IsDir() {
+		c.Existing = "directory"
+		return c
+	}
+
+	existing, err := os.ReadFile(target)
+	if err != nil {
+		return c
+	}
+	if content, err := os.ReadFile(source); err == nil && bytes.Equal(existing, content) {
+		c.Identical = true
+		c.Resolution = Overwrite
+	}
+
+	return c
+}
+
+// NewShadowed describes a target farm linked that was since replaced by a
+// file, as programs that save their settings do. Unlike files that were in
+// the way before linking, the changes were made to what farm placed, so a
+// modified file is adopted back into the package by default.
+func NewShadowed(target, source string) Conflict {
+	c := New(target, source)
+	if c.Existing == "file" && !c.Identical {
+		c.Resolution = Adopt
+	}
+	return c
+}
+
+func (r *Report) Save(path string) error {
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conflict report: %w", err)
+	}
+
+	if err := os.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return fmt.Errorf("failed to write conflict report: %w", err)
+	}
+
+	return nil
+}
+
+func Load(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conflict report: %w", err)
+	}
+
+	var report Report
+	if err := yaml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse conflict report: %w", err)
+	}
+
+	for _, c := range report.Conflicts {
+		switch c.Resolution {
+		case Backup, Overwrite, Adopt, Skip:
+		default:
+			return nil, fmt.Errorf("%s: unknown resolution %q", c.Target, c.Resolution)
+		}
+	}
+
+	return &report, nil
+}
+
+// Apply resolves the conflict so the next link can create its symlink.
+func Apply(c Conflict) error {
+	if _, err := os.Lstat(c.Target); os.IsNotExist(err) {
+		return nil
+	}
+
+	switch c.Resolution {
+	case Backup:
+		backup := c.Target + BackupSuffix
+		if _, err := os.Lstat(backup); err == nil {
+			return fmt.Errorf("backup %s already exists", backup)
+		}
+		return os.Rename(c.Target, backup)
+	case Overwrite:
+		return os.RemoveAll(c.Target)
+	case Adopt:
+		if err := os.RemoveAll(c.Source); err != nil {
+			return fmt.Errorf("failed to remove source %s: %w", c.Source, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(c.Source), 0755); err != nil {
+			return fmt.Errorf("failed to create source directory: %w", err)
+		}
+		return os.Rename(c.Target, c.Source)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package conflict
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProposesResolution(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	source := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("same"), 0644))
+
+	identical := filepath.Join(tmpDir, "identical")
+	require.NoError(t, os.WriteFile(identical, []byte("same"), 0644))
+	assert.Equal(t, Conflict{Target: identical, Source: source, Existing: "file", Identical: true, Resolution: Overwrite}, New(identical, source))
+
+	different := filepath.Join(tmpDir, "different")
+	require.NoError(t, os.WriteFile(different, []byte("different"), 0644))
+	assert.Equal(t, Backup, New(different, source).Resolution)
+
+	dir := filepath.Join(tmpDir, "dir")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	assert.Equal(t, Conflict{Target: dir, Source: source, Existing: "directory", Resolution: Backup}, New(dir, source))
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conflicts.yaml")
+
+	report := &Report{Conflicts: []Conflict{
+		{Target: "/home/user/.zshrc", Source: "/dotfiles/zsh/.zshrc", Existing: "file", Resolution: Backup},
+	}}
+	require.NoError(t, report.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, report, loaded)
+
+	require.NoError(t, os.WriteFile(path, []byte("conflicts:\n  - target: /a\n    resolution: delete\n"), 0644))
+	_, err = Load(path)
+	assert.ErrorContains(t, err, `unknown resolution "delete"`)
+}
+
+func TestApply(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	source := filepath.Join(tmpDir, "source")
+	target := filepath.Join(tmpDir, "target")
+	write := func() {
+		require.NoError(t, os.WriteFile(source, []byte("source"), 0644))
+		require.NoError(t, os.WriteFile(target, []byte("target"), 0644))
+	}
+
+	write()
+	require.NoError(t, Apply(Conflict{Target: target, Source: source, Resolution: Backup}))
+	assert.NoFileExists(t, target)
+	content, err := os.ReadFile(target + BackupSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, "target", string(content))
+
+	// Backups are never overwritten
+	write()
+	assert.ErrorContains(t, Apply(Conflict{Target: target, Source: source, Resolution: Backup}), "already exists")
+
+	require.NoError(t, Apply(Conflict{Target: target, Source: source, Resolution: Skip}))
+	assert.FileExists(t, target)
+
+	require.NoError(t, Apply(Conflict{Target: target, Source: source, Resolution: Adopt}))
+	assert.NoFileExists(t, target)
+	content, err = os.ReadFile(source)
+	require.NoError(t, err)
+	assert.Equal(t, "target", string(content))
+
+	write()
+	require.NoError(t, Apply(Conflict{Target: target, Source: source, Resolution: Overwrite}))
+	assert.NoFileExists(t, target)
+	assert.FileExists(t, source)
+}
+
+func TestNewShadowed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	source := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("same"), 0644))
+
+	saved := filepath.Join(tmpDir, "saved")
+	require.NoError(t, os.WriteFile(saved, []byte("saved"), 0644))
+	assert.Equal(t, Adopt, NewShadowed(saved, source).Resolution)
+
+	identical := filepath.Join(tmpDir, "identical")
+	require.NoError(t, os.WriteFile(identical, []byte("same"), 0644))
+	assert.Equal(t, Overwrite, NewShadowed(identical, source).Resolution)
+
+	dir := filepath.Join(tmpDir, "dir")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	assert.Equal(t, Backup, NewShadowed(dir, source).Resolution)
+}
+
+func TestDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	source := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("theme = dark\nfont = mono\nsize = 12\n"), 0644))
+	target := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.WriteFile(target, []byte("theme = light\nfont = mono\nsize = 12\nzoom = 2\n"), 0644))
+
+	diff, err := Diff(source, target)
+	require.NoError(t, err)
+	assert.Equal(t, "- theme = dark\n+ theme = light\n+ zoom = 2\n", diff)
+
+	diff, err = Diff(source, source)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	require.NoError(t, os.WriteFile(target, []byte{0, 1, 2}, 0644))
+	diff, err = Diff(source, target)
+	require.NoError(t, err)
+	assert.Equal(t, "Files differ\n", diff)
+}
@@ -0,0 +1,38 @@
+package conflict
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mskelton/farm/internal/diff"
+)
+
+// Diff returns the lines removed from the source and added in the target,
+// prefixed with - and + like a unified diff without context. Binary files
+// and files too long to compare are only reported as differing.
+func Diff(source, target string) (string, error) {
+	before, err := os.ReadFile(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	after, err := os.ReadFile(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", target, err)
+	}
+
+	if string(before) == string(after) {
+		return "", nil
+	}
+	if !diff.Comparable(before, after) {
+		return "Files differ\n", nil
+	}
+
+	var out strings.Builder
+	for _, line := range diff.Lines(string(before), string(after)) {
+		if line.Kind != ' ' {
+			fmt.Fprintf(&out, "%c %s\n", line.Kind, line.Text)
+		}
+	}
+	return out.String(), nil
+}
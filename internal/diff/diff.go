@@ -0,0 +1,149 @@
+// Package diff compares text line by line, to show how a file changes.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// MaxLines bounds the length of the texts compared line by line, as the
+// comparison grows with the product of their lengths.
+const MaxLines = 4000
+
+// context is how many unchanged lines surround the changes of a hunk.
+const context = 3
+
+// Line is a line of a comparison: kept in both texts, removed from the
+// first, or added in the second.
+type Line struct {
+	Kind byte // ' ', '-', or '+'
+	Text string
+}
+
+// Lines compares two texts line by line, returning every line of both in
+// order. Lines are removed before they're added where they change.
+func Lines(before, after string) []Line {
+	a, b := split(before), split(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, Line{Kind: ' ', Text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, Line{Kind: '-', Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Kind: '+', Text: b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// Comparable reports whether two texts can be compared line by line, which
+// binary files and files longer than MaxLines can't.
+func Comparable(before, after []byte) bool {
+	if bytes.IndexByte(before, 0) >= 0 || bytes.IndexByte(after, 0) >= 0 {
+		return false
+	}
+	return bytes.Count(before, []byte("\n")) <= MaxLines && bytes.Count(after, []byte("\n")) <= MaxLines
+}
+
+// Unified returns the changes from before to after as a unified diff with
+// three lines of context, labeled with the names of both sides, or nothing
+// when they're equal.
+func Unified(beforeName, afterName string, before, after []byte) string {
+	if bytes.Equal(before, after) {
+		return ""
+	}
+	if !Comparable(before, after) {
+		return fmt.Sprintf("Files %s and %s differ\n", beforeName, afterName)
+	}
+
+	lines := Lines(string(before), string(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", beforeName, afterName)
+
+	// Line numbers in both texts at the start of each line
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	oldLine[0], newLine[0] = 1, 1
+	for k, line := range lines {
+		oldLine[k+1], newLine[k+1] = oldLine[k], newLine[k]
+		if line.Kind != '+' {
+			oldLine[k+1]++
+		}
+		if line.Kind != '-' {
+			newLine[k+1]++
+		}
+	}
+
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk while changes follow
+		// within twice the context
+		first := start
+		for first < len(lines) && lines[first].Kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for k := first; k < len(lines) && k <= last+2*context; k++ {
+			if lines[k].Kind != ' ' {
+				last = k
+			}
+		}
+
+		from := max(first-context, start)
+		to := min(last+context+1, len(lines))
+		oldCount, newCount := oldLine[to]-oldLine[from], newLine[to]-newLine[from]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine[from], oldCount), hunkRange(newLine[from], newCount))
+		for _, line := range lines[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", line.Kind, line.Text)
+		}
+		start = to
+	}
+
+	return out.String()
+}
+
+// hunkRange formats where a hunk starts and how many lines it spans, which
+// for an empty side is the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,64 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLines(t *testing.T) {
+	assert.Equal(t, []Line{
+		{Kind: '-', Text: "theme = dark"},
+		{Kind: '+', Text: "theme = light"},
+		{Kind: ' ', Text: "font = mono"},
+		{Kind: '+', Text: "zoom = 2"},
+	}, Lines("theme = dark\nfont = mono\n", "theme = light\nfont = mono\nzoom = 2\n"))
+}
+
+func TestUnified(t *testing.T) {
+	assert.Empty(t, Unified("a", "b", []byte("same\n"), []byte("same\n")))
+
+	before := []byte("[user]\n  name = Mark\n  email = mark@home.dev\n")
+	after := []byte("[user]\n  name = Mark\n  email = mark@work.dev\n")
+	assert.Equal(t, `--- ~/.gitconfig
++++ ~/.gitconfig (rendered)
+@@ -1,3 +1,3 @@
+ [user]
+   name = Mark
+-  email = mark@home.dev
++  email = mark@work.dev
+`, Unified("~/.gitconfig", "~/.gitconfig (rendered)", before, after))
+
+	// Changes far apart get their own hunks, with three lines of context
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+	before = []byte(strings.Join(lines, "\n") + "\n")
+	lines[1], lines[17] = "second", "eighteenth"
+	after = []byte(strings.Join(lines, "\n") + "\n")
+	assert.Equal(t, `--- a
++++ b
+@@ -1,5 +1,5 @@
+ x
+-xx
++second
+ xxx
+ xxxx
+ xxxxx
+@@ -15,6 +15,6 @@
+ xxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxx
+-xxxxxxxxxxxxxxxxxx
++eighteenth
+ xxxxxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxxxxx
+`, Unified("a", "b", before, after))
+
+	// New files are added from nothing
+	assert.Equal(t, "--- /dev/null\n+++ b\n@@ -0,0 +1,2 @@\n+one\n+two\n", Unified("/dev/null", "b", nil, []byte("one\ntwo\n")))
+
+	assert.Equal(t, "Files a and b differ\n", Unified("a", "b", []byte{0, 1}, []byte("text")))
+}
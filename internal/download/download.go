@@ -0,0 +1,112 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mskelton/farm/internal/fsutil"
+)
+
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+// Fetch downloads url and verifies it against the expected sha256 checksum.
+// When extract is set the download is treated as a .tar.gz or .zip archive
+// and only the file at that path inside the archive is returned.
+func Fetch(url, checksum, extract string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	if actual := fsutil.Checksum(data); !strings.EqualFold(actual, checksum) {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, checksum, actual)
+	}
+
+	if extract == "" {
+		return data, nil
+	}
+
+	return Extract(url, data, extract)
+}
+
+// Extract returns the file at name inside an archive. The archive format is
+// detected from the file name.
+func Extract(archive string, data []byte, name string) ([]byte, error) {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+
+	switch {
+	case strings.HasSuffix(archive, ".tar.gz") || strings.HasSuffix(archive, ".tgz"):
+		return extractTarGz(data, name)
+	case strings.HasSuffix(archive, ".zip"):
+		return extractZip(data, name)
+	default:
+		return nil, fmt.Errorf("can't extract %s from %s, only .tar.gz and .zip archives are supported", name, archive)
+	}
+}
+
+func extractTarGz(data []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if header.Typeflag == tar.TypeReg && path.Clean(header.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+func extractZip(data []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() || path.Clean(file.Name) != name {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", name, err)
+		}
+		defer rc.Close()
+
+		return io.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
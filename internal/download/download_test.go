@@ -0,0 +1,93 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestFetch(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho fzf\n")
+	archive := tarGz(t, map[string]string{"./starship": "starship", "README.md": "readme"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fzf":
+			w.Write(binary)
+		case "/starship.tar.gz":
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	data, err := Fetch(server.URL+"/fzf", checksum(binary), "")
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+
+	_, err = Fetch(server.URL+"/fzf", checksum([]byte("other")), "")
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	data, err = Fetch(server.URL+"/starship.tar.gz", checksum(archive), "starship")
+	require.NoError(t, err)
+	assert.Equal(t, "starship", string(data))
+
+	_, err = Fetch(server.URL+"/missing", checksum(binary), "")
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestExtract(t *testing.T) {
+	archive := zipped(t, map[string]string{"bin/fzf": "fzf"})
+
+	data, err := Extract("fzf.zip", archive, "./bin/fzf")
+	require.NoError(t, err)
+	assert.Equal(t, "fzf", string(data))
+
+	_, err = Extract("fzf.zip", archive, "fzf")
+	assert.ErrorContains(t, err, "fzf not found in archive")
+
+	_, err = Extract("fzf.tar.xz", archive, "fzf")
+	assert.ErrorContains(t, err, "only .tar.gz and .zip archives are supported")
+}
@@ -0,0 +1,176 @@
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/lockfile"
+)
+
+// Problem is an inconsistency between a lockfile entry and the filesystem,
+// along with a suggestion for repairing it.
+type Problem struct {
+	Target string
+	Issue  string
+	Repair string
+}
+
+// Check verifies every entry in the lockfile: that symlinks resolve to their
+// source, that copied, rendered, and downloaded files still match the
+// checksum they were written with, and that directories exist with the mode
+// the config asks for.
+func Check(cfg *config.Config, lock *lockfile.LockFile) []Problem {
+	modes := directoryModes(cfg)
+
+	var problems []Problem
+	for _, link := range lock.Symlinks.Sorted() {
+		var problem *Problem
+		switch {
+		case link.Kind == lockfile.KindDirectory:
+			problem = checkDirectory(link, modes)
+		case link.IsFile():
+			problem = checkFile(link)
+		default:
+			problem = checkSymlink(link)
+		}
+
+		if problem == nil && cfg.FindPackage(link.Source) == nil {
+			problem = &Problem{Issue: "not part of any configured package", Repair: "run 'farm lock gc' to stop tracking it"}
+		}
+
+		if problem != nil {
+			problem.Target = link.Target
+			problems = append(problems, *problem)
+		}
+	}
+
+	return problems
+}
+
+// directoryModes maps the directories with an explicit mode in the config to
+// that mode.
+func directoryModes(cfg *config.Config) map[string]os.FileMode {
+	modes := make(map[string]os.FileMode)
+	for _, pkg := range cfg.Packages {
+		for _, dir := range pkg.Directories {
+			if dir.Mode == 0 {
+				continue
+			}
+			for _, path := range dir.Paths(pkg.Targets) {
+				modes[path] = os.FileMode(dir.Mode)
+			}
+		}
+	}
+	return modes
+}
+
+func checkSymlink(link lockfile.Symlink) *Problem {
+	info, err := os.Lstat(link.Target)
+	if os.IsNotExist(err) {
+		return &Problem{Issue: "symlink is missing", Repair: "run 'farm link' to recreate it"}
+	}
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to stat: %v", err)}
+	}
+
+	if info.IsDir() {
+		return &Problem{Issue: "replaced by a directory", Repair: "move it aside and run 'farm link'"}
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return checkShadowed(link)
+	}
+
+	dest, err := os.Readlink(link.Target)
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to read symlink: %v", err)}
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(link.Target), dest)
+	}
+
+	if !fsutil.SamePath(dest, link.Source) && (link.Resolved == "" || !fsutil.SamePath(dest, link.Resolved)) {
+		return &Problem{Issue: fmt.Sprintf("points to %s instead of %s", dest, link.Source), Repair: "run 'farm link' to relink it"}
+	}
+
+	if _, err := os.Stat(link.Source); os.IsNotExist(err) {
+		return &Problem{Issue: fmt.Sprintf("source %s is missing", link.Source), Repair: "run 'farm link' to remove the dead link"}
+	}
+
+	return nil
+}
+
+// checkShadowed describes a link that was replaced by a regular file, which
+// programs that save their settings do, by whether the file still matches
+// its source.
+func checkShadowed(link lockfile.Symlink) *Problem {
+	repair := "run 'farm link --conflict-report <file>' to adopt it back or relink it"
+
+	target, err := os.ReadFile(link.Target)
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to read: %v", err)}
+	}
+	source, err := os.ReadFile(link.Source)
+	if err == nil && bytes.Equal(target, source) {
+		return &Problem{Issue: "replaced by a copy of its source", Repair: repair}
+	}
+
+	return &Problem{Issue: "replaced by a modified regular file", Repair: repair}
+}
+
+func checkFile(link lockfile.Symlink) *Problem {
+	repair := "run 'farm link' to rewrite it"
+	if link.Kind == lockfile.KindDownload {
+		repair = "run 'farm update-assets' to download it again"
+	}
+
+	info, err := os.Lstat(link.Target)
+	if os.IsNotExist(err) {
+		return &Problem{Issue: fmt.Sprintf("%s file is missing", link.Kind), Repair: repair}
+	}
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to stat: %v", err)}
+	}
+
+	if !info.Mode().IsRegular() {
+		return &Problem{Issue: fmt.Sprintf("%s file is no longer a regular file", link.Kind), Repair: repair}
+	}
+
+	// Entries written before checksums were recorded can't be verified
+	if link.Checksum == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(link.Target)
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to read: %v", err)}
+	}
+	if fsutil.Checksum(content) != link.Checksum {
+		return &Problem{Issue: fmt.Sprintf("%s file was modified since farm wrote it", link.Kind), Repair: repair}
+	}
+
+	return nil
+}
+
+func checkDirectory(link lockfile.Symlink, modes map[string]os.FileMode) *Problem {
+	info, err := os.Lstat(link.Target)
+	if os.IsNotExist(err) {
+		return &Problem{Issue: "directory is missing", Repair: "run 'farm link' to recreate it"}
+	}
+	if err != nil {
+		return &Problem{Issue: fmt.Sprintf("failed to stat: %v", err)}
+	}
+
+	if !info.IsDir() {
+		return &Problem{Issue: "is no longer a directory", Repair: "move it aside and run 'farm link'"}
+	}
+
+	if mode, ok := modes[link.Target]; ok && info.Mode().Perm() != mode {
+		return &Problem{Issue: fmt.Sprintf("has mode %04o instead of %04o", info.Mode().Perm(), mode), Repair: "run 'farm link' to reset its mode"}
+	}
+
+	return nil
+}
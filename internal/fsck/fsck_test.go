@@ -0,0 +1,82 @@
+package fsck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "source")
+	target := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.MkdirAll(source, 0755))
+	require.NoError(t, os.MkdirAll(target, 0755))
+
+	for _, name := range []string{"good", "moved", "copied", "edited", "saved", "resaved"} {
+		require.NoError(t, os.WriteFile(filepath.Join(source, name), []byte(name), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:  source,
+				Targets: []string{target},
+				Directories: []config.Directory{
+					{Path: "private", Mode: 0700},
+				},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+
+	// A healthy symlink, copy, and directory
+	require.NoError(t, os.Symlink(filepath.Join(source, "good"), filepath.Join(target, "good")))
+	lock.AddSymlink(filepath.Join(target, "good"), filepath.Join(source, "good"), false)
+	require.NoError(t, os.WriteFile(filepath.Join(target, "copied"), []byte("copied"), 0644))
+	lock.AddFile(filepath.Join(target, "copied"), filepath.Join(source, "copied"), lockfile.KindCopy, fsutil.Checksum([]byte("copied")))
+	require.NoError(t, os.Mkdir(filepath.Join(target, "private"), 0700))
+	lock.AddDirectory(filepath.Join(target, "private"), source)
+	assert.Empty(t, Check(cfg, lock))
+
+	// Break each kind of entry
+	require.NoError(t, os.Symlink(filepath.Join(source, "good"), filepath.Join(target, "moved")))
+	lock.AddSymlink(filepath.Join(target, "moved"), filepath.Join(source, "moved"), false)
+	require.NoError(t, os.WriteFile(filepath.Join(target, "edited"), []byte("local edit"), 0644))
+	lock.AddFile(filepath.Join(target, "edited"), filepath.Join(source, "edited"), lockfile.KindRender, fsutil.Checksum([]byte("edited")))
+	lock.AddSymlink(filepath.Join(target, "missing"), filepath.Join(source, "good"), false)
+	lock.AddFile(filepath.Join(target, "tool"), source, lockfile.KindDownload, "")
+	require.NoError(t, os.Chmod(filepath.Join(target, "private"), 0755))
+	lock.AddSymlink(filepath.Join(tmpDir, "orphan"), filepath.Join(tmpDir, "elsewhere"), false)
+	require.NoError(t, os.Symlink(filepath.Join(tmpDir, "elsewhere"), filepath.Join(tmpDir, "orphan")))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "elsewhere"), nil, 0644))
+
+	// Links replaced by a program saving its settings
+	require.NoError(t, os.WriteFile(filepath.Join(target, "saved"), []byte("saved by an app"), 0644))
+	lock.AddSymlink(filepath.Join(target, "saved"), filepath.Join(source, "saved"), false)
+	require.NoError(t, os.WriteFile(filepath.Join(target, "resaved"), []byte("resaved"), 0644))
+	lock.AddSymlink(filepath.Join(target, "resaved"), filepath.Join(source, "resaved"), false)
+
+	issues := make(map[string]string)
+	for _, problem := range Check(cfg, lock) {
+		issues[problem.Target] = problem.Issue + " → " + problem.Repair
+	}
+
+	assert.Equal(t, map[string]string{
+		filepath.Join(tmpDir, "orphan"):  "not part of any configured package → run 'farm lock gc' to stop tracking it",
+		filepath.Join(target, "edited"):  "render file was modified since farm wrote it → run 'farm link' to rewrite it",
+		filepath.Join(target, "missing"): "symlink is missing → run 'farm link' to recreate it",
+		filepath.Join(target, "moved"):   "points to " + filepath.Join(source, "good") + " instead of " + filepath.Join(source, "moved") + " → run 'farm link' to relink it",
+		filepath.Join(target, "private"): "has mode 0755 instead of 0700 → run 'farm link' to reset its mode",
+		filepath.Join(target, "resaved"): "replaced by a copy of its source → run 'farm link --conflict-report <file>' to adopt it back or relink it",
+		filepath.Join(target, "saved"):   "replaced by a modified regular file → run 'farm link --conflict-report <file>' to adopt it back or relink it",
+		filepath.Join(target, "tool"):    "download file is missing → run 'farm update-assets' to download it again",
+	}, issues)
+}
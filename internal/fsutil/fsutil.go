@@ -0,0 +1,130 @@
+package fsutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+// MaxSymlinkDepth caps how many links ResolveChain follows, matching the
+// limit of most kernels.
+const MaxSymlinkDepth = 40
+
+// ErrSymlinkLoop is returned for symlink chains that loop back on themselves
+// or are too long to resolve.
+var ErrSymlinkLoop = errors.New("symlink loop")
+
+// SamePath reports whether two absolute paths resolve to the same file once
+// symlinks in either path are followed, so links that differ only in how
+// their destination is spelled are treated as equivalent.
+func SamePath(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+
+	resolvedA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+
+	resolvedB, err := filepath.EvalSymlinks(b)
+	if err != nil {
+		return false
+	}
+
+	return resolvedA == resolvedB
+}
+
+// ResolveExisting follows the symlinks in the part of path that exists,
+// keeping the rest as is, so paths that haven't been created yet can still be
+// compared by where they'll end up.
+func ResolveExisting(path string) string {
+	rest := ""
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if filepath.Dir(dir) == dir {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// Checksum returns the hex encoded sha256 digest of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ResolveChain follows the symlink at path one link at a time and returns
+// every path in the chain, ending with the file it resolves to. When the
+// chain is broken the last path is the one that doesn't exist and the error
+// satisfies os.IsNotExist.
+func ResolveChain(path string) ([]string, error) {
+	chain := []string{path}
+	seen := map[string]bool{path: true}
+
+	for {
+		info, err := os.Lstat(path)
+		if errors.Is(err, syscall.ELOOP) {
+			return chain, ErrSymlinkLoop
+		}
+		if err != nil {
+			return chain, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return chain, nil
+		}
+
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return chain, err
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(filepath.Dir(path), dest)
+		}
+
+		chain = append(chain, dest)
+		if seen[dest] || len(chain) > MaxSymlinkDepth {
+			return chain, ErrSymlinkLoop
+		}
+		seen[dest] = true
+		path = dest
+	}
+}
+
+// CaseInsensitive reports whether the filesystem holding the existing path
+// ignores case, by looking the path up with the case of its name swapped.
+// Paths without letters fall back to the platform's default.
+func CaseInsensitive(path string) bool {
+	for ; filepath.Dir(path) != path; path = filepath.Dir(path) {
+		name := filepath.Base(path)
+		swapped := strings.Map(swapCase, name)
+		if swapped == name {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		other, err := os.Stat(filepath.Join(filepath.Dir(path), swapped))
+		return err == nil && os.SameFile(info, other)
+	}
+
+	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+}
+
+func swapCase(r rune) rune {
+	if unicode.IsUpper(r) {
+		return unicode.ToLower(r)
+	}
+	return unicode.ToUpper(r)
+}
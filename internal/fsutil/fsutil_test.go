@@ -0,0 +1,109 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamePath(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	realDir := filepath.Join(tmpDir, "real")
+	require.NoError(t, os.MkdirAll(realDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(realDir, "file.txt"), []byte("content"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(realDir, "other.txt"), []byte("content"), 0644))
+
+	aliasDir := filepath.Join(tmpDir, "alias")
+	require.NoError(t, os.Symlink(realDir, aliasDir))
+
+	assert.True(t, SamePath(filepath.Join(realDir, "file.txt"), filepath.Join(realDir, "file.txt")))
+	assert.True(t, SamePath(filepath.Join(realDir, "file.txt"), filepath.Join(realDir, "sub", "..", "file.txt")))
+	assert.True(t, SamePath(filepath.Join(aliasDir, "file.txt"), filepath.Join(realDir, "file.txt")))
+	assert.False(t, SamePath(filepath.Join(realDir, "file.txt"), filepath.Join(realDir, "other.txt")))
+	assert.False(t, SamePath(filepath.Join(realDir, "missing.txt"), filepath.Join(realDir, "file.txt")))
+}
+
+func TestResolveExisting(t *testing.T) {
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	realDir := filepath.Join(tmpDir, "real")
+	require.NoError(t, os.MkdirAll(realDir, 0755))
+	aliasDir := filepath.Join(tmpDir, "alias")
+	require.NoError(t, os.Symlink(realDir, aliasDir))
+
+	assert.Equal(t, realDir, ResolveExisting(aliasDir))
+	assert.Equal(t, filepath.Join(realDir, "missing/file"), ResolveExisting(filepath.Join(aliasDir, "missing/file")))
+	assert.Equal(t, filepath.Join(tmpDir, "missing"), ResolveExisting(filepath.Join(tmpDir, "missing")))
+}
+
+func TestResolveChain(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	file := filepath.Join(tmpDir, "file.txt")
+	require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+
+	first := filepath.Join(tmpDir, "first")
+	second := filepath.Join(tmpDir, "second")
+	require.NoError(t, os.Symlink("second", first))
+	require.NoError(t, os.Symlink(file, second))
+
+	chain, err := ResolveChain(first)
+	require.NoError(t, err)
+	assert.Equal(t, []string{first, second, file}, chain)
+
+	// A link to a link to a missing file
+	require.NoError(t, os.Remove(file))
+	chain, err = ResolveChain(first)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{first, second, file}, chain)
+
+	loopA := filepath.Join(tmpDir, "loop-a")
+	loopB := filepath.Join(tmpDir, "loop-b")
+	require.NoError(t, os.Symlink(loopB, loopA))
+	require.NoError(t, os.Symlink(loopA, loopB))
+
+	chain, err = ResolveChain(loopA)
+	assert.ErrorIs(t, err, ErrSymlinkLoop)
+	assert.Equal(t, []string{loopA, loopB, loopA}, chain)
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Probe")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	// Whether the lookup with swapped case finds the same directory
+	_, err := os.Stat(filepath.Join(filepath.Dir(dir), "pROBE"))
+	assert.Equal(t, err == nil, CaseInsensitive(dir))
+}
+
+func TestNFC(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"leaves ascii alone", "init.lua", "init.lua"},
+		{"leaves composed names alone", "caf\u00e9", "caf\u00e9"},
+		{"composes an accent", "cafe\u0301", "caf\u00e9"},
+		{"composes an umlaut", "A\u0308rger", "\u00c4rger"},
+		{"composes marks in canonical order", "e\u0323\u0302", "\u1ec7"},
+		{"composes hangul", "\u1112\u1161\u11ab\u1100\u116e\u11a8\u110b\u1165", "\ud55c\uad6d\uc5b4"},
+		{"composes every path component", "re\u0301sume\u0301/nai\u0308ve.txt", "r\u00e9sum\u00e9/na\u00efve.txt"},
+		{"keeps marks without a composition", "d\u0323\u0307", "\u1e0d\u0307"},
+		{"replaces singletons", "\u212b", "\u00c5"},
+		{"recomposes mixed spellings", "\u00e9\u0323", "\u1eb9\u0301"},
+		{"decomposes exclusions", "\u0958", "\u0915\u093c"},
+		{"composes a syllable with a trailing consonant", "\uac00\u11a8", "\uac01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NFC(tt.in))
+		})
+	}
+}
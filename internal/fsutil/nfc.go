@@ -0,0 +1,135 @@
+package fsutil
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// NFC normalizes s to Unicode Normalization Form C, such as the names macOS
+// returns in NFD, so the same name compares equal however it was spelled.
+// Names that are already composed are returned unchanged.
+func NFC(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	// Mixed spellings only compose the same way once they're fully
+	// decomposed and their marks are in canonical order
+	var decomposed []rune
+	for _, r := range s {
+		decomposed = decompose(decomposed, r)
+	}
+	reorder(decomposed)
+
+	out := make([]rune, 0, len(decomposed))
+	starter := -1
+	var lastClass uint8
+	for _, r := range decomposed {
+		class := combiningClass(r)
+
+		// A mark may only join the starter when nothing between them blocks it
+		if starter >= 0 && (starter == len(out)-1 || (lastClass != 0 && lastClass < class)) {
+			if composed, ok := compose(out[starter], r); ok {
+				out[starter] = composed
+				continue
+			}
+		}
+
+		if class == 0 {
+			starter = len(out)
+		}
+		lastClass = class
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+// decompose appends the full canonical decomposition of r to out.
+func decompose(out []rune, r rune) []rune {
+	if r >= hangulSBase && r < hangulSBase+hangulSCount {
+		index := r - hangulSBase
+		out = append(out, hangulLBase+index/hangulNCount, hangulVBase+index%hangulNCount/hangulTCount)
+		if t := index % hangulTCount; t != 0 {
+			out = append(out, hangulTBase+t)
+		}
+		return out
+	}
+
+	if single, ok := singletons[r]; ok {
+		return decompose(out, single)
+	}
+	pair, ok := excluded[r]
+	if !ok {
+		pair, ok = decompositions[r]
+	}
+	if ok {
+		return decompose(decompose(out, pair[0]), pair[1])
+	}
+	return append(out, r)
+}
+
+// reorder sorts each run of marks by their combining class, keeping marks of
+// the same class in the order they were written.
+func reorder(runes []rune) {
+	for i := 1; i < len(runes); i++ {
+		class := combiningClass(runes[i])
+		if class == 0 {
+			continue
+		}
+		for j := i; j > 0 && combiningClass(runes[j-1]) > class; j-- {
+			runes[j-1], runes[j] = runes[j], runes[j-1]
+		}
+	}
+}
+
+// decompositions maps each character in compositions back to its pair.
+var decompositions = func() map[rune][2]rune {
+	pairs := make(map[rune][2]rune, len(compositions))
+	for pair, composed := range compositions {
+		pairs[composed] = pair
+	}
+	return pairs
+}()
+
+func compose(a, b rune) (rune, bool) {
+	// Hangul syllables are composed from their jamo algorithmically
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+b-hangulVBase)*hangulTCount, true
+	}
+	if a >= hangulSBase && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 && b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + b - hangulTBase, true
+	}
+
+	composed, ok := compositions[[2]rune{a, b}]
+	return composed, ok
+}
+
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(combiningClasses), func(i int) bool {
+		return combiningClasses[i].hi >= r
+	})
+	if i < len(combiningClasses) && combiningClasses[i].lo <= r {
+		return combiningClasses[i].class
+	}
+	return 0
+}
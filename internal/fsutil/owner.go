@@ -0,0 +1,62 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+)
+
+// Owner is the user and group a file belongs to. An ID of -1 leaves that
+// part of the ownership unchanged.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// LookupOwner resolves a user and a group, each given by name or numeric ID.
+// Either may be empty to leave it unchanged.
+func LookupOwner(userName, groupName string) (Owner, error) {
+	owner := Owner{UID: -1, GID: -1}
+
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return owner, fmt.Errorf("unknown owner %s: %w", userName, err)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return owner, fmt.Errorf("owner %s has a non-numeric uid %s", userName, u.Uid)
+			}
+		}
+		owner.UID = id
+	}
+
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return owner, fmt.Errorf("unknown group %s: %w", groupName, err)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return owner, fmt.Errorf("group %s has a non-numeric gid %s", groupName, g.Gid)
+			}
+		}
+		owner.GID = id
+	}
+
+	return owner, nil
+}
+
+// Chown gives path to the owner, changing symlinks themselves rather than
+// what they point at. Windows has no such ownership, so it does nothing
+// there.
+func (o Owner) Chown(path string) error {
+	if runtime.GOOS == "windows" || (o.UID == -1 && o.GID == -1) {
+		return nil
+	}
+	return os.Lchown(path, o.UID, o.GID)
+}
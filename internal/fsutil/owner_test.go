@@ -0,0 +1,28 @@
+package fsutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupOwner(t *testing.T) {
+	owner, err := LookupOwner("", "")
+	require.NoError(t, err)
+	assert.Equal(t, Owner{UID: -1, GID: -1}, owner)
+
+	owner, err = LookupOwner("1000", "100")
+	require.NoError(t, err)
+	assert.Equal(t, Owner{UID: 1000, GID: 100}, owner)
+
+	owner, err = LookupOwner("root", "")
+	require.NoError(t, err)
+	assert.Equal(t, Owner{UID: 0, GID: -1}, owner)
+
+	_, err = LookupOwner("farm-no-such-user", "")
+	assert.ErrorContains(t, err, "unknown owner farm-no-such-user")
+
+	_, err = LookupOwner("", "farm-no-such-group")
+	assert.ErrorContains(t, err, "unknown group farm-no-such-group")
+}
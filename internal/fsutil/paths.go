@@ -0,0 +1,98 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// ErrInvalidPath is wrapped by the errors ValidatePath returns.
+var ErrInvalidPath = errors.New("invalid path")
+
+// pathRules are the limits a platform places on paths. Lengths exclude the
+// terminating NUL.
+type pathRules struct {
+	maxPath  int
+	maxName  int
+	invalid  string
+	reserved []string
+	trailing string
+}
+
+var windowsReserved = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+func rulesFor(goos string) pathRules {
+	switch goos {
+	case "windows":
+		return pathRules{maxPath: 259, maxName: 255, invalid: `<>:"|?*`, reserved: windowsReserved, trailing: ". "}
+	case "darwin":
+		return pathRules{maxPath: 1023, maxName: 255}
+	default:
+		return pathRules{maxPath: 4095, maxName: 255}
+	}
+}
+
+// ValidatePath checks path against the length limits and forbidden names of
+// the current platform, so problems are reported up front rather than as
+// cryptic errors from the syscall that creates it.
+func ValidatePath(path string) error {
+	return validatePath(runtime.GOOS, path)
+}
+
+func validatePath(goos, path string) error {
+	rules := rulesFor(goos)
+
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("%w: %q contains a NUL character", ErrInvalidPath, path)
+	}
+	if len(path) > rules.maxPath {
+		return fmt.Errorf("%w: %s is %d characters long, over the limit of %d on %s", ErrInvalidPath, path, len(path), rules.maxPath, goos)
+	}
+
+	separators := "/"
+	if goos == "windows" {
+		separators = `/\`
+		// The colon of a drive letter is the only one allowed
+		if len(path) >= 2 && path[1] == ':' {
+			path = path[2:]
+		}
+	}
+
+	names := strings.FieldsFunc(path, func(r rune) bool {
+		return strings.ContainsRune(separators, r)
+	})
+	for _, name := range names {
+		if name == "." || name == ".." {
+			continue
+		}
+		if len(name) > rules.maxName {
+			return fmt.Errorf("%w: name %s is %d characters long, over the limit of %d on %s", ErrInvalidPath, name, len(name), rules.maxName, goos)
+		}
+		if i := strings.IndexAny(name, rules.invalid); i >= 0 {
+			return fmt.Errorf("%w: name %s contains %q, which isn't allowed on %s", ErrInvalidPath, name, name[i], goos)
+		}
+		for _, c := range name {
+			if c < 0x20 && rules.invalid != "" {
+				return fmt.Errorf("%w: name %q contains a control character, which isn't allowed on %s", ErrInvalidPath, name, goos)
+			}
+		}
+		if rules.trailing != "" && strings.ContainsAny(name[len(name)-1:], rules.trailing) {
+			return fmt.Errorf("%w: name %q ends with %q, which %s drops", ErrInvalidPath, name, name[len(name)-1], goos)
+		}
+
+		// Reserved device names are reserved with any extension too
+		stem, _, _ := strings.Cut(name, ".")
+		for _, reserved := range rules.reserved {
+			if strings.EqualFold(stem, reserved) {
+				return fmt.Errorf("%w: name %s is reserved on %s", ErrInvalidPath, name, goos)
+			}
+		}
+	}
+
+	return nil
+}
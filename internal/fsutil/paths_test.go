@@ -0,0 +1,39 @@
+package fsutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		goos  string
+		path  string
+		valid bool
+	}{
+		{"linux", "/home/user/.config/nvim/init.lua", true},
+		{"linux", "/home/user/what?.txt", true},
+		{"linux", "/home/user/" + strings.Repeat("a", 256), false},
+		{"linux", "/home/user/\x00", false},
+		{"darwin", "/Users/user/" + strings.Repeat("a/", 510), false},
+		{"windows", `C:\Users\user\AppData\Roaming\Code\User\settings.json`, true},
+		{"windows", `C:\Users\user\` + strings.Repeat("a", 250), false},
+		{"windows", `C:\Users\user\what?.txt`, false},
+		{"windows", `C:\Users\user\a:b`, false},
+		{"windows", `C:\Users\user\con.txt`, false},
+		{"windows", `C:\Users\user\console.txt`, true},
+		{"windows", `C:\Users\user\trailing.`, false},
+		{"windows", `C:\Users\user\..\user\file`, true},
+	}
+
+	for _, tt := range tests {
+		err := validatePath(tt.goos, tt.path)
+		if tt.valid {
+			assert.NoError(t, err, "%s on %s", tt.path, tt.goos)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidPath, "%s on %s", tt.path, tt.goos)
+		}
+	}
+}
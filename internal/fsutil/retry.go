@@ -0,0 +1,105 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = 100 * time.Millisecond
+)
+
+// RetryPolicy retries filesystem operations that fail with errors typical of
+// network home directories (NFS, SSHFS), doubling the backoff each attempt.
+// With a rate set, operations are also spaced out so a struggling server
+// isn't flooded.
+type RetryPolicy struct {
+	Attempts int           `yaml:"attempts,omitempty"`
+	Backoff  time.Duration `yaml:"backoff,omitempty"`
+	// Rate is the most operations per second, retries included. Zero means
+	// unlimited.
+	Rate int `yaml:"rate,omitempty"`
+}
+
+// The rate is shared by every operation of the process, however many
+// policies they use
+var (
+	rateMu   sync.Mutex
+	rateNext time.Time
+)
+
+// RetryError reports an operation that kept failing with a transient error
+// until the retry policy gave up.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ESTALE) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
+
+func (p RetryPolicy) Do(op func() error) error {
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		p.wait()
+		if err = op(); err == nil || !IsTransient(err) {
+			return err
+		}
+
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	if attempts == 1 {
+		return err
+	}
+
+	return &RetryError{Attempts: attempts, Err: err}
+}
+
+// wait blocks until the next operation is allowed by the rate.
+func (p RetryPolicy) wait() {
+	if p.Rate <= 0 {
+		return
+	}
+
+	rateMu.Lock()
+	now := time.Now()
+	if rateNext.Before(now) {
+		rateNext = now
+	}
+	delay := rateNext.Sub(now)
+	rateNext = rateNext.Add(time.Second / time.Duration(p.Rate))
+	rateMu.Unlock()
+
+	time.Sleep(delay)
+}
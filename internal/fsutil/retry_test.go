@@ -0,0 +1,71 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	t.Run("succeeds after transient failures", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(func() error {
+			calls++
+			if calls < 3 {
+				return &os.PathError{Op: "symlink", Path: "/nfs/home/.vimrc", Err: syscall.ESTALE}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up on persistent transient failures", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(func() error {
+			calls++
+			return &os.PathError{Op: "remove", Path: "/nfs/home/.vimrc", Err: syscall.EIO}
+		})
+		assert.Equal(t, 3, calls)
+
+		var retryErr *RetryError
+		assert.True(t, errors.As(err, &retryErr))
+		assert.True(t, errors.Is(err, syscall.EIO))
+		assert.Contains(t, err.Error(), "after 3 attempts")
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(func() error {
+			calls++
+			return os.ErrNotExist
+		})
+		assert.Equal(t, 1, calls)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("single attempt returns the error as is", func(t *testing.T) {
+		err := RetryPolicy{Attempts: 1}.Do(func() error {
+			return syscall.EIO
+		})
+		assert.Equal(t, syscall.EIO, err)
+	})
+}
+
+func TestRetryPolicyRate(t *testing.T) {
+	policy := RetryPolicy{Rate: 100}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, policy.Do(func() error { return nil }))
+	}
+
+	// The first operation runs right away, each of the others 10ms later
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package fsutil
+
+import "syscall"
+
+// Writable reports whether the current user may create files in dir.
+func Writable(dir string) bool {
+	return syscall.Access(dir, 0x2) == nil
+}
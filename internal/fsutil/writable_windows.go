@@ -0,0 +1,7 @@
+package fsutil
+
+// Writable reports whether the current user may create files in dir. Windows
+// permissions aren't checked up front, failures surface when linking.
+func Writable(dir string) bool {
+	return true
+}
@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// bare is the repository of dotfiles kept as a bare repo, with its files
+// checked out into a separate work tree, usually the home directory.
+var bare struct {
+	gitDir   string
+	workTree string
+}
+
+// SetBareRepo points the git commands run on paths inside workTree at the
+// repository in gitDir, like the `config` alias of a bare dotfiles repo does.
+// An empty gitDir turns it off.
+func SetBareRepo(gitDir, workTree string) error {
+	if gitDir == "" {
+		bare.gitDir, bare.workTree = "", ""
+		return nil
+	}
+
+	dir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return err
+	}
+	tree, err := filepath.Abs(workTree)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("git directory %s doesn't exist", gitDir)
+	}
+
+	bare.gitDir, bare.workTree = dir, tree
+	return nil
+}
+
+// inBareRepo reports whether dir is inside the work tree of the bare repo.
+func inBareRepo(dir string) bool {
+	if bare.gitDir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(bare.workTree, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// command runs git in dir, on the bare repo when dir is in its work tree.
+func command(dir string, args ...string) *exec.Cmd {
+	args = append([]string{"-C", dir}, args...)
+	if inBareRepo(dir) {
+		args = append([]string{"--git-dir", bare.gitDir, "--work-tree", bare.workTree}, args...)
+	}
+	return exec.Command("git", args...)
+}
+
+// Submodules returns the paths of the submodules declared in the
+// .gitmodules file at the root of a repository.
+func Submodules(root string) ([]string, error) {
+	file, err := os.Open(filepath.Join(root, ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read .gitmodules: %w", err)
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && strings.TrimSpace(key) == "path" {
+			paths = append(paths, strings.TrimSpace(value))
+		}
+	}
+
+	return paths, scanner.Err()
+}
+
+// UninitializedSubmodule reports whether path lives inside a submodule that
+// hasn't been checked out, returning the superproject root and the
+// submodule's path relative to it.
+func UninitializedSubmodule(path string) (string, string, bool) {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err == nil {
+			submodules, _ := Submodules(dir)
+			for _, submodule := range submodules {
+				submoduleDir := filepath.Join(dir, submodule)
+				if path != submoduleDir && !strings.HasPrefix(path, submoduleDir+"/") {
+					continue
+				}
+
+				// Checked out submodules have a .git file pointing at the
+				// superproject's module directory
+				if _, err := os.Stat(filepath.Join(submoduleDir, ".git")); os.IsNotExist(err) {
+					return dir, submodule, true
+				}
+			}
+		}
+
+		if filepath.Dir(dir) == dir {
+			return "", "", false
+		}
+	}
+}
+
+func InitSubmodule(root, submodule string) error {
+	output, err := command(root, "submodule", "update", "--init", "--", submodule).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to initialize submodule %s: %w: %s", submodule, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// TrackedSymlinks returns the absolute paths of the files under dir that git
+// tracks as symlinks. Directories outside a git repository have none.
+func TrackedSymlinks(dir string) map[string]bool {
+	links := make(map[string]bool)
+
+	output, err := command(dir, "ls-files", "--stage", "-z").Output()
+	if err != nil {
+		return links
+	}
+
+	for _, entry := range strings.Split(string(output), "\x00") {
+		meta, path, ok := strings.Cut(entry, "\t")
+		if ok && strings.HasPrefix(meta, "120000 ") {
+			links[filepath.Join(dir, path)] = true
+		}
+	}
+
+	return links
+}
+
+// TrackedFiles returns the paths of the files under dir that git tracks,
+// relative to dir. It fails when dir isn't inside a git repository.
+func TrackedFiles(dir string) ([]string, error) {
+	output, err := command(dir, "ls-files", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files git tracks in %s: %w", dir, err)
+	}
+
+	var paths []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			paths = append(paths, filepath.FromSlash(path))
+		}
+	}
+	return paths, nil
+}
+
+// Root returns the top-level directory of the repository dir is in.
+func Root(dir string) (string, bool) {
+	output, err := command(dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
+
+// Dirty returns the absolute paths of the files with uncommitted changes in
+// the repository at root, untracked files included.
+func Dirty(root string) ([]string, error) {
+	output, err := command(root, "status", "--porcelain", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the status of %s: %w", root, err)
+	}
+
+	var paths []string
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		if len(entries[i]) < 4 {
+			continue
+		}
+		paths = append(paths, filepath.Join(root, entries[i][3:]))
+
+		// Renames are followed by the path they were renamed from
+		if entries[i][0] == 'R' || entries[i][0] == 'C' {
+			i++
+		}
+	}
+	return paths, nil
+}
+
+// Behind returns how many commits the repository at root is behind its
+// upstream as of the last fetch. Branches without an upstream are never
+// behind.
+func Behind(root string) (int, error) {
+	if err := command(root, "rev-parse", "--abbrev-ref", "@{upstream}").Run(); err != nil {
+		return 0, nil
+	}
+
+	output, err := command(root, "rev-list", "--count", "HEAD..@{upstream}").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to compare %s with its upstream: %w", root, err)
+	}
+
+	behind, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to compare %s with its upstream: %w", root, err)
+	}
+	return behind, nil
+}
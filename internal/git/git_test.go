@@ -0,0 +1,214 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmodules(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	paths, err := Submodules(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".gitmodules"), []byte(`[submodule "nvim"]
+	path = nvim
+	url = https://github.com/user/nvim.git
+[submodule "themes"]
+	path = config/themes
+	url = https://github.com/user/themes.git
+`), 0644))
+
+	paths, err = Submodules(tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nvim", "config/themes"}, paths)
+}
+
+func TestUninitializedSubmodule(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".gitmodules"), []byte(`[submodule "nvim"]
+	path = nvim
+	url = https://github.com/user/nvim.git
+[submodule "themes"]
+	path = themes
+	url = https://github.com/user/themes.git
+`), 0644))
+
+	// nvim is checked out, themes is still an empty directory
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "nvim", ".git"), []byte("gitdir: ../.git/modules/nvim"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "themes"), 0755))
+
+	_, _, ok := UninitializedSubmodule(filepath.Join(tmpDir, "nvim"))
+	assert.False(t, ok)
+
+	_, _, ok = UninitializedSubmodule(filepath.Join(tmpDir, "zsh"))
+	assert.False(t, ok)
+
+	root, submodule, ok := UninitializedSubmodule(filepath.Join(tmpDir, "themes"))
+	assert.True(t, ok)
+	assert.Equal(t, tmpDir, root)
+	assert.Equal(t, "themes", submodule)
+
+	// Package sources nested inside the submodule are detected too
+	root, submodule, ok = UninitializedSubmodule(filepath.Join(tmpDir, "themes", "dark"))
+	assert.True(t, ok)
+	assert.Equal(t, tmpDir, root)
+	assert.Equal(t, "themes", submodule)
+}
+
+func TestTrackedSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	assert.Empty(t, TrackedSymlinks(tmpDir))
+
+	require.NoError(t, exec.Command("git", "init", "-q", tmpDir).Run())
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "themes"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "themes", "dark.theme"), []byte("dark"), 0644))
+	require.NoError(t, os.Symlink("dark.theme", filepath.Join(tmpDir, "themes", "current.theme")))
+	require.NoError(t, exec.Command("git", "-C", tmpDir, "add", ".").Run())
+
+	themes := filepath.Join(tmpDir, "themes")
+	assert.Equal(t, map[string]bool{filepath.Join(themes, "current.theme"): true}, TrackedSymlinks(themes))
+}
+
+func TestTrackedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	_, err := TrackedFiles(tmpDir)
+	assert.Error(t, err)
+
+	require.NoError(t, exec.Command("git", "init", "-q", tmpDir).Run())
+	for _, file := range []string{"nvim/init.lua", "nvim/lua/plugins.lua", "nvim/scratch.lua", "zsh/.zshrc"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, file), []byte("content"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "-C", tmpDir, "add", "nvim/init.lua", "nvim/lua", "zsh").Run())
+
+	// Paths are relative to the directory asked about
+	paths, err := TrackedFiles(filepath.Join(tmpDir, "nvim"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"init.lua", filepath.Join("lua", "plugins.lua")}, paths)
+}
+
+func TestCleanliness(t *testing.T) {
+	tmpDir := t.TempDir()
+	upstream := filepath.Join(tmpDir, "upstream")
+	clone := filepath.Join(tmpDir, "clone")
+
+	run := func(dir string, args ...string) {
+		args = append([]string{"-C", dir, "-c", "user.name=farm", "-c", "user.email=farm@example.com"}, args...)
+		output, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	require.NoError(t, exec.Command("git", "init", "-q", upstream).Run())
+	require.NoError(t, os.WriteFile(filepath.Join(upstream, ".zshrc"), []byte("zsh"), 0644))
+	run(upstream, "add", ".")
+	run(upstream, "commit", "-q", "-m", "initial")
+	require.NoError(t, exec.Command("git", "clone", "-q", upstream, clone).Run())
+
+	root, ok := Root(filepath.Join(clone))
+	require.True(t, ok)
+	assert.Equal(t, clone, root)
+
+	dirty, err := Dirty(clone)
+	require.NoError(t, err)
+	assert.Empty(t, dirty)
+	behind, err := Behind(clone)
+	require.NoError(t, err)
+	assert.Zero(t, behind)
+
+	require.NoError(t, os.WriteFile(filepath.Join(clone, ".zshrc"), []byte("edited"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(clone, ".vimrc"), []byte("new"), 0644))
+	dirty, err = Dirty(clone)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(clone, ".zshrc"), filepath.Join(clone, ".vimrc")}, dirty)
+
+	// Commits upstream count once they're fetched
+	run(upstream, "commit", "-q", "--allow-empty", "-m", "second")
+	run(clone, "fetch", "-q")
+	behind, err = Behind(clone)
+	require.NoError(t, err)
+	assert.Equal(t, 1, behind)
+}
+
+func TestIgnore(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(tmpDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	write(".gitignore", "# build output\n*.log\n/dist\ncache/\n!keep.log\nnvim/**/*.bak\n")
+	write("nvim/.gitignore", "plugin/packer_compiled.lua\n")
+	for _, name := range []string{"a.log", "keep.log", "dist/app", "nvim/dist/app", "nvim/cache/x", "nvim/cache", "nvim/lua/a.bak", "nvim/plugin/packer_compiled.lua", "nvim/init.lua", "zsh/plugin/packer_compiled.lua"} {
+		if name != "nvim/cache" {
+			write(name, "content")
+		}
+	}
+
+	// Outside of a repository nothing is ignored
+	assert.False(t, NewIgnore().Ignored(filepath.Join(tmpDir, "a.log")))
+
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, ".git"), 0755))
+	ignore := NewIgnore()
+	ignored := func(name string) bool {
+		return ignore.Ignored(filepath.Join(tmpDir, name))
+	}
+
+	assert.True(t, ignored("a.log"))
+	assert.False(t, ignored("keep.log"))
+	assert.True(t, ignored("dist/app"))
+	// Anchored patterns only match relative to their .gitignore
+	assert.False(t, ignored("nvim/dist/app"))
+	assert.True(t, ignored("nvim/cache"))
+	assert.True(t, ignored("nvim/cache/x"))
+	assert.True(t, ignored("nvim/lua/a.bak"))
+	assert.True(t, ignored("nvim/plugin/packer_compiled.lua"))
+	assert.False(t, ignored("zsh/plugin/packer_compiled.lua"))
+	assert.False(t, ignored("nvim/init.lua"))
+}
+
+func TestBareRepo(t *testing.T) {
+	tmpDir := t.TempDir()
+	gitDir := filepath.Join(tmpDir, ".cfg")
+	workTree := filepath.Join(tmpDir, "home")
+
+	assert.ErrorContains(t, SetBareRepo(gitDir, workTree), "doesn't exist")
+
+	require.NoError(t, exec.Command("git", "init", "-q", "--bare", gitDir).Run())
+	for _, file := range []string{".zshrc", ".config/nvim/init.lua", ".config/nvim/scratch.lua"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(workTree, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(workTree, file), []byte("content"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "--git-dir", gitDir, "--work-tree", workTree, "add", ".zshrc", ".config/nvim/init.lua").Run())
+
+	// Without the bare repo the work tree isn't a repository
+	_, ok := Root(workTree)
+	assert.False(t, ok)
+
+	require.NoError(t, SetBareRepo(gitDir, workTree))
+	defer SetBareRepo("", "")
+
+	nvim := filepath.Join(workTree, ".config", "nvim")
+	root, ok := Root(nvim)
+	require.True(t, ok)
+	assert.Equal(t, workTree, root)
+
+	paths, err := TrackedFiles(nvim)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"init.lua"}, paths)
+
+	// Paths outside the work tree are left to their own repository
+	_, ok = Root(tmpDir)
+	assert.False(t, ok)
+}
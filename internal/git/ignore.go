@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern of a .gitignore file.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Ignore matches paths against the .gitignore files of the repository they
+// live in. Every .gitignore file and repository root it looks up is cached,
+// so one Ignore should be reused for the paths of a run.
+type Ignore struct {
+	rules map[string][]ignoreRule
+	roots map[string]string
+}
+
+func NewIgnore() *Ignore {
+	return &Ignore{
+		rules: make(map[string][]ignoreRule),
+		roots: make(map[string]string),
+	}
+}
+
+// Ignored reports whether path is excluded by the .gitignore files found
+// walking up from it to the root of its repository. As with git, nothing
+// inside an ignored directory can be included again by a negated pattern.
+// Paths outside of a repository are never ignored.
+func (g *Ignore) Ignored(path string) bool {
+	root, ok := g.root(filepath.Dir(path))
+	if !ok {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	// Check every directory on the way down, so the files of an ignored
+	// directory are ignored too
+	current := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+
+		isDir := i < len(parts)-1
+		if !isDir {
+			info, err := os.Stat(current)
+			isDir = err == nil && info.IsDir()
+		}
+		if g.matches(root, current, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches applies the .gitignore files from root down to the directory of
+// path, where the last pattern that matches decides.
+func (g *Ignore) matches(root, path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, rule := range g.load(dirs[i]) {
+			if rule.matches(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// root returns the root of the repository dir lives in, the closest
+// directory holding a .git directory or file, or the work tree of the bare
+// repo.
+func (g *Ignore) root(dir string) (string, bool) {
+	if root, ok := g.roots[dir]; ok {
+		return root, root != ""
+	}
+
+	root := ""
+	if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+		root = dir
+	} else if bare.gitDir != "" && dir == bare.workTree {
+		root = dir
+	} else if parent := filepath.Dir(dir); parent != dir {
+		root, _ = g.root(parent)
+	}
+
+	g.roots[dir] = root
+	return root, root != ""
+}
+
+// load returns the rules of the .gitignore file in dir, which has none when
+// the file doesn't exist.
+func (g *Ignore) load(dir string) []ignoreRule {
+	if rules, ok := g.rules[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	if file, err := os.Open(filepath.Join(dir, ".gitignore")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+				rules = append(rules, rule)
+			}
+		}
+		file.Close()
+	}
+
+	g.rules[dir] = rules
+	return rules
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// Escapes a leading # or !
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns with a slash anywhere but the end are relative to the
+	// directory of the .gitignore file, the rest match at any depth
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.pattern = line
+	return rule, true
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if !r.anchored {
+		matched, _ := path.Match(r.pattern, path.Base(rel))
+		return matched
+	}
+
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches a pattern against a path one component at a time,
+// where ** matches any number of components.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
@@ -0,0 +1,83 @@
+// Package home decides whose home directory ~ refers to. Runs through sudo
+// act on behalf of the user who invoked sudo rather than on root, so links
+// land in their home and belong to them.
+package home
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+)
+
+// User is the user farm links for when it differs from the one running it.
+type User struct {
+	Name string
+	Dir  string
+	UID  int
+	GID  int
+}
+
+var current *User
+
+// Lookup finds a user by name.
+func Lookup(name string) (*User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", name, err)
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non-numeric uid %s", name, u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non-numeric gid %s", name, u.Gid)
+	}
+
+	return &User{Name: u.Username, Dir: u.HomeDir, UID: uid, GID: gid}, nil
+}
+
+// Detect returns the user to link for: the named one when a name is given,
+// otherwise the user who invoked sudo when running as root. It returns nil
+// when farm links for the user running it.
+func Detect(name string) (*User, error) {
+	if name != "" {
+		return Lookup(name)
+	}
+
+	sudoUser := os.Getenv("SUDO_USER")
+	if os.Geteuid() != 0 || sudoUser == "" || sudoUser == "root" {
+		return nil, nil
+	}
+	return Lookup(sudoUser)
+}
+
+// Set makes u the user farm links for, or the user running it when u is nil.
+func Set(u *User) {
+	current = u
+}
+
+// Dir returns the home directory ~ expands to.
+func Dir() (string, error) {
+	if current != nil {
+		return current.Dir, nil
+	}
+	return os.UserHomeDir()
+}
+
+// Chown hands path over to the user farm links for, so entries created while
+// running as root don't end up owned by root. Symlinks themselves are
+// changed rather than what they point at.
+func Chown(path string) error {
+	if current == nil || current.UID == os.Geteuid() || runtime.GOOS == "windows" {
+		return nil
+	}
+
+	if err := os.Lchown(path, current.UID, current.GID); err != nil {
+		return fmt.Errorf("failed to change owner of %s to %s: %w", path, current.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package home
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	defer Set(nil)
+
+	expected, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	dir, err := Dir()
+	require.NoError(t, err)
+	assert.Equal(t, expected, dir)
+
+	Set(&User{Name: "alice", Dir: "/home/alice"})
+	dir, err = Dir()
+	require.NoError(t, err)
+	assert.Equal(t, "/home/alice", dir)
+}
+
+func TestDetect(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+
+	u, err := Detect("")
+	require.NoError(t, err)
+	assert.Nil(t, u)
+
+	_, err = Detect("farm-no-such-user")
+	assert.Error(t, err)
+
+	if os.Geteuid() != 0 {
+		t.Skip("SUDO_USER is only honored when running as root")
+	}
+
+	t.Setenv("SUDO_USER", "root")
+	u, err = Detect("")
+	require.NoError(t, err)
+	assert.Nil(t, u)
+
+	t.Setenv("SUDO_USER", "nobody")
+	u, err = Detect("")
+	if err != nil {
+		t.Skip("no nobody user to detect")
+	}
+	assert.Equal(t, "nobody", u.Name)
+	assert.NotZero(t, u.UID)
+}
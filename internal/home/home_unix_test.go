@@ -0,0 +1,46 @@
+//go:build linux || darwin
+
+package home
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChown(t *testing.T) {
+	defer Set(nil)
+
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+
+	// Nothing changes when linking for the user running farm
+	require.NoError(t, Chown(path))
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+
+	link := path + ".link"
+	require.NoError(t, os.Symlink(path, link))
+
+	Set(&User{Name: "nobody", UID: 65534, GID: 65534})
+	require.NoError(t, Chown(link))
+
+	info, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.Equal(t, 65534, uid(info))
+
+	// The symlink changes owner, not the file it points at
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, 0, uid(info))
+}
+
+func uid(info os.FileInfo) int {
+	return int(info.Sys().(*syscall.Stat_t).Uid)
+}
@@ -0,0 +1,120 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PreLink  = "pre_link"
+	PostLink = "post_link"
+	Verify   = "verify"
+)
+
+// killGrace is how long a hook that timed out has to exit after being asked
+// to stop before it is killed, swapped out by tests.
+var killGrace = 5 * time.Second
+
+// Options limits how a package's hooks run.
+type Options struct {
+	// Timeout stops a hook that runs longer, along with everything it
+	// started. Zero means no limit.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Dir is the directory hooks run in instead of the package's source
+	Dir string `yaml:"dir,omitempty"`
+	// CleanEnv keeps hooks from inheriting farm's environment, except for
+	// the variables listed in PassEnv
+	CleanEnv bool     `yaml:"clean_env,omitempty"`
+	PassEnv  []string `yaml:"pass_env,omitempty"`
+}
+
+// environ returns the environment a hook inherits from farm.
+func (o Options) environ() []string {
+	if !o.CleanEnv {
+		return os.Environ()
+	}
+
+	var env []string
+	for _, name := range o.PassEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// Context describes the run a hook is executed for. It is exported to the
+// hook's environment so scripts can act conditionally.
+type Context struct {
+	Package    string
+	Source     string
+	Targets    []string
+	Changed    bool
+	DryRun     bool
+	ResultPath string
+	// Sandbox is the throwaway directory `farm test` linked into, which is
+	// also used as the hook's home directory
+	Sandbox string
+	// Options are the package's limits on its hooks, which aren't exported
+	Options Options
+}
+
+func (c Context) Env() []string {
+	env := []string{
+		"FARM_PACKAGE=" + c.Package,
+		"FARM_SOURCE=" + c.Source,
+		"FARM_TARGETS=" + strings.Join(c.Targets, string(os.PathListSeparator)),
+		"FARM_CHANGED=" + strconv.FormatBool(c.Changed),
+		"FARM_DRY_RUN=" + strconv.FormatBool(c.DryRun),
+	}
+
+	if c.ResultPath != "" {
+		env = append(env, "FARM_RESULT="+c.ResultPath)
+	}
+
+	if c.Sandbox != "" {
+		env = append(env, "FARM_SANDBOX="+c.Sandbox, "HOME="+c.Sandbox)
+	}
+
+	return env
+}
+
+// Run executes a hook command with the shell from the package's source
+// directory, or the directory its options name. A hook that outlives its
+// timeout is stopped along with the processes it started.
+func Run(command string, ctx Context, stdout, stderr io.Writer) error {
+	parent := context.Background()
+	if ctx.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		parent, cancel = context.WithTimeout(parent, ctx.Options.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(parent, "sh", "-c", command)
+	cmd.Dir = ctx.Source
+	if ctx.Options.Dir != "" {
+		cmd.Dir = ctx.Options.Dir
+	}
+	cmd.Env = append(ctx.Options.environ(), ctx.Env()...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if ctx.Options.Timeout > 0 {
+		killGroup(cmd)
+	}
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(parent.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("hook %q for package %s timed out after %s", command, ctx.Package, ctx.Options.Timeout)
+		}
+		return fmt.Errorf("hook %q for package %s failed: %w", command, ctx.Package, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	source := t.TempDir()
+
+	ctx := Context{
+		Package:    "nvim",
+		Source:     source,
+		Targets:    []string{"/home/user/.config", "/root/.config"},
+		Changed:    true,
+		ResultPath: "/tmp/result.json",
+	}
+
+	stdout := new(bytes.Buffer)
+	err := Run(`pwd; echo "$FARM_PACKAGE $FARM_TARGETS $FARM_CHANGED $FARM_DRY_RUN $FARM_RESULT"`, ctx, stdout, os.Stderr)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, source, lines[0])
+	assert.Equal(t, "nvim /home/user/.config:/root/.config true false /tmp/result.json", lines[1])
+
+	err = Run("exit 3", ctx, stdout, os.Stderr)
+	assert.ErrorContains(t, err, `hook "exit 3" for package nvim failed`)
+}
+
+func TestEnvOmitsEmptyResult(t *testing.T) {
+	for _, v := range (Context{Package: "nvim"}).Env() {
+		assert.False(t, strings.HasPrefix(v, "FARM_RESULT="))
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	source := t.TempDir()
+	dir := t.TempDir()
+	t.Setenv("FARM_TEST_SECRET", "secret")
+	t.Setenv("FARM_TEST_PASSED", "passed")
+
+	ctx := Context{
+		Package: "nvim",
+		Source:  source,
+		Options: Options{Dir: dir, CleanEnv: true, PassEnv: []string{"PATH", "FARM_TEST_PASSED"}},
+	}
+
+	stdout := new(bytes.Buffer)
+	err := Run(`pwd; echo "$FARM_PACKAGE:$FARM_TEST_SECRET:$FARM_TEST_PASSED"`, ctx, stdout, os.Stderr)
+	require.NoError(t, err)
+	assert.Equal(t, dir+"\nnvim::passed\n", stdout.String())
+
+	defer func(grace time.Duration) { killGrace = grace }(killGrace)
+	killGrace = 50 * time.Millisecond
+
+	// Hooks that hang are stopped, along with what they started
+	ctx.Options = Options{Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err = Run("sleep 10 & wait", ctx, stdout, os.Stderr)
+	assert.ErrorContains(t, err, `hook "sleep 10 & wait" for package nvim timed out after 50ms`)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// Even when they ignore being asked to stop
+	start = time.Now()
+	err = Run("trap '' TERM; sleep 10", ctx, stdout, os.Stderr)
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package hooks
+
+import "os/exec"
+
+// killGroup leaves stopping the hook to the default of killing the shell,
+// since process groups aren't available on this platform.
+func killGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 2 * killGrace
+}
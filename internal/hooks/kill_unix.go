@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package hooks
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killGroup runs the hook in its own process group, so stopping it when it
+// times out reaches everything it started. The group is asked to terminate
+// first and killed if it's still around after the grace period.
+func killGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		group := -cmd.Process.Pid
+		time.AfterFunc(killGrace, func() {
+			_ = syscall.Kill(group, syscall.SIGKILL)
+		})
+		return syscall.Kill(group, syscall.SIGTERM)
+	}
+	// Processes left holding the output open don't keep farm waiting
+	cmd.WaitDelay = 2 * killGrace
+}
@@ -0,0 +1,59 @@
+package i18n
+
+var de = map[string]string{
+	"No packages found for environment '%s'":       "Keine Pakete für die Umgebung '%s' gefunden",
+	"Available environments: %v":                   "Verfügbare Umgebungen: %v",
+	"No packages configured in %s":                 "Keine Pakete in %s konfiguriert",
+	"Run with --clean-all to clean up dead links":  "Mit --clean-all werden tote Links trotzdem entfernt",
+	" for environment '%s'":                        " für die Umgebung '%s'",
+	" (from FARM_ENV)":                             " (aus FARM_ENV)",
+	" (from the command line)":                     " (von der Kommandozeile)",
+	"Linked %d files, removed %d dead links%s":     "%d Dateien verlinkt, %d tote Links entfernt%s",
+	"Removed %d symlinks%s":                        "%d Symlinks entfernt%s",
+	"Will remove empty directories:":               "Folgende leere Verzeichnisse werden entfernt:",
+	"Removed empty directories:":                   "Entfernte leere Verzeichnisse:",
+	"Will leave empty directories:":                "Folgende Verzeichnisse bleiben leer zurück:",
+	"Left empty directories:":                      "Leer zurückgebliebene Verzeichnisse:",
+	"Run with --remove-empty-dirs to remove them":  "Mit --remove-empty-dirs werden sie entfernt",
+	"Will remove symlinks:":                        "Folgende Symlinks werden entfernt:",
+	"Removed symlinks:":                            "Entfernte Symlinks:",
+	"No symlinks tracked%s":                        "Keine Symlinks erfasst%s",
+	"Tracking %d symlinks%s":                       "%d Symlinks erfasst%s",
+	"(%d ok)":                                      "(%d in Ordnung)",
+	"(%d ok, %d dead)":                             "(%d in Ordnung, %d tot)",
+	"(unconfigured)":                               "(nicht konfiguriert)",
+	"Found %d dead symlinks:":                      "%d tote Symlinks gefunden:",
+	"Found %d links replaced by files:":            "%d durch Dateien ersetzte Links gefunden:",
+	"directory":                                    "Verzeichnis",
+	"same as source":                               "wie die Quelle",
+	"modified":                                     "verändert",
+	"Run '%s' to adopt them back or relink them":   "Führe '%s' aus, um sie zurückzuholen oder neu zu verlinken",
+	"Found %d conflicting claims:":                 "%d widersprüchliche Einträge gefunden:",
+	"Run 'farm link%s' to clean up dead symlinks":  "Führe 'farm link%s' aus, um tote Symlinks zu entfernen",
+	"Run 'farm clean%s' to clean up dead symlinks": "Führe 'farm clean%s' aus, um tote Symlinks zu entfernen",
+	"Removed %d dead links":                        "%d tote Links entfernt",
+	"%d packages changed since they were linked:":  "%d Pakete wurden seit dem Verlinken geändert:",
+	"changed %s, linked %s":                        "geändert %s, verlinkt %s",
+	"Run 'farm link%s' to apply the changes":       "Führe 'farm link%s' aus, um die Änderungen anzuwenden",
+	"Config changed since linking (%d packages):":  "Konfiguration seit dem Verlinken geändert (%d Pakete):",
+	"Changes from config edits:":                   "Änderungen durch die Konfiguration:",
+	"Changes from filesystem drift:":               "Änderungen durch das Dateisystem:",
+	"changed":                                      "geändert",
+	"added":                                        "hinzugefügt",
+	"removed":                                      "entfernt",
+	"Will create symlinks:":                        "Folgende Symlinks werden erstellt:",
+	"Created symlinks:":                            "Erstellte Symlinks:",
+	"Will create directories:":                     "Folgende Verzeichnisse werden erstellt:",
+	"Created directories:":                         "Erstellte Verzeichnisse:",
+	"Skipped optional targets:":                    "Übersprungene optionale Ziele:",
+	"Will replace symlinks:":                       "Folgende Symlinks werden ersetzt:",
+	"Replaced symlinks:":                           "Ersetzte Symlinks:",
+	"Will remove dead symlinks:":                   "Folgende tote Symlinks werden entfernt:",
+	"Will change file content:":                    "Folgende Dateiinhalte werden geändert:",
+	"Ignored paths:":                               "Ignorierte Pfade:",
+	"Removed dead symlinks:":                       "Entfernte tote Symlinks:",
+	"Unused patterns:":                             "Ungenutzte Muster:",
+	"Next steps:":                                  "Nächste Schritte:",
+	"Errors:":                                      "Fehler:",
+	"Persistent filesystem errors:":                "Dauerhafte Dateisystemfehler:",
+}
@@ -0,0 +1,95 @@
+// Package i18n translates farm's output. Messages are looked up by their
+// English format string, so anything missing from a catalog is printed in
+// English. Only the output of link, unlink, clean, and status goes through
+// it so far.
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const DefaultLanguage = "en"
+
+var catalogs = map[string]map[string]string{
+	DefaultLanguage: {},
+	"de":            de,
+}
+
+var current = catalogs[DefaultLanguage]
+
+// Languages returns the languages with a catalog.
+func Languages() []string {
+	languages := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// Detect picks the language to use, preferring lang and falling back to the
+// LC_ALL, LC_MESSAGES, and LANG environment variables. Locales from the
+// environment without a catalog resolve to English, while an unsupported lang
+// is returned as is so that SetLanguage reports it.
+func Detect(lang string) string {
+	if lang != "" {
+		if match, ok := match(lang); ok {
+			return match
+		}
+		return lang
+	}
+
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			if match, ok := match(value); ok {
+				return match
+			}
+			break
+		}
+	}
+
+	return DefaultLanguage
+}
+
+// match finds the catalog for a locale such as de_DE.UTF-8, trying the full
+// locale before the bare language.
+func match(locale string) (string, bool) {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	locale = strings.ReplaceAll(locale, "-", "_")
+	if _, ok := catalogs[locale]; ok {
+		return locale, true
+	}
+
+	lang, _, _ := strings.Cut(locale, "_")
+	lang = strings.ToLower(lang)
+	if _, ok := catalogs[lang]; ok {
+		return lang, true
+	}
+
+	return "", false
+}
+
+// SetLanguage selects the catalog used by Sprintf.
+func SetLanguage(lang string) error {
+	catalog, ok := catalogs[lang]
+	if !ok {
+		return fmt.Errorf("unsupported language %s (available: %s)", lang, strings.Join(Languages(), ", "))
+	}
+
+	current = catalog
+	return nil
+}
+
+// Sprintf formats the translation of format in the current language.
+func Sprintf(format string, args ...any) string {
+	if translated, ok := current[format]; ok {
+		format = translated
+	}
+
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,96 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		lang     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "flag",
+			lang:     "de",
+			expected: "de",
+		},
+		{
+			name:     "flag with region",
+			lang:     "de-AT",
+			expected: "de",
+		},
+		{
+			name:     "unsupported flag",
+			lang:     "xx",
+			expected: "xx",
+		},
+		{
+			name:     "LANG",
+			env:      map[string]string{"LANG": "de_DE.UTF-8"},
+			expected: "de",
+		},
+		{
+			name:     "LC_ALL overrides LANG",
+			env:      map[string]string{"LC_ALL": "C", "LANG": "de_DE.UTF-8"},
+			expected: "en",
+		},
+		{
+			name:     "LC_MESSAGES overrides LANG",
+			env:      map[string]string{"LC_MESSAGES": "de_CH@euro", "LANG": "en_US.UTF-8"},
+			expected: "de",
+		},
+		{
+			name:     "unsupported locale",
+			env:      map[string]string{"LANG": "fr_FR.UTF-8"},
+			expected: "en",
+		},
+		{
+			name:     "flag overrides environment",
+			lang:     "en",
+			env:      map[string]string{"LANG": "de_DE.UTF-8"},
+			expected: "en",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			assert.Equal(t, tt.expected, Detect(tt.lang))
+		})
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	defer SetLanguage(DefaultLanguage)
+
+	require.NoError(t, SetLanguage("de"))
+	assert.Equal(t, "3 Symlinks erfasst", Sprintf("Tracking %d symlinks%s", 3, ""))
+	// Messages without a translation are printed in English
+	assert.Equal(t, "Fetching x", Sprintf("Fetching %s", "x"))
+
+	require.NoError(t, SetLanguage(DefaultLanguage))
+	assert.Equal(t, "Tracking 3 symlinks", Sprintf("Tracking %d symlinks%s", 3, ""))
+
+	err := SetLanguage("xx")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported language xx (available: de, en)")
+}
+
+func TestCatalogsKeepVerbs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+
+	for lang, catalog := range catalogs {
+		for format, translated := range catalog {
+			assert.Equal(t, verbs.FindAllString(format, -1), verbs.FindAllString(translated, -1), "%s: %q", lang, format)
+		}
+	}
+}
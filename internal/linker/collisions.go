@@ -0,0 +1,69 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/fsutil"
+)
+
+// caseInsensitive is swapped out by tests, which can't rely on the filesystem
+// they run on ignoring case.
+var caseInsensitive = fsutil.CaseInsensitive
+
+// claim is a target linked during this run, along with its source.
+type claim struct {
+	target string
+	source string
+}
+
+// CollisionError is reported for a target that differs from another target
+// linked in the same run only in case, on a filesystem that ignores case,
+// where both would end up at the same path.
+type CollisionError struct {
+	Target      string
+	Source      string
+	Other       string
+	OtherSource string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("target %s collides with %s (linked from %s) on this case-insensitive filesystem", e.Target, e.Other, e.OtherSource)
+}
+
+// claimTarget records that source is placed at target, failing when an
+// earlier target of the run only differs from it in case and the filesystem
+// would treat them as the same path.
+func (l *Linker) claimTarget(source, target string) error {
+	if !l.ignoresCase(filepath.Dir(target)) {
+		return nil
+	}
+
+	key := strings.ToLower(target)
+	if other, ok := l.claims[key]; ok && other.target != target {
+		return &CollisionError{Target: target, Source: source, Other: other.target, OtherSource: other.source}
+	}
+
+	l.claims[key] = claim{target: target, source: source}
+	return nil
+}
+
+// ignoresCase reports whether dir lives on a case-insensitive filesystem,
+// probing the closest directory that exists once and caching the answer.
+func (l *Linker) ignoresCase(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	insensitive, ok := l.caseFolding[dir]
+	if !ok {
+		insensitive = caseInsensitive(dir)
+		l.caseFolding[dir] = insensitive
+	}
+	return insensitive
+}
@@ -0,0 +1,104 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+)
+
+// ensureDirectories creates the package's declared directories and stops
+// tracking directories that were removed from its config. Relative
+// directories are only created in the given targets.
+func (l *Linker) ensureDirectories(pkg *config.Package, targets []string, result *LinkResult) {
+	declared := make(map[string]bool)
+
+	for _, dir := range pkg.Directories {
+		mode := os.FileMode(dir.Mode)
+		if mode == 0 {
+			mode = 0755
+		}
+
+		for _, path := range dir.Paths(targets) {
+			declared[path] = true
+			if err := l.ensureDirectory(path, mode, dir.Mode != 0, pkg, result); err != nil {
+				result.Errors = append(result.Errors, err)
+			}
+		}
+	}
+
+	var stale []string
+	for _, link := range l.lockFile.Symlinks {
+		if link.Kind == lockfile.KindDirectory && link.Source == pkg.Source && !declared[link.Target] {
+			stale = append(stale, link.Target)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, path := range stale {
+		l.removeDirectory(path, result)
+	}
+}
+
+func (l *Linker) ensureDirectory(path string, mode os.FileMode, explicitMode bool, pkg *config.Package, result *LinkResult) error {
+	info, err := l.lstat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("directory %s already exists and is not a directory", path)
+		}
+
+		if explicitMode && info.Mode().Perm() != mode && !l.dryRun {
+			if err := os.Chmod(path, mode); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", path, err)
+			}
+		}
+
+		l.lockFile.AddDirectory(path, pkg.Source)
+		return l.chown(pkg, path, result)
+	}
+
+	if !l.dryRun {
+		if err := l.mkdirAll(path, mode); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", path, err)
+		}
+
+		// MkdirAll is subject to the umask
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", path, err)
+		}
+	}
+	if err := l.chown(pkg, path, result); err != nil {
+		return err
+	}
+
+	l.lockFile.AddDirectory(path, pkg.Source)
+	result.Created = append(result.Created, path)
+	l.record(result, plan.Action{Kind: plan.Mkdir, Source: pkg.Source, Target: path, Reason: "declared directory"})
+
+	return nil
+}
+
+// removeDirectory stops tracking a directory and removes it if it is empty.
+// Directories that still have contents are left in place.
+func (l *Linker) removeDirectory(path string, result *LinkResult) {
+	source := l.lockFile.Symlinks[path].Source
+	l.lockFile.RemoveSymlink(path)
+
+	entries, err := os.ReadDir(path)
+	if err != nil || len(entries) > 0 {
+		return
+	}
+
+	if !l.dryRun {
+		if err := l.remove(path); err != nil && !os.IsNotExist(err) {
+			result.Errors = append(result.Errors, fmt.Errorf("failed to remove directory %s: %w", path, err))
+			return
+		}
+	}
+
+	result.Removed = append(result.Removed, path)
+	l.record(result, plan.Action{Kind: plan.Remove, Source: source, Target: path, Reason: "managed directory is empty"})
+}
@@ -0,0 +1,95 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/download"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+)
+
+// ensureDownloads fetches the package's downloads for the current platform
+// and removes downloads that were dropped from its config.
+func (l *Linker) ensureDownloads(pkg *config.Package, result *LinkResult) {
+	declared := make(map[string]bool)
+
+	for _, d := range pkg.Downloads {
+		variant, ok := d.Resolve(runtime.GOOS, runtime.GOARCH)
+		if !ok {
+			continue
+		}
+
+		path := d.Path()
+		declared[path] = true
+		if err := l.ensureDownload(path, variant, pkg, result); err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+
+	for _, link := range l.lockFile.Symlinks.Sorted() {
+		if link.Kind != lockfile.KindDownload || link.Source != pkg.Source || declared[link.Target] {
+			continue
+		}
+
+		if !l.dryRun {
+			if err := l.remove(link.Target); err != nil && !os.IsNotExist(err) {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to remove download %s: %w", link.Target, err))
+				continue
+			}
+		}
+		l.lockFile.RemoveSymlink(link.Target)
+		result.Removed = append(result.Removed, link.Target)
+		l.record(result, plan.Action{Kind: plan.Remove, Source: link.Source, Target: link.Target, Reason: "download is no longer declared"})
+	}
+}
+
+func (l *Linker) ensureDownload(path string, variant config.Variant, pkg *config.Package, result *LinkResult) error {
+	// Downloads are only fetched again when their checksum changes, or when
+	// refreshing with `farm update-assets`
+	if tracked, ok := l.lockFile.Symlinks[path]; ok && tracked.SourceChecksum == variant.SHA256 && !l.refreshDownloads {
+		if _, err := l.lstat(path); err == nil {
+			return nil
+		}
+	}
+
+	// Dry runs report the download without fetching it
+	var content []byte
+	if !l.dryRun {
+		var err error
+		content, err = download.Fetch(variant.URL, variant.SHA256, variant.Extract)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := l.writeFile(pkg.Source, path, content, 0755, lockfile.KindDownload, result); err != nil {
+		return err
+	}
+	l.lockFile.SetSourceChecksum(path, variant.SHA256)
+
+	return nil
+}
+
+// UpdateDownloads fetches every download of the configured packages again,
+// replacing files whose content changed.
+func (l *Linker) UpdateDownloads() (*LinkResult, error) {
+	result := &LinkResult{
+		Created:  []string{},
+		Removed:  []string{},
+		Replaced: []string{},
+		Errors:   []error{},
+		Changed:  make(map[string]bool),
+	}
+
+	l.refreshDownloads = true
+	defer func() { l.refreshDownloads = false }()
+
+	for _, pkg := range l.config.Packages {
+		l.ensureDownloads(pkg, result)
+	}
+
+	return result, nil
+}
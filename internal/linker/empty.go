@@ -0,0 +1,96 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/home"
+	"github.com/mskelton/farm/internal/plan"
+)
+
+// emptyDirs returns the directories that removing the given paths leaves
+// with nothing in them, deepest first. Package targets and the home
+// directory are never reported, even when they end up empty.
+func (l *Linker) emptyDirs(removed []string) []string {
+	keep := make(map[string]bool)
+	for _, pkg := range l.config.Packages {
+		for _, target := range pkg.Targets {
+			keep[target] = true
+		}
+	}
+	if homeDir, err := home.Dir(); err == nil {
+		keep[homeDir] = true
+	}
+
+	gone := make(map[string]bool, len(removed))
+	candidates := make(map[string]bool)
+	for _, path := range removed {
+		gone[path] = true
+		candidates[filepath.Dir(path)] = true
+	}
+
+	var empty []string
+	for len(candidates) > 0 {
+		// Children are settled before their parents by always taking the
+		// deepest candidate
+		var dir string
+		for candidate := range candidates {
+			if dir == "" || depth(candidate) > depth(dir) || (depth(candidate) == depth(dir) && candidate < dir) {
+				dir = candidate
+			}
+		}
+		delete(candidates, dir)
+
+		if gone[dir] || keep[dir] || filepath.Dir(dir) == dir {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		leftover := false
+		for _, entry := range entries {
+			if !gone[filepath.Join(dir, entry.Name())] {
+				leftover = true
+				break
+			}
+		}
+		if leftover {
+			continue
+		}
+
+		gone[dir] = true
+		empty = append(empty, dir)
+		candidates[filepath.Dir(dir)] = true
+	}
+
+	return empty
+}
+
+func depth(path string) int {
+	return strings.Count(path, string(filepath.Separator))
+}
+
+// removeEmptyDirs reports the directories unlinking leaves empty, and
+// removes them when remove_empty_dirs is set.
+func (l *Linker) removeEmptyDirs(result *LinkResult) {
+	for _, dir := range l.emptyDirs(result.Removed) {
+		if !l.config.Settings.RemoveEmptyDirs {
+			result.EmptyDirs = append(result.EmptyDirs, dir)
+			continue
+		}
+
+		if !l.dryRun {
+			if err := l.remove(dir); err != nil && !os.IsNotExist(err) {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to remove empty directory %s: %w", dir, err))
+				continue
+			}
+		}
+		result.EmptyDirs = append(result.EmptyDirs, dir)
+		l.record(result, plan.Action{Kind: plan.Remove, Target: dir, Reason: "left empty"})
+	}
+}
@@ -0,0 +1,58 @@
+package linker
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/lockfile"
+)
+
+var fontExtensions = map[string]bool{
+	".otf":   true,
+	".ttc":   true,
+	".ttf":   true,
+	".woff":  true,
+	".woff2": true,
+}
+
+func isFont(path string) bool {
+	return fontExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// linkFonts copies the font files in a font package into the target. Fonts
+// are copied rather than linked since font managers don't reliably follow
+// symlinks.
+func (l *Linker) linkFonts(pkg *config.Package, target string, result *LinkResult) error {
+	return filepath.WalkDir(pkg.Source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read font %s: %w", path, err)
+		}
+
+		relativePath, err := filepath.Rel(pkg.Source, path)
+		if err != nil || relativePath == "." {
+			return err
+		}
+
+		if l.shouldIgnore(pkg, relativePath) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() || !isFont(path) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read font %s: %w", path, err)
+		}
+
+		return l.writeFile(path, filepath.Join(target, relativePath), content, 0644, lockfile.KindFont, result)
+	})
+}
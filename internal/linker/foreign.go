@@ -0,0 +1,54 @@
+package linker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/home"
+)
+
+// foreignDirs are where other dotfile managers keep their files by default,
+// relative to the home directory.
+var foreignDirs = []struct {
+	manager string
+	dir     string
+}{
+	{"chezmoi", ".local/share/chezmoi"},
+	{"yadm", ".local/share/yadm"},
+	{"homeshick", ".homesick/repos"},
+	{"dotbot", ".dotbot"},
+}
+
+// foreignManager returns the dotfile manager whose directory dest lies in,
+// along with that directory. Stow directories are recognized by $STOW_DIR,
+// their .stow marker, or the .stow-local-ignore of a package in them.
+// Anything inside a package farm links from is its own.
+func (l *Linker) foreignManager(dest string) (string, string, bool) {
+	if l.config.FindPackage(dest) != nil {
+		return "", "", false
+	}
+
+	if homeDir, err := home.Dir(); err == nil {
+		for _, foreign := range foreignDirs {
+			dir := filepath.Join(homeDir, foreign.dir)
+			if dest == dir || strings.HasPrefix(dest, dir+"/") {
+				return foreign.manager, dir, true
+			}
+		}
+	}
+
+	if dir := filepath.Clean(os.Getenv("STOW_DIR")); dir != "." && strings.HasPrefix(dest, dir+"/") {
+		return "stow", dir, true
+	}
+	for dir := filepath.Dir(dest); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".stow")); err == nil {
+			return "stow", dir, true
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".stow-local-ignore")); err == nil {
+			return "stow", filepath.Dir(dir), true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,78 @@
+package linker
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/home"
+)
+
+// Filesystem operations are retried according to the configured policy so
+// transient errors on network home directories don't abort a run.
+
+func (l *Linker) lstat(path string) (os.FileInfo, error) {
+	for dir := filepath.Dir(path); len(l.unfolded) > 0 && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if l.unfolded[dir] {
+			return nil, &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+		}
+	}
+
+	var info os.FileInfo
+	err := l.retry.Do(func() error {
+		var err error
+		info, err = os.Lstat(path)
+		return err
+	})
+	return info, err
+}
+
+func (l *Linker) remove(path string) error {
+	return l.retry.Do(func() error {
+		return os.Remove(path)
+	})
+}
+
+func (l *Linker) symlink(oldname, newname string) error {
+	err := l.retry.Do(func() error {
+		return os.Symlink(oldname, newname)
+	})
+	if err != nil {
+		return err
+	}
+	return home.Chown(newname)
+}
+
+func (l *Linker) mkdirAll(path string, perm os.FileMode) error {
+	// Find the directories that don't exist yet, which are the ones to chown
+	var created []string
+	for dir := path; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		created = append(created, dir)
+	}
+
+	err := l.retry.Do(func() error {
+		return os.MkdirAll(path, perm)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range created {
+		if err := home.Chown(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Linker) writeFileContent(path string, content []byte, perm os.FileMode) error {
+	err := l.retry.Do(func() error {
+		return os.WriteFile(path, content, perm)
+	})
+	if err != nil {
+		return err
+	}
+	return home.Chown(path)
+}
@@ -0,0 +1,64 @@
+package linker
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+)
+
+// commentSyntax maps file extensions, or whole names for dotfiles without
+// one, to how a line comment is opened and closed in them.
+var commentSyntax = map[string][2]string{
+	".sh": {"#", ""}, ".bash": {"#", ""}, ".zsh": {"#", ""}, ".fish": {"#", ""},
+	".py": {"#", ""}, ".rb": {"#", ""}, ".pl": {"#", ""}, ".yaml": {"#", ""},
+	".yml": {"#", ""}, ".toml": {"#", ""}, ".conf": {"#", ""}, ".cfg": {"#", ""},
+	".env": {"#", ""}, ".nix": {"#", ""}, ".zshrc": {"#", ""}, ".zshenv": {"#", ""},
+	".zprofile": {"#", ""}, ".bashrc": {"#", ""}, ".bash_profile": {"#", ""},
+	".profile": {"#", ""}, ".gitconfig": {"#", ""}, ".gitignore": {"#", ""},
+	".inputrc": {"#", ""}, "config": {"#", ""},
+	".js": {"//", ""}, ".ts": {"//", ""}, ".jsonc": {"//", ""}, ".json5": {"//", ""},
+	".go": {"//", ""}, ".rs": {"//", ""}, ".c": {"//", ""}, ".h": {"//", ""},
+	".cpp": {"//", ""}, ".java": {"//", ""}, ".kt": {"//", ""}, ".swift": {"//", ""},
+	".kdl": {"//", ""},
+	".lua": {"--", ""}, ".sql": {"--", ""}, ".hs": {"--", ""},
+	".vim": {`"`, ""}, ".vimrc": {`"`, ""}, ".gvimrc": {`"`, ""},
+	".el": {";;", ""}, ".lisp": {";;", ""}, ".clj": {";;", ""}, ".ini": {";", ""},
+	".css": {"/*", " */"}, ".scss": {"//", ""},
+	".html": {"<!--", " -->"}, ".xml": {"<!--", " -->"}, ".plist": {"<!--", " -->"},
+	".md": {"<!--", " -->"},
+}
+
+// withHeader prepends the package's header to a file farm generates, as a
+// comment in the syntax of the target's file type. Files whose type isn't
+// known, or can't hold comments like JSON, are left as they are.
+func withHeader(pkg *config.Package, source, target string, content []byte) []byte {
+	if pkg.Header == "" {
+		return content
+	}
+
+	name := filepath.Base(target)
+	syntax, ok := commentSyntax[filepath.Ext(name)]
+	if !ok {
+		syntax, ok = commentSyntax[name]
+	}
+	if !ok {
+		return content
+	}
+
+	var header bytes.Buffer
+	text := strings.ReplaceAll(pkg.Header, "{source}", source)
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		header.WriteString(syntax[0] + " " + line + syntax[1] + "\n")
+	}
+
+	// Shebangs and XML declarations have to stay on the first line
+	if bytes.HasPrefix(content, []byte("#!")) || bytes.HasPrefix(content, []byte("<?xml")) {
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			return append(append(append([]byte{}, content[:i+1]...), header.Bytes()...), content[i+1:]...)
+		}
+	}
+
+	return append(header.Bytes(), content...)
+}
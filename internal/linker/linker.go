@@ -1,70 +1,464 @@
 package linker
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/diff"
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/git"
 	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/plan"
+	"github.com/mskelton/farm/transform"
 )
 
 type Linker struct {
-	config   *config.Config
-	lockFile *lockfile.LockFile
-	dryRun   bool
+	config    *config.Config
+	lockFile  *lockfile.LockFile
+	dryRun    bool
+	foldsSeen map[string]bool
+	retry     fsutil.RetryPolicy
+	// refreshDownloads fetches downloads even when they're up to date
+	refreshDownloads bool
+	// parentDirs holds the parent directories already created, or planned in
+	// a dry run, so each is reported once
+	parentDirs map[string]bool
+	// patternsUsed holds the patterns that matched at least one path
+	patternsUsed map[Pattern]bool
+	// ignored holds the paths the ignore patterns excluded, by source
+	ignored map[string]IgnoredFile
+	// collectConflicts records conflicts and carries on instead of failing
+	collectConflicts bool
+	conflicts        []*ConflictError
+	// placeholders caches the files git tracks as symlinks per package
+	// source, shared with the linkers that plan each target
+	placeholders map[string]map[string]bool
+	// claims holds the targets linked during the run by their lowercase
+	// path, shared with the linkers that plan each target
+	claims map[string]claim
+	// caseFolding caches whether a directory's filesystem ignores case
+	caseFolding map[string]bool
+	// unowned holds the packages whose owner couldn't be set, so each is
+	// warned about once
+	unowned map[string]bool
+	// requirements caches whether each package's required paths exist
+	requirements map[*config.Package]error
+	// tracked caches the paths git tracks in the sources of only_tracked
+	// packages, shared with the linkers that plan each target
+	tracked map[string]*trackedPaths
+	// unfolded holds the folded links a dry run would replace with a
+	// directory, so the paths below them are treated as missing
+	unfolded map[string]bool
+	// rendered collects the content of files instead of writing them, when
+	// set
+	rendered map[string][]byte
+	// pathsMatched holds the paths linking is limited to that matched a
+	// file or directory
+	pathsMatched map[string]bool
 }
 
 type LinkResult struct {
-	Created []string
-	Removed []string
-	Errors  []error
+	Created  []string
+	Removed  []string
+	Replaced []string
+	Errors   []error
+	// Changed holds the sources of packages whose links changed
+	Changed map[string]bool
+	// FontsChanged is set when fonts were installed or removed, so the
+	// font cache needs refreshing
+	FontsChanged bool
+	// CreatedDirs holds the parent directories created for links
+	CreatedDirs []CreatedDir
+	// UnusedPatterns holds the patterns that matched nothing
+	UnusedPatterns []Pattern
+	// Ignored holds the paths the ignore patterns excluded from the link
+	Ignored []IgnoredFile
+	// SkippedTargets holds the optional targets that were skipped because
+	// they or the directory they live in don't exist
+	SkippedTargets []string
+	// MissingBases holds the directories that didn't exist and had to be
+	// created for targets that aren't optional
+	MissingBases []string
+	// Warnings holds problems with individual files that were skipped
+	Warnings []string
+	// Diffs holds, in a dry run, how the content of each copied or rendered
+	// file would change, as a unified diff by target
+	Diffs map[string]string
+	// EmptyDirs holds the directories unlinking left empty, or removed when
+	// remove_empty_dirs is set
+	EmptyDirs []string
+	// Plan describes every change, with the package and reason behind it
+	Plan plan.Plan
+}
+
+// CreatedDir is a parent directory farm created for a link. Mode is zero
+// when the directory was left to the umask.
+type CreatedDir struct {
+	Path string
+	Mode os.FileMode
+}
+
+// ErrTargetSkipped is reported for targets that were left alone because
+// another target of the same package failed to plan.
+var ErrTargetSkipped = errors.New("skipped because another target of the package failed")
+
+// TargetError attributes an error to one target of a package.
+type TargetError struct {
+	Package string
+	Target  string
+	Err     error
+}
+
+func (e *TargetError) Error() string {
+	if e.Package == "" {
+		return fmt.Sprintf("%s: %v", e.Target, e.Err)
+	}
+	return fmt.Sprintf("%s -> %s: %v", e.Package, e.Target, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError is reported for a target that is in the way of a link, such
+// as a regular file farm didn't create.
+type ConflictError struct {
+	Target string
+	Source string
+	// Manager is the other dotfile manager whose link is in the way, if any
+	Manager string
+	reason  string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("target %s already exists and %s", e.Target, e.reason)
+}
+
+func (r *LinkResult) changes() int {
+	return len(r.Created) + len(r.Removed) + len(r.Replaced)
+}
+
+// Persistent returns the errors caused by filesystem operations that kept
+// failing with transient errors after every retry.
+func (r *LinkResult) Persistent() []error {
+	var persistent []error
+	for _, err := range r.Errors {
+		var retryErr *fsutil.RetryError
+		if errors.As(err, &retryErr) {
+			persistent = append(persistent, err)
+		}
+	}
+	return persistent
 }
 
 func New(cfg *config.Config, lock *lockfile.LockFile, dryRun bool) *Linker {
+	if cfg.Settings.NormalizesUnicode() {
+		lock.Normalize()
+	}
+
 	return &Linker{
-		config:   cfg,
-		lockFile: lock,
-		dryRun:   dryRun,
+		config:       cfg,
+		lockFile:     lock,
+		dryRun:       dryRun,
+		foldsSeen:    make(map[string]bool),
+		retry:        cfg.Settings.Retry,
+		parentDirs:   make(map[string]bool),
+		patternsUsed: make(map[Pattern]bool),
+		ignored:      make(map[string]IgnoredFile),
+		placeholders: make(map[string]map[string]bool),
+		claims:       make(map[string]claim),
+		caseFolding:  make(map[string]bool),
+		unowned:      make(map[string]bool),
+		requirements: make(map[*config.Package]error),
+		tracked:      make(map[string]*trackedPaths),
+		unfolded:     make(map[string]bool),
+		pathsMatched: make(map[string]bool),
 	}
 }
 
 func (l *Linker) Link() (*LinkResult, error) {
 	result := &LinkResult{
-		Created: []string{},
+		Created:  []string{},
+		Removed:  []string{},
+		Replaced: []string{},
+		Errors:   []error{},
+		Changed:  make(map[string]bool),
+	}
+
+	if l.config.Settings.CleansOnLink() && !l.partial() {
+		if err := l.removeDeadLinks(result); err != nil {
+			return nil, err
+		}
+	}
+
+	var walked []*config.Package
+	for _, pkg := range l.config.Packages {
+		before := result.changes()
+
+		if err := l.checkRequiredPaths(pkg); err != nil {
+			result.Errors = append(result.Errors, err)
+			l.record(result, plan.Action{Kind: plan.Skip, Package: pkg.Name, Reason: "required path doesn't exist"})
+			continue
+		}
+		if _, err := l.trackedFiles(pkg); err != nil {
+			result.Errors = append(result.Errors, err)
+			l.record(result, plan.Action{Kind: plan.Skip, Package: pkg.Name, Reason: "tracked files unknown"})
+			continue
+		}
+
+		// Fonts, directories, and downloads aren't paths in the source
+		if l.partial() && pkg.Fonts {
+			continue
+		}
+
+		available := l.preflightTargets(pkg, result)
+		if !l.partial() {
+			l.ensureDirectories(pkg, available, result)
+			l.ensureDownloads(pkg, result)
+		}
+
+		targets := l.planTargets(pkg, available, result)
+		for _, target := range targets {
+			if err := l.linkPackage(pkg, target, result); err != nil {
+				result.Errors = append(result.Errors, &TargetError{Package: pkg.Name, Target: target, Err: err})
+			}
+		}
+		if len(targets) > 0 && !l.partial() {
+			walked = append(walked, pkg)
+			l.lockFile.SetApplied(pkg.Source, time.Now())
+			l.lockFile.SetConfigHash(pkg.Source, l.config.PackageHash(pkg))
+		}
+
+		if result.changes() > before {
+			result.Changed[pkg.Source] = true
+			if pkg.Fonts {
+				result.FontsChanged = true
+			}
+		}
+	}
+
+	if l.partial() {
+		l.unmatchedPaths(result)
+	} else {
+		l.pruneFoldDecisions()
+		result.UnusedPatterns = l.unusedPatterns(walked)
+	}
+	result.Ignored = l.ignoredFiles()
+
+	return result, nil
+}
+
+// Clean removes dead links without linking anything, for when link is
+// configured not to clean up after itself.
+func (l *Linker) Clean() (*LinkResult, error) {
+	result := &LinkResult{
 		Removed: []string{},
 		Errors:  []error{},
+		Changed: make(map[string]bool),
+	}
+
+	if err := l.removeDeadLinks(result); err != nil {
+		return nil, err
 	}
 
-	deadLinks, err := l.lockFile.GetDeadSymlinks()
+	return result, nil
+}
+
+// removeDeadLinks removes the dead links of the configured packages. Links of
+// other packages are left alone, since their source may only be missing
+// temporarily, unless CleanAll is set.
+func (l *Linker) removeDeadLinks(result *LinkResult) error {
+	deadLinks, err := l.lockFile.FindDeadSymlinks()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get dead symlinks: %w", err)
+		return fmt.Errorf("failed to get dead symlinks: %w", err)
 	}
 
-	for _, dead := range deadLinks {
+	for _, deadLink := range deadLinks {
+		dead := deadLink.Target
+		source := l.lockFile.Symlinks[dead].Source
+		pkg := l.config.FindPackage(source)
+		if pkg == nil && !l.config.Settings.CleanAll {
+			continue
+		}
+
+		// The source may live on a volume that isn't mounted yet
+		if pkg != nil && l.checkRequiredPaths(pkg) != nil {
+			continue
+		}
+
+		// A copy whose source is gone may still hold the user's edits
+		if link := l.lockFile.Symlinks[dead]; link.IsFile() {
+			if changed := l.changedSince(link); changed != "" {
+				l.lockFile.RemoveSymlink(dead)
+				result.Warnings = append(result.Warnings, fmt.Sprintf("kept %s, %s", dead, changed))
+				continue
+			}
+		}
+
 		if !l.dryRun {
-			if err := os.Remove(dead); err != nil && !os.IsNotExist(err) {
+			if err := l.remove(dead); err != nil && !os.IsNotExist(err) {
 				result.Errors = append(result.Errors, fmt.Errorf("failed to remove dead link %s: %w", dead, err))
 				continue
 			}
 		}
+		if pkg != nil {
+			result.Changed[pkg.Source] = true
+		}
+		if l.lockFile.Symlinks[dead].Kind == lockfile.KindFont {
+			result.FontsChanged = true
+		}
 		l.lockFile.RemoveSymlink(dead)
 		result.Removed = append(result.Removed, dead)
+		l.record(result, plan.Action{Kind: plan.Remove, Source: source, Target: dead, Reason: "dead link, " + deadLink.Reason})
 	}
 
-	for _, pkg := range l.config.Packages {
-		for _, target := range pkg.Targets {
-			if err := l.linkPackage(pkg, target, result); err != nil {
-				result.Errors = append(result.Errors, err)
+	return nil
+}
+
+// pruneFoldDecisions drops the unfolded directories under the linked packages
+// that no longer exist in the source tree, along with the fold decisions
+// older versions of farm cached.
+func (l *Linker) pruneFoldDecisions() {
+	for source, decision := range l.lockFile.Folds {
+		if !decision.Unfolded {
+			l.lockFile.RemoveFoldDecision(source)
+			continue
+		}
+		if l.foldsSeen[source] {
+			continue
+		}
+
+		for _, pkg := range l.config.Packages {
+			if strings.HasPrefix(source, pkg.Source+"/") {
+				l.lockFile.RemoveFoldDecision(source)
+				break
 			}
 		}
 	}
+}
 
-	return result, nil
+// preflightTargets returns the package's targets that are available. Optional
+// targets are skipped quietly when the directory they live in doesn't exist,
+// such as the config directory of an app that isn't installed, and targets
+// marked only_if_target_exists when they don't exist themselves.
+func (l *Linker) preflightTargets(pkg *config.Package, result *LinkResult) []string {
+	var available []string
+	for _, target := range pkg.Targets {
+		if other, ok := l.sourceContaining(target); ok {
+			result.Errors = append(result.Errors, &TargetError{Package: pkg.Name, Target: target, Err: fmt.Errorf("%w of package %s", ErrTargetInSource, other.Name)})
+			continue
+		}
+
+		if pkg.OnlyIfTargetExists(target) {
+			if info, err := os.Stat(target); err != nil || !info.IsDir() {
+				result.SkippedTargets = append(result.SkippedTargets, target)
+				l.record(result, plan.Action{Kind: plan.Skip, Package: pkg.Name, Target: target, Reason: "target doesn't exist"})
+				continue
+			}
+		}
+
+		base := filepath.Dir(target)
+		if _, err := os.Stat(base); os.IsNotExist(err) {
+			if pkg.IsOptionalTarget(target) {
+				result.SkippedTargets = append(result.SkippedTargets, target)
+				l.record(result, plan.Action{Kind: plan.Skip, Package: pkg.Name, Target: target, Reason: "optional target's directory doesn't exist"})
+				continue
+			}
+			if !slices.Contains(result.MissingBases, base) {
+				result.MissingBases = append(result.MissingBases, base)
+			}
+		}
+
+		available = append(available, target)
+	}
+	return available
+}
+
+// ErrTargetInSource is reported for targets inside a package's source, such
+// as `targets: ["."]`, which would fill the repo with links to itself.
+var ErrTargetInSource = errors.New("target is inside the source")
+
+// sourceContaining returns the package whose source the target would end up
+// in. Only the directory the target is placed in is resolved, since the
+// target itself may be a folded link into the source.
+func (l *Linker) sourceContaining(target string) (*config.Package, bool) {
+	resolved := filepath.Join(fsutil.ResolveExisting(filepath.Dir(target)), filepath.Base(target))
+	for _, pkg := range l.config.Packages {
+		source := fsutil.ResolveExisting(pkg.Source)
+		if resolved == source || strings.HasPrefix(resolved, source+"/") {
+			return pkg, true
+		}
+	}
+	return nil, false
+}
+
+// checkWritable fails for a target that doesn't exist yet when the directory
+// it would be created in isn't writable.
+func checkWritable(target string) error {
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		return nil
+	}
+
+	dir := filepath.Dir(target)
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	if !fsutil.Writable(dir) {
+		return fmt.Errorf("%s is not writable", dir)
+	}
+	return nil
+}
+
+// planTargets dry-runs each of the package's targets and returns the ones
+// that should be applied. By default targets are applied in lock-step, so a
+// single failing target leaves the package untouched everywhere unless
+// skip_failed_targets is set.
+func (l *Linker) planTargets(pkg *config.Package, targets []string, result *LinkResult) []string {
+	var healthy, failed []string
+	for _, target := range targets {
+		err := checkWritable(target)
+		if err == nil {
+			plan := New(l.config, l.lockFile.Clone(), true)
+			plan.placeholders = l.placeholders
+			plan.tracked = l.tracked
+			plan.claims = l.claims
+			plan.caseFolding = l.caseFolding
+			plan.pathsMatched = l.pathsMatched
+			err = plan.linkPackage(pkg, target, &LinkResult{Changed: make(map[string]bool)})
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, &TargetError{Package: pkg.Name, Target: target, Err: err})
+			failed = append(failed, target)
+			continue
+		}
+		healthy = append(healthy, target)
+	}
+
+	if len(failed) == 0 || l.config.Settings.SkipFailedTargets {
+		return healthy
+	}
+
+	for _, target := range healthy {
+		result.Errors = append(result.Errors, &TargetError{Package: pkg.Name, Target: target, Err: ErrTargetSkipped})
+	}
+	return nil
 }
 
 func (l *Linker) linkPackage(pkg *config.Package, targetBase string, result *LinkResult) error {
+	if pkg.Fonts {
+		return l.linkFonts(pkg, targetBase, result)
+	}
 	return l.linkDirectory(pkg.Source, targetBase, pkg, result)
 }
 
@@ -74,36 +468,70 @@ func (l *Linker) linkDirectory(source, target string, pkg *config.Package, resul
 		return fmt.Errorf("failed to read source directory %s: %w", source, err)
 	}
 
-	for _, entry := range entries {
+	for _, selected := range selectVariants(pkg, entries) {
+		entry := selected.entry
+
+		// Targets get the composed name, so they're tracked the same way
+		// however the source spells it
+		name, targetName := entry.Name(), selected.name
+		if l.config.Settings.NormalizesUnicode() {
+			name, targetName = fsutil.NFC(name), fsutil.NFC(targetName)
+		}
+
 		// Construct relative path from package source
 		relativePath := strings.TrimPrefix(source, pkg.Source)
 		relativePath = strings.TrimPrefix(relativePath, "/")
 		if relativePath != "" {
-			relativePath = filepath.Join(relativePath, entry.Name())
+			relativePath = filepath.Join(relativePath, name)
 		} else {
-			relativePath = entry.Name()
+			relativePath = name
 		}
 
 		// Skip ignored files/directories
-		if l.config.ShouldIgnore(relativePath) {
+		if l.shouldIgnore(pkg, relativePath) {
+			continue
+		}
+
+		// Only the selected paths are linked, walking the directories above
+		// them to get there
+		selected, above := l.selectPath(relativePath)
+		if !selected && !(above && entry.IsDir()) {
 			continue
 		}
 
 		sourcePath := filepath.Join(source, entry.Name())
-		targetPath := filepath.Join(target, entry.Name())
+		targetPath := filepath.Join(target, targetName)
+
+		// Directories above the selected paths are never folded or unfolded,
+		// which would link or unlink far more than was asked for. A folded
+		// one already links everything below it.
+		if !selected {
+			if link, ok := l.lockFile.Symlinks[targetPath]; ok && link.IsFolded && link.Source == sourcePath {
+				continue
+			}
+			if err := l.linkDirectory(sourcePath, targetPath, pkg, result); err != nil {
+				return err
+			}
+			continue
+		}
 
 		if entry.IsDir() {
-			if l.shouldFold(entry.Name(), source, pkg) {
+			// Folding a directory into a Windows target would link it, which
+			// Windows programs can't follow
+			if !pkg.CopiesInto(targetPath) && l.shouldFold(entry.Name(), source, targetPath, pkg, result) {
 				if err := l.createSymlink(sourcePath, targetPath, true, result); err != nil {
 					return err
 				}
 			} else {
+				if err := l.replaceFoldedLink(sourcePath, targetPath, result); err != nil {
+					return err
+				}
 				if err := l.linkDirectory(sourcePath, targetPath, pkg, result); err != nil {
 					return err
 				}
 			}
 		} else {
-			if err := l.createSymlink(sourcePath, targetPath, false, result); err != nil {
+			if err := l.linkFile(sourcePath, targetPath, relativePath, pkg, result); err != nil {
 				return err
 			}
 		}
@@ -112,7 +540,248 @@ func (l *Linker) linkDirectory(source, target string, pkg *config.Package, resul
 	return nil
 }
 
-func (l *Linker) shouldFold(dirName, currentPath string, pkg *config.Package) bool {
+// replaceFoldedLink removes the folded link of a directory that is no longer
+// folded, so its entries are linked into a real directory rather than through
+// the link into the source. Dry runs remember it instead, so the entries
+// aren't mistaken for conflicts.
+func (l *Linker) replaceFoldedLink(source, target string, result *LinkResult) error {
+	link, ok := l.lockFile.Symlinks[target]
+	if !ok || !link.IsFolded || link.Source != source {
+		return nil
+	}
+
+	if l.dryRun {
+		l.unfolded[target] = true
+	} else if err := l.remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove folded symlink %s: %w", target, err)
+	}
+
+	l.lockFile.RemoveSymlink(target)
+	result.Removed = append(result.Removed, target)
+	l.record(result, plan.Action{Kind: plan.Remove, Source: source, Target: target, Reason: "no longer folded"})
+	return nil
+}
+
+// linkFile places a single file, giving the package's transformers a chance
+// to copy, render, or skip it instead of symlinking.
+func (l *Linker) linkFile(source, target, relativePath string, pkg *config.Package, result *LinkResult) error {
+	// Links must never point at a placeholder, which only contains a path
+	if dest, ok := l.placeholderDest(pkg, source); ok {
+		info, err := os.Stat(dest)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped %s, it is a git symlink placeholder for %s which doesn't exist", source, dest))
+			return nil
+		}
+
+		if (pkg.CopyPlaceholders || pkg.CopiesInto(target)) && !info.IsDir() {
+			content, err := os.ReadFile(dest)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", dest, err)
+			}
+			return l.writeFile(source, target, withHeader(pkg, source, target, content), info.Mode().Perm(), lockfile.KindCopy, result)
+		}
+
+		return l.linkOrCopy(source, target, pkg, result)
+	}
+
+	for _, name := range pkg.Transform {
+		t, ok := transform.Lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown transformer %s", name)
+		}
+
+		out, err := t.Transform(transform.Input{
+			Source:        source,
+			Target:        target,
+			RelativePath:  relativePath,
+			PackageSource: pkg.Source,
+		})
+		if err != nil {
+			return fmt.Errorf("transformer %s failed for %s: %w", name, source, err)
+		}
+		if out == nil {
+			continue
+		}
+
+		switch out.Action {
+		case transform.ActionSkip:
+			return nil
+		case transform.ActionSymlink:
+			return l.linkOrCopy(source, target, pkg, result)
+		case transform.ActionCopy:
+			content, err := os.ReadFile(source)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", source, err)
+			}
+			return l.writeFile(source, target, withHeader(pkg, source, target, content), out.Mode, lockfile.KindCopy, result)
+		case transform.ActionRender:
+			return l.writeFile(source, target, withHeader(pkg, source, target, out.Content), out.Mode, lockfile.KindRender, result)
+		default:
+			return fmt.Errorf("transformer %s returned unknown action %q for %s", name, out.Action, source)
+		}
+	}
+
+	return l.linkOrCopy(source, target, pkg, result)
+}
+
+// addDiff records how a dry run would change the content of a file.
+func (l *Linker) addDiff(result *LinkResult, target, beforeName string, before, after []byte, kind string) {
+	if result.Diffs == nil {
+		result.Diffs = make(map[string]string)
+	}
+	result.Diffs[target] = diff.Unified(beforeName, fmt.Sprintf("%s (%s)", target, kind), before, after)
+}
+
+// Expected returns the content farm writes to a copied or rendered target,
+// running the package's transformers again without writing anything.
+func (l *Linker) Expected(target string) ([]byte, error) {
+	link, ok := l.lockFile.Symlinks[target]
+	if !ok || (link.Kind != lockfile.KindCopy && link.Kind != lockfile.KindRender) {
+		return nil, fmt.Errorf("%s isn't a copied or rendered file", target)
+	}
+
+	pkg := l.config.FindPackage(link.Source)
+	if pkg == nil {
+		return nil, fmt.Errorf("%s is not part of any configured package", link.Source)
+	}
+	relativePath, err := filepath.Rel(pkg.Source, link.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	capture := New(l.config, l.lockFile.Clone(), true)
+	capture.rendered = make(map[string][]byte)
+	if err := capture.linkFile(link.Source, target, relativePath, pkg, &LinkResult{Changed: make(map[string]bool)}); err != nil {
+		return nil, err
+	}
+
+	content, ok := capture.rendered[target]
+	if !ok {
+		return nil, fmt.Errorf("%s is no longer copied or rendered, run 'farm link' to update it", target)
+	}
+	return content, nil
+}
+
+// linkOrCopy links a file, or copies it when the target is in one of the
+// package's Windows targets.
+func (l *Linker) linkOrCopy(source, target string, pkg *config.Package, result *LinkResult) error {
+	if !pkg.CopiesInto(target) {
+		return l.createSymlink(source, target, false, result)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	return l.writeFile(source, target, withHeader(pkg, source, target, content), info.Mode().Perm(), lockfile.KindCopy, result)
+}
+
+func (l *Linker) writeFile(source, target string, content []byte, mode os.FileMode, kind string, result *LinkResult) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	if l.rendered != nil {
+		l.rendered[target] = content
+		return nil
+	}
+
+	if err := fsutil.ValidatePath(target); err != nil {
+		return err
+	}
+	if err := l.claimTarget(source, target); err != nil {
+		return err
+	}
+
+	replaced := ""
+	if existing, err := l.lstat(target); err == nil {
+		// Only files farm wrote itself, or stale symlinks, may be overwritten
+		tracked, ok := l.lockFile.Symlinks[target]
+		if existing.Mode()&os.ModeSymlink == 0 && (!ok || !tracked.IsFile()) {
+			return l.conflict(&ConflictError{Target: target, Source: source, reason: "is not managed by farm"})
+		}
+
+		if ok && tracked.IsFile() {
+			current, err := os.ReadFile(target)
+			if err == nil && bytes.Equal(current, content) {
+				l.lockFile.AddFile(target, source, kind, fsutil.Checksum(content))
+				return l.chown(l.config.FindPackage(source), target, result)
+			}
+			if err == nil && l.dryRun {
+				l.addDiff(result, target, target, current, content, kind)
+			}
+		}
+
+		result.Replaced = append(result.Replaced, target)
+		replaced = "replaces a stale symlink"
+		if ok && tracked.IsFile() {
+			replaced = "content changed"
+		}
+	} else if l.dryRun && kind == lockfile.KindRender {
+		l.addDiff(result, target, "/dev/null", nil, content, kind)
+	}
+
+	if err := l.ensureParentDir(source, filepath.Dir(target), result); err != nil {
+		return err
+	}
+
+	if !l.dryRun {
+		// Remove first so a symlink at the target isn't written through
+		if err := l.remove(target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+
+		if err := l.writeFileContent(target, content, mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+	}
+	if err := l.chown(l.config.FindPackage(source), target, result); err != nil {
+		return err
+	}
+
+	l.lockFile.AddFile(target, source, kind, fsutil.Checksum(content))
+	result.Created = append(result.Created, target)
+	if replaced != "" {
+		l.record(result, plan.Action{Kind: plan.Replace, Source: source, Target: target, Reason: replaced})
+	} else {
+		l.record(result, plan.Action{Kind: plan.Create, Source: source, Target: target, Reason: "new file (" + kind + ")"})
+	}
+
+	return nil
+}
+
+func (l *Linker) shouldFold(dirName, currentPath, targetPath string, pkg *config.Package, result *LinkResult) bool {
+	sourcePath := filepath.Join(currentPath, dirName)
+	l.foldsSeen[sourcePath] = true
+
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(sourcePath, pkg.Source), "/")
+	l.noteFoldPatterns(pkg, relativePath)
+
+	// Directories unfolded by hand stay that way
+	if l.lockFile.IsUnfolded(sourcePath) {
+		return false
+	}
+
+	return l.resolveFold(dirName, currentPath, targetPath, pkg) && !l.sharedWithPackage(targetPath, pkg, result)
+}
+
+// sharedWithPackage reports whether another package links into the target
+// directory, warning that it isn't folded since folding it would hide the
+// other package's links.
+func (l *Linker) sharedWithPackage(targetPath string, pkg *config.Package, result *LinkResult) bool {
+	other := l.foreignContributor(targetPath, pkg)
+	if other == nil {
+		return false
+	}
+
+	result.Warnings = append(result.Warnings, fmt.Sprintf("not folding %s, package %s also links into it", targetPath, other.Name))
+	return true
+}
+
+func (l *Linker) resolveFold(dirName, currentPath, targetPath string, pkg *config.Package) bool {
 	relativePath := strings.TrimPrefix(currentPath, pkg.Source)
 	relativePath = strings.TrimPrefix(relativePath, "/")
 	if relativePath != "" {
@@ -122,18 +791,35 @@ func (l *Linker) shouldFold(dirName, currentPath string, pkg *config.Package) bo
 	}
 
 	// Check no_fold patterns first
+	deep := false
 	for _, noFoldPath := range pkg.NoFold {
-		if l.matchesPath(noFoldPath, relativePath) {
+		unfold, fold := l.noFold(noFoldPath, relativePath)
+		if unfold {
 			return false
 		}
+		deep = deep || fold
 
 		// Check if this directory contains any paths that would match no_fold patterns
 		// If folding this directory would prevent no_fold patterns from being honored, don't fold
-		if strings.HasPrefix(noFoldPath, relativePath+"/") {
+		if base, _ := noFoldDepth(noFoldPath); strings.HasPrefix(base, relativePath+"/") {
 			return false
 		}
 	}
 
+	// Variants are only picked when linking file by file
+	if pkg.Variants && containsVariants(filepath.Join(currentPath, dirName)) {
+		return false
+	}
+
+	// Directories below the depth a no_fold pattern unfolds are folded
+	if deep {
+		return true
+	}
+
+	if pkg.AutoFold {
+		return l.canAutoFold(filepath.Join(currentPath, dirName), targetPath, pkg)
+	}
+
 	// Check fold patterns
 	for _, foldPath := range pkg.Fold {
 		if l.matchesPath(foldPath, relativePath) {
@@ -144,7 +830,113 @@ func (l *Linker) shouldFold(dirName, currentPath string, pkg *config.Package) bo
 	return pkg.DefaultFold
 }
 
+// canAutoFold reports whether a directory can be folded without hiding any
+// exceptions: the target must not already be a real directory, no other
+// package may contribute to it, and nothing inside it may be ignored or
+// excluded from folding.
+func (l *Linker) canAutoFold(sourcePath, targetPath string, pkg *config.Package) bool {
+	if info, err := os.Lstat(targetPath); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+
+	if l.foreignContributor(targetPath, pkg) != nil {
+		return false
+	}
+
+	foldable := true
+	_ = filepath.WalkDir(sourcePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			foldable = false
+			return filepath.SkipAll
+		}
+
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(path, pkg.Source), "/")
+		if l.shouldIgnore(pkg, relativePath) {
+			foldable = false
+			return filepath.SkipAll
+		}
+
+		for _, noFoldPath := range pkg.NoFold {
+			if unfold, _ := l.noFold(noFoldPath, relativePath); unfold {
+				foldable = false
+				return filepath.SkipAll
+			}
+		}
+
+		return nil
+	})
+
+	return foldable
+}
+
+// foreignContributor returns another package that links into the target
+// directory or below it, if there is one.
+func (l *Linker) foreignContributor(targetPath string, pkg *config.Package) *config.Package {
+	for _, other := range l.config.Packages {
+		if other == pkg {
+			continue
+		}
+
+		for _, base := range other.Targets {
+			// Another package links into this directory or below it
+			if base == targetPath || strings.HasPrefix(base, targetPath+"/") {
+				return other
+			}
+
+			// Another package has a matching directory that lands here
+			if strings.HasPrefix(targetPath, base+"/") {
+				relativePath := strings.TrimPrefix(targetPath, base+"/")
+				if _, err := os.Stat(filepath.Join(other.Source, relativePath)); err == nil {
+					return other
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// noFoldDepth splits a no_fold pattern into the directory it applies to and
+// how many levels of it are linked file by file. Each trailing /* is one
+// level, so `nvim/*` links the entries of nvim one by one but folds the
+// directories in it. Without any, or with /**, nothing below is folded.
+func noFoldDepth(pattern string) (string, int) {
+	if base, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return base, -1
+	}
+
+	depth := 0
+	for strings.HasSuffix(pattern, "/*") {
+		pattern = strings.TrimSuffix(pattern, "/*")
+		depth++
+	}
+	if depth == 0 {
+		return pattern, -1
+	}
+	return pattern, depth
+}
+
+// noFold reports whether a no_fold pattern keeps the directory at
+// relativePath unfolded, or whether it lies deep enough below the pattern to
+// be folded instead.
+func (l *Linker) noFold(pattern, relativePath string) (unfold bool, fold bool) {
+	base, depth := noFoldDepth(pattern)
+	if !l.matchesPath(base, relativePath) {
+		return false, false
+	}
+	if depth < 0 {
+		return true, false
+	}
+
+	below := strings.Count(relativePath, "/") - strings.Count(base, "/")
+	return below < depth, below >= depth
+}
+
 func (l *Linker) matchesPath(pattern, path string) bool {
+	if l.config.Settings.NormalizesUnicode() {
+		pattern, path = fsutil.NFC(pattern), fsutil.NFC(path)
+	}
+
 	// Direct match
 	if pattern == path {
 		return true
@@ -176,15 +968,143 @@ func (l *Linker) matchesPath(pattern, path string) bool {
 	return false
 }
 
-func (l *Linker) createSymlink(source, target string, isFolded bool, result *LinkResult) error {
-	targetDir := filepath.Dir(target)
+// ensureParentDir creates the missing directories above a link. They get the
+// package's parent_dir_mode when it has one, and are otherwise left to the
+// umask.
+func (l *Linker) ensureParentDir(source, dir string, result *LinkResult) error {
+	var mode os.FileMode
+	pkg := l.config.FindPackage(source)
+	if pkg != nil {
+		mode = os.FileMode(pkg.ParentDirMode)
+	}
+
+	var missing []string
+	for path := dir; !l.parentDirs[path]; path = filepath.Dir(path) {
+		if _, err := l.lstat(path); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, path)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
 	if !l.dryRun {
-		if err := os.MkdirAll(targetDir, 0755); err != nil {
-			return fmt.Errorf("failed to create target directory %s: %w", targetDir, err)
+		perm := mode
+		if perm == 0 {
+			perm = 0755
+		}
+
+		if err := l.mkdirAll(dir, perm); err != nil {
+			return fmt.Errorf("failed to create target directory %s: %w", dir, err)
 		}
 	}
 
-	if existingTarget, err := os.Lstat(target); err == nil {
+	for i := len(missing) - 1; i >= 0; i-- {
+		path := missing[i]
+
+		// MkdirAll is subject to the umask
+		if mode != 0 && !l.dryRun {
+			if err := os.Chmod(path, mode); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", path, err)
+			}
+		}
+		if err := l.chown(pkg, path, result); err != nil {
+			return err
+		}
+
+		l.parentDirs[path] = true
+		result.CreatedDirs = append(result.CreatedDirs, CreatedDir{Path: path, Mode: mode})
+		l.record(result, plan.Action{Kind: plan.Mkdir, Source: source, Target: path, Reason: "parent directory"})
+	}
+
+	return nil
+}
+
+// linkDest returns what a link to source points at. Sources that are
+// symlinks themselves are linked as is, unless their package dereferences
+// them, in which case the link points at the file the chain resolves to.
+func (l *Linker) linkDest(source string) (string, error) {
+	pkg := l.config.FindPackage(source)
+	if pkg == nil {
+		return source, nil
+	}
+
+	if dest, ok := l.placeholderDest(pkg, source); ok {
+		return dest, nil
+	}
+
+	if !pkg.Dereference {
+		return source, nil
+	}
+
+	chain, err := fsutil.ResolveChain(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to dereference %s: %w", source, err)
+	}
+	return chain[len(chain)-1], nil
+}
+
+// placeholderDest reports whether source is a symlink that git checked out as
+// a plain text file containing the link's destination, as it does with
+// core.symlinks=false, and returns that destination.
+func (l *Linker) placeholderDest(pkg *config.Package, source string) (string, bool) {
+	tracked, ok := l.placeholders[pkg.Source]
+	if !ok {
+		tracked = git.TrackedSymlinks(pkg.Source)
+		l.placeholders[pkg.Source] = tracked
+	}
+
+	if !tracked[source] {
+		return "", false
+	}
+
+	info, err := os.Lstat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return "", false
+	}
+
+	dest := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(source), dest)
+	}
+	return dest, true
+}
+
+// isSymlink reports whether path itself is a symlink.
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+func (l *Linker) createSymlink(source, target string, isFolded bool, result *LinkResult) error {
+	dest, err := l.linkDest(source)
+	if err != nil {
+		return err
+	}
+
+	if err := fsutil.ValidatePath(target); err != nil {
+		return err
+	}
+	if err := l.claimTarget(source, target); err != nil {
+		return err
+	}
+
+	if err := l.ensureParentDir(source, filepath.Dir(target), result); err != nil {
+		return err
+	}
+
+	replaced := ""
+	if existingTarget, err := l.lstat(target); err == nil {
 		if existingTarget.Mode()&os.ModeSymlink != 0 {
 			existingSource, _ := os.Readlink(target)
 			existingSourceAbs := existingSource
@@ -192,56 +1112,412 @@ func (l *Linker) createSymlink(source, target string, isFolded bool, result *Lin
 				existingSourceAbs = filepath.Join(filepath.Dir(target), existingSource)
 			}
 
-			if existingSourceAbs == source {
+			// Links that already resolve to the source are left untouched,
+			// even if their destination is spelled differently, as long as
+			// they dereference the source the same way
+			if fsutil.SamePath(existingSourceAbs, dest) && isSymlink(existingSourceAbs) == isSymlink(dest) {
 				// Symlink already exists and points to correct source
 				// Add it to lockfile if not already tracked
-				l.lockFile.AddSymlink(target, source, isFolded)
+				l.addSymlink(target, source, dest, isFolded)
 				return nil
 			}
 
+			// Links of another dotfile manager, likely still in use while
+			// migrating from it, are only replaced once confirmed
+			if _, tracked := l.lockFile.Symlinks[target]; !tracked {
+				if manager, dir, ok := l.foreignManager(existingSourceAbs); ok {
+					switch l.config.Settings.ForeignLinks {
+					case config.ForeignKeep:
+						result.Warnings = append(result.Warnings, fmt.Sprintf("kept %s, it links into %s's directory %s", target, manager, dir))
+						return nil
+					case config.ForeignReplace:
+					default:
+						return l.conflict(&ConflictError{Target: target, Source: source, Manager: manager, reason: fmt.Sprintf("links into %s's directory %s", manager, dir)})
+					}
+				}
+			}
+
 			if !l.dryRun {
-				if err := os.Remove(target); err != nil {
+				if err := l.remove(target); err != nil {
 					return fmt.Errorf("failed to remove existing symlink %s: %w", target, err)
 				}
 			}
+			result.Replaced = append(result.Replaced, target)
+			replaced = "pointed to " + existingSourceAbs
+			if tracked, ok := l.lockFile.Symlinks[target]; ok && !tracked.IsFile() && tracked.Source != source {
+				replaced = "source moved from " + tracked.Source
+			}
+		} else if tracked, ok := l.lockFile.Symlinks[target]; ok && tracked.IsFile() {
+			// A file farm previously copied or rendered is replaced by the link
+			if !l.dryRun {
+				if err := l.remove(target); err != nil {
+					return fmt.Errorf("failed to remove managed file %s: %w", target, err)
+				}
+			}
+			result.Replaced = append(result.Replaced, target)
+			replaced = "replaces a " + tracked.Kind + " file"
 		} else {
-			return fmt.Errorf("target %s already exists and is not a symlink", target)
+			return l.conflict(&ConflictError{Target: target, Source: source, reason: "is not a symlink"})
 		}
 	}
 
 	if !l.dryRun {
-		relSource, err := filepath.Rel(filepath.Dir(target), source)
+		relSource, err := filepath.Rel(filepath.Dir(target), dest)
 		if err != nil {
 			return fmt.Errorf("failed to calculate relative path: %w", err)
 		}
 
-		if err := os.Symlink(relSource, target); err != nil {
-			return fmt.Errorf("failed to create symlink %s -> %s: %w", target, source, err)
+		if err := l.symlink(relSource, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s -> %s: %w", target, dest, err)
 		}
 	}
+	if err := l.chown(l.config.FindPackage(source), target, result); err != nil {
+		return err
+	}
 
-	l.lockFile.AddSymlink(target, source, isFolded)
+	l.addSymlink(target, source, dest, isFolded)
 	result.Created = append(result.Created, target)
+	if replaced != "" {
+		l.record(result, plan.Action{Kind: plan.Replace, Source: source, Target: target, Reason: replaced})
+	} else if isFolded {
+		l.record(result, plan.Action{Kind: plan.Create, Source: source, Target: target, Reason: "folded directory"})
+	} else {
+		l.record(result, plan.Action{Kind: plan.Create, Source: source, Target: target, Reason: "new link"})
+	}
+
+	return nil
+}
+
+// record adds an action to the result's plan, attributing it to the package
+// of its source.
+func (l *Linker) record(result *LinkResult, action plan.Action) {
+	if action.Package == "" && action.Source != "" {
+		if pkg := l.config.FindPackage(action.Source); pkg != nil {
+			action.Package = pkg.Name
+		}
+	}
+	result.Plan.Add(action)
+}
+
+func (l *Linker) addSymlink(target, source, dest string, isFolded bool) {
+	l.lockFile.AddSymlink(target, source, isFolded)
+	if dest != source {
+		l.lockFile.SetResolved(target, dest)
+	}
+}
+
+func (l *Linker) Unfold(target string) (*LinkResult, error) {
+	result := &LinkResult{
+		Created: []string{},
+		Removed: []string{},
+		Errors:  []error{},
+	}
+
+	link, ok := l.lockFile.Symlinks[target]
+	if !ok {
+		return nil, fmt.Errorf("%s is not managed by farm", target)
+	}
+	if !link.IsFolded {
+		return nil, fmt.Errorf("%s is not a folded directory", target)
+	}
+
+	entries, err := os.ReadDir(link.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source directory %s: %w", link.Source, err)
+	}
 
+	if !l.dryRun {
+		if err := l.remove(target); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove folded symlink %s: %w", target, err)
+		}
+		if err := l.mkdirAll(target, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create target directory %s: %w", target, err)
+		}
+	}
+	l.lockFile.RemoveSymlink(target)
+	l.record(result, plan.Action{Kind: plan.Remove, Source: link.Source, Target: target, Reason: "unfolded"})
+
+	// Remember the decision so no later link folds the directory again,
+	// however the config changes
+	pkg := l.config.FindPackage(link.Source)
+	l.lockFile.SetUnfolded(link.Source)
+	for _, entry := range entries {
+		sourcePath := filepath.Join(link.Source, entry.Name())
+		targetPath := filepath.Join(target, entry.Name())
+
+		if pkg != nil {
+			relativePath := strings.TrimPrefix(strings.TrimPrefix(sourcePath, pkg.Source), "/")
+			if l.shouldIgnore(pkg, relativePath) {
+				continue
+			}
+		}
+
+		// The folded symlink is still in place during a dry run, so only
+		// report the entries that would be linked
+		if l.dryRun {
+			l.lockFile.AddSymlink(targetPath, sourcePath, entry.IsDir())
+			result.Created = append(result.Created, targetPath)
+			l.record(result, plan.Action{Kind: plan.Create, Source: sourcePath, Target: targetPath, Reason: "directory unfolded"})
+			continue
+		}
+
+		// Subdirectories follow the package's own folding rules so the result
+		// matches what the next link would produce
+		if entry.IsDir() && pkg != nil && !l.shouldFold(entry.Name(), link.Source, targetPath, pkg, result) {
+			err = l.linkDirectory(sourcePath, targetPath, pkg, result)
+		} else {
+			err = l.createSymlink(sourcePath, targetPath, entry.IsDir(), result)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+
+	return result, nil
+}
+
+// Fold undoes Unfold: it forgets that the directory at target was unfolded,
+// and when the package's config folds it, replaces the directory with the
+// folded symlink again. Files in it that farm doesn't manage have to be moved
+// out first.
+func (l *Linker) Fold(target string) (*LinkResult, error) {
+	result := &LinkResult{
+		Created: []string{},
+		Removed: []string{},
+		Errors:  []error{},
+	}
+
+	source := ""
+	for _, link := range l.lockFile.Symlinks.Sorted() {
+		if rel, ok := strings.CutPrefix(link.Target, target+"/"); ok {
+			if dir, ok := strings.CutSuffix(link.Source, "/"+rel); ok && l.lockFile.IsUnfolded(dir) {
+				source = dir
+				break
+			}
+		}
+	}
+	if source == "" {
+		return nil, fmt.Errorf("%s was not unfolded with farm unfold", target)
+	}
+
+	pkg := l.config.FindPackage(source)
+	if pkg == nil || !l.resolveFold(filepath.Base(source), filepath.Dir(source), target, pkg) || l.foreignContributor(target, pkg) != nil {
+		l.lockFile.RemoveFoldDecision(source)
+		return result, nil
+	}
+
+	err := filepath.WalkDir(target, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if link, ok := l.lockFile.Symlinks[path]; !ok || l.changedSince(link) != "" {
+			return fmt.Errorf("%s isn't managed by farm, move it out of %s first", path, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.lockFile.RemoveFoldDecision(source)
+	for _, link := range l.lockFile.Symlinks.Sorted() {
+		if strings.HasPrefix(link.Target, target+"/") {
+			l.lockFile.RemoveSymlink(link.Target)
+			result.Removed = append(result.Removed, link.Target)
+			l.record(result, plan.Action{Kind: plan.Remove, Source: link.Source, Target: link.Target, Reason: "folded"})
+		}
+	}
+
+	if l.dryRun {
+		l.lockFile.AddSymlink(target, source, true)
+		result.Created = append(result.Created, target)
+		l.record(result, plan.Action{Kind: plan.Create, Source: source, Target: target, Reason: "directory folded"})
+		return result, nil
+	}
+
+	// Only farm's links and the directories holding them are left
+	if err := os.RemoveAll(target); err != nil {
+		return nil, fmt.Errorf("failed to remove unfolded directory %s: %w", target, err)
+	}
+	if err := l.createSymlink(source, target, true, result); err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+
+	return result, nil
+}
+
+// Conflicts plans a link of every package and returns every target that is
+// in the way, rather than stopping at the first one of each target.
+func (l *Linker) Conflicts() []*ConflictError {
+	plan := New(l.config, l.lockFile.Clone(), true)
+	plan.collectConflicts = true
+
+	result := &LinkResult{Changed: make(map[string]bool)}
+	for _, pkg := range plan.config.Packages {
+		for _, target := range plan.preflightTargets(pkg, result) {
+			_ = plan.linkPackage(pkg, target, result)
+		}
+	}
+
+	return plan.conflicts
+}
+
+func (l *Linker) conflict(err *ConflictError) error {
+	if !l.collectConflicts {
+		return err
+	}
+
+	l.conflicts = append(l.conflicts, err)
 	return nil
 }
 
+// Orphans returns lockfile entries whose source no longer belongs to any
+// configured package.
+func (l *Linker) Orphans() []lockfile.Symlink {
+	var orphans []lockfile.Symlink
+	for _, link := range l.lockFile.Symlinks.Sorted() {
+		if l.config.FindPackage(link.Source) == nil {
+			orphans = append(orphans, link)
+		}
+	}
+	return orphans
+}
+
+func (l *Linker) RemoveOrphans(orphans []lockfile.Symlink) *LinkResult {
+	result := &LinkResult{
+		Removed: []string{},
+		Errors:  []error{},
+	}
+
+	for _, link := range orphans {
+		if !l.dryRun {
+			// Only remove what farm created, a regular file may have replaced it
+			info, err := l.lstat(link.Target)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := l.remove(link.Target); err != nil && !os.IsNotExist(err) {
+					result.Errors = append(result.Errors, fmt.Errorf("failed to remove symlink %s: %w", link.Target, err))
+					continue
+				}
+			}
+		}
+
+		l.lockFile.RemoveSymlink(link.Target)
+		result.Removed = append(result.Removed, link.Target)
+		l.record(result, plan.Action{Kind: plan.Remove, Source: link.Source, Target: link.Target, Reason: "package is no longer configured"})
+	}
+
+	return result
+}
+
+// changedSince describes how a target no longer holds what farm put there:
+// a symlink that was replaced or now points elsewhere, or a file whose
+// content differs from what farm wrote. It is empty when the target is
+// unchanged or already gone.
+func (l *Linker) changedSince(link lockfile.Symlink) string {
+	info, err := l.lstat(link.Target)
+	if err != nil {
+		return ""
+	}
+
+	if link.IsFile() {
+		if !info.Mode().IsRegular() {
+			return "it was replaced since farm wrote it"
+		}
+		if link.Checksum == "" {
+			return ""
+		}
+		content, err := os.ReadFile(link.Target)
+		if err != nil {
+			return fmt.Sprintf("it can't be read: %v", err)
+		}
+		if fsutil.Checksum(content) != link.Checksum {
+			return "it was modified since farm wrote it"
+		}
+		return ""
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "it was replaced since farm linked it"
+	}
+	dest, err := os.Readlink(link.Target)
+	if err != nil {
+		return fmt.Sprintf("it can't be read: %v", err)
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(link.Target), dest)
+	}
+	if !fsutil.SamePath(dest, link.Source) && (link.Resolved == "" || !fsutil.SamePath(dest, link.Resolved)) {
+		return "it now points to " + dest
+	}
+	return ""
+}
+
 func (l *Linker) Unlink() (*LinkResult, error) {
+	return l.unlink(func(lockfile.Symlink) bool { return true })
+}
+
+// UnlinkPackages removes only the links that belong to the configured
+// packages, leaving everything else in the lockfile alone.
+func (l *Linker) UnlinkPackages() (*LinkResult, error) {
+	return l.unlink(func(link lockfile.Symlink) bool {
+		return l.config.FindPackage(link.Source) != nil
+	})
+}
+
+func (l *Linker) unlink(match func(lockfile.Symlink) bool) (*LinkResult, error) {
 	result := &LinkResult{
 		Removed: []string{},
 		Errors:  []error{},
 	}
 
+	var directories []string
 	for _, link := range l.lockFile.Symlinks.Sorted() {
+		if !match(link) {
+			continue
+		}
+
+		if link.Kind == lockfile.KindDirectory {
+			directories = append(directories, link.Target)
+			continue
+		}
+
+		// Leave anything that was replaced or edited since farm created it
+		if changed := l.changedSince(link); changed != "" {
+			l.lockFile.RemoveSymlink(link.Target)
+			result.Warnings = append(result.Warnings, fmt.Sprintf("kept %s, %s", link.Target, changed))
+			continue
+		}
+
 		if !l.dryRun {
-			if err := os.Remove(link.Target); err != nil && !os.IsNotExist(err) {
+			if err := l.remove(link.Target); err != nil && !os.IsNotExist(err) {
 				result.Errors = append(result.Errors, fmt.Errorf("failed to remove symlink %s: %w", link.Target, err))
 				continue
 			}
 		}
 
+		if link.Kind == lockfile.KindFont {
+			result.FontsChanged = true
+		}
 		l.lockFile.RemoveSymlink(link.Target)
 		result.Removed = append(result.Removed, link.Target)
+		l.record(result, plan.Action{Kind: plan.Remove, Source: link.Source, Target: link.Target, Reason: "unlinked"})
+	}
+
+	// Directories go last, deepest first, once the links inside are gone
+	for i := len(directories) - 1; i >= 0; i-- {
+		l.removeDirectory(directories[i], result)
+	}
+
+	l.removeEmptyDirs(result)
+
+	for _, pkg := range l.config.Packages {
+		l.lockFile.RemoveApplied(pkg.Source)
+		l.lockFile.RemoveConfigHash(pkg.Source)
+		for source := range l.lockFile.Folds {
+			if strings.HasPrefix(source, pkg.Source+"/") {
+				l.lockFile.RemoveFoldDecision(source)
+			}
+		}
 	}
 
 	return result, nil
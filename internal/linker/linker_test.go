@@ -1,12 +1,23 @@
 package linker
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsutil"
 	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/transform"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -109,6 +120,54 @@ func TestLinkMultipleTargets(t *testing.T) {
 	}
 }
 
+func TestTargetFailureIsolation(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	goodDir := filepath.Join(tmpDir, "good")
+	badDir := filepath.Join(tmpDir, "bad")
+
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(badDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "test.txt"), []byte("test content"), 0644))
+
+	// An unmanaged file blocks the link in one of the targets
+	require.NoError(t, os.WriteFile(filepath.Join(badDir, "test.txt"), []byte("local"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Name:    "test",
+				Source:  sourceDir,
+				Targets: []string{goodDir, badDir},
+			},
+		},
+	}
+
+	// By default targets are applied in lock-step
+	result, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	require.Len(t, result.Errors, 2)
+
+	var targetErr *TargetError
+	require.ErrorAs(t, result.Errors[0], &targetErr)
+	assert.Equal(t, badDir, targetErr.Target)
+	assert.ErrorContains(t, targetErr, "already exists and is not a symlink")
+
+	require.ErrorAs(t, result.Errors[1], &targetErr)
+	assert.Equal(t, goodDir, targetErr.Target)
+	assert.ErrorIs(t, targetErr, ErrTargetSkipped)
+	assert.NoFileExists(t, filepath.Join(goodDir, "test.txt"))
+
+	// Skipping failed targets completes the healthy ones
+	cfg.Settings.SkipFailedTargets = true
+	result, err = New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(goodDir, "test.txt")}, result.Created)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, "test -> "+badDir+": target "+filepath.Join(badDir, "test.txt")+" already exists and is not a symlink", result.Errors[0].Error())
+}
+
 func TestFoldingBehavior(t *testing.T) {
 	_, sourceDir, targetDir := setupTestEnvironment(t)
 
@@ -165,7 +224,9 @@ func TestRemoveDeadLinks(t *testing.T) {
 	require.NoError(t, os.Remove(deadSource))
 
 	cfg := &config.Config{
-		Packages: []*config.Package{},
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}},
+		},
 	}
 
 	linker := New(cfg, lock, false)
@@ -179,6 +240,35 @@ func TestRemoveDeadLinks(t *testing.T) {
 	assert.True(t, os.IsNotExist(err))
 }
 
+func TestRemoveDeadLinksScoped(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	// The source of another environment's package is on an unmounted drive
+	otherSource := filepath.Join(tmpDir, "mnt", "work", "dead.txt")
+	deadTarget := filepath.Join(targetDir, "dead.txt")
+	require.NoError(t, os.Symlink(otherSource, deadTarget))
+
+	lock := lockfile.New()
+	lock.AddSymlink(deadTarget, otherSource, false)
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}},
+		},
+	}
+
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Removed)
+	assert.Contains(t, lock.Symlinks, deadTarget)
+
+	cfg.Settings.CleanAll = true
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{deadTarget}, result.Removed)
+	assert.NotContains(t, lock.Symlinks, deadTarget)
+}
+
 func TestDryRun(t *testing.T) {
 	_, sourceDir, targetDir := setupTestEnvironment(t)
 
@@ -233,6 +323,155 @@ func TestUnlink(t *testing.T) {
 	assert.True(t, os.IsNotExist(err))
 }
 
+func TestUnlinkEmptyDirs(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	for _, file := range []string{".config/nvim/lua/init.lua", ".config/nvim/init.lua", ".config/git/config", ".zshrc"} {
+		path := filepath.Join(sourceDir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+	}
+	// Files farm doesn't manage keep their directory
+	require.NoError(t, os.MkdirAll(filepath.Join(targetDir, ".config/git"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, ".config/git/local"), []byte("local"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	nvim := filepath.Join(targetDir, ".config/nvim")
+	lua := filepath.Join(nvim, "lua")
+
+	result, err := New(cfg, lock.Clone(), true).Unlink()
+	require.NoError(t, err)
+	assert.Equal(t, []string{lua, nvim}, result.EmptyDirs)
+	assert.DirExists(t, lua)
+
+	cfg.Settings.RemoveEmptyDirs = true
+	result, err = New(cfg, lock, false).Unlink()
+	require.NoError(t, err)
+	assert.Equal(t, []string{lua, nvim}, result.EmptyDirs)
+	assert.NoDirExists(t, nvim)
+	assert.FileExists(t, filepath.Join(targetDir, ".config/git/local"))
+
+	// The target itself stays even when it's left empty
+	assert.DirExists(t, targetDir)
+}
+
+func TestUnlinkPackages(t *testing.T) {
+	tmpDir, _, targetDir := setupTestEnvironment(t)
+
+	var sources []string
+	for _, name := range []string{"vim", "zsh"} {
+		source := filepath.Join(tmpDir, name)
+		require.NoError(t, os.MkdirAll(source, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(source, name+"rc"), []byte(name), 0644))
+		sources = append(sources, source)
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sources[0], Targets: []string{targetDir}},
+			{Source: sources[1], Targets: []string{targetDir}},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	cfg.Packages = cfg.Packages[:1]
+	result, err := New(cfg, lock, false).UnlinkPackages()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{filepath.Join(targetDir, "vimrc")}, result.Removed)
+	assert.NoFileExists(t, filepath.Join(targetDir, "vimrc"))
+	assert.FileExists(t, filepath.Join(targetDir, "zshrc"))
+	assert.Contains(t, lock.Symlinks, filepath.Join(targetDir, "zshrc"))
+}
+
+func TestUnlinkKeepsChangedTargets(t *testing.T) {
+	tmpDir, _, targetDir := setupTestEnvironment(t)
+
+	linked := filepath.Join(tmpDir, "linked")
+	copied := filepath.Join(tmpDir, "copied")
+	for _, source := range []string{linked, copied} {
+		require.NoError(t, os.MkdirAll(source, 0755))
+	}
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, os.WriteFile(filepath.Join(linked, name), []byte(name), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(copied, name+".copy"), []byte(name), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: linked, Targets: []string{targetDir}},
+			{Source: copied, Targets: []string{targetDir}, Copy: true},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	// A program saved over one link, and one copy was edited in place
+	replaced := filepath.Join(targetDir, "a")
+	require.NoError(t, os.Remove(replaced))
+	require.NoError(t, os.WriteFile(replaced, []byte("saved"), 0644))
+	edited := filepath.Join(targetDir, "a.copy")
+	require.NoError(t, os.WriteFile(edited, []byte("edited"), 0644))
+
+	result, err := New(cfg, lock, false).Unlink()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(targetDir, "b"), filepath.Join(targetDir, "b.copy")}, result.Removed)
+	assert.Len(t, result.Warnings, 2)
+	assert.Empty(t, lock.Symlinks)
+
+	content, err := os.ReadFile(replaced)
+	require.NoError(t, err)
+	assert.Equal(t, "saved", string(content))
+	content, err = os.ReadFile(edited)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", string(content))
+}
+
+func TestRemoveDeadLinksKeepsEditedCopies(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}, Copy: true},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	edited := filepath.Join(targetDir, "a")
+	require.NoError(t, os.WriteFile(edited, []byte("edited"), 0644))
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, os.Remove(filepath.Join(sourceDir, name)))
+	}
+
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(targetDir, "b")}, result.Removed)
+	assert.Len(t, result.Warnings, 1)
+	assert.FileExists(t, edited)
+	assert.NotContains(t, lock.Symlinks, edited)
+}
+
 func TestReplaceExistingSymlink(t *testing.T) {
 	_, sourceDir, targetDir := setupTestEnvironment(t)
 
@@ -261,12 +500,59 @@ func TestReplaceExistingSymlink(t *testing.T) {
 	result, err := linker.Link()
 	require.NoError(t, err)
 	assert.Len(t, result.Created, 2)
+	assert.Equal(t, []string{targetFile}, result.Replaced)
 
 	content, err := os.ReadFile(targetFile)
 	require.NoError(t, err)
 	assert.Equal(t, "new", string(content))
 }
 
+func TestForeignLinks(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+	t.Setenv("STOW_DIR", "")
+
+	// A stow directory the machine was set up with before farm
+	stowed := filepath.Join(tmpDir, "dotfiles", "zsh", ".zshrc")
+	require.NoError(t, os.MkdirAll(filepath.Dir(stowed), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "dotfiles", ".stow"), nil, 0644))
+	require.NoError(t, os.WriteFile(stowed, []byte("stow"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".zshrc"), []byte("farm"), 0644))
+
+	target := filepath.Join(targetDir, ".zshrc")
+	require.NoError(t, os.Symlink(stowed, target))
+
+	link := func(foreign config.ForeignLinks) *LinkResult {
+		cfg := &config.Config{
+			Packages: []*config.Package{{Source: sourceDir, Targets: []string{targetDir}}},
+			Settings: config.Settings{ForeignLinks: foreign},
+		}
+		result, err := New(cfg, lockfile.New(), false).Link()
+		require.NoError(t, err)
+		return result
+	}
+
+	result := link("")
+	require.Len(t, result.Errors, 1)
+	var conflict *ConflictError
+	require.ErrorAs(t, result.Errors[0], &conflict)
+	assert.Equal(t, "stow", conflict.Manager)
+	assert.ErrorContains(t, result.Errors[0], "links into stow's directory "+filepath.Join(tmpDir, "dotfiles"))
+
+	result = link(config.ForeignKeep)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{"kept " + target + ", it links into stow's directory " + filepath.Join(tmpDir, "dotfiles")}, result.Warnings)
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "stow", string(content))
+
+	result = link(config.ForeignReplace)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{target}, result.Replaced)
+	content, err = os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "farm", string(content))
+}
+
 func TestIgnorePatterns(t *testing.T) {
 	_, sourceDir, targetDir := setupTestEnvironment(t)
 
@@ -290,7 +576,7 @@ func TestIgnorePatterns(t *testing.T) {
 	}
 
 	// Validate config to compile ignore patterns
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -336,7 +622,7 @@ func TestCustomIgnorePatterns(t *testing.T) {
 	}
 
 	// Validate config to compile ignore patterns
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -372,7 +658,7 @@ func TestExistingSymlinkAddedToLockfile(t *testing.T) {
 		},
 	}
 
-	err = cfg.Validate()
+	cfg, err = cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -516,7 +802,7 @@ func TestMultiLevelIgnorePatterns(t *testing.T) {
 		},
 	}
 
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -537,6 +823,16 @@ func TestMultiLevelIgnorePatterns(t *testing.T) {
 		assert.NotContains(t, created, "annotations/file.lua")
 		assert.NotContains(t, created, "path/file.txt")
 	}
+
+	// Each ignored path is reported with the pattern that excluded it
+	var ignored []string
+	for _, file := range result.Ignored {
+		ignored = append(ignored, file.Source+" "+file.Pattern)
+	}
+	assert.Equal(t, []string{
+		filepath.Join(emmyDir, "annotations") + " EmmyLua.spoon/annotations",
+		filepath.Join(sourceDir, "deep", "nested", "path") + " nested/path",
+	}, ignored)
 }
 
 func TestMultiLevelNoFoldPatterns(t *testing.T) {
@@ -577,7 +873,7 @@ func TestMultiLevelNoFoldPatterns(t *testing.T) {
 		},
 	}
 
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -642,7 +938,7 @@ func TestMultiLevelFoldPatterns(t *testing.T) {
 		},
 	}
 
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -701,7 +997,7 @@ func TestMixedMultiLevelPatterns(t *testing.T) {
 		},
 	}
 
-	err := cfg.Validate()
+	cfg, err := cfg.Resolve()
 	require.NoError(t, err)
 
 	lock := lockfile.New()
@@ -726,3 +1022,1328 @@ func TestMixedMultiLevelPatterns(t *testing.T) {
 	// Verify count (bin folded + settings.json individual)
 	assert.Equal(t, 2, len(result.Created))
 }
+
+func TestFoldFollowsSourceTree(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	defer func(goos, goarch string) { variantOS, variantArch = goos, goarch }(variantOS, variantArch)
+	variantOS, variantArch = "linux", "amd64"
+
+	dir := filepath.Join(sourceDir, "d")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("generic"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}, Variants: true, DefaultFold: true},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	info, err := os.Lstat(filepath.Join(targetDir, "d"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink)
+
+	// A variant added later unfolds the directory on the next link
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.linux"), []byte("linux"), 0644))
+	_, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	info, err = os.Lstat(filepath.Join(targetDir, "d"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	content, err := os.ReadFile(filepath.Join(targetDir, "d", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, "linux", string(content))
+}
+
+func TestUnusedPatterns(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, ".config", "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".config", "nvim", "init.lua"), []byte("init"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "notes.bak"), []byte("bak"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Name:    "dotfiles",
+				Source:  sourceDir,
+				Targets: []string{targetDir},
+				Fold:    []string{".config/nvim"},
+				NoFold:  []string{".config/nivm"},
+			},
+		},
+		Ignore: []string{"*.bak", "*.orig"},
+	}
+	cfg, err := cfg.Resolve()
+	require.NoError(t, err)
+
+	expected := []Pattern{
+		{Kind: PatternIgnore, Pattern: "*.orig"},
+		{Package: "dotfiles", Kind: PatternNoFold, Pattern: ".config/nivm"},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, expected, result.UnusedPatterns)
+
+	// Patterns count as used when the fold decision comes from the cache
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, expected, result.UnusedPatterns)
+
+	// Patterns scoped to packages that weren't linked aren't unused
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "scratch"), []byte("scratch"), 0644))
+	cfg.Ignore = append(cfg.Ignore, "dotfiles:/scratch", "other:*.tmp")
+	cfg.IgnoreGlobs = append(cfg.IgnoreGlobs, "dotfiles:/scratch", "other:*.tmp")
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, expected, result.UnusedPatterns)
+}
+
+func TestAutoFolding(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "nvim", "lua"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "nvim", "lua", "init.lua"), []byte("lua"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "git"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "git", "config"), []byte("git"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "git", "cache.tmp"), []byte("tmp"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "zsh"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "zsh", "zshrc"), []byte("zsh"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "fish", "secret"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fish", "secret", "key"), []byte("key"), 0644))
+
+	// A second package that contributes to the zsh directory
+	workDir := filepath.Join(tmpDir, "work")
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "zsh"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "zsh", "work.zsh"), []byte("work"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:   sourceDir,
+				Targets:  []string{targetDir},
+				AutoFold: true,
+				NoFold:   []string{"fish/secret"},
+			},
+			{
+				Source:  workDir,
+				Targets: []string{targetDir},
+			},
+		},
+		IgnoreGlobs: []string{"*.tmp"},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	isSymlink := func(path string) bool {
+		info, err := os.Lstat(filepath.Join(targetDir, path))
+		require.NoError(t, err)
+		return info.Mode()&os.ModeSymlink != 0
+	}
+
+	assert.True(t, isSymlink("nvim"), "directory owned by one package should be folded")
+	assert.False(t, isSymlink("git"), "directory containing ignored files should not be folded")
+	assert.True(t, isSymlink("git/config"))
+	assert.False(t, isSymlink("zsh"), "directory shared with another package should not be folded")
+	assert.True(t, isSymlink("zsh/zshrc"))
+	assert.True(t, isSymlink("zsh/work.zsh"))
+	assert.False(t, isSymlink("fish"), "directory containing no_fold paths should not be folded")
+	assert.False(t, isSymlink("fish/secret"))
+}
+
+func TestUnfold(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	nvimDir := filepath.Join(sourceDir, "nvim")
+	require.NoError(t, os.MkdirAll(filepath.Join(nvimDir, "lua"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(nvimDir, "init.lua"), []byte("init"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(nvimDir, "lua", "plugins.lua"), []byte("plugins"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:  sourceDir,
+				Targets: []string{targetDir},
+				Fold:    []string{"nvim"},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+
+	nvimTarget := filepath.Join(targetDir, "nvim")
+	require.True(t, lock.Symlinks[nvimTarget].IsFolded)
+
+	result, err := New(cfg, lock, false).Unfold(nvimTarget)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(nvimTarget, "init.lua"),
+		filepath.Join(nvimTarget, "lua"),
+	}, result.Created)
+
+	info, err := os.Lstat(nvimTarget)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.NotContains(t, lock.Symlinks, nvimTarget)
+	assert.True(t, lock.Symlinks[filepath.Join(nvimTarget, "lua")].IsFolded)
+
+	// A machine-local file can now live next to the linked files, and
+	// relinking keeps the directory unfolded
+	require.NoError(t, os.WriteFile(filepath.Join(nvimTarget, "local.lua"), []byte("local"), 0644))
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.FileExists(t, filepath.Join(nvimTarget, "local.lua"))
+
+	// Editing the folding config doesn't fold it again either
+	cfg.Packages[0].NoFold = []string{"other"}
+	cfg.Packages[0].DefaultFold = true
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.True(t, lock.IsUnfolded(nvimDir))
+	assert.FileExists(t, filepath.Join(nvimTarget, "local.lua"))
+
+	_, err = New(cfg, lock, false).Unfold(filepath.Join(nvimTarget, "init.lua"))
+	assert.ErrorContains(t, err, "is not a folded directory")
+
+	// Unlinking the package forgets the unfold
+	clone := lock.Clone()
+	_, err = New(cfg, clone, true).Unlink()
+	require.NoError(t, err)
+	assert.False(t, clone.IsUnfolded(nvimDir))
+
+	// Folding it again needs the local file out of the way
+	_, err = New(cfg, lock, false).Fold(nvimTarget)
+	assert.ErrorContains(t, err, "isn't managed by farm")
+	assert.True(t, lock.IsUnfolded(nvimDir))
+
+	require.NoError(t, os.Remove(filepath.Join(nvimTarget, "local.lua")))
+	result, err = New(cfg, lock, false).Fold(nvimTarget)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{nvimTarget}, result.Created)
+	assert.False(t, lock.IsUnfolded(nvimDir))
+	assert.True(t, lock.Symlinks[nvimTarget].IsFolded)
+	assert.NotContains(t, lock.Symlinks, filepath.Join(nvimTarget, "init.lua"))
+	info, err = os.Lstat(nvimTarget)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink)
+
+	_, err = New(cfg, lock, false).Fold(nvimTarget)
+	assert.ErrorContains(t, err, "was not unfolded with farm unfold")
+
+	_, err = New(cfg, lock, false).Unfold(filepath.Join(targetDir, "unknown"))
+	assert.ErrorContains(t, err, "is not managed by farm")
+}
+
+func TestOrphans(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "kept.txt"), []byte("kept"), 0644))
+
+	removedDir := filepath.Join(tmpDir, "removed")
+	require.NoError(t, os.MkdirAll(removedDir, 0755))
+	orphanSource := filepath.Join(removedDir, "orphan.txt")
+	require.NoError(t, os.WriteFile(orphanSource, []byte("orphan"), 0644))
+
+	orphanTarget := filepath.Join(targetDir, "orphan.txt")
+	require.NoError(t, os.Symlink(orphanSource, orphanTarget))
+
+	// The orphan's target was replaced by a regular file
+	replacedTarget := filepath.Join(targetDir, "replaced.txt")
+	require.NoError(t, os.WriteFile(replacedTarget, []byte("real"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:  sourceDir,
+				Targets: []string{targetDir},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	lock.AddSymlink(orphanTarget, orphanSource, false)
+	lock.AddSymlink(replacedTarget, filepath.Join(removedDir, "replaced.txt"), false)
+
+	l := New(cfg, lock, false)
+	orphans := l.Orphans()
+	require.Len(t, orphans, 2)
+	assert.Equal(t, orphanTarget, orphans[0].Target)
+	assert.Equal(t, replacedTarget, orphans[1].Target)
+
+	result := l.RemoveOrphans(orphans)
+	assert.Empty(t, result.Errors)
+	assert.ElementsMatch(t, []string{orphanTarget, replacedTarget}, result.Removed)
+
+	_, err = os.Lstat(orphanTarget)
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, replacedTarget, "regular files are never removed")
+	assert.Len(t, lock.Symlinks, 1)
+	assert.Contains(t, lock.Symlinks, filepath.Join(targetDir, "kept.txt"))
+}
+
+func TestEquivalentSymlinkNotReplaced(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	testFile := filepath.Join(sourceDir, "test.txt")
+	require.NoError(t, os.WriteFile(testFile, []byte("test"), 0644))
+
+	// The existing link reaches the source through a symlinked directory
+	aliasDir := filepath.Join(tmpDir, "alias")
+	require.NoError(t, os.Symlink(sourceDir, aliasDir))
+	existingDest := filepath.Join(aliasDir, "test.txt")
+	targetLink := filepath.Join(targetDir, "test.txt")
+	require.NoError(t, os.Symlink(existingDest, targetLink))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:  sourceDir,
+				Targets: []string{targetDir},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Contains(t, lock.Symlinks, targetLink)
+
+	dest, err := os.Readlink(targetLink)
+	require.NoError(t, err)
+	assert.Equal(t, existingDest, dest, "equivalent link should not be rewritten")
+
+	// The link is not considered dead on the next run either
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Removed)
+	assert.Empty(t, result.Created)
+}
+
+func TestDereference(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	theme := filepath.Join(sourceDir, "dark.theme")
+	require.NoError(t, os.WriteFile(theme, []byte("dark"), 0644))
+	current := filepath.Join(sourceDir, "current.theme")
+	require.NoError(t, os.Symlink("dark.theme", current))
+
+	pkg := &config.Package{Source: sourceDir, Targets: []string{targetDir}}
+	cfg := &config.Config{Packages: []*config.Package{pkg}}
+	target := filepath.Join(targetDir, "current.theme")
+
+	// By default the link points at the symlink in the source
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	dest, err := os.Readlink(target)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "source", "current.theme"), dest)
+	assert.Empty(t, lock.Symlinks[target].Resolved)
+
+	// Dereferencing replaces it with a link to the resolved file
+	pkg.Dereference = true
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Contains(t, result.Replaced, target)
+	dest, err = os.Readlink(target)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "source", "dark.theme"), dest)
+	assert.Equal(t, current, lock.Symlinks[target].Source)
+	assert.Equal(t, theme, lock.Symlinks[target].Resolved)
+
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Replaced)
+	assert.Empty(t, result.Removed)
+}
+
+func TestSymlinkPlaceholders(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "dark.theme"), []byte("dark"), 0644))
+	require.NoError(t, os.Symlink("dark.theme", filepath.Join(sourceDir, "current.theme")))
+	require.NoError(t, os.Symlink("missing.theme", filepath.Join(sourceDir, "broken.theme")))
+	require.NoError(t, exec.Command("git", "init", "-q", sourceDir).Run())
+	require.NoError(t, exec.Command("git", "-C", sourceDir, "add", ".").Run())
+
+	// Check the links out the way git does with core.symlinks=false
+	for _, name := range []string{"current.theme", "broken.theme"} {
+		path := filepath.Join(sourceDir, name)
+		dest, err := os.Readlink(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(path))
+		require.NoError(t, os.WriteFile(path, []byte(dest), 0644))
+	}
+
+	pkg := &config.Package{Source: sourceDir, Targets: []string{targetDir}}
+	cfg := &config.Config{Packages: []*config.Package{pkg}, IgnoreGlobs: []string{".git*"}}
+	current := filepath.Join(targetDir, "current.theme")
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	require.Len(t, result.Warnings, 1)
+	assert.Contains(t, result.Warnings[0], "broken.theme")
+	assert.NoFileExists(t, filepath.Join(targetDir, "broken.theme"))
+
+	// The link points at the file the placeholder names
+	content, err := os.ReadFile(current)
+	require.NoError(t, err)
+	assert.Equal(t, "dark", string(content))
+	assert.Equal(t, filepath.Join(sourceDir, "dark.theme"), lock.Symlinks[current].Resolved)
+
+	dead, err := lock.GetDeadSymlinks()
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+
+	// Or a copy of it
+	pkg.CopyPlaceholders = true
+	_, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	info, err := os.Lstat(current)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, lockfile.KindCopy, lock.Symlinks[current].Kind)
+}
+
+func TestTransformers(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	for _, name := range []string{"plain.txt", "secret.enc", "copied.txt", "skipped.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0644))
+	}
+
+	transform.Register("linker-test", transform.Func(func(in transform.Input) (*transform.Output, error) {
+		switch in.RelativePath {
+		case "secret.enc":
+			return &transform.Output{Action: transform.ActionRender, Content: []byte("decrypted"), Mode: 0600}, nil
+		case "copied.txt":
+			return &transform.Output{Action: transform.ActionCopy}, nil
+		case "skipped.txt":
+			return &transform.Output{Action: transform.ActionSkip}, nil
+		}
+		return nil, nil
+	}))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:    sourceDir,
+				Targets:   []string{targetDir},
+				Transform: []string{"linker-test"},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Len(t, result.Created, 3)
+
+	info, err := os.Lstat(filepath.Join(targetDir, "plain.txt"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+
+	rendered := filepath.Join(targetDir, "secret.enc")
+	info, err = os.Lstat(rendered)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := os.ReadFile(rendered)
+	require.NoError(t, err)
+	assert.Equal(t, "decrypted", string(content))
+	assert.Equal(t, lockfile.KindRender, lock.Symlinks[rendered].Kind)
+
+	copied := filepath.Join(targetDir, "copied.txt")
+	content, err = os.ReadFile(copied)
+	require.NoError(t, err)
+	assert.Equal(t, "copied.txt", string(content))
+	assert.Equal(t, lockfile.KindCopy, lock.Symlinks[copied].Kind)
+
+	_, err = os.Lstat(filepath.Join(targetDir, "skipped.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Relinking unchanged files is a no-op
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Replaced)
+	assert.Empty(t, result.Diffs)
+
+	// Dry runs show how the content of copies would change
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "copied.txt"), []byte("changed\n"), 0644))
+	result, err = New(cfg, lock.Clone(), true).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{copied}, result.Replaced)
+	assert.Contains(t, result.Diffs[copied], "+++ "+copied+" (copy)\n")
+	assert.Contains(t, result.Diffs[copied], "-copied.txt\n+changed\n")
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "copied.txt"), []byte("copied.txt"), 0644))
+
+	// What farm would write is rendered again regardless of local edits
+	require.NoError(t, os.WriteFile(rendered, []byte("edited"), 0600))
+	expected, err := New(cfg, lock, false).Expected(rendered)
+	require.NoError(t, err)
+	assert.Equal(t, "decrypted", string(expected))
+	content, err = os.ReadFile(rendered)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", string(content))
+	_, err = New(cfg, lock, false).Expected(filepath.Join(targetDir, "plain.txt"))
+	assert.ErrorContains(t, err, "isn't a copied or rendered file")
+
+	// Copies are cleaned up once their source is removed
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "copied.txt")))
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{copied}, result.Removed)
+	_, err = os.Lstat(copied)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPersistentErrors(t *testing.T) {
+	persistent := fmt.Errorf("failed to create symlink: %w", &fsutil.RetryError{Attempts: 3, Err: syscall.ESTALE})
+	other := fmt.Errorf("target /home/user/.vimrc already exists and is not a symlink")
+
+	result := &LinkResult{Errors: []error{other, persistent}}
+	assert.Equal(t, []error{persistent}, result.Persistent())
+}
+
+func TestDirectories(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("file"), 0644))
+
+	cacheDir := filepath.Join(tmpDir, "cache", "foo")
+	pkg := &config.Package{
+		Source:  sourceDir,
+		Targets: []string{targetDir},
+		Directories: []config.Directory{
+			{Path: cacheDir, Mode: 0700},
+			{Path: ".local/bin"},
+		},
+	}
+	cfg := &config.Config{Packages: []*config.Package{pkg}}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+
+	binDir := filepath.Join(targetDir, ".local", "bin")
+	assert.Contains(t, result.Created, cacheDir)
+	assert.Contains(t, result.Created, binDir)
+
+	info, err := os.Stat(cacheDir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	assert.DirExists(t, binDir)
+	assert.Equal(t, lockfile.KindDirectory, lock.Symlinks[cacheDir].Kind)
+
+	// Relinking leaves existing directories alone
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Removed)
+
+	// Unlink removes empty directories but keeps ones with contents
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "tool"), []byte("tool"), 0755))
+	result, err = New(cfg, lock, false).Unlink()
+	require.NoError(t, err)
+	assert.Contains(t, result.Removed, cacheDir)
+	assert.NotContains(t, result.Removed, binDir)
+
+	_, err = os.Stat(cacheDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(binDir, "tool"))
+	assert.Empty(t, lock.Symlinks)
+
+	// Directories dropped from the config are no longer tracked
+	_, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	pkg.Directories = pkg.Directories[1:]
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Contains(t, result.Removed, cacheDir)
+	assert.NotContains(t, lock.Symlinks, cacheDir)
+}
+
+func TestParentDirMode(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, ".gnupg", "keys"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".gnupg", "keys", "gpg.conf"), []byte("conf"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}, ParentDirMode: 0700},
+		},
+	}
+
+	gnupg := filepath.Join(targetDir, ".gnupg")
+	keys := filepath.Join(gnupg, "keys")
+
+	// Dry runs report the directories and their modes without creating them
+	result, err := New(cfg, lockfile.New(), true).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []CreatedDir{{Path: gnupg, Mode: 0700}, {Path: keys, Mode: 0700}}, result.CreatedDirs)
+	assert.NoDirExists(t, gnupg)
+
+	result, err = New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Len(t, result.CreatedDirs, 2)
+
+	for _, dir := range []string{gnupg, keys} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+
+	// Existing directories are not reported again
+	result, err = New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.CreatedDirs)
+}
+
+func TestOptionalTargets(t *testing.T) {
+	tmpDir, sourceDir, _ := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "settings.json"), []byte("{}"), 0644))
+
+	code := filepath.Join(tmpDir, "Code", "User")
+	cursor := filepath.Join(tmpDir, "Cursor", "User")
+	zed := filepath.Join(tmpDir, "Zed", "User")
+	require.NoError(t, os.MkdirAll(filepath.Dir(code), 0755))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:          sourceDir,
+				Targets:         []string{code, cursor, zed},
+				OptionalTargets: []string{code, cursor},
+				Directories:     []config.Directory{{Path: "cache"}},
+			},
+		},
+	}
+
+	result, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	// Optional targets are only skipped when the app's directory is missing
+	assert.FileExists(t, filepath.Join(code, "settings.json"))
+	assert.Equal(t, []string{cursor}, result.SkippedTargets)
+	assert.NoDirExists(t, filepath.Dir(cursor))
+
+	// Other targets are still created, but the missing base is reported
+	assert.FileExists(t, filepath.Join(zed, "settings.json"))
+	assert.Equal(t, []string{filepath.Dir(zed)}, result.MissingBases)
+}
+
+func TestOnlyIfTargetExists(t *testing.T) {
+	tmpDir, sourceDir, _ := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "settings.json"), []byte("{}"), 0644))
+
+	code := filepath.Join(tmpDir, "Code", "User")
+	cursor := filepath.Join(tmpDir, "Cursor", "User")
+	require.NoError(t, os.MkdirAll(code, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Dir(cursor), 0755))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:          sourceDir,
+				Targets:         []string{code, cursor},
+				ExistingTargets: []string{code, cursor},
+			},
+		},
+	}
+
+	result, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.FileExists(t, filepath.Join(code, "settings.json"))
+	assert.Equal(t, []string{cursor}, result.SkippedTargets)
+	assert.NoDirExists(t, cursor)
+}
+
+func TestCaseCollisions(t *testing.T) {
+	tmpDir, _, targetDir := setupTestEnvironment(t)
+
+	upper := filepath.Join(tmpDir, "upper")
+	lower := filepath.Join(tmpDir, "lower")
+	require.NoError(t, os.MkdirAll(upper, 0755))
+	require.NoError(t, os.MkdirAll(lower, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(upper, "Foo.conf"), []byte("upper"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(lower, "foo.conf"), []byte("lower"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: upper, Targets: []string{targetDir}},
+			{Source: lower, Targets: []string{targetDir}},
+		},
+	}
+
+	t.Run("case-sensitive", func(t *testing.T) {
+		caseInsensitive = func(string) bool { return false }
+		t.Cleanup(func() { caseInsensitive = fsutil.CaseInsensitive })
+
+		result, err := New(cfg, lockfile.New(), true).Link()
+		require.NoError(t, err)
+		assert.Empty(t, result.Errors)
+		assert.Len(t, result.Created, 2)
+	})
+
+	t.Run("case-insensitive", func(t *testing.T) {
+		caseInsensitive = func(string) bool { return true }
+		t.Cleanup(func() { caseInsensitive = fsutil.CaseInsensitive })
+
+		result, err := New(cfg, lockfile.New(), false).Link()
+		require.NoError(t, err)
+		require.Len(t, result.Errors, 1)
+
+		var collision *CollisionError
+		require.ErrorAs(t, result.Errors[0], &collision)
+		assert.Equal(t, filepath.Join(targetDir, "foo.conf"), collision.Target)
+		assert.Equal(t, filepath.Join(targetDir, "Foo.conf"), collision.Other)
+
+		// The first link wins and the colliding one is never written
+		assert.Equal(t, []string{filepath.Join(targetDir, "Foo.conf")}, result.Created)
+		assert.NoFileExists(t, filepath.Join(targetDir, "foo.conf"))
+	})
+}
+
+func TestInvalidTargetPath(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("content"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{filepath.Join(targetDir, strings.Repeat("a", 256))}},
+		},
+	}
+
+	// The name is too long on every platform, so planning reports it
+	result, err := New(cfg, lockfile.New(), true).Link()
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.ErrorIs(t, result.Errors[0], fsutil.ErrInvalidPath)
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	// Names as macOS may return them, decomposed
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "cafe\u0301.conf"), []byte("content"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "re\u0301sume\u0301.md"), []byte("content"), 0644))
+
+	normalize := true
+	cfg := &config.Config{
+		Settings:    config.Settings{NormalizeUnicode: &normalize},
+		IgnoreGlobs: []string{"r\u00e9sum\u00e9.md"},
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, true).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	// The composed ignore pattern matches, and the target is tracked composed
+	assert.Equal(t, []string{filepath.Join(targetDir, "caf\u00e9.conf")}, result.Created)
+	assert.Contains(t, lock.Symlinks, filepath.Join(targetDir, "caf\u00e9.conf"))
+}
+
+func TestVariants(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	defer func(goos, arch string, host func() (string, error)) {
+		variantOS, variantArch, hostname = goos, arch, host
+	}(variantOS, variantArch, hostname)
+	variantOS, variantArch = "linux", "arm64"
+	hostname = func() (string, error) { return "workmac.local", nil }
+
+	for _, file := range []string{
+		"gitconfig", "gitconfig.darwin", "gitconfig.linux",
+		"zshrc.darwin", "tmux.conf", "tmux.conf.arm64", "tmux.conf@workmac",
+		"app.js", "nvim/init.lua", "nvim/init.lua.windows",
+	} {
+		path := filepath.Join(sourceDir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}, Variants: true, DefaultFold: true},
+		},
+	}
+
+	_, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+
+	for target, source := range map[string]string{
+		"gitconfig":     "gitconfig.linux",
+		"tmux.conf":     "tmux.conf@workmac",
+		"app.js":        "app.js",
+		"nvim/init.lua": "nvim/init.lua",
+	} {
+		content, err := os.ReadFile(filepath.Join(targetDir, target))
+		require.NoError(t, err, target)
+		assert.Equal(t, source, string(content))
+	}
+
+	// Variants for other machines aren't linked, and a directory with
+	// variants in it isn't folded
+	assert.NoFileExists(t, filepath.Join(targetDir, "zshrc"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "gitconfig.darwin"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "nvim/init.lua.windows"))
+	info, err := os.Lstat(filepath.Join(targetDir, "nvim"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestRequiredPaths(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "playlist"), []byte("content"), 0644))
+
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+
+	mount := filepath.Join(tmpDir, "mnt")
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Name:          "music",
+				Source:        sourceDir,
+				Targets:       []string{targetDir},
+				RequiresPaths: []config.RequiredPath{{Path: mount, Wait: 20 * time.Millisecond}},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	var missing *MissingPathError
+	require.ErrorAs(t, result.Errors[0], &missing)
+	assert.Equal(t, mount, missing.Path)
+	assert.Empty(t, result.Created)
+	assert.NoFileExists(t, filepath.Join(targetDir, "playlist"))
+
+	// The path showing up while farm waits is enough
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		_ = os.Mkdir(mount, 0755)
+	}()
+	cfg.Packages[0].RequiresPaths[0].Wait = 5 * time.Second
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.FileExists(t, filepath.Join(targetDir, "playlist"))
+}
+
+func TestTargetInSource(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".vimrc"), []byte("content"), 0644))
+
+	// A directory that leads back into the source through a symlink
+	alias := filepath.Join(tmpDir, "alias")
+	require.NoError(t, os.Symlink(sourceDir, alias))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Name: "vim", Source: sourceDir, Targets: []string{sourceDir, filepath.Join(alias, "nested"), targetDir}},
+		},
+	}
+
+	result, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 2)
+	for _, err := range result.Errors {
+		assert.ErrorIs(t, err, ErrTargetInSource)
+	}
+	assert.ErrorContains(t, result.Errors[0], "target is inside the source of package vim")
+
+	assert.Equal(t, []string{filepath.Join(targetDir, ".vimrc")}, result.Created)
+	assert.NoDirExists(t, filepath.Join(sourceDir, "nested"))
+}
+
+func TestNoFoldDepth(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		folded   []string
+		unfolded []string
+	}{
+		{"nvim", nil, []string{"nvim", "nvim/lua", "nvim/pack", "nvim/pack/start"}},
+		{"nvim/**", nil, []string{"nvim", "nvim/lua", "nvim/pack", "nvim/pack/start"}},
+		{"nvim/*", []string{"nvim/lua", "nvim/pack"}, []string{"nvim"}},
+		{"nvim/*/*", []string{"nvim/lua/user", "nvim/pack/start"}, []string{"nvim", "nvim/lua", "nvim/pack"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, sourceDir, targetDir := setupTestEnvironment(t)
+			for _, file := range []string{"nvim/init.lua", "nvim/lua/user/keys.lua", "nvim/pack/start/plugin/plugin.vim"} {
+				path := filepath.Join(sourceDir, file)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+			}
+
+			cfg := &config.Config{
+				Packages: []*config.Package{
+					{Source: sourceDir, Targets: []string{targetDir}, NoFold: []string{tt.pattern}},
+				},
+			}
+
+			result, err := New(cfg, lockfile.New(), false).Link()
+			require.NoError(t, err)
+			require.Empty(t, result.Errors)
+			assert.Empty(t, result.UnusedPatterns)
+
+			for _, dir := range tt.folded {
+				info, err := os.Lstat(filepath.Join(targetDir, dir))
+				require.NoError(t, err)
+				assert.True(t, info.Mode()&os.ModeSymlink != 0, "%s should be folded", dir)
+			}
+			for _, dir := range tt.unfolded {
+				info, err := os.Lstat(filepath.Join(targetDir, dir))
+				require.NoError(t, err)
+				assert.True(t, info.IsDir(), "%s should not be folded", dir)
+			}
+
+			content, err := os.ReadFile(filepath.Join(targetDir, "nvim/pack/start/plugin/plugin.vim"))
+			require.NoError(t, err)
+			assert.Equal(t, "nvim/pack/start/plugin/plugin.vim", string(content))
+		})
+	}
+}
+
+func TestFoldSharedDirectory(t *testing.T) {
+	tmpDir, _, targetDir := setupTestEnvironment(t)
+
+	nvim := filepath.Join(tmpDir, "nvim")
+	plugins := filepath.Join(tmpDir, "plugins")
+	for _, file := range []string{filepath.Join(nvim, ".config/nvim/init.lua"), filepath.Join(plugins, ".config/nvim/plugin/telescope.lua")} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Name: "nvim", Source: nvim, Targets: []string{targetDir}, DefaultFold: true},
+		},
+	}
+
+	// On its own the package folds its directories
+	lock := lockfile.New()
+	_, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	info, err := os.Lstat(filepath.Join(targetDir, ".config"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+
+	// Once another package links into them they're unfolded, even though
+	// the package's own fold decisions are cached
+	cfg.Packages = append(cfg.Packages, &config.Package{Name: "plugins", Source: plugins, Targets: []string{targetDir}})
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Contains(t, result.Warnings, "not folding "+filepath.Join(targetDir, ".config")+", package plugins also links into it")
+
+	info, err = os.Lstat(filepath.Join(targetDir, ".config/nvim"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.FileExists(t, filepath.Join(targetDir, ".config/nvim/init.lua"))
+	assert.FileExists(t, filepath.Join(targetDir, ".config/nvim/plugin/telescope.lua"))
+	assert.NoFileExists(t, filepath.Join(nvim, ".config/nvim/plugin/telescope.lua"))
+}
+
+func TestHeader(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	files := map[string]string{
+		"init.lua":    "vim.o.number = true\n",
+		"install.sh":  "#!/bin/sh\necho hi\n",
+		"style.css":   "body {}\n",
+		"data.json":   "{}\n",
+		"linked.yaml": "key: value\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644))
+	}
+
+	transform.Register("header-test", transform.Func(func(in transform.Input) (*transform.Output, error) {
+		if in.RelativePath == "linked.yaml" {
+			return nil, nil
+		}
+		return &transform.Output{Action: transform.ActionCopy}, nil
+	}))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:    sourceDir,
+				Targets:   []string{targetDir},
+				Transform: []string{"header-test"},
+				Header:    "managed by farm from {source}, do not edit",
+			},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(targetDir, name))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	header := "managed by farm from " + sourceDir
+	assert.Equal(t, "-- "+header+"/init.lua, do not edit\nvim.o.number = true\n", read("init.lua"))
+	assert.Equal(t, "#!/bin/sh\n# "+header+"/install.sh, do not edit\necho hi\n", read("install.sh"))
+	assert.Equal(t, "/* "+header+"/style.css, do not edit */\nbody {}\n", read("style.css"))
+
+	// JSON can't hold comments, and links show their source already
+	assert.Equal(t, "{}\n", read("data.json"))
+	assert.Equal(t, "key: value\n", read("linked.yaml"))
+
+	// Relinking recognizes the annotated files as up to date
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Replaced)
+}
+
+func TestFonts(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "JetBrainsMono"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "JetBrainsMono", "JetBrainsMono.ttf"), []byte("ttf"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "Inter.OTF"), []byte("otf"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "LICENSE.txt"), []byte("license"), 0644))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Source: sourceDir, Targets: []string{targetDir}, Fonts: true},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.True(t, result.FontsChanged)
+	assert.Len(t, result.Created, 2)
+
+	// Fonts are copied, not linked, and other files are left out
+	font := filepath.Join(targetDir, "JetBrainsMono", "JetBrainsMono.ttf")
+	info, err := os.Lstat(font)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, lockfile.KindFont, lock.Symlinks[font].Kind)
+	assert.FileExists(t, filepath.Join(targetDir, "Inter.OTF"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "LICENSE.txt"))
+
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.False(t, result.FontsChanged)
+	assert.Empty(t, result.Created)
+
+	result, err = New(cfg, lock, false).Unlink()
+	require.NoError(t, err)
+	assert.True(t, result.FontsChanged)
+	assert.NoFileExists(t, font)
+	assert.Empty(t, lock.Symlinks)
+}
+
+func TestDownloads(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+
+	binary := []byte("#!/bin/sh\necho fzf\n")
+	sum := sha256.Sum256(binary)
+	checksum := hex.EncodeToString(sum[:])
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(binary)
+	}))
+	defer server.Close()
+
+	binDir := filepath.Join(tmpDir, "bin")
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Source:  sourceDir,
+				Targets: []string{targetDir},
+				Downloads: []config.Download{
+					{Name: "fzf", Dest: binDir, Variant: config.Variant{URL: server.URL + "/fzf", SHA256: checksum}},
+					{Name: "other-os", Dest: binDir, Variants: map[string]config.Variant{"plan9": {URL: server.URL, SHA256: checksum}}},
+				},
+			},
+		},
+	}
+
+	lock := lockfile.New()
+
+	// Dry runs don't download anything
+	result, err := New(cfg, lock.Clone(), true).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(binDir, "fzf")}, result.Created)
+	assert.Zero(t, requests)
+
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, 1, requests)
+
+	path := filepath.Join(binDir, "fzf")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.Equal(t, lockfile.KindDownload, lock.Symlinks[path].Kind)
+	assert.Equal(t, checksum, lock.Symlinks[path].SourceChecksum)
+	assert.Equal(t, checksum, lock.Symlinks[path].Checksum)
+	assert.NoFileExists(t, filepath.Join(binDir, "other-os"))
+
+	// Up to date downloads aren't fetched again unless refreshed
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Equal(t, 1, requests)
+
+	result, err = New(cfg, lock, false).UpdateDownloads()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created, "unchanged content is not rewritten")
+	assert.Equal(t, 2, requests)
+
+	// A bad checksum is reported and the file is left alone
+	cfg.Packages[0].Downloads[0].SHA256 = strings.Repeat("0", 64)
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.ErrorContains(t, result.Errors[0], "checksum mismatch")
+	assert.FileExists(t, path)
+
+	// Downloads dropped from the config are removed
+	cfg.Packages[0].Downloads = nil
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, result.Removed)
+	assert.NoFileExists(t, path)
+}
+
+func TestRespectGitignore(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, ".git"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("*.log\nsource/nvim/plugin/\n"), 0644))
+
+	for _, file := range []string{".zshrc", "debug.log", "nvim/init.lua", "nvim/plugin/packer_compiled.lua", "tmux/tmux.conf"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, file), []byte("content"), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Name: "dotfiles", Source: sourceDir, Targets: []string{targetDir}, AutoFold: true},
+		},
+	}
+
+	// Without the setting .gitignore files are left alone
+	result, err := New(cfg, lockfile.New(), true).Link()
+	require.NoError(t, err)
+	assert.Contains(t, result.Created, filepath.Join(targetDir, "debug.log"))
+
+	cfg.Settings.RespectGitignore = true
+	result, err = New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+
+	assert.FileExists(t, filepath.Join(targetDir, ".zshrc"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "debug.log"))
+	assert.FileExists(t, filepath.Join(targetDir, "nvim", "init.lua"))
+	assert.NoDirExists(t, filepath.Join(targetDir, "nvim", "plugin"))
+
+	// Directories holding ignored files aren't folded, the rest still are
+	assert.False(t, isSymlink(filepath.Join(targetDir, "nvim")))
+	assert.True(t, isSymlink(filepath.Join(targetDir, "tmux")))
+}
+
+func TestOnlyTracked(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Name: "dotfiles", Source: sourceDir, Targets: []string{targetDir}, AutoFold: true, OnlyTracked: true},
+		},
+	}
+
+	// Outside a repository nothing is known to be tracked
+	result, err := New(cfg, lockfile.New(), true).Link()
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.ErrorContains(t, result.Errors[0], "package dotfiles only links tracked files")
+
+	require.NoError(t, exec.Command("git", "init", "-q", sourceDir).Run())
+	for _, file := range []string{".zshrc", "scratch.txt", "nvim/init.lua", "nvim/lazy-lock.json", "tmux/tmux.conf"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, file), []byte("content"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "-C", sourceDir, "add", ".zshrc", "nvim/init.lua", "tmux").Run())
+
+	result, err = New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+
+	assert.FileExists(t, filepath.Join(targetDir, ".zshrc"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "scratch.txt"))
+	assert.FileExists(t, filepath.Join(targetDir, "nvim", "init.lua"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "nvim", "lazy-lock.json"))
+	assert.Contains(t, result.Ignored, IgnoredFile{Package: "dotfiles", Source: filepath.Join(sourceDir, "scratch.txt"), Pattern: OnlyTrackedPattern})
+	pattern, ok := New(cfg, lockfile.New(), true).Ignored(cfg.Packages[0], "nvim/lazy-lock.json")
+	assert.True(t, ok)
+	assert.Equal(t, OnlyTrackedPattern, pattern)
+
+	// Directories holding untracked files aren't folded
+	assert.False(t, isSymlink(filepath.Join(targetDir, "nvim")))
+	assert.True(t, isSymlink(filepath.Join(targetDir, "tmux")))
+}
+
+func TestWindowsTargets(t *testing.T) {
+	tmpDir, sourceDir, targetDir := setupTestEnvironment(t)
+	windowsDir := filepath.Join(tmpDir, "windows")
+	require.NoError(t, os.Mkdir(windowsDir, 0755))
+
+	for _, file := range []string{"settings.json", "snippets/go.json"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, file), []byte("{}"), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{Name: "vscode", Source: sourceDir, Targets: []string{targetDir, windowsDir}, WindowsTargets: []string{windowsDir}, AutoFold: true},
+		},
+	}
+
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+
+	// The Linux side is linked as usual
+	assert.True(t, isSymlink(filepath.Join(targetDir, "settings.json")))
+	assert.True(t, isSymlink(filepath.Join(targetDir, "snippets")))
+
+	// The Windows side gets copies, and directories aren't folded
+	for _, file := range []string{"settings.json", "snippets/go.json"} {
+		target := filepath.Join(windowsDir, file)
+		assert.False(t, isSymlink(target))
+		assert.FileExists(t, target)
+		assert.Equal(t, lockfile.KindCopy, lock.Symlinks[target].Kind)
+	}
+	assert.False(t, isSymlink(filepath.Join(windowsDir, "snippets")))
+}
+
+func TestLinkPaths(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	for _, file := range []string{".zshrc", ".config/nvim/init.lua", ".config/nvim/lua/plugins/lsp.lua", ".config/nvim/lua/plugins/README.md", ".config/git/config"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, file), []byte(file), 0644))
+	}
+
+	pkg := &config.Package{Source: sourceDir, Targets: []string{targetDir}}
+	cfg := &config.Config{
+		Packages: []*config.Package{pkg},
+		Settings: config.Settings{Paths: []string{".config/nvim/lua/plugins/*.lua", ".config/tmux"}},
+	}
+
+	lock := lockfile.New()
+	lock.AddSymlink(filepath.Join(targetDir, ".gone"), filepath.Join(sourceDir, ".gone"), false)
+	lock.SetUnfolded(filepath.Join(sourceDir, ".config", "old"))
+
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(targetDir, ".config/nvim/lua/plugins/lsp.lua")}, result.Created)
+	assert.Equal(t, []string{"--path .config/tmux matched nothing in the linked packages"}, result.Warnings)
+
+	// Nothing that needs every path of the package is touched
+	assert.Contains(t, lock.Symlinks, filepath.Join(targetDir, ".gone"))
+	assert.Contains(t, lock.Folds, filepath.Join(sourceDir, ".config", "old"))
+	assert.NotContains(t, lock.Applied, sourceDir)
+
+	// A directory in the path is linked whole, folding as usual
+	cfg.Settings.Paths = []string{".config/git"}
+	pkg.Fold = []string{".config/git"}
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(targetDir, ".config/git")}, result.Created)
+	assert.True(t, isSymlink(filepath.Join(targetDir, ".config/git")))
+	assert.NoFileExists(t, filepath.Join(targetDir, ".zshrc"))
+}
+
+func TestLinkPathsDefaultFold(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	for _, file := range []string{".config/nvim/init.lua", ".config/kitty/kitty.conf"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, file), []byte(file), 0644))
+	}
+
+	cfg := &config.Config{
+		Packages: []*config.Package{{Source: sourceDir, Targets: []string{targetDir}, DefaultFold: true}},
+		Settings: config.Settings{Paths: []string{".config/nvim"}},
+	}
+
+	// The directories above a path aren't folded, which would link more
+	lock := lockfile.New()
+	result, err := New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(targetDir, ".config/nvim")}, result.Created)
+	assert.Empty(t, result.Warnings)
+	assert.False(t, isSymlink(filepath.Join(targetDir, ".config")))
+	assert.NoDirExists(t, filepath.Join(targetDir, ".config/kitty"))
+
+	// Nor unfolded when they already are
+	require.NoError(t, os.RemoveAll(filepath.Join(targetDir, ".config")))
+	lock = lockfile.New()
+	cfg.Settings.Paths = nil
+	_, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	require.True(t, isSymlink(filepath.Join(targetDir, ".config")))
+
+	cfg.Settings.Paths = []string{".config/nvim"}
+	result, err = New(cfg, lock, false).Link()
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Removed)
+	assert.True(t, isSymlink(filepath.Join(targetDir, ".config")))
+}
@@ -0,0 +1,40 @@
+package linker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/fsutil"
+)
+
+// chown gives an entry the package created to the package's owner and group.
+// Handing files to someone else takes root, so when farm isn't allowed to the
+// package's ownership is skipped with a warning instead of failing the run.
+func (l *Linker) chown(pkg *config.Package, path string, result *LinkResult) error {
+	if pkg == nil || (pkg.Owner == "" && pkg.Group == "") {
+		return nil
+	}
+
+	owner, err := fsutil.LookupOwner(pkg.Owner, pkg.Group)
+	if err != nil {
+		return fmt.Errorf("package %s: %w", pkg.Name, err)
+	}
+	if l.dryRun {
+		return nil
+	}
+
+	err = owner.Chown(path)
+	if errors.Is(err, os.ErrPermission) {
+		if !l.unowned[pkg.Source] {
+			l.unowned[pkg.Source] = true
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipped setting the owner of package %s, which requires root", pkg.Name))
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set owner of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+//go:build linux || darwin
+
+package linker
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/transform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwner(t *testing.T) {
+	_, sourceDir, targetDir := setupTestEnvironment(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "nested/linked"), []byte("linked"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "copied"), []byte("copied"), 0644))
+
+	transform.Register("owner-test", transform.Func(func(in transform.Input) (*transform.Output, error) {
+		if in.RelativePath != "copied" {
+			return nil, nil
+		}
+		return &transform.Output{Action: transform.ActionCopy}, nil
+	}))
+
+	cfg := &config.Config{
+		Packages: []*config.Package{
+			{
+				Name:        "owned",
+				Source:      sourceDir,
+				Targets:     []string{targetDir},
+				Transform:   []string{"owner-test"},
+				Directories: []config.Directory{{Path: "cache"}},
+				Owner:       "65534",
+				Group:       "65534",
+			},
+		},
+	}
+
+	result, err := New(cfg, lockfile.New(), false).Link()
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	if os.Geteuid() != 0 {
+		assert.Equal(t, []string{"skipped setting the owner of package owned, which requires root"}, result.Warnings)
+		return
+	}
+
+	assert.Empty(t, result.Warnings)
+	for _, name := range []string{"copied", "nested", "nested/linked", "cache"} {
+		info, err := os.Lstat(filepath.Join(targetDir, name))
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(65534), stat.Uid, name)
+		assert.Equal(t, uint32(65534), stat.Gid, name)
+	}
+
+	// The file a link points at keeps its owner
+	info, err := os.Stat(filepath.Join(sourceDir, "nested/linked"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), info.Sys().(*syscall.Stat_t).Uid)
+
+	t.Run("unknown owner", func(t *testing.T) {
+		cfg.Packages[0].Owner = "farm-no-such-user"
+		cfg.Packages[0].Targets = []string{t.TempDir()}
+		result, err := New(cfg, lockfile.New(), false).Link()
+		require.NoError(t, err)
+		require.NotEmpty(t, result.Errors)
+		assert.ErrorContains(t, result.Errors[0], "unknown owner farm-no-such-user")
+	})
+}
@@ -0,0 +1,60 @@
+package linker
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// partial reports whether linking is limited to some paths of the packages,
+// in which case nothing that needs a walk of every package is done: dead
+// links are kept, unfolded directories aren't pruned, and packages aren't
+// recorded as applied.
+func (l *Linker) partial() bool {
+	return len(l.config.Settings.Paths) > 0
+}
+
+// selectPath reports whether a path relative to a package source lies in one
+// of the paths linking is limited to, or above one, so walking it leads to
+// one. Each component of a path may be a glob, matched against the component
+// of the relative path at the same depth.
+func (l *Linker) selectPath(relativePath string) (selected, above bool) {
+	if !l.partial() {
+		return true, false
+	}
+
+	parts := strings.Split(filepath.ToSlash(relativePath), "/")
+	for _, path := range l.config.Settings.Paths {
+		patternParts := strings.Split(path, "/")
+		n := min(len(parts), len(patternParts))
+		if !matchParts(patternParts[:n], parts[:n]) {
+			continue
+		}
+
+		if len(parts) >= len(patternParts) {
+			l.pathsMatched[path] = true
+			return true, false
+		}
+		above = true
+	}
+	return false, above
+}
+
+func matchParts(patterns, parts []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// unmatchedPaths warns about each path linking was limited to that matched
+// nothing in the linked packages, which is likely a typo.
+func (l *Linker) unmatchedPaths(result *LinkResult) {
+	for _, path := range l.config.Settings.Paths {
+		if !l.pathsMatched[path] {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("--path %s matched nothing in the linked packages", path))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package linker
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+
+	"github.com/mskelton/farm/internal/config"
+)
+
+const (
+	PatternIgnore = "ignore"
+	PatternFold   = "fold"
+	PatternNoFold = "no_fold"
+)
+
+// Pattern is an ignore, fold, or no_fold pattern from the config. Package is
+// empty for ignore patterns, which apply to every package.
+type Pattern struct {
+	Package string
+	Kind    string
+	Pattern string
+}
+
+// OnlyTrackedPattern is reported as the pattern that ignored a file git
+// doesn't track, in an only_tracked package.
+const OnlyTrackedPattern = "only_tracked"
+
+// IgnoredFile is a path of a package that was left out of the link, along
+// with the pattern that excluded it. Nothing below an ignored directory is
+// listed.
+type IgnoredFile struct {
+	Package string
+	Source  string
+	Pattern string
+}
+
+// Ignored returns the pattern that leaves a path, relative to the source of
+// pkg, out of the link: an ignore pattern from the config, .gitignore, or
+// only_tracked for files git doesn't track in only_tracked packages.
+func (l *Linker) Ignored(pkg *config.Package, relativePath string) (string, bool) {
+	if pattern, ok := l.config.IgnoredIn(pkg, relativePath); ok {
+		return pattern, true
+	}
+
+	if tracked, err := l.trackedFiles(pkg); err == nil && tracked != nil && !tracked.contains(relativePath) {
+		return OnlyTrackedPattern, true
+	}
+	return "", false
+}
+
+// shouldIgnore is Ignored, remembering which pattern matched and what it
+// excluded.
+func (l *Linker) shouldIgnore(pkg *config.Package, relativePath string) bool {
+	pattern, ok := l.Ignored(pkg, relativePath)
+	if !ok {
+		return false
+	}
+
+	if pattern != OnlyTrackedPattern {
+		l.patternsUsed[Pattern{Kind: PatternIgnore, Pattern: pattern}] = true
+	}
+	source := filepath.Join(pkg.Source, relativePath)
+	l.ignored[source] = IgnoredFile{Package: pkg.Name, Source: source, Pattern: pattern}
+	return true
+}
+
+// ignoredFiles returns what the ignore patterns excluded, sorted by source.
+func (l *Linker) ignoredFiles() []IgnoredFile {
+	var ignored []IgnoredFile
+	for _, source := range slices.Sorted(maps.Keys(l.ignored)) {
+		ignored = append(ignored, l.ignored[source])
+	}
+	return ignored
+}
+
+// noteFoldPatterns remembers the fold and no_fold patterns that match a
+// directory. It runs for directories unfolded by hand too, so their patterns
+// still count as used.
+func (l *Linker) noteFoldPatterns(pkg *config.Package, relativePath string) {
+	for _, pattern := range pkg.Fold {
+		if l.matchesPath(pattern, relativePath) {
+			l.patternsUsed[Pattern{Package: pkg.Name, Kind: PatternFold, Pattern: pattern}] = true
+		}
+	}
+	for _, pattern := range pkg.NoFold {
+		if unfold, fold := l.noFold(pattern, relativePath); unfold || fold {
+			l.patternsUsed[Pattern{Package: pkg.Name, Kind: PatternNoFold, Pattern: pattern}] = true
+		}
+	}
+}
+
+// unusedPatterns returns the patterns that matched nothing in the linked
+// packages, which usually means they're stale or misspelled. Only the
+// packages that were walked are considered.
+func (l *Linker) unusedPatterns(walked []*config.Package) []Pattern {
+	walkedNames := make(map[string]bool, len(walked))
+	for _, pkg := range walked {
+		walkedNames[pkg.Name] = true
+	}
+
+	var unused []Pattern
+	if len(walked) > 0 {
+		for _, pattern := range l.config.Ignore {
+			// Patterns scoped to a package only count when it was walked
+			if scope := config.ParseIgnore(pattern).Package; scope != "" && !walkedNames[scope] {
+				continue
+			}
+
+			p := Pattern{Kind: PatternIgnore, Pattern: pattern}
+			if !l.patternsUsed[p] {
+				unused = append(unused, p)
+			}
+		}
+	}
+
+	for _, pkg := range walked {
+		for _, pattern := range pkg.Fold {
+			p := Pattern{Package: pkg.Name, Kind: PatternFold, Pattern: pattern}
+			if !l.patternsUsed[p] {
+				unused = append(unused, p)
+			}
+		}
+		for _, pattern := range pkg.NoFold {
+			p := Pattern{Package: pkg.Name, Kind: PatternNoFold, Pattern: pattern}
+			if !l.patternsUsed[p] {
+				unused = append(unused, p)
+			}
+		}
+	}
+
+	return unused
+}
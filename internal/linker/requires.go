@@ -0,0 +1,59 @@
+package linker
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mskelton/farm/internal/config"
+)
+
+// pollInterval is how often a required path is checked while waiting for it,
+// swapped out by tests.
+var pollInterval = time.Second
+
+// MissingPathError is reported for a package that was skipped because a path
+// it requires doesn't exist, such as a volume that isn't mounted yet.
+type MissingPathError struct {
+	Package string
+	Path    string
+}
+
+func (e *MissingPathError) Error() string {
+	return fmt.Sprintf("package %s requires %s, which doesn't exist", e.Package, e.Path)
+}
+
+// checkRequiredPaths returns an error when one of the package's required
+// paths doesn't exist, after waiting for it as long as the package allows.
+// The answer is cached, so the package is only waited on once per run.
+func (l *Linker) checkRequiredPaths(pkg *config.Package) error {
+	if err, ok := l.requirements[pkg]; ok {
+		return err
+	}
+
+	var err error
+	for _, required := range pkg.RequiresPaths {
+		if !waitForPath(required.Path, required.Wait) {
+			err = &MissingPathError{Package: pkg.Name, Path: required.Path}
+			break
+		}
+	}
+
+	l.requirements[pkg] = err
+	return err
+}
+
+// waitForPath reports whether path exists, polling for it until wait has
+// passed.
+func waitForPath(path string, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(min(pollInterval, time.Until(deadline)))
+	}
+}
@@ -0,0 +1,46 @@
+package linker
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/git"
+)
+
+// trackedPaths is what git tracks in a package's source: the files, and the
+// directories leading to them, relative to the source.
+type trackedPaths struct {
+	paths map[string]bool
+	err   error
+}
+
+func (t *trackedPaths) contains(relativePath string) bool {
+	return t.paths[relativePath]
+}
+
+// trackedFiles returns the paths git tracks in the source of an only_tracked
+// package, listing them once per run. Other packages have none to check
+// against.
+func (l *Linker) trackedFiles(pkg *config.Package) (*trackedPaths, error) {
+	if pkg == nil || !pkg.OnlyTracked {
+		return nil, nil
+	}
+
+	tracked, ok := l.tracked[pkg.Source]
+	if !ok {
+		tracked = &trackedPaths{paths: make(map[string]bool)}
+		files, err := git.TrackedFiles(pkg.Source)
+		if err != nil {
+			tracked.err = fmt.Errorf("package %s only links tracked files: %w", pkg.Name, err)
+		}
+		for _, file := range files {
+			for path := file; path != "." && !tracked.paths[path]; path = filepath.Dir(path) {
+				tracked.paths[path] = true
+			}
+		}
+		l.tracked[pkg.Source] = tracked
+	}
+
+	return tracked, tracked.err
+}
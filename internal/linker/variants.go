@@ -0,0 +1,135 @@
+package linker
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mskelton/farm/internal/config"
+)
+
+// The platform and host variants are picked for, swapped out by tests.
+var (
+	variantOS   = runtime.GOOS
+	variantArch = runtime.GOARCH
+	hostname    = os.Hostname
+)
+
+// variantSystems and variantArchs are the suffixes recognized as variants.
+// Names like js or wasm are left out, they're more likely file extensions.
+var (
+	variantSystems = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "linux", "netbsd", "openbsd", "solaris", "windows"}
+	variantArchs   = []string{"386", "amd64", "arm", "arm64", "loong64", "ppc64le", "riscv64", "s390x"}
+)
+
+// Variants of the same file are ranked by how specific they are, the most
+// specific one that matches is linked.
+const (
+	rankPlain = iota
+	rankArch
+	rankOS
+	rankHost
+)
+
+// variantEntry is a directory entry chosen to be linked, along with the
+// name it is linked under.
+type variantEntry struct {
+	entry os.DirEntry
+	name  string
+}
+
+// parseVariant splits a variant suffix such as .linux or @workmac off name,
+// reporting its rank and whether it matches this machine.
+func parseVariant(name string) (base string, rank int, matches bool) {
+	if i := strings.LastIndex(name, "@"); i > 0 && i < len(name)-1 {
+		return name[:i], rankHost, matchesHost(name[i+1:])
+	}
+
+	i := strings.LastIndex(name, ".")
+	if i <= 0 {
+		return name, rankPlain, true
+	}
+
+	suffix := name[i+1:]
+	for _, goos := range variantSystems {
+		if suffix == goos {
+			return name[:i], rankOS, suffix == variantOS
+		}
+	}
+	for _, arch := range variantArchs {
+		if suffix == arch {
+			return name[:i], rankArch, suffix == variantArch
+		}
+	}
+
+	return name, rankPlain, true
+}
+
+func matchesHost(name string) bool {
+	host, err := hostname()
+	if err != nil {
+		return false
+	}
+
+	short, _, _ := strings.Cut(host, ".")
+	return strings.EqualFold(name, host) || strings.EqualFold(name, short)
+}
+
+// isVariant reports whether name has a variant suffix.
+func isVariant(name string) bool {
+	_, rank, _ := parseVariant(name)
+	return rank != rankPlain
+}
+
+// selectVariants picks the entries of a directory to link. Without variants
+// every entry is linked under its own name. With them, the variants of a file
+// that don't match this machine are dropped and the most specific one left is
+// linked under the name without its suffix.
+func selectVariants(pkg *config.Package, entries []os.DirEntry) []variantEntry {
+	selected := make([]variantEntry, 0, len(entries))
+	if !pkg.Variants {
+		for _, entry := range entries {
+			selected = append(selected, variantEntry{entry: entry, name: entry.Name()})
+		}
+		return selected
+	}
+
+	best := make(map[string]int)
+	for i, entry := range entries {
+		base, rank, matches := parseVariant(entry.Name())
+		if !matches {
+			continue
+		}
+
+		if j, ok := best[base]; ok {
+			if _, other, _ := parseVariant(entries[j].Name()); other >= rank {
+				continue
+			}
+		}
+		best[base] = i
+	}
+
+	for i, entry := range entries {
+		base, _, _ := parseVariant(entry.Name())
+		if j, ok := best[base]; ok && j == i {
+			selected = append(selected, variantEntry{entry: entry, name: base})
+		}
+	}
+
+	return selected
+}
+
+// containsVariants reports whether anything inside dir has a variant suffix,
+// in which case the directory can't be folded.
+func containsVariants(dir string) bool {
+	found := false
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && path != dir && isVariant(d.Name()) {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
@@ -2,11 +2,17 @@ package lockfile
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"time"
+
+	"github.com/mskelton/farm/internal/fsutil"
+	"github.com/mskelton/farm/internal/home"
 )
 
 type SymlinkMap map[string]Symlink
@@ -28,9 +34,31 @@ func (m SymlinkMap) Sorted() []Symlink {
 }
 
 type LockFile struct {
-	Version  string     `json:"version"`
-	Updated  time.Time  `json:"updated"`
-	Symlinks SymlinkMap `json:"symlinks"`
+	Version  string                  `json:"version"`
+	Updated  time.Time               `json:"updated"`
+	Symlinks SymlinkMap              `json:"symlinks"`
+	Folds    map[string]FoldDecision `json:"folds,omitempty"`
+	// Applied holds when each package was last linked, by its source
+	Applied map[string]time.Time `json:"applied,omitempty"`
+	// ConfigHashes holds the hash of each package's config when it was
+	// last linked, by its source
+	ConfigHashes map[string]string `json:"config_hashes,omitempty"`
+	// Namespaces hold the entries of runs with their targets rebased under
+	// another directory, keyed by that directory
+	Namespaces map[string]*LockFile `json:"namespaces,omitempty"`
+	// Portable stores paths inside the lockfile's directory and the home
+	// directory with the $REPO and $HOME markers, for lockfiles committed
+	// and shared between machines
+	Portable bool `json:"portable,omitempty"`
+
+	// parent is the lockfile a namespace belongs to, which is what gets saved
+	parent *LockFile
+}
+
+type FoldDecision struct {
+	// Unfolded is set by farm unfold and keeps the directory unfolded
+	// whatever the config says
+	Unfolded bool `json:"unfolded,omitempty"`
 }
 
 type Symlink struct {
@@ -38,6 +66,38 @@ type Symlink struct {
 	Target   string    `json:"target"`
 	Created  time.Time `json:"created"`
 	IsFolded bool      `json:"is_folded"`
+	Kind     string    `json:"kind,omitempty"`
+	// Checksum is the sha256 of the content farm wrote for file entries
+	Checksum string `json:"checksum,omitempty"`
+	// SourceChecksum is the checksum a download was verified against
+	SourceChecksum string `json:"source_checksum,omitempty"`
+	// Resolved is the file the link points at when its source is a symlink
+	// that was dereferenced
+	Resolved string `json:"resolved,omitempty"`
+	// Verified is when farm last linked the entry or found it in place,
+	// while Created stays when it was first linked
+	Verified time.Time `json:"verified,omitzero"`
+}
+
+const (
+	KindCopy      = "copy"
+	KindRender    = "render"
+	KindDirectory = "directory"
+	KindFont      = "font"
+	KindDownload  = "download"
+)
+
+func (s Symlink) IsFile() bool {
+	return s.Kind == KindCopy || s.Kind == KindRender || s.Kind == KindFont || s.Kind == KindDownload
+}
+
+// LastVerified returns when the entry was last linked or found in place.
+// Entries from before this was recorded only have when they were created.
+func (s Symlink) LastVerified() time.Time {
+	if s.Verified.IsZero() {
+		return s.Created
+	}
+	return s.Verified
 }
 
 const (
@@ -50,6 +110,7 @@ func New() *LockFile {
 		Version:  CurrentVersion,
 		Updated:  time.Now(),
 		Symlinks: make(map[string]Symlink),
+		Folds:    make(map[string]FoldDecision),
 	}
 }
 
@@ -75,21 +136,73 @@ func Load(path string) (*LockFile, error) {
 		return nil, fmt.Errorf("unsupported lockfile version: %s", lock.Version)
 	}
 
+	// Markers are expanded whether or not the lockfile is still portable, in
+	// case it was turned off on another machine
+	repoDir, homeDir := portableDirs(path)
+	lock = *lock.mapPaths(func(p string) string { return withoutMarkers(p, repoDir, homeDir) })
+
 	if lock.Symlinks == nil {
 		lock.Symlinks = make(SymlinkMap)
 	}
 
+	if lock.Folds == nil {
+		lock.Folds = make(map[string]FoldDecision)
+	}
+
 	return &lock, nil
 }
 
+// LoadNamespace loads the entries for targets rebased under base. Saving the
+// returned lockfile saves the whole file.
+func LoadNamespace(path string, base string) (*LockFile, error) {
+	lock, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock.Namespace(base), nil
+}
+
+func (l *LockFile) Namespace(base string) *LockFile {
+	if l.Namespaces == nil {
+		l.Namespaces = make(map[string]*LockFile)
+	}
+
+	ns, ok := l.Namespaces[base]
+	if !ok {
+		ns = New()
+		l.Namespaces[base] = ns
+	}
+	if ns.Symlinks == nil {
+		ns.Symlinks = make(SymlinkMap)
+	}
+	if ns.Folds == nil {
+		ns.Folds = make(map[string]FoldDecision)
+	}
+	ns.parent = l
+
+	return ns
+}
+
 func (l *LockFile) Save(path string) error {
+	if l.parent != nil {
+		l.Updated = time.Now()
+		return l.parent.Save(path)
+	}
+
 	if path == "" {
 		path = DefaultPath
 	}
 
 	l.Updated = time.Now()
 
-	data, err := json.MarshalIndent(l, "", "  ")
+	out := l
+	if l.Portable {
+		repoDir, homeDir := portableDirs(path)
+		out = l.mapPaths(func(p string) string { return withMarkers(p, repoDir, homeDir) })
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal lockfile: %w", err)
 	}
@@ -98,56 +211,356 @@ func (l *LockFile) Save(path string) error {
 		return fmt.Errorf("failed to write lockfile: %w", err)
 	}
 
-	return nil
+	return home.Chown(path)
 }
 
+func (l *LockFile) Clone() *LockFile {
+	clone := &LockFile{
+		Version:  l.Version,
+		Updated:  l.Updated,
+		Symlinks: make(SymlinkMap, len(l.Symlinks)),
+		Folds:    make(map[string]FoldDecision, len(l.Folds)),
+		Portable: l.Portable,
+	}
+
+	for target, link := range l.Symlinks {
+		clone.Symlinks[target] = link
+	}
+	for source, decision := range l.Folds {
+		clone.Folds[source] = decision
+	}
+	if l.Applied != nil {
+		clone.Applied = maps.Clone(l.Applied)
+	}
+	if l.ConfigHashes != nil {
+		clone.ConfigHashes = maps.Clone(l.ConfigHashes)
+	}
+
+	return clone
+}
+
+// Replace swaps the entries of the lockfile for other's. A namespace stays in
+// its lockfile, so only its own entries are replaced when the file is saved.
+func (l *LockFile) Replace(other *LockFile) {
+	parent := l.parent
+	*l = *other.Clone()
+	l.parent = parent
+	if parent == nil {
+		l.Namespaces = other.Namespaces
+	} else {
+		l.Portable = false
+	}
+}
+
+// AddSymlink tracks a link. Links that were already tracked the same way
+// keep when they were created, and are only marked as verified.
 func (l *LockFile) AddSymlink(target string, source string, isFolded bool) {
+	now := time.Now()
+	created := l.createdAt(target, now, func(existing Symlink) bool {
+		return existing.Kind == "" && existing.Source == source && existing.IsFolded == isFolded
+	})
 	l.Symlinks[target] = Symlink{
 		Source:   source,
 		Target:   target,
-		Created:  time.Now(),
+		Created:  created,
+		Verified: now,
 		IsFolded: isFolded,
 	}
 }
 
+// AddFile tracks a target that farm wrote as a regular file rather than a
+// symlink, such as a copy or a rendered file. Files that were already
+// tracked with the same content keep when they were created.
+func (l *LockFile) AddFile(target string, source string, kind string, checksum string) {
+	now := time.Now()
+	created := l.createdAt(target, now, func(existing Symlink) bool {
+		return existing.Kind == kind && existing.Source == source && existing.Checksum == checksum
+	})
+	l.Symlinks[target] = Symlink{
+		Source:   source,
+		Target:   target,
+		Created:  created,
+		Verified: now,
+		Kind:     kind,
+		Checksum: checksum,
+	}
+}
+
+// createdAt returns when the entry of target was created, if it's tracked
+// and unchanged, or now otherwise.
+func (l *LockFile) createdAt(target string, now time.Time, unchanged func(Symlink) bool) time.Time {
+	if existing, ok := l.Symlinks[target]; ok && !existing.Created.IsZero() && unchanged(existing) {
+		return existing.Created
+	}
+	return now
+}
+
+// SetSourceChecksum records the checksum a downloaded file was verified
+// against.
+func (l *LockFile) SetSourceChecksum(target string, checksum string) {
+	if link, ok := l.Symlinks[target]; ok {
+		link.SourceChecksum = checksum
+		l.Symlinks[target] = link
+	}
+}
+
+// SetResolved records that a link points at the file its source resolves to
+// rather than at the source itself.
+func (l *LockFile) SetResolved(target string, resolved string) {
+	if link, ok := l.Symlinks[target]; ok {
+		link.Resolved = resolved
+		l.Symlinks[target] = link
+	}
+}
+
+func (l *LockFile) AddDirectory(target string, source string) {
+	now := time.Now()
+	if existing, ok := l.Symlinks[target]; ok && existing.Kind == KindDirectory {
+		existing.Verified = now
+		l.Symlinks[target] = existing
+		return
+	}
+
+	l.Symlinks[target] = Symlink{
+		Source:   source,
+		Target:   target,
+		Created:  now,
+		Verified: now,
+		Kind:     KindDirectory,
+	}
+}
+
+// Normalize composes the tracked targets to NFC, merging entries that only
+// differ in how they're normalized. Entries that were already composed win,
+// and otherwise the first target in sorted order.
+func (l *LockFile) Normalize() {
+	for _, target := range slices.Sorted(maps.Keys(l.Symlinks)) {
+		link := l.Symlinks[target]
+		normalized := fsutil.NFC(target)
+		if normalized == target {
+			continue
+		}
+
+		delete(l.Symlinks, target)
+		if _, ok := l.Symlinks[normalized]; ok {
+			continue
+		}
+		link.Target = normalized
+		l.Symlinks[normalized] = link
+	}
+}
+
+// Which returns the entry that manages path, along with the source file path
+// comes from. Paths inside a folded directory are managed by the link of the
+// directory, so the source is the matching file under its source.
+func (l *LockFile) Which(path string) (Symlink, string, bool) {
+	if link, ok := l.Symlinks[path]; ok {
+		return link, link.Source, true
+	}
+
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		link, ok := l.Symlinks[dir]
+		if !ok {
+			continue
+		}
+		if !link.IsFolded {
+			return Symlink{}, "", false
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return Symlink{}, "", false
+		}
+		return link, filepath.Join(link.Source, rel), true
+	}
+
+	return Symlink{}, "", false
+}
+
+// Merge adds the entries and unfolded directories of other that l doesn't
+// have, so entries already in l win. It returns the targets both track from
+// different sources, sorted.
+func (l *LockFile) Merge(other *LockFile) []string {
+	var conflicts []string
+	for target, link := range other.Symlinks {
+		existing, ok := l.Symlinks[target]
+		if !ok {
+			l.Symlinks[target] = link
+			continue
+		}
+		if existing.Source != link.Source || existing.Kind != link.Kind {
+			conflicts = append(conflicts, target)
+		}
+	}
+
+	for source, decision := range other.Folds {
+		if _, ok := l.Folds[source]; !ok {
+			l.Folds[source] = decision
+		}
+	}
+
+	for source, applied := range other.Applied {
+		if _, ok := l.Applied[source]; !ok {
+			l.SetApplied(source, applied)
+		}
+	}
+	for source, hash := range other.ConfigHashes {
+		if _, ok := l.ConfigHashes[source]; !ok {
+			l.SetConfigHash(source, hash)
+		}
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
 func (l *LockFile) RemoveSymlink(target string) {
 	delete(l.Symlinks, target)
 }
 
+// SetUnfolded records that a directory was unfolded by hand, which no change
+// to the config overrides.
+func (l *LockFile) SetUnfolded(source string) {
+	if l.Folds == nil {
+		l.Folds = make(map[string]FoldDecision)
+	}
+	l.Folds[source] = FoldDecision{Unfolded: true}
+}
+
+func (l *LockFile) IsUnfolded(source string) bool {
+	return l.Folds[source].Unfolded
+}
+
+func (l *LockFile) RemoveFoldDecision(source string) {
+	delete(l.Folds, source)
+}
+
+// SetApplied records when the package with the given source was linked.
+func (l *LockFile) SetApplied(source string, at time.Time) {
+	if l.Applied == nil {
+		l.Applied = make(map[string]time.Time)
+	}
+	l.Applied[source] = at
+}
+
+// LastApplied returns when the package with the given source was last
+// linked, if it ever was.
+func (l *LockFile) LastApplied(source string) (time.Time, bool) {
+	at, ok := l.Applied[source]
+	return at, ok
+}
+
+func (l *LockFile) RemoveApplied(source string) {
+	delete(l.Applied, source)
+}
+
+// SetConfigHash records the hash of the config the package with the given
+// source was linked with.
+func (l *LockFile) SetConfigHash(source string, hash string) {
+	if l.ConfigHashes == nil {
+		l.ConfigHashes = make(map[string]string)
+	}
+	l.ConfigHashes[source] = hash
+}
+
+func (l *LockFile) RemoveConfigHash(source string) {
+	delete(l.ConfigHashes, source)
+}
+
+// DeadSymlink is a tracked entry that no longer resolves to its source.
+type DeadSymlink struct {
+	Target string
+	Reason string
+	// Chain holds the links followed from the target, ending with the path
+	// the chain resolved to or broke at
+	Chain []string
+}
+
+const (
+	DeadMissing       = "target is missing"
+	DeadSourceMissing = "source is missing"
+	DeadLoop          = "symlink loop"
+	DeadRetargeted    = "points somewhere else"
+)
+
 func (l *LockFile) GetDeadSymlinks() ([]string, error) {
-	var dead []string
+	dead, err := l.FindDeadSymlinks()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, 0, len(dead))
+	for _, link := range dead {
+		targets = append(targets, link.Target)
+	}
+	return targets, nil
+}
+
+// FindDeadSymlinks returns the dead entries along with why they're dead.
+// Symlink chains are followed to the end, so a link to a link to a missing
+// file is dead, and chains that loop are reported as such.
+func (l *LockFile) FindDeadSymlinks() ([]DeadSymlink, error) {
+	var dead []DeadSymlink
 
 	for _, link := range l.Symlinks.Sorted() {
+		// Directories are recreated by link rather than cleaned up
+		if link.Kind == KindDirectory {
+			continue
+		}
+
 		targetInfo, err := os.Lstat(link.Target)
 		if err != nil {
 			if os.IsNotExist(err) {
-				dead = append(dead, link.Target)
+				dead = append(dead, DeadSymlink{Target: link.Target, Reason: DeadMissing})
 				continue
 			}
 			return nil, fmt.Errorf("failed to stat %s: %w", link.Target, err)
 		}
 
-		if targetInfo.Mode()&os.ModeSymlink == 0 {
+		// Copied and rendered files are dead once their source is gone
+		if link.IsFile() {
+			if _, err := os.Stat(link.Source); os.IsNotExist(err) {
+				dead = append(dead, DeadSymlink{Target: link.Target, Reason: DeadSourceMissing})
+			}
 			continue
 		}
 
-		linkDest, err := os.Readlink(link.Target)
-		if err != nil {
-			dead = append(dead, link.Target)
+		if targetInfo.Mode()&os.ModeSymlink == 0 {
 			continue
 		}
 
-		linkDestAbs := linkDest
-		if !filepath.IsAbs(linkDest) {
-			linkDestAbs = filepath.Join(filepath.Dir(link.Target), linkDest)
-		}
-
-		if _, err := os.Stat(linkDestAbs); os.IsNotExist(err) {
-			dead = append(dead, link.Target)
-		} else if linkDestAbs != link.Source {
-			dead = append(dead, link.Target)
+		chain, err := fsutil.ResolveChain(link.Target)
+		switch {
+		case errors.Is(err, fsutil.ErrSymlinkLoop):
+			dead = append(dead, DeadSymlink{Target: link.Target, Reason: DeadLoop, Chain: chain})
+		case os.IsNotExist(err):
+			dead = append(dead, DeadSymlink{Target: link.Target, Reason: DeadSourceMissing, Chain: chain})
+		case err != nil && len(chain) < 2:
+			// The link itself can't be read
+			dead = append(dead, DeadSymlink{Target: link.Target, Reason: err.Error(), Chain: chain})
+		case !fsutil.SamePath(chain[1], link.Source) && (link.Resolved == "" || !fsutil.SamePath(chain[1], link.Resolved)):
+			dead = append(dead, DeadSymlink{Target: link.Target, Reason: DeadRetargeted, Chain: chain})
 		}
 	}
 
 	return dead, nil
 }
+
+// FindShadowedSymlinks returns the entries linked as symlinks whose target
+// was replaced by a regular file or directory, as programs that save their
+// settings by writing a new file over the old one do. Unlike dead links,
+// these hold changes that would be lost by relinking.
+func (l *LockFile) FindShadowedSymlinks() []Symlink {
+	var shadowed []Symlink
+	for _, link := range l.Symlinks.Sorted() {
+		if link.Kind == KindDirectory || link.IsFile() {
+			continue
+		}
+
+		info, err := os.Lstat(link.Target)
+		if err == nil && info.Mode()&os.ModeSymlink == 0 {
+			shadowed = append(shadowed, link)
+		}
+	}
+	return shadowed
+}
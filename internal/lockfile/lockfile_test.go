@@ -3,6 +3,7 @@ package lockfile
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -96,6 +97,49 @@ func TestAddRemoveSymlink(t *testing.T) {
 	assert.Empty(t, lock.Symlinks)
 }
 
+func TestAddKeepsCreated(t *testing.T) {
+	lock := New()
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	lock.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	link := lock.Symlinks["/home/user/.vimrc"]
+	assert.Equal(t, link.Created, link.Verified)
+
+	// Re-adding an unchanged link only marks it as verified
+	link.Created, link.Verified = created, created
+	lock.Symlinks[link.Target] = link
+	lock.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	link = lock.Symlinks["/home/user/.vimrc"]
+	assert.Equal(t, created, link.Created)
+	assert.True(t, link.Verified.After(created))
+	assert.Equal(t, link.Verified, link.LastVerified())
+
+	// Links from another source are new
+	lock.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/nvim/.vimrc", false)
+	assert.True(t, lock.Symlinks["/home/user/.vimrc"].Created.After(created))
+
+	// Files keep it as long as their content stays the same
+	lock.AddFile("/home/user/.npmrc", "/home/user/dotfiles/npm/.npmrc", KindCopy, "abc")
+	file := lock.Symlinks["/home/user/.npmrc"]
+	file.Created = created
+	lock.Symlinks[file.Target] = file
+	lock.AddFile("/home/user/.npmrc", "/home/user/dotfiles/npm/.npmrc", KindCopy, "abc")
+	assert.Equal(t, created, lock.Symlinks["/home/user/.npmrc"].Created)
+	lock.AddFile("/home/user/.npmrc", "/home/user/dotfiles/npm/.npmrc", KindCopy, "def")
+	assert.True(t, lock.Symlinks["/home/user/.npmrc"].Created.After(created))
+
+	lock.AddDirectory("/home/user/.cache/app", "/home/user/dotfiles/app")
+	dir := lock.Symlinks["/home/user/.cache/app"]
+	dir.Created, dir.Verified = created, created
+	lock.Symlinks[dir.Target] = dir
+	lock.AddDirectory("/home/user/.cache/app", "/home/user/dotfiles/app")
+	assert.Equal(t, created, lock.Symlinks["/home/user/.cache/app"].Created)
+	assert.True(t, lock.Symlinks["/home/user/.cache/app"].Verified.After(created))
+
+	// Entries from before verification was recorded fall back to Created
+	assert.Equal(t, created, Symlink{Created: created}.LastVerified())
+}
+
 func TestGetDeadSymlinks(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -132,3 +176,318 @@ func TestGetDeadSymlinks(t *testing.T) {
 	assert.Contains(t, dead, nonExistentLink)
 	assert.NotContains(t, dead, goodLink)
 }
+
+func TestFindDeadSymlinksChains(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// target -> source -> missing, where the source is itself a link
+	missing := filepath.Join(tmpDir, "missing.txt")
+	source := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.Symlink(missing, source))
+	chained := filepath.Join(tmpDir, "chained")
+	require.NoError(t, os.Symlink(source, chained))
+
+	loopA := filepath.Join(tmpDir, "loop-a")
+	loopB := filepath.Join(tmpDir, "loop-b")
+	require.NoError(t, os.Symlink(loopB, loopA))
+	require.NoError(t, os.Symlink(loopA, loopB))
+
+	lock := New()
+	lock.AddSymlink(chained, source, false)
+	lock.AddSymlink(loopA, loopB, false)
+
+	dead, err := lock.FindDeadSymlinks()
+	require.NoError(t, err)
+	assert.Equal(t, []DeadSymlink{
+		{Target: chained, Reason: DeadSourceMissing, Chain: []string{chained, source, missing}},
+		{Target: loopA, Reason: DeadLoop, Chain: []string{loopA, loopB, loopA}},
+	}, dead)
+}
+
+func TestFindShadowedSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	source := filepath.Join(tmpDir, "source.txt")
+	require.NoError(t, os.WriteFile(source, []byte("source"), 0644))
+
+	linked := filepath.Join(tmpDir, "linked")
+	require.NoError(t, os.Symlink(source, linked))
+	saved := filepath.Join(tmpDir, "saved")
+	require.NoError(t, os.WriteFile(saved, []byte("saved by an app"), 0644))
+	copied := filepath.Join(tmpDir, "copied")
+	require.NoError(t, os.WriteFile(copied, []byte("source"), 0644))
+
+	lock := New()
+	lock.AddSymlink(linked, source, false)
+	lock.AddSymlink(saved, source, false)
+	lock.AddSymlink(filepath.Join(tmpDir, "missing"), source, false)
+	// Copies are regular files to begin with
+	lock.AddFile(copied, source, KindCopy, "")
+
+	shadowed := lock.FindShadowedSymlinks()
+	require.Len(t, shadowed, 1)
+	assert.Equal(t, saved, shadowed[0].Target)
+}
+
+func TestUnfolded(t *testing.T) {
+	tmpDir := t.TempDir()
+	lockPath := filepath.Join(tmpDir, "test.lock")
+
+	lock := New()
+	lock.SetUnfolded("/home/user/dotfiles/nvim/lua")
+	assert.True(t, lock.IsUnfolded("/home/user/dotfiles/nvim/lua"))
+	assert.False(t, lock.IsUnfolded("/home/user/dotfiles/nvim"))
+
+	require.NoError(t, lock.Save(lockPath))
+
+	loaded, err := Load(lockPath)
+	require.NoError(t, err)
+	assert.True(t, loaded.IsUnfolded("/home/user/dotfiles/nvim/lua"))
+
+	loaded.RemoveFoldDecision("/home/user/dotfiles/nvim/lua")
+	assert.Empty(t, loaded.Folds)
+}
+
+func TestExportImportYAML(t *testing.T) {
+	lock := New()
+	lock.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	lock.AddSymlink("/home/user/.config/nvim", "/home/user/dotfiles/nvim", true)
+	lock.AddSymlink("/etc/hosts", "/srv/hosts", false)
+
+	data, err := lock.ExportYAML("/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+
+	exported := string(data)
+	assert.Contains(t, exported, "target: ~/.vimrc")
+	assert.Contains(t, exported, "source: ./vim/.vimrc")
+	assert.Contains(t, exported, "target: /etc/hosts")
+	assert.Contains(t, exported, "folded: true")
+
+	// Entries are sorted by target path
+	assert.Less(t, strings.Index(exported, "/etc/hosts"), strings.Index(exported, "~/.config/nvim"))
+	assert.Less(t, strings.Index(exported, "~/.config/nvim"), strings.Index(exported, "~/.vimrc"))
+
+	imported, err := ImportYAML(data, "/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.Len(t, imported.Symlinks, 3)
+	for target, link := range lock.Symlinks {
+		assert.Equal(t, link.Source, imported.Symlinks[target].Source)
+		assert.Equal(t, link.IsFolded, imported.Symlinks[target].IsFolded)
+		assert.True(t, link.Created.Equal(imported.Symlinks[target].Created))
+	}
+
+	// Importing on a machine with a different home resolves the paths there
+	moved, err := ImportYAML(data, "/Users/user/dotfiles", "/Users/user")
+	require.NoError(t, err)
+	assert.Equal(t, "/Users/user/dotfiles/vim/.vimrc", moved.Symlinks["/Users/user/.vimrc"].Source)
+
+	// Directories unfolded by hand survive
+	lock.SetUnfolded("/home/user/dotfiles/nvim/lua")
+	data, err = lock.ExportYAML("/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "unfolded:\n    - ./nvim/lua\n")
+	imported, err = ImportYAML(data, "/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.True(t, imported.IsUnfolded("/home/user/dotfiles/nvim/lua"))
+
+	_, err = ImportYAML([]byte("version: \"0.1\"\nsymlinks: []\n"), "/", "/")
+	assert.ErrorContains(t, err, "unsupported lockfile version")
+}
+
+func TestExportImportYAMLKinds(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	verified := created.Add(time.Hour)
+
+	lock := New()
+	for _, link := range []Symlink{
+		{Target: "/home/user/.vimrc", Source: "/home/user/dotfiles/vim/.vimrc", Resolved: "/home/user/dotfiles/shared/vimrc"},
+		{Target: "/home/user/.config/nvim", Source: "/home/user/dotfiles/nvim", IsFolded: true},
+		{Target: "/home/user/.tigrc", Source: "/home/user/dotfiles/tig/.tigrc", Kind: KindCopy, Checksum: "aaa"},
+		{Target: "/home/user/.gitconfig", Source: "/home/user/dotfiles/git/.gitconfig", Kind: KindRender, Checksum: "bbb"},
+		{Target: "/home/user/.cache/zsh", Source: "/home/user/dotfiles/zsh", Kind: KindDirectory},
+		{Target: "/home/user/.local/share/fonts/Mono.ttf", Source: "/home/user/dotfiles/fonts/Mono.ttf", Kind: KindFont},
+		{Target: "/home/user/.local/bin/jq", Source: "https://example.com/jq", Kind: KindDownload, Checksum: "ccc", SourceChecksum: "ddd"},
+	} {
+		link.Created, link.Verified = created, verified
+		lock.Symlinks[link.Target] = link
+	}
+
+	data, err := lock.ExportYAML("/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "resolved: ./shared/vimrc")
+
+	// Every field survives, on another machine too
+	imported, err := ImportYAML(data, "/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.Equal(t, lock.Symlinks, imported.Symlinks)
+
+	moved, err := ImportYAML(data, "/Users/user/dotfiles", "/Users/user")
+	require.NoError(t, err)
+	tig := moved.Symlinks["/Users/user/.tigrc"]
+	assert.Equal(t, KindCopy, tig.Kind)
+	assert.Equal(t, "aaa", tig.Checksum)
+	assert.Equal(t, "/Users/user/dotfiles/shared/vimrc", moved.Symlinks["/Users/user/.vimrc"].Resolved)
+}
+
+func TestExportImportYAMLState(t *testing.T) {
+	applied := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	lock := New()
+	lock.Portable = true
+	lock.SetApplied("/home/user/dotfiles/vim", applied)
+	lock.SetConfigHash("/home/user/dotfiles/vim", "hash")
+	ns := lock.Namespace("/tmp/alt")
+	ns.AddSymlink("/tmp/alt/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	ns.SetApplied("/home/user/dotfiles/vim", applied)
+
+	data, err := lock.ExportYAML("/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "./vim: hash")
+
+	imported, err := ImportYAML(data, "/home/user/dotfiles", "/home/user")
+	require.NoError(t, err)
+	assert.True(t, imported.Portable)
+	at, ok := imported.LastApplied("/home/user/dotfiles/vim")
+	assert.True(t, ok)
+	assert.True(t, applied.Equal(at))
+	assert.Equal(t, map[string]string{"/home/user/dotfiles/vim": "hash"}, imported.ConfigHashes)
+	require.Contains(t, imported.Namespaces, "/tmp/alt")
+	assert.Contains(t, imported.Namespaces["/tmp/alt"].Symlinks, "/tmp/alt/home/user/.vimrc")
+	_, ok = imported.Namespaces["/tmp/alt"].LastApplied("/home/user/dotfiles/vim")
+	assert.True(t, ok)
+}
+
+func TestNamespaces(t *testing.T) {
+	tmpDir := t.TempDir()
+	lockPath := filepath.Join(tmpDir, "test.lock")
+
+	root := New()
+	root.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	require.NoError(t, root.Save(lockPath))
+
+	ns, err := LoadNamespace(lockPath, "/tmp/homesim")
+	require.NoError(t, err)
+	assert.Empty(t, ns.Symlinks)
+
+	ns.AddSymlink("/tmp/homesim/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	require.NoError(t, ns.Save(lockPath))
+
+	// Saving the namespace keeps the real entries intact
+	loaded, err := Load(lockPath)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Symlinks, 1)
+	assert.Contains(t, loaded.Symlinks, "/home/user/.vimrc")
+	assert.Contains(t, loaded.Namespace("/tmp/homesim").Symlinks, "/tmp/homesim/.vimrc")
+}
+
+func TestNormalize(t *testing.T) {
+	composed := "/home/user/caf\u00e9.conf"
+	decomposed := "/home/user/cafe\u0301.conf"
+
+	lock := New()
+	lock.AddSymlink(decomposed, "/home/user/dotfiles/cafe/cafe\u0301.conf", false)
+	lock.AddSymlink("/home/user/.vimrc", "/home/user/dotfiles/vim/.vimrc", false)
+	lock.Normalize()
+
+	require.Len(t, lock.Symlinks, 2)
+	assert.NotContains(t, lock.Symlinks, decomposed)
+	assert.Equal(t, composed, lock.Symlinks[composed].Target)
+
+	// Entries spelled both ways collapse into the composed one
+	lock.AddSymlink(decomposed, "/home/user/dotfiles/other.conf", false)
+	lock.Normalize()
+	require.Len(t, lock.Symlinks, 2)
+	assert.Equal(t, "/home/user/dotfiles/cafe/cafe\u0301.conf", lock.Symlinks[composed].Source)
+}
+
+func TestWhich(t *testing.T) {
+	lock := New()
+	lock.AddSymlink("/home/user/.vimrc", "/dotfiles/vim/.vimrc", false)
+	lock.AddSymlink("/home/user/.config/nvim", "/dotfiles/nvim/.config/nvim", true)
+	lock.AddDirectory("/home/user/.cache/app", "/dotfiles/app")
+
+	link, source, ok := lock.Which("/home/user/.vimrc")
+	require.True(t, ok)
+	assert.Equal(t, "/home/user/.vimrc", link.Target)
+	assert.Equal(t, "/dotfiles/vim/.vimrc", source)
+
+	// Files inside a folded directory belong to the directory's link
+	link, source, ok = lock.Which("/home/user/.config/nvim/lua/init.lua")
+	require.True(t, ok)
+	assert.Equal(t, "/home/user/.config/nvim", link.Target)
+	assert.Equal(t, "/dotfiles/nvim/.config/nvim/lua/init.lua", source)
+
+	// Files inside directories farm only created aren't managed
+	_, _, ok = lock.Which("/home/user/.cache/app/data")
+	assert.False(t, ok)
+	_, _, ok = lock.Which("/home/user/.bashrc")
+	assert.False(t, ok)
+}
+
+func TestMerge(t *testing.T) {
+	system := New()
+	system.AddSymlink("/etc/vimrc", "/dotfiles/system/vimrc", false)
+	system.AddSymlink("/home/user/.zshrc", "/dotfiles/system/.zshrc", false)
+	system.AddSymlink("/home/user/.gitconfig", "/dotfiles/git/.gitconfig", false)
+
+	user := New()
+	user.AddSymlink("/home/user/.zshrc", "/dotfiles/user/.zshrc", false)
+	user.AddSymlink("/home/user/.gitconfig", "/dotfiles/git/.gitconfig", false)
+	user.AddSymlink("/home/user/.vimrc", "/dotfiles/user/.vimrc", false)
+	user.SetUnfolded("/dotfiles/user/.config")
+
+	conflicts := system.Merge(user)
+	assert.Equal(t, []string{"/home/user/.zshrc"}, conflicts)
+
+	// Entries of the lockfile merged into win
+	assert.Len(t, system.Symlinks, 4)
+	assert.Equal(t, "/dotfiles/system/.zshrc", system.Symlinks["/home/user/.zshrc"].Source)
+	assert.Equal(t, "/dotfiles/user/.vimrc", system.Symlinks["/home/user/.vimrc"].Source)
+
+	assert.True(t, system.IsUnfolded("/dotfiles/user/.config"))
+}
+
+func TestPortable(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	repoDir := filepath.Join(homeDir, "dotfiles")
+	require.NoError(t, os.Mkdir(repoDir, 0755))
+	path := filepath.Join(repoDir, "farm.lock")
+
+	lock := New()
+	lock.Portable = true
+	lock.AddSymlink(filepath.Join(homeDir, ".zshrc"), filepath.Join(repoDir, "zsh/.zshrc"), false)
+	lock.AddSymlink("/etc/vimrc", filepath.Join(repoDir, "vim/vimrc"), false)
+	lock.SetUnfolded(filepath.Join(repoDir, "nvim"))
+	lock.SetApplied(filepath.Join(repoDir, "zsh"), time.Now())
+	require.NoError(t, lock.Save(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), homeDir)
+	assert.Contains(t, string(data), `"$HOME/.zshrc"`)
+	assert.Contains(t, string(data), `"$REPO/zsh/.zshrc"`)
+	assert.Contains(t, string(data), `"/etc/vimrc"`)
+
+	// Another machine with its home directory elsewhere
+	otherHome := t.TempDir()
+	t.Setenv("HOME", otherHome)
+	otherRepo := filepath.Join(otherHome, "src/dotfiles")
+	require.NoError(t, os.MkdirAll(otherRepo, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(otherRepo, "farm.lock"), data, 0644))
+
+	loaded, err := Load(filepath.Join(otherRepo, "farm.lock"))
+	require.NoError(t, err)
+	assert.True(t, loaded.Portable)
+
+	link, ok := loaded.Symlinks[filepath.Join(otherHome, ".zshrc")]
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(otherRepo, "zsh/.zshrc"), link.Source)
+	assert.Equal(t, filepath.Join(otherHome, ".zshrc"), link.Target)
+	assert.Equal(t, filepath.Join(otherRepo, "vim/vimrc"), loaded.Symlinks["/etc/vimrc"].Source)
+
+	assert.True(t, loaded.IsUnfolded(filepath.Join(otherRepo, "nvim")))
+
+	_, ok = loaded.LastApplied(filepath.Join(otherRepo, "zsh"))
+	assert.True(t, ok)
+}
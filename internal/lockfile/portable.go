@@ -0,0 +1,109 @@
+package lockfile
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mskelton/farm/internal/home"
+)
+
+// Markers a portable lockfile stores in place of the directory holding the
+// lockfile and the home directory, so it stays valid on machines where they
+// live elsewhere.
+const (
+	RepoMarker = "$REPO"
+	HomeMarker = "$HOME"
+)
+
+// portableDirs returns the directories the markers of the lockfile at path
+// stand for. Either is empty when it can't be determined.
+func portableDirs(path string) (string, string) {
+	repoDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		repoDir = ""
+	}
+
+	homeDir, err := home.Dir()
+	if err != nil {
+		homeDir = ""
+	}
+
+	return repoDir, homeDir
+}
+
+// withMarkers replaces a leading repo or home directory with its marker. The
+// repo is tried first since it usually lives inside the home directory.
+func withMarkers(path, repoDir, homeDir string) string {
+	for _, dir := range []struct{ path, marker string }{{repoDir, RepoMarker}, {homeDir, HomeMarker}} {
+		if dir.path == "" {
+			continue
+		}
+		if path == dir.path {
+			return dir.marker
+		}
+		if strings.HasPrefix(path, dir.path+"/") {
+			return dir.marker + strings.TrimPrefix(path, dir.path)
+		}
+	}
+	return path
+}
+
+// withoutMarkers expands a leading marker to the directory it stands for.
+func withoutMarkers(path, repoDir, homeDir string) string {
+	for _, dir := range []struct{ path, marker string }{{repoDir, RepoMarker}, {homeDir, HomeMarker}} {
+		if dir.path == "" {
+			continue
+		}
+		if path == dir.marker {
+			return dir.path
+		}
+		if strings.HasPrefix(path, dir.marker+"/") {
+			return dir.path + strings.TrimPrefix(path, dir.marker)
+		}
+	}
+	return path
+}
+
+// mapPaths returns a copy of the lockfile with fn applied to every path it
+// holds, including those of its namespaces.
+func (l *LockFile) mapPaths(fn func(string) string) *LockFile {
+	mapped := *l
+	mapped.Symlinks = make(SymlinkMap, len(l.Symlinks))
+	for target, link := range l.Symlinks {
+		link.Source = fn(link.Source)
+		link.Target = fn(link.Target)
+		if link.Resolved != "" {
+			link.Resolved = fn(link.Resolved)
+		}
+		mapped.Symlinks[fn(target)] = link
+	}
+
+	mapped.Folds = make(map[string]FoldDecision, len(l.Folds))
+	for source, decision := range l.Folds {
+		mapped.Folds[fn(source)] = decision
+	}
+
+	if l.Applied != nil {
+		mapped.Applied = make(map[string]time.Time, len(l.Applied))
+		for source, applied := range l.Applied {
+			mapped.Applied[fn(source)] = applied
+		}
+	}
+
+	if l.ConfigHashes != nil {
+		mapped.ConfigHashes = make(map[string]string, len(l.ConfigHashes))
+		for source, hash := range l.ConfigHashes {
+			mapped.ConfigHashes[fn(source)] = hash
+		}
+	}
+
+	if l.Namespaces != nil {
+		mapped.Namespaces = make(map[string]*LockFile, len(l.Namespaces))
+		for base, ns := range l.Namespaces {
+			mapped.Namespaces[fn(base)] = ns.mapPaths(fn)
+		}
+	}
+
+	return &mapped
+}
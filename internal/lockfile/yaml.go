@@ -0,0 +1,197 @@
+package lockfile
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type yamlLockFile struct {
+	Version      string                   `yaml:"version,omitempty"`
+	Updated      time.Time                `yaml:"updated"`
+	Portable     bool                     `yaml:"portable,omitempty"`
+	Symlinks     []yamlSymlink            `yaml:"symlinks"`
+	Unfolded     []string                 `yaml:"unfolded,omitempty"`
+	Applied      map[string]time.Time     `yaml:"applied,omitempty"`
+	ConfigHashes map[string]string        `yaml:"config_hashes,omitempty"`
+	Namespaces   map[string]*yamlLockFile `yaml:"namespaces,omitempty"`
+}
+
+type yamlSymlink struct {
+	Target         string    `yaml:"target"`
+	Source         string    `yaml:"source"`
+	IsFolded       bool      `yaml:"folded,omitempty"`
+	Kind           string    `yaml:"kind,omitempty"`
+	Checksum       string    `yaml:"checksum,omitempty"`
+	SourceChecksum string    `yaml:"source_checksum,omitempty"`
+	Resolved       string    `yaml:"resolved,omitempty"`
+	Created        time.Time `yaml:"created"`
+	Verified       time.Time `yaml:"verified,omitempty"`
+}
+
+// ExportYAML renders the lockfile as sorted YAML with paths shortened
+// relative to baseDir ("./") or homeDir ("~/") where possible. Every field of
+// each entry is kept, so copied, rendered, and downloaded files are still
+// recognized as farm's after importing, along with when each package was
+// applied, the directories unfolded by hand, and the namespaces of
+// --target-base runs.
+func (l *LockFile) ExportYAML(baseDir, homeDir string) ([]byte, error) {
+	out := l.toYAML(baseDir, homeDir)
+	out.Version = l.Version
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lockfile: %w", err)
+	}
+
+	return data, nil
+}
+
+func (l *LockFile) toYAML(baseDir, homeDir string) *yamlLockFile {
+	out := &yamlLockFile{
+		Updated:  l.Updated,
+		Portable: l.Portable,
+		Symlinks: []yamlSymlink{},
+	}
+
+	for _, link := range l.Symlinks.Sorted() {
+		entry := yamlSymlink{
+			Target:         shortenPath(link.Target, baseDir, homeDir),
+			Source:         shortenPath(link.Source, baseDir, homeDir),
+			IsFolded:       link.IsFolded,
+			Kind:           link.Kind,
+			Checksum:       link.Checksum,
+			SourceChecksum: link.SourceChecksum,
+			Created:        link.Created,
+			Verified:       link.Verified,
+		}
+		if link.Resolved != "" {
+			entry.Resolved = shortenPath(link.Resolved, baseDir, homeDir)
+		}
+		out.Symlinks = append(out.Symlinks, entry)
+	}
+
+	for _, source := range slices.Sorted(maps.Keys(l.Folds)) {
+		if l.Folds[source].Unfolded {
+			out.Unfolded = append(out.Unfolded, shortenPath(source, baseDir, homeDir))
+		}
+	}
+
+	if len(l.Applied) > 0 {
+		out.Applied = make(map[string]time.Time, len(l.Applied))
+		for source, at := range l.Applied {
+			out.Applied[shortenPath(source, baseDir, homeDir)] = at
+		}
+	}
+
+	if len(l.ConfigHashes) > 0 {
+		out.ConfigHashes = make(map[string]string, len(l.ConfigHashes))
+		for source, hash := range l.ConfigHashes {
+			out.ConfigHashes[shortenPath(source, baseDir, homeDir)] = hash
+		}
+	}
+
+	if len(l.Namespaces) > 0 {
+		out.Namespaces = make(map[string]*yamlLockFile, len(l.Namespaces))
+		for base, ns := range l.Namespaces {
+			out.Namespaces[shortenPath(base, baseDir, homeDir)] = ns.toYAML(baseDir, homeDir)
+		}
+	}
+
+	return out
+}
+
+func ImportYAML(data []byte, baseDir, homeDir string) (*LockFile, error) {
+	var in yamlLockFile
+	if err := yaml.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+
+	if in.Version != CurrentVersion {
+		return nil, fmt.Errorf("unsupported lockfile version: %s", in.Version)
+	}
+
+	return fromYAML(&in, baseDir, homeDir)
+}
+
+func fromYAML(in *yamlLockFile, baseDir, homeDir string) (*LockFile, error) {
+	lock := New()
+	lock.Updated = in.Updated
+	lock.Portable = in.Portable
+
+	for i, link := range in.Symlinks {
+		if link.Target == "" || link.Source == "" {
+			return nil, fmt.Errorf("symlink %d: source and target are required", i)
+		}
+
+		target := expandPath(link.Target, baseDir, homeDir)
+		entry := Symlink{
+			Source:         expandPath(link.Source, baseDir, homeDir),
+			Target:         target,
+			Created:        link.Created,
+			IsFolded:       link.IsFolded,
+			Kind:           link.Kind,
+			Checksum:       link.Checksum,
+			SourceChecksum: link.SourceChecksum,
+			Verified:       link.Verified,
+		}
+		if link.Resolved != "" {
+			entry.Resolved = expandPath(link.Resolved, baseDir, homeDir)
+		}
+		lock.Symlinks[target] = entry
+	}
+
+	for _, source := range in.Unfolded {
+		lock.SetUnfolded(expandPath(source, baseDir, homeDir))
+	}
+
+	for source, at := range in.Applied {
+		lock.SetApplied(expandPath(source, baseDir, homeDir), at)
+	}
+
+	for source, hash := range in.ConfigHashes {
+		lock.SetConfigHash(expandPath(source, baseDir, homeDir), hash)
+	}
+
+	for base, ns := range in.Namespaces {
+		imported, err := fromYAML(ns, baseDir, homeDir)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", base, err)
+		}
+		if lock.Namespaces == nil {
+			lock.Namespaces = make(map[string]*LockFile)
+		}
+		lock.Namespaces[expandPath(base, baseDir, homeDir)] = imported
+	}
+
+	return lock, nil
+}
+
+func shortenPath(path, baseDir, homeDir string) string {
+	if baseDir != "" && strings.HasPrefix(path, baseDir+"/") {
+		return "./" + strings.TrimPrefix(path, baseDir+"/")
+	}
+
+	if homeDir != "" && strings.HasPrefix(path, homeDir+"/") {
+		return "~/" + strings.TrimPrefix(path, homeDir+"/")
+	}
+
+	return path
+}
+
+func expandPath(path, baseDir, homeDir string) string {
+	if strings.HasPrefix(path, "./") {
+		return filepath.Join(baseDir, path[2:])
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir, path[2:])
+	}
+
+	return path
+}
@@ -0,0 +1,118 @@
+// Package plan describes the changes a run makes, or would make in a dry run,
+// in a form that serializes the same way every time.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Kind string
+
+const (
+	Create  Kind = "create"
+	Replace Kind = "replace"
+	Remove  Kind = "remove"
+	Mkdir   Kind = "mkdir"
+	Skip    Kind = "skip"
+)
+
+// kindOrder orders actions on the same target the way they're carried out.
+var kindOrder = []Kind{Skip, Remove, Mkdir, Replace, Create}
+
+// Action is a single change to a target. Package and Source are empty for
+// changes that don't come from a package, such as removing a dead link of a
+// package that's no longer configured.
+type Action struct {
+	Kind    Kind   `json:"kind" yaml:"kind"`
+	Package string `json:"package,omitempty" yaml:"package,omitempty"`
+	Source  string `json:"source,omitempty" yaml:"source,omitempty"`
+	Target  string `json:"target" yaml:"target"`
+	Reason  string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+type Plan struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+}
+
+func (p *Plan) Add(action Action) {
+	p.Actions = append(p.Actions, action)
+}
+
+// Count returns the number of actions of the given kind.
+func (p *Plan) Count(kind Kind) int {
+	count := 0
+	for _, action := range p.Actions {
+		if action.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Sorted returns a copy of the plan with its actions ordered by target, and
+// by the order they're carried out in for the same target, so serializing it
+// doesn't depend on the order packages were walked in.
+func (p *Plan) Sorted() *Plan {
+	actions := slices.Clone(p.Actions)
+	if actions == nil {
+		actions = []Action{}
+	}
+
+	slices.SortStableFunc(actions, func(a, b Action) int {
+		if c := strings.Compare(a.Target, b.Target); c != 0 {
+			return c
+		}
+		return slices.Index(kindOrder, a.Kind) - slices.Index(kindOrder, b.Kind)
+	})
+	return &Plan{Actions: actions}
+}
+
+// Reason returns why the plan carries out an action of the given kind on
+// target, or an empty string without one.
+func (p *Plan) Reason(kind Kind, target string) string {
+	for _, action := range p.Actions {
+		if action.Kind == kind && action.Target == target {
+			return action.Reason
+		}
+	}
+	return ""
+}
+
+// Explain lists the sorted plan one action per line, with the kind, package,
+// target, and reason separated by tabs so it can be read by tools like cut or
+// awk. Missing fields are written as a dash.
+func (p *Plan) Explain() string {
+	var b strings.Builder
+	for _, action := range p.Sorted().Actions {
+		fields := []string{string(action.Kind), action.Package, action.Target, action.Reason}
+		for i, field := range fields {
+			if field == "" {
+				fields[i] = "-"
+			}
+		}
+		b.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	return b.String()
+}
+
+// Marshal serializes the sorted plan as json or yaml.
+func (p *Plan) Marshal(format string) ([]byte, error) {
+	sorted := p.Sorted()
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(sorted, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(sorted)
+	default:
+		return nil, fmt.Errorf("unknown plan format %q, expected json or yaml", format)
+	}
+}
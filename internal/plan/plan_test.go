@@ -0,0 +1,84 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	var p Plan
+	p.Add(Action{Kind: Create, Package: "vim", Source: "/dotfiles/vim/.vimrc", Target: "/home/.vimrc"})
+	p.Add(Action{Kind: Mkdir, Package: "nvim", Source: "/dotfiles/nvim/init.lua", Target: "/home/.config", Reason: "parent directory"})
+	p.Add(Action{Kind: Remove, Target: "/home/.vimrc", Reason: "dead link, target is missing"})
+
+	data, err := p.Marshal("json")
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "actions": [
+    {
+      "kind": "mkdir",
+      "package": "nvim",
+      "source": "/dotfiles/nvim/init.lua",
+      "target": "/home/.config",
+      "reason": "parent directory"
+    },
+    {
+      "kind": "remove",
+      "target": "/home/.vimrc",
+      "reason": "dead link, target is missing"
+    },
+    {
+      "kind": "create",
+      "package": "vim",
+      "source": "/dotfiles/vim/.vimrc",
+      "target": "/home/.vimrc"
+    }
+  ]
+}
+`, string(data))
+
+	data, err = p.Marshal("yaml")
+	require.NoError(t, err)
+	assert.Equal(t, `actions:
+    - kind: mkdir
+      package: nvim
+      source: /dotfiles/nvim/init.lua
+      target: /home/.config
+      reason: parent directory
+    - kind: remove
+      target: /home/.vimrc
+      reason: dead link, target is missing
+    - kind: create
+      package: vim
+      source: /dotfiles/vim/.vimrc
+      target: /home/.vimrc
+`, string(data))
+
+	// Serializing doesn't reorder the plan itself
+	assert.Equal(t, Create, p.Actions[0].Kind)
+	assert.Equal(t, 1, p.Count(Remove))
+
+	empty, err := (&Plan{}).Marshal("json")
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"actions\": []\n}\n", string(empty))
+
+	_, err = p.Marshal("toml")
+	assert.Error(t, err)
+}
+
+func TestExplain(t *testing.T) {
+	var p Plan
+	p.Add(Action{Kind: Create, Package: "vim", Source: "/dotfiles/vim/.vimrc", Target: "/home/.vimrc", Reason: "new link"})
+	p.Add(Action{Kind: Remove, Target: "/home/.vimrc", Reason: "dead link, target is missing"})
+	p.Add(Action{Kind: Skip, Package: "zsh", Reason: "required path doesn't exist"})
+
+	assert.Equal(t, "skip\tzsh\t-\trequired path doesn't exist\n"+
+		"remove\t-\t/home/.vimrc\tdead link, target is missing\n"+
+		"create\tvim\t/home/.vimrc\tnew link\n", p.Explain())
+
+	assert.Equal(t, "new link", p.Reason(Create, "/home/.vimrc"))
+	assert.Equal(t, "", p.Reason(Replace, "/home/.vimrc"))
+	assert.Equal(t, "", (&Plan{}).Explain())
+}
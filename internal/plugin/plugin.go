@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Plugins are executables named farm-<name> on PATH. Farm asks each plugin
+// which events it handles by running `farm-<name> farm-plugin-info`, which
+// must print a JSON object like {"description": "...", "events": ["post-apply"]}.
+// Events are delivered by running `farm-<name> <event>` with the event's
+// JSON payload on stdin.
+const (
+	Prefix         = "farm-"
+	InfoCommand    = "farm-plugin-info"
+	EventPrePlan   = "pre-plan"
+	EventPostApply = "post-apply"
+)
+
+const infoTimeout = 5 * time.Second
+
+type Plugin struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+}
+
+// Enabled finds the plugins with the given names and asks only them for the
+// events they want, so executables on PATH the user hasn't opted into never
+// run. The names no plugin was found for are returned too.
+func Enabled(pathList string, names []string) (plugins []*Plugin, missing []string) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	found := make(map[string]*Plugin)
+	for _, plugin := range Find(pathList) {
+		found[plugin.Name] = plugin
+	}
+
+	for _, name := range names {
+		plugin, ok := found[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		plugin.loadInfo()
+		plugins = append(plugins, plugin)
+	}
+	return plugins, missing
+}
+
+// Handled reports whether any of the plugins handles an event, so payloads
+// that take work to build are only built when needed.
+func Handled(plugins []*Plugin, event string) bool {
+	for _, p := range plugins {
+		if p.Handles(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find finds plugins in the directories of a PATH-style list without running
+// them, so their events are unknown. When the same plugin name appears in
+// several directories, the first one wins.
+func Find(pathList string) []*Plugin {
+	seen := make(map[string]bool)
+	var plugins []*Plugin
+
+	for _, dir := range filepath.SplitList(pathList) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, Prefix) || len(name) == len(Prefix) || seen[name] {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[name] = true
+
+			plugins = append(plugins, &Plugin{
+				Name: strings.TrimPrefix(name, Prefix),
+				Path: path,
+			})
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+
+	return plugins
+}
+
+// loadInfo queries the plugin for its registration. Executables that don't
+// speak the protocol are still listed but receive no events.
+func (p *Plugin) loadInfo() {
+	ctx, cancel := context.WithTimeout(context.Background(), infoTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, p.Path, InfoCommand).Output()
+	if err != nil {
+		return
+	}
+
+	var info struct {
+		Description string   `json:"description"`
+		Events      []string `json:"events"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return
+	}
+
+	p.Description = info.Description
+	p.Events = info.Events
+}
+
+func (p *Plugin) Handles(event string) bool {
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plugin) Run(event string, payload any, stdout io.Writer) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", event, err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.Path, event)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("plugin %s failed on %s: %w: %s", p.Name, event, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// Dispatch delivers an event to every plugin registered for it, in name
+// order, stopping at the first failure.
+func Dispatch(plugins []*Plugin, event string, payload any, stdout io.Writer) error {
+	for _, p := range plugins {
+		if !p.Handles(event) {
+			continue
+		}
+
+		if err := p.Run(event, payload, stdout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
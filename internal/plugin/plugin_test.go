@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePlugin(t *testing.T, dir, name, script string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755))
+}
+
+func TestFind(t *testing.T) {
+	firstDir := t.TempDir()
+	secondDir := t.TempDir()
+
+	writePlugin(t, firstDir, "farm-notify", `if [ "$1" = "farm-plugin-info" ]; then
+  echo '{"description": "Send notifications", "events": ["post-apply"]}'
+fi
+`)
+	writePlugin(t, secondDir, "farm-notify", `echo '{"events": ["pre-plan"]}'`)
+	writePlugin(t, secondDir, "farm-legacy", `exit 1`)
+	require.NoError(t, os.WriteFile(filepath.Join(secondDir, "farm-noexec"), []byte("#!/bin/sh\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(secondDir, "other"), []byte("#!/bin/sh\n"), 0755))
+
+	pathList := firstDir + string(os.PathListSeparator) + secondDir
+	plugins := Find(pathList)
+	require.Len(t, plugins, 2)
+	assert.Equal(t, "legacy", plugins[0].Name)
+	assert.Equal(t, "notify", plugins[1].Name)
+	assert.Equal(t, filepath.Join(firstDir, "farm-notify"), plugins[1].Path)
+
+	// Nothing is run until a plugin is enabled
+	assert.Empty(t, plugins[1].Events)
+
+	plugins, _ = Enabled(pathList, []string{"legacy", "notify"})
+	require.Len(t, plugins, 2)
+	assert.Empty(t, plugins[0].Events)
+	assert.Equal(t, "Send notifications", plugins[1].Description)
+	assert.True(t, plugins[1].Handles(EventPostApply))
+	assert.False(t, plugins[1].Handles(EventPrePlan))
+}
+
+func TestEnabled(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+
+	writePlugin(t, dir, "farm-notify", `echo '{"events": ["post-apply"]}'`)
+	writePlugin(t, dir, "farm-other", `touch `+ran)
+
+	plugins, missing := Enabled(dir, []string{"notify", "gone"})
+	require.Len(t, plugins, 1)
+	assert.Equal(t, "notify", plugins[0].Name)
+	assert.True(t, Handled(plugins, EventPostApply))
+	assert.False(t, Handled(plugins, EventPrePlan))
+	assert.Equal(t, []string{"gone"}, missing)
+
+	// Plugins that weren't enabled are never run
+	assert.NoFileExists(t, ran)
+
+	plugins, missing = Enabled(dir, nil)
+	assert.Empty(t, plugins)
+	assert.Empty(t, missing)
+}
+
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	received := filepath.Join(dir, "received.json")
+
+	writePlugin(t, dir, "farm-record", `if [ "$1" = "farm-plugin-info" ]; then
+  echo '{"events": ["post-apply"]}'
+  exit 0
+fi
+echo "handling $1"
+cat > `+received+`
+`)
+	writePlugin(t, dir, "farm-veto", `if [ "$1" = "farm-plugin-info" ]; then
+  echo '{"events": ["pre-plan"]}'
+  exit 0
+fi
+echo "not today" >&2
+exit 1
+`)
+
+	plugins, _ := Enabled(dir, []string{"record", "veto"})
+	require.Len(t, plugins, 2)
+
+	var stdout bytes.Buffer
+	require.NoError(t, Dispatch(plugins, EventPostApply, map[string][]string{"created": {"/home/user/.vimrc"}}, &stdout))
+	assert.Equal(t, "handling post-apply\n", stdout.String())
+
+	data, err := os.ReadFile(received)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"created": ["/home/user/.vimrc"]}`, string(data))
+
+	err = Dispatch(plugins, EventPrePlan, map[string]string{}, &stdout)
+	assert.ErrorContains(t, err, "plugin veto failed on pre-plan")
+	assert.ErrorContains(t, err, "not today")
+}
@@ -0,0 +1,141 @@
+// Package prompt asks the user yes/no questions, either on the terminal or
+// from answers recorded ahead of time, so interactive flows can be scripted
+// and tested.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prompter answers yes/no questions.
+type Prompter interface {
+	Confirm(question string, defaultAnswer bool) (bool, error)
+}
+
+// Terminal reads answers typed by the user.
+type Terminal struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewTerminal(in io.Reader, out io.Writer) *Terminal {
+	return &Terminal{in: bufio.NewReader(in), out: out}
+}
+
+func (t *Terminal) Confirm(question string, defaultAnswer bool) (bool, error) {
+	fmt.Fprintf(t.out, "%s %s ", question, hint(defaultAnswer))
+
+	line, err := t.in.ReadString('\n')
+	if err != nil && line == "" {
+		return defaultAnswer, nil
+	}
+
+	// Anything but yes is a no
+	answer, err := parse(line, defaultAnswer)
+	return answer && err == nil, nil
+}
+
+// Auto takes the default answer of every question, or yes when Yes is set,
+// without waiting for input.
+type Auto struct {
+	Out io.Writer
+	Yes bool
+}
+
+func (a *Auto) Confirm(question string, defaultAnswer bool) (bool, error) {
+	answer := defaultAnswer || a.Yes
+	echo(a.Out, question, defaultAnswer, answer)
+	return answer, nil
+}
+
+// Answer is a prerecorded reply to every question containing Question.
+type Answer struct {
+	Question string `yaml:"question"`
+	Answer   string `yaml:"answer"`
+}
+
+// Script answers questions from an answers file. Questions it has no answer
+// for are an error rather than a guess, so a run never goes somewhere the
+// script didn't anticipate.
+type Script struct {
+	Answers []Answer
+	Out     io.Writer
+}
+
+// Load reads an answers file, a YAML list of answers.
+func Load(path string, out io.Writer) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read answers: %w", err)
+	}
+
+	var answers []Answer
+	if err := yaml.Unmarshal(data, &answers); err != nil {
+		return nil, fmt.Errorf("failed to parse answers %s: %w", path, err)
+	}
+
+	for i, answer := range answers {
+		if answer.Question == "" {
+			return nil, fmt.Errorf("answer %d in %s has no question", i+1, path)
+		}
+		if _, err := parse(answer.Answer, false); err != nil {
+			return nil, fmt.Errorf("answer to %q in %s: %w", answer.Question, path, err)
+		}
+	}
+
+	return &Script{Answers: answers, Out: out}, nil
+}
+
+func (s *Script) Confirm(question string, defaultAnswer bool) (bool, error) {
+	for _, answer := range s.Answers {
+		if !strings.Contains(question, answer.Question) {
+			continue
+		}
+
+		reply, err := parse(answer.Answer, defaultAnswer)
+		if err != nil {
+			return false, err
+		}
+		echo(s.Out, question, defaultAnswer, reply)
+		return reply, nil
+	}
+
+	return false, fmt.Errorf("no answer to %q in the answers file", strings.TrimSpace(question))
+}
+
+func hint(defaultAnswer bool) string {
+	if defaultAnswer {
+		return "[Y/n]"
+	}
+	return "[y/N]"
+}
+
+// echo prints the question along with the answer it was given, so the output
+// reads the same as when the user typed it.
+func echo(out io.Writer, question string, defaultAnswer, answer bool) {
+	reply := "n"
+	if answer {
+		reply = "y"
+	}
+	fmt.Fprintf(out, "%s %s %s\n", question, hint(defaultAnswer), reply)
+}
+
+// parse reads a reply, where an empty reply takes the default answer.
+func parse(reply string, defaultAnswer bool) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(reply)) {
+	case "":
+		return defaultAnswer, nil
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown answer %q, expected yes or no", strings.TrimSpace(reply))
+	}
+}
@@ -0,0 +1,81 @@
+package prompt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminal(t *testing.T) {
+	out := new(bytes.Buffer)
+	p := NewTerminal(strings.NewReader("y\n\nmaybe\nNO\n"), out)
+
+	for _, expected := range []bool{true, true, false, false} {
+		answer, err := p.Confirm("Continue?", true)
+		require.NoError(t, err)
+		assert.Equal(t, expected, answer)
+	}
+	assert.Equal(t, strings.Repeat("Continue? [Y/n] ", 4), out.String())
+
+	// Running out of input takes the default answer
+	answer, err := p.Confirm("Continue?", false)
+	require.NoError(t, err)
+	assert.False(t, answer)
+}
+
+func TestAuto(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	answer, err := (&Auto{Out: out}).Confirm("Continue?", false)
+	require.NoError(t, err)
+	assert.False(t, answer)
+
+	answer, err = (&Auto{Out: out, Yes: true}).Confirm("Continue?", false)
+	require.NoError(t, err)
+	assert.True(t, answer)
+
+	assert.Equal(t, "Continue? [y/N] n\nContinue? [y/N] y\n", out.String())
+}
+
+func TestScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answers.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`- question: orphaned symlinks
+  answer: yes
+- question: submodule
+  answer: ""
+`), 0644))
+
+	out := new(bytes.Buffer)
+	p, err := Load(path, out)
+	require.NoError(t, err)
+
+	answer, err := p.Confirm("\nRemove 3 orphaned symlinks?", false)
+	require.NoError(t, err)
+	assert.True(t, answer)
+
+	answer, err = p.Confirm("Run 'git submodule update --init' for it?", true)
+	require.NoError(t, err)
+	assert.True(t, answer)
+
+	assert.Equal(t, "\nRemove 3 orphaned symlinks? [y/N] y\nRun 'git submodule update --init' for it? [Y/n] y\n", out.String())
+
+	_, err = p.Confirm("\nDelete everything?", false)
+	assert.EqualError(t, err, `no answer to "Delete everything?" in the answers file`)
+
+	t.Run("invalid answer", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("- question: anything\n  answer: sure\n"), 0644))
+		_, err := Load(path, out)
+		assert.ErrorContains(t, err, `unknown answer "sure"`)
+	})
+
+	t.Run("missing question", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("- answer: yes\n"), 0644))
+		_, err := Load(path, out)
+		assert.ErrorContains(t, err, "answer 1")
+	})
+}
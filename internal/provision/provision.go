@@ -0,0 +1,156 @@
+package provision
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provisioner installs items such as packages or editor extensions that a
+// workstation needs alongside its dotfiles.
+type Provisioner interface {
+	// Installed lists the items that are already installed.
+	Installed() ([]string, error)
+	Install(item string) error
+}
+
+// Command is a provisioner backed by a command line tool. The item is
+// appended to the install arguments.
+type Command struct {
+	Bin         string
+	ListArgs    []string
+	InstallArgs []string
+}
+
+func (c *Command) Installed() ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(c.Bin, c.ListArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", c.commandLine(c.ListArgs), err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Tools like mas print extra columns after the identifier
+	var items []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			items = append(items, fields[0])
+		}
+	}
+	return items, nil
+}
+
+func (c *Command) Install(item string) error {
+	args := append(append([]string{}, c.InstallArgs...), item)
+	out, err := exec.Command(c.Bin, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", c.commandLine(args), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c *Command) commandLine(args []string) string {
+	return strings.Join(append([]string{c.Bin}, args...), " ")
+}
+
+var (
+	mu           sync.RWMutex
+	provisioners = make(map[string]Provisioner)
+)
+
+func init() {
+	Register("brew", &Command{Bin: "brew", ListArgs: []string{"list", "--formula", "-1"}, InstallArgs: []string{"install", "--formula"}})
+	Register("cask", &Command{Bin: "brew", ListArgs: []string{"list", "--cask", "-1"}, InstallArgs: []string{"install", "--cask"}})
+	Register("mas", &Command{Bin: "mas", ListArgs: []string{"list"}, InstallArgs: []string{"install"}})
+	Register("registry", &Registry{})
+	for _, editor := range Editors {
+		Register(editor, &Command{Bin: editor, ListArgs: []string{"--list-extensions"}, InstallArgs: []string{"--install-extension"}})
+	}
+}
+
+// Editors are the VS Code compatible editors whose extensions packages can
+// list under `extensions`.
+var Editors = []string{"code", "cursor", "codium"}
+
+func IsEditor(name string) bool {
+	for _, editor := range Editors {
+		if editor == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Register makes a provisioner available under name. It panics if the name
+// is already registered or the provisioner is nil.
+func Register(name string, p Provisioner) {
+	mu.Lock()
+	defer mu.Unlock()
+	if p == nil {
+		panic("provision: Register provisioner is nil")
+	}
+	if _, dup := provisioners[name]; dup {
+		panic(fmt.Sprintf("provision: Register called twice for provisioner %s", name))
+	}
+	provisioners[name] = p
+}
+
+func Lookup(name string) (Provisioner, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := provisioners[name]
+	return p, ok
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(provisioners))
+	for name := range provisioners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Missing returns the items that the provisioner has not installed yet.
+// Identifiers are compared case-insensitively since tools like code report
+// extensions in a different case than they are usually written. Provisioners
+// that implement Checker are asked about each item instead.
+func Missing(p Provisioner, items []string) ([]string, error) {
+	if checker, ok := p.(Checker); ok {
+		var missing []string
+		for _, item := range items {
+			has, err := checker.Has(item)
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				missing = append(missing, item)
+			}
+		}
+		return missing, nil
+	}
+
+	installed, err := p.Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	have := make(map[string]bool, len(installed))
+	for _, item := range installed {
+		have[strings.ToLower(item)] = true
+	}
+
+	var missing []string
+	for _, item := range items {
+		if !have[strings.ToLower(item)] {
+			missing = append(missing, item)
+		}
+	}
+	return missing, nil
+}
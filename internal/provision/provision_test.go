@@ -0,0 +1,98 @@
+package provision
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTool writes a script that lists the items in a state file and appends
+// installed items to it.
+func fakeTool(t *testing.T, installed string) (*Command, string) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state")
+	require.NoError(t, os.WriteFile(state, []byte(installed), 0644))
+
+	bin := filepath.Join(dir, "tool")
+	script := "#!/bin/sh\nif [ \"$1\" = list ]; then cat " + state + "; else echo \"$2\" >> " + state + "; fi\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	return &Command{Bin: bin, ListArgs: []string{"list"}, InstallArgs: []string{"install"}}, state
+}
+
+func TestCommand(t *testing.T) {
+	tool, state := fakeTool(t, "497799835  Xcode (15.0)\nGolang.Go\n")
+
+	installed, err := tool.Installed()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"497799835", "Golang.Go"}, installed)
+
+	missing, err := Missing(tool, []string{"golang.go", "497799835", "ms-python.python"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ms-python.python"}, missing)
+
+	require.NoError(t, tool.Install("ms-python.python"))
+	content, err := os.ReadFile(state)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "ms-python.python\n")
+
+	broken := &Command{Bin: "false"}
+	_, err = broken.Installed()
+	assert.ErrorContains(t, err, "false failed")
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Subset(t, Names(), []string{"brew", "cask", "code", "mas"})
+
+	_, ok := Lookup("brew")
+	assert.True(t, ok)
+
+	assert.Panics(t, func() { Register("brew", &Command{}) })
+	assert.Panics(t, func() { Register("nil", nil) })
+}
+
+func TestRegistryValues(t *testing.T) {
+	dir := t.TempDir()
+	added := filepath.Join(dir, "added")
+
+	// A fake reg that knows a single value and records what's added
+	bin := filepath.Join(dir, "reg")
+	script := `#!/bin/sh
+if [ "$1" = add ]; then echo "$@" >> ` + added + `; exit 0; fi
+if [ "$4" = AppsUseLightTheme ]; then
+	printf 'HKEY_CURRENT_USER\\Software\\Themes\r\n    AppsUseLightTheme    REG_DWORD    0x0\r\n'
+	exit 0
+fi
+echo "ERROR: The system was unable to find the specified registry key or value." >&2
+exit 1
+`
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+	reg := &Registry{Bin: bin}
+
+	missing, err := Missing(reg, []string{
+		`HKCU\Software\Themes\AppsUseLightTheme=REG_DWORD:0`,
+		`HKCU\Software\Themes\SystemUsesLightTheme=REG_DWORD:0`,
+		`HKCU\Software\Themes\AppsUseLightTheme=REG_DWORD:1`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`HKCU\Software\Themes\SystemUsesLightTheme=REG_DWORD:0`,
+		`HKCU\Software\Themes\AppsUseLightTheme=REG_DWORD:1`,
+	}, missing)
+
+	require.NoError(t, reg.Install(`HKCU\Control Panel\Desktop\WallpaperStyle=10`))
+	content, err := os.ReadFile(added)
+	require.NoError(t, err)
+	assert.Equal(t, "add HKCU\\Control Panel\\Desktop /v WallpaperStyle /t REG_SZ /d 10 /f\n", string(content))
+
+	// Only user values can be set
+	_, err = reg.Has(`HKLM\Software\Policies\Value=1`)
+	assert.ErrorContains(t, err, "must be under HKCU")
+	_, err = reg.Has(`HKCU\Software\Themes`)
+	assert.ErrorContains(t, err, "has no data")
+	_, err = reg.Has(`HKCU=1`)
+	assert.ErrorContains(t, err, "has no name")
+}
@@ -0,0 +1,138 @@
+package provision
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Checker is implemented by provisioners that can't list everything they
+// installed, but can tell whether a single item is.
+type Checker interface {
+	Has(item string) (bool, error)
+}
+
+// registryTypes are the value types reg accepts, REG_SZ being the default.
+var registryTypes = []string{"REG_SZ", "REG_EXPAND_SZ", "REG_MULTI_SZ", "REG_DWORD", "REG_QWORD", "REG_BINARY"}
+
+// Registry sets values in the current user's Windows registry with reg, the
+// counterpart of `defaults write` on macOS. Items are written as
+// KEY\name=TYPE:data, such as
+// HKCU\Control Panel\Desktop\WallpaperStyle=REG_SZ:10, where the type
+// defaults to REG_SZ.
+type Registry struct {
+	Bin string
+}
+
+// registryValue is a registry value an item sets.
+type registryValue struct {
+	key  string
+	name string
+	kind string
+	data string
+}
+
+func parseRegistryValue(item string) (registryValue, error) {
+	path, data, ok := strings.Cut(item, "=")
+	if !ok {
+		return registryValue{}, fmt.Errorf("registry value %s has no data, expected KEY\\name=TYPE:data", item)
+	}
+
+	i := strings.LastIndex(path, `\`)
+	if i <= 0 || i == len(path)-1 {
+		return registryValue{}, fmt.Errorf("registry value %s has no name, expected KEY\\name=TYPE:data", item)
+	}
+
+	value := registryValue{key: path[:i], name: path[i+1:], kind: "REG_SZ", data: data}
+	root, _, _ := strings.Cut(value.key, `\`)
+	if !strings.EqualFold(root, "HKCU") && !strings.EqualFold(root, "HKEY_CURRENT_USER") {
+		return registryValue{}, fmt.Errorf("registry value %s must be under HKCU, only user values are supported", item)
+	}
+
+	if kind, rest, ok := strings.Cut(data, ":"); ok {
+		for _, t := range registryTypes {
+			if strings.EqualFold(kind, t) {
+				value.kind, value.data = t, rest
+				break
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// Installed lists nothing, the registry can't be listed as a whole. Values
+// are checked one at a time with Has instead.
+func (r *Registry) Installed() ([]string, error) {
+	return nil, nil
+}
+
+// Has reports whether the value is already set to the item's data.
+func (r *Registry) Has(item string) (bool, error) {
+	value, err := parseRegistryValue(item)
+	if err != nil {
+		return false, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(r.bin(), "query", value.key, "/v", value.name)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// reg exits with 1 when the key or value doesn't exist
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s query %s failed: %w", r.bin(), value.key, err)
+	}
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "    ", 3)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], value.name) {
+			continue
+		}
+
+		data := ""
+		if len(fields) == 3 {
+			data = strings.TrimSpace(fields[2])
+		}
+		return fields[1] == value.kind && sameRegistryData(value.kind, data, value.data), nil
+	}
+
+	return false, nil
+}
+
+// sameRegistryData compares registry data the way reg prints it to data as
+// written in an item, where numbers may be written in decimal or hex.
+func sameRegistryData(kind, current, want string) bool {
+	if kind == "REG_DWORD" || kind == "REG_QWORD" {
+		a, errA := strconv.ParseUint(current, 0, 64)
+		b, errB := strconv.ParseUint(want, 0, 64)
+		return errA == nil && errB == nil && a == b
+	}
+	return current == want
+}
+
+func (r *Registry) Install(item string) error {
+	value, err := parseRegistryValue(item)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"add", value.key, "/v", value.name, "/t", value.kind, "/d", value.data, "/f"}
+	out, err := exec.Command(r.bin(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s add %s failed: %w: %s", r.bin(), value.key, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (r *Registry) bin() string {
+	if r.Bin == "" {
+		return "reg"
+	}
+	return r.Bin
+}
@@ -0,0 +1,158 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type Source struct {
+	URL    string
+	Key    string
+	Subdir string
+	Ref    string
+}
+
+// Parse recognizes remote package sources of the form
+// host/user/repo//subdir@ref, optionally prefixed with a URL scheme such as
+// https:// or file://. Both the subdirectory and the ref are optional, but a
+// source without a scheme needs one of them to be remote, since
+// host/user/repo may just as well be a local directory such as
+// config.d/nvim/lua.
+func Parse(source string) (*Source, bool) {
+	rest := source
+	scheme := ""
+	if i := strings.Index(rest, "://"); i > 0 {
+		scheme = rest[:i+3]
+		rest = rest[i+3:]
+	} else if !looksLikeHost(rest) || !strings.Contains(rest, "//") && !strings.Contains(rest, "@") {
+		return nil, false
+	}
+
+	ref := ""
+	if i := strings.LastIndex(rest, "@"); i >= 0 && !strings.Contains(rest[i:], "/") {
+		ref = rest[i+1:]
+		rest = rest[:i]
+	}
+
+	subdir := ""
+	if i := strings.Index(rest, "//"); i >= 0 {
+		subdir = strings.Trim(rest[i+2:], "/")
+		rest = rest[:i]
+	}
+
+	url := "https://" + rest
+	if scheme != "" {
+		url = scheme + rest
+	}
+
+	key := strings.Trim(rest, "/")
+	if ref != "" {
+		key += "@" + ref
+	}
+
+	return &Source{
+		URL:    url,
+		Key:    key,
+		Subdir: subdir,
+		Ref:    ref,
+	}, true
+}
+
+// Validate rejects sources whose checkout would land outside the cache
+// directory, or whose subdirectory leaves the checkout.
+func (s *Source) Validate() error {
+	for _, path := range []string{s.Key, s.Subdir} {
+		for _, part := range strings.Split(path, "/") {
+			if part == ".." {
+				return fmt.Errorf("%s can't contain ..", s.URL)
+			}
+		}
+	}
+	if s.Key == "" {
+		return fmt.Errorf("%s doesn't name a repository", s.URL)
+	}
+	return nil
+}
+
+func looksLikeHost(source string) bool {
+	if strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~") {
+		return false
+	}
+
+	parts := strings.Split(source, "/")
+	return len(parts) >= 3 && strings.Contains(parts[0], ".")
+}
+
+func DefaultCacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "farm", "sources")
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache", "farm", "sources")
+}
+
+func (s *Source) CheckoutDir(cacheDir string) string {
+	return filepath.Join(cacheDir, filepath.FromSlash(s.Key))
+}
+
+func (s *Source) Dir(cacheDir string) string {
+	return filepath.Join(s.CheckoutDir(cacheDir), filepath.FromSlash(s.Subdir))
+}
+
+func (s *Source) IsFetched(cacheDir string) bool {
+	_, err := os.Stat(filepath.Join(s.CheckoutDir(cacheDir), ".git"))
+	return err == nil
+}
+
+// Fetch clones the source into the cache, or refreshes an existing checkout
+// to the latest commit of its ref.
+func (s *Source) Fetch(cacheDir string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	dir := s.CheckoutDir(cacheDir)
+
+	if !s.IsFetched(cacheDir) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+
+		args := []string{"clone", "--depth", "1"}
+		if s.Ref != "" {
+			args = append(args, "--branch", s.Ref)
+		}
+		args = append(args, s.URL, dir)
+
+		if err := git(args...); err != nil {
+			return fmt.Errorf("failed to clone %s: %w", s.URL, err)
+		}
+		return nil
+	}
+
+	ref := s.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if err := git("-C", dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", s.URL, err)
+	}
+
+	if err := git("-C", dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+		return fmt.Errorf("failed to update %s: %w", s.URL, err)
+	}
+
+	return nil
+}
+
+func git(args ...string) error {
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		source   string
+		isRemote bool
+		expected Source
+	}{
+		{
+			source:   "github.com/user/dotfiles@main",
+			isRemote: true,
+			expected: Source{URL: "https://github.com/user/dotfiles", Key: "github.com/user/dotfiles@main", Ref: "main"},
+		},
+		{
+			source:   "github.com/user/dotfiles//nvim@v1.2",
+			isRemote: true,
+			expected: Source{URL: "https://github.com/user/dotfiles", Key: "github.com/user/dotfiles@v1.2", Subdir: "nvim", Ref: "v1.2"},
+		},
+		{
+			source:   "file:///srv/repo//config/tmux",
+			isRemote: true,
+			expected: Source{URL: "file:///srv/repo", Key: "srv/repo", Subdir: "config/tmux"},
+		},
+		{source: "./vim"},
+		{source: "/home/user/dotfiles/vim"},
+		{source: "~/dotfiles/vim"},
+		{source: "vim"},
+		{source: "config.d/nvim"},
+		{source: "config.d/nvim/lua"},
+		{source: "github.com/user/dotfiles"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			src, ok := Parse(tt.source)
+			assert.Equal(t, tt.isRemote, ok)
+			if tt.isRemote {
+				assert.Equal(t, tt.expected, *src)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, source := range []string{"https://github.com/user/dotfiles", "github.com/user/dotfiles//nvim@main"} {
+		src, ok := Parse(source)
+		require.True(t, ok)
+		assert.NoError(t, src.Validate(), source)
+	}
+
+	for _, source := range []string{"github.com/../../../.ssh//x", "https://github.com/user/../../x@main", "github.com/user/dotfiles//../../x", "file:///"} {
+		src, ok := Parse(source)
+		require.True(t, ok)
+		assert.Error(t, src.Validate(), source)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	tmpDir := t.TempDir()
+	repoDir := filepath.Join(tmpDir, "repo")
+	cacheDir := filepath.Join(tmpDir, "cache")
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "nvim", "init.lua"), []byte("v1"), 0644))
+	run("init", "-q", "-b", "main")
+	run("add", ".")
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "v1")
+
+	src, ok := Parse("file://" + repoDir + "//nvim@main")
+	require.True(t, ok)
+	assert.False(t, src.IsFetched(cacheDir))
+
+	require.NoError(t, src.Fetch(cacheDir))
+	assert.True(t, src.IsFetched(cacheDir))
+
+	content, err := os.ReadFile(filepath.Join(src.Dir(cacheDir), "init.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(content))
+
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "nvim", "init.lua"), []byte("v2"), 0644))
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "v2")
+
+	require.NoError(t, src.Fetch(cacheDir))
+	content, err = os.ReadFile(filepath.Join(src.Dir(cacheDir), "init.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(content))
+}
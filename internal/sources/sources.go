@@ -0,0 +1,80 @@
+// Package sources prepares the packages whose files don't live in the
+// repository, fetching remote sources and extracting archive sources into
+// farm's cache so they can be linked like any other package.
+package sources
+
+import (
+	"fmt"
+
+	"github.com/mskelton/farm/internal/archive"
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/remote"
+)
+
+// Fetch clones the remote sources of the packages that weren't fetched yet,
+// or all of them when refresh is set, returning how many it fetched. Dry runs
+// only report the sources they would fetch. report, when not nil, is called
+// with each source before it's fetched.
+func Fetch(packages []*config.Package, refresh, dryRun bool, report func(source string)) (int, error) {
+	cacheDir := remote.DefaultCacheDir()
+	fetched := make(map[string]bool)
+
+	for _, pkg := range packages {
+		if pkg.Remote == "" {
+			continue
+		}
+
+		src, _ := remote.Parse(pkg.Remote)
+		if fetched[src.Key] || (!refresh && src.IsFetched(cacheDir)) {
+			continue
+		}
+		fetched[src.Key] = true
+
+		if report != nil {
+			report(pkg.Remote)
+		}
+		if dryRun {
+			continue
+		}
+		if err := src.Fetch(cacheDir); err != nil {
+			return 0, fmt.Errorf("failed to fetch remote source: %w", err)
+		}
+	}
+
+	return len(fetched), nil
+}
+
+// Extract extracts the archive sources of the packages that weren't
+// extracted yet or changed since into the cache, so packages are linked from
+// their current contents. report, when not nil, is called with each archive
+// before it's extracted.
+func Extract(packages []*config.Package, report func(archive string)) error {
+	cacheDir := archive.DefaultCacheDir()
+	extracted := make(map[string]bool)
+
+	for _, pkg := range packages {
+		if pkg.Archive == "" || extracted[pkg.Archive] {
+			continue
+		}
+		extracted[pkg.Archive] = true
+
+		current, err := archive.IsCurrent(cacheDir, pkg.Archive)
+		if err != nil {
+			return fmt.Errorf("package %s: %w", pkg.Name, err)
+		}
+		if current {
+			continue
+		}
+
+		// Dry runs extract too, the cache is farm's own and the links can't
+		// be planned without the archive's contents
+		if report != nil {
+			report(pkg.Archive)
+		}
+		if err := archive.Extract(cacheDir, pkg.Archive); err != nil {
+			return fmt.Errorf("package %s: %w", pkg.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package link links the packages of a farm config from Go, so programs that
+// register their own transformers with the transform package can run a link
+// that uses them without building the farm command:
+//
+//	func main() {
+//		transform.Register("kms", kms)
+//
+//		result, err := link.Run(link.Options{Config: "farm.yaml", Lockfile: "farm.lock"})
+//		...
+//	}
+//
+// It links the way `farm link` does, fetching remote sources and extracting
+// archive sources first, without the command's prompts, hooks, plugins, and
+// checks of the repository.
+package link
+
+import (
+	"fmt"
+
+	"github.com/mskelton/farm/internal/config"
+	"github.com/mskelton/farm/internal/linker"
+	"github.com/mskelton/farm/internal/lockfile"
+	"github.com/mskelton/farm/internal/sources"
+)
+
+type Options struct {
+	// Config is the path of the config, farm.yaml when empty.
+	Config string
+	// Lockfile is the path of the lockfile, farm.lock when empty.
+	Lockfile string
+	// Environment links the packages of an environment along with the ones
+	// without environments. It's required when any package has environments.
+	Environment string
+	// DryRun plans the link without changing anything or saving the
+	// lockfile.
+	DryRun bool
+}
+
+type Result struct {
+	// Created, Removed, and Replaced are the targets that were changed.
+	Created  []string
+	Removed  []string
+	Replaced []string
+	// Warnings are problems with files that were skipped.
+	Warnings []string
+	// Errors are the targets that couldn't be linked. The rest were linked
+	// and the lockfile saved regardless.
+	Errors []error
+}
+
+// Run links the packages of the config, recording the links in the lockfile.
+func Run(opts Options) (*Result, error) {
+	if opts.Config == "" {
+		opts.Config = "farm.yaml"
+	}
+	if opts.Lockfile == "" {
+		opts.Lockfile = "farm.lock"
+	}
+
+	cfg, err := config.Load(opts.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if opts.Environment == "" {
+		if available := cfg.GetAvailableEnvironments(); len(available) > 0 {
+			return nil, fmt.Errorf("environment not specified (available environments: %v)", available)
+		}
+	}
+
+	lock, err := lockfile.Load(opts.Lockfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	packages := cfg.GetPackagesForEnvironment(opts.Environment)
+	if _, err := sources.Fetch(packages, false, opts.DryRun, nil); err != nil {
+		return nil, err
+	}
+	if err := sources.Extract(packages, nil); err != nil {
+		return nil, err
+	}
+
+	filtered := &config.Config{
+		Packages:    packages,
+		Ignore:      cfg.Ignore,
+		IgnoreGlobs: cfg.IgnoreGlobs,
+		Settings:    cfg.Settings,
+	}
+	result, err := linker.New(filtered, lock, opts.DryRun).Link()
+	if err != nil {
+		return nil, fmt.Errorf("failed to link: %w", err)
+	}
+
+	if !opts.DryRun {
+		if err := lock.Save(opts.Lockfile); err != nil {
+			return nil, fmt.Errorf("failed to save lockfile: %w", err)
+		}
+	}
+
+	return &Result{
+		Created:  result.Created,
+		Removed:  result.Removed,
+		Replaced: result.Replaced,
+		Warnings: result.Warnings,
+		Errors:   result.Errors,
+	}, nil
+}
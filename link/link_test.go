@@ -0,0 +1,84 @@
+package link
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mskelton/farm/transform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	lockfilePath := filepath.Join(tmpDir, "farm.lock")
+
+	transform.Register("test-link-upper", transform.Func(func(in transform.Input) (*transform.Output, error) {
+		content, err := os.ReadFile(in.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &transform.Output{Action: transform.ActionRender, Content: bytes.ToUpper(content)}, nil
+	}))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "secrets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "secrets", ".token"), []byte("secret"), 0644))
+	require.NoError(t, os.WriteFile(configPath, []byte(`packages:
+  - source: ./secrets
+    targets: [./home]
+    transform: [test-link-upper]
+    environments: [work]
+`), 0644))
+
+	_, err := Run(Options{Config: configPath, Lockfile: lockfilePath})
+	assert.ErrorContains(t, err, "environment not specified (available environments: [work])")
+
+	// Dry runs change nothing
+	result, err := Run(Options{Config: configPath, Lockfile: lockfilePath, Environment: "work", DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(tmpDir, "home", ".token")}, result.Created)
+	assert.NoFileExists(t, lockfilePath)
+
+	result, err = Run(Options{Config: configPath, Lockfile: lockfilePath, Environment: "work"})
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	content, err := os.ReadFile(filepath.Join(tmpDir, "home", ".token"))
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", string(content))
+	assert.FileExists(t, lockfilePath)
+}
+
+func TestRunArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+	configPath := filepath.Join(tmpDir, "farm.yaml")
+	lockfilePath := filepath.Join(tmpDir, "farm.lock")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := "set -g mouse on"
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: ".tmux.conf", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "tmux.tar.gz"), buf.Bytes(), 0644))
+	require.NoError(t, os.WriteFile(configPath, []byte(`packages:
+  - source: ./tmux.tar.gz
+    targets: [./home]
+`), 0644))
+
+	// Archive sources are extracted before linking, as `farm link` does
+	result, err := Run(Options{Config: configPath, Lockfile: lockfilePath})
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	linked, err := os.ReadFile(filepath.Join(tmpDir, "home", ".tmux.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(linked))
+}
@@ -0,0 +1,107 @@
+// Package transform lets programs embedding farm customize how individual
+// files are placed at their targets, for example to decrypt secrets with a
+// corporate KMS instead of symlinking the encrypted file.
+//
+// Transformers are registered by name, typically from an init function, and
+// enabled per package with the `transform` config key:
+//
+//	packages:
+//	  - source: ./secrets
+//	    targets: ["~"]
+//	    transform: [kms]
+package transform
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+type Action string
+
+const (
+	// ActionSymlink links the target to the source file, the default.
+	ActionSymlink Action = "symlink"
+	// ActionCopy copies the source file to the target.
+	ActionCopy Action = "copy"
+	// ActionRender writes the transformer's Content to the target.
+	ActionRender Action = "render"
+	// ActionSkip leaves the target untouched.
+	ActionSkip Action = "skip"
+)
+
+type Input struct {
+	// Source is the absolute path of the file in the package source.
+	Source string
+	// Target is the absolute path the file is placed at.
+	Target string
+	// RelativePath is the file's path relative to the package source.
+	RelativePath string
+	// PackageSource is the absolute path of the package source directory.
+	PackageSource string
+}
+
+type Output struct {
+	Action Action
+	// Content is written to the target for ActionRender.
+	Content []byte
+	// Mode is the file mode for copied or rendered files, 0644 when unset.
+	Mode os.FileMode
+}
+
+// Transformer decides how a single file is placed. Returning a nil Output
+// defers to the next transformer configured for the package, and finally to
+// the default symlink behavior.
+type Transformer interface {
+	Transform(in Input) (*Output, error)
+}
+
+// Func adapts an ordinary function to the Transformer interface.
+type Func func(in Input) (*Output, error)
+
+func (f Func) Transform(in Input) (*Output, error) {
+	return f(in)
+}
+
+var (
+	mu           sync.RWMutex
+	transformers = make(map[string]Transformer)
+)
+
+// Register makes a transformer available under name. It panics if the name
+// is already registered or the transformer is nil.
+func Register(name string, t Transformer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if t == nil {
+		panic("transform: Register transformer is nil")
+	}
+	if _, dup := transformers[name]; dup {
+		panic(fmt.Sprintf("transform: Register called twice for transformer %s", name))
+	}
+
+	transformers[name] = t
+}
+
+func Lookup(name string) (Transformer, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, ok := transformers[name]
+	return t, ok
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(transformers))
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,30 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	upper := Func(func(in Input) (*Output, error) {
+		return &Output{Action: ActionRender, Content: []byte(in.RelativePath)}, nil
+	})
+
+	Register("test-upper", upper)
+
+	found, ok := Lookup("test-upper")
+	assert.True(t, ok)
+	out, err := found.Transform(Input{RelativePath: "gitconfig"})
+	assert.NoError(t, err)
+	assert.Equal(t, ActionRender, out.Action)
+	assert.Equal(t, "gitconfig", string(out.Content))
+
+	assert.Contains(t, Names(), "test-upper")
+
+	_, ok = Lookup("missing")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { Register("test-upper", upper) })
+	assert.Panics(t, func() { Register("test-nil", nil) })
+}